@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/config"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/secrets"
+	"github.com/nsmak/consumerService/consumer/web/api"
+	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/health"
+	"github.com/nsmak/consumerService/consumer/web/logging"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/metrics"
+	"github.com/nsmak/consumerService/consumer/web/ratelimit"
+	"github.com/nsmak/consumerService/consumer/web/rpc"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/store/memory"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// app - running service components
+type app struct {
+	cfg        config.Config
+	logging    *logging.Logging
+	log        *zap.Logger
+	restoreLog func() // restores standard logger redirected to log
+	secrets    *secrets.Resolver
+	store      store.DataStore
+	limitStore ratelimit.Store
+	domain     *domain.Service
+	tracer     *sdktrace.TracerProvider // nil if tracing is disabled
+	health     *health.Handler
+	server     *http.Server
+	listener   net.Listener
+	grpc       *rpc.Server  // nil if gRPC API is disabled
+	grpcLn     net.Listener // nil if gRPC API is disabled
+}
+
+// newApp creates components in dependency order: logging, secrets, tracing, store, services and listeners.
+// Listeners are bound here, so address errors are reported before workers start.
+func newApp(ctx context.Context, c config.Config) (*app, error) {
+	l, err := logging.New(logging.Opts{
+		Format:       c.Log.Format,
+		Level:        c.Log.Level,
+		Levels:       c.Log.ComponentLevels(),
+		RedactEmails: c.Log.RedactEmails,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("log: %w", err)
+	}
+	a := &app{cfg: c, logging: l, log: l.Logger("app")}
+	var providers map[string]secrets.Provider
+	if c.Secrets.VaultAddr != "" {
+		token, err := secrets.NewResolver(nil).Resolve(ctx, c.Secrets.VaultToken)
+		if err != nil {
+			return nil, fmt.Errorf("vault token: %w", err)
+		}
+		providers = map[string]secrets.Provider{"vault": secrets.Vault{Addr: c.Secrets.VaultAddr, Token: string(token)}}
+	}
+	a.secrets = secrets.NewResolver(providers)
+
+	signingKey, err := a.secrets.Secret(ctx, c.Auth.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("auth.signing_key: %w", err)
+	}
+	if len(signingKey.Value()) < config.MinSigningKeySize {
+		return nil, fmt.Errorf("auth.signing_key: must be at least %d bytes long", config.MinSigningKeySize)
+	}
+	var (
+		idTokenKey       *rsa.PrivateKey
+		idTokenKeySecret *secrets.Secret
+	)
+	if c.Auth.IDTokenKey != "" {
+		if idTokenKeySecret, err = a.secrets.Secret(ctx, c.Auth.IDTokenKey); err != nil {
+			return nil, fmt.Errorf("auth.id_token_key: %w", err)
+		}
+		if idTokenKey, err = auth.ParseIDTokenKey(idTokenKeySecret.Value()); err != nil {
+			return nil, fmt.Errorf("auth.id_token_key: %w", err)
+		}
+	} else {
+		a.log.Warn("auth.id_token_key isn't set, ID tokens are signed with generated key which is lost on restart")
+		if idTokenKey, err = auth.GenerateIDTokenKey(); err != nil {
+			return nil, fmt.Errorf("auth.id_token_key: %w", err)
+		}
+	}
+
+	var tp trace.TracerProvider
+	if c.Tracing.Endpoint != "" {
+		if a.tracer, err = tracing.NewProvider(ctx, tracing.Opts{
+			Endpoint:    c.Tracing.Endpoint,
+			Insecure:    c.Tracing.Insecure,
+			ServiceName: c.Tracing.ServiceName,
+			SampleRatio: c.Tracing.SampleRatio,
+		}); err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		otel.SetTracerProvider(a.tracer)
+		tp = a.tracer
+	}
+
+	// memory store is the only driver, it doesn't need migrations
+	a.store = memory.New()
+	m := metrics.New()
+
+	authService := auth.NewService(auth.Opts{
+		SigningKey: signingKey.Value(),
+		IDTokenKey: idTokenKey,
+		Metrics:    m,
+		Logger:     l.Logger("auth"),
+		WebAuthn: webauthn.Config{
+			RPID:    c.Auth.WebAuthnRPID,
+			RPName:  c.Auth.WebAuthnRPName,
+			Origins: c.Auth.WebAuthnOrigins,
+		},
+	})
+	signingKey.OnChange(func(key []byte) {
+		if len(key) < config.MinSigningKeySize {
+			a.log.Warn("rotated signing key is too short, it is ignored", zap.Int("min_size", config.MinSigningKeySize))
+			return
+		}
+		authService.SetSigningKey(key)
+	})
+	if idTokenKeySecret != nil {
+		idTokenKeySecret.OnChange(func(b []byte) {
+			key, err := auth.ParseIDTokenKey(b)
+			if err != nil {
+				a.log.Warn("rotated id token key is invalid, it is ignored", zap.Error(err))
+				return
+			}
+			authService.SetIDTokenKey(key)
+		})
+	}
+
+	if a.limitStore, err = a.newLimitStore(ctx); err != nil {
+		return nil, err
+	}
+	checks := []health.Check{
+		{Name: "store", Func: a.store.Ping},
+		{Name: "signing_key", Func: func(context.Context) error {
+			if !authService.SigningKeyLoaded() {
+				return errors.New("signing key isn't loaded")
+			}
+			return nil
+		}},
+		{Name: "migrations", Func: a.checkMigrations},
+	}
+	var ml mailer.Mailer
+	if c.Mail.SMTPAddr != "" {
+		password, err := a.secrets.Resolve(ctx, c.Mail.Password)
+		if err != nil {
+			return nil, fmt.Errorf("mail.password: %w", err)
+		}
+		smtp := mailer.NewSMTP(mailer.SMTPOpts{Addr: c.Mail.SMTPAddr, From: c.Mail.From, Username: c.Mail.Username, Password: string(password)})
+		checks = append(checks, health.Check{Name: "mailer", Func: smtp.Ping})
+		ml = smtp
+	}
+	a.health = health.NewHandler(0, checks...)
+	a.domain = domain.NewService(tracing.Store(tp, m.Store(a.store)), authService, domain.Opts{
+		Mailer:       ml,
+		LoginLinkURL: c.Mail.LoginLinkURL,
+		Limiter: ratelimit.NewLimiter(a.limitStore, ratelimit.Opts{
+			IP:        ratelimit.Rule{Limit: c.RateLimit.IPLimit, Window: c.RateLimit.IPWindow},
+			Account:   ratelimit.Rule{Limit: c.RateLimit.AccountLimit, Window: c.RateLimit.AccountWindow},
+			Lockout:   c.RateLimit.Lockout,
+			BaseDelay: c.RateLimit.BaseDelay,
+			MaxDelay:  c.RateLimit.MaxDelay,
+		}),
+		AccessTokenTTL:              c.Auth.AccessTokenTTL,
+		PasswordPolicy:              models.PasswordPolicy{MinLength: c.Password.MinLength, MaxLength: c.Password.MaxLength},
+		EnumerationSafeRegistration: c.Accounts.EnumerationSafeRegistration,
+		DeletionGracePeriod:         c.Accounts.DeletionGracePeriod,
+		IntrospectionCacheTTL:       c.Auth.IntrospectionCacheTTL,
+		OAuthAccessTokenTTL:         c.Auth.OAuthAccessTokenTTL,
+		RefreshTokenTTL:             c.Auth.RefreshTokenTTL,
+		Issuer:                      c.Auth.Issuer,
+		Metrics:                     m,
+		TracerProvider:              tp,
+		Logger:                      l.Logger("domain"),
+	})
+
+	apiHandler := api.NewHandler(a.domain, authService, api.Opts{LogLevels: l})
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", a.health)
+	mux.Handle("/readyz", a.health)
+	mux.Handle("/metrics", m.Handler())
+	// probes and scrapes are neither traced nor logged
+	mux.Handle("/", tracing.InstrumentHandler(tp, apiHandler.Route, logging.Middleware(l.Logger("http"), apiHandler.Route, apiHandler)))
+	route := func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "/" {
+			return pattern
+		}
+		return apiHandler.Route(r)
+	}
+	a.server = &http.Server{
+		Handler:      m.InstrumentHandler(route, mux),
+		ReadTimeout:  c.HTTP.ReadTimeout,
+		WriteTimeout: c.HTTP.WriteTimeout,
+	}
+	if a.listener, err = net.Listen("tcp", c.HTTP.ListenAddr); err != nil {
+		a.close()
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	if c.GRPC.ListenAddr != "" {
+		a.grpc = rpc.NewServer(a.domain, rpc.Opts{Logger: l.Logger("grpc")})
+		if a.grpcLn, err = net.Listen("tcp", c.GRPC.ListenAddr); err != nil {
+			a.listener.Close()
+			a.close()
+			return nil, fmt.Errorf("grpc: %w", err)
+		}
+	}
+	// packages which use standard logger write to the same output
+	a.restoreLog = zap.RedirectStdLog(l.Logger("std"))
+	return a, nil
+}
+
+func (a *app) newLimitStore(ctx context.Context) (ratelimit.Store, error) {
+	if a.cfg.RateLimit.Store != config.LimitStoreRedis {
+		return ratelimit.NewMemoryStore(), nil
+	}
+	password, err := a.secrets.Secret(ctx, a.cfg.RateLimit.RedisPassword)
+	if err != nil {
+		return nil, fmt.Errorf("rate_limit.redis_password: %w", err)
+	}
+	s := ratelimit.NewRedisStore(ratelimit.RedisOpts{
+		Addr:     a.cfg.RateLimit.RedisAddr,
+		Password: string(password.Value()),
+		DB:       a.cfg.RateLimit.RedisDB,
+	})
+	password.OnChange(func(v []byte) { s.SetPassword(string(v)) })
+	return s, nil
+}
+
+// run serves requests and runs background workers until ctx is done or server fails, then shuts down:
+// fails readiness probe for http.drain_delay, stops accepting connections, drains in-flight requests,
+// stops workers, flushes outbox and closes stores.
+// Shutdown takes no longer than http.drain_delay plus http.shutdown_timeout.
+func (a *app) run(ctx context.Context) error {
+	serverErr := make(chan error, 2)
+	go func() {
+		if err := a.server.Serve(a.listener); err != http.ErrServerClosed {
+			serverErr <- fmt.Errorf("http: %w", err)
+		}
+	}()
+	a.log.Info("listening", zap.Stringer("addr", a.listener.Addr()))
+	if a.grpc != nil {
+		go func() {
+			if err := a.grpc.Serve(a.grpcLn); err != nil {
+				serverErr <- fmt.Errorf("grpc: %w", err)
+			}
+		}()
+		a.log.Info("listening for grpc", zap.Stringer("addr", a.grpcLn.Addr()))
+	}
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	for _, w := range []func(context.Context){
+		func(ctx context.Context) { a.domain.RunPurger(ctx, a.cfg.Accounts.PurgeInterval) },
+		func(ctx context.Context) { a.domain.RunExportBuilder(ctx, a.cfg.Accounts.ExportInterval) },
+		func(ctx context.Context) { a.domain.RunOutboxRelay(ctx, a.cfg.Events.OutboxInterval) },
+		func(ctx context.Context) { a.domain.RunWebhookDispatcher(ctx, a.cfg.Events.WebhookInterval) },
+		a.domain.RunMailer,
+		func(ctx context.Context) {
+			a.secrets.Watch(ctx, a.cfg.Secrets.RefreshInterval, a.logging.Logger("secrets"))
+		},
+	} {
+		workers.Add(1)
+		go func(w func(context.Context)) {
+			defer workers.Done()
+			w(workersCtx)
+		}(w)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serverErr:
+	}
+
+	// load balancer needs time to notice failing readiness and stop routing new requests here
+	a.health.Drain()
+	if a.grpc != nil {
+		a.grpc.Drain()
+	}
+	if err == nil && a.cfg.HTTP.DrainDelay > 0 {
+		a.log.Info("draining", zap.Duration("delay", a.cfg.HTTP.DrainDelay))
+		time.Sleep(a.cfg.HTTP.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if shutdownErr := a.server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = fmt.Errorf("http shutdown: %w", shutdownErr)
+	}
+	if a.grpc != nil {
+		a.grpc.GracefulStop(shutdownCtx)
+	}
+	stopWorkers()
+	workers.Wait()
+	if n, flushErr := a.domain.PublishOutbox(shutdownCtx); flushErr != nil && err == nil {
+		err = fmt.Errorf("flush outbox: %w", flushErr)
+	} else if n != 0 {
+		a.log.Info("outbox flushed", zap.Int("messages", n))
+	}
+	a.close()
+	return err
+}
+
+// migrator is implemented by stores with versioned schema
+type migrator interface {
+	SchemaVersion(ctx context.Context) (current, latest int, err error)
+}
+
+// checkMigrations fails if store schema isn't migrated to the latest version, stores without schema always pass
+func (a *app) checkMigrations(ctx context.Context) error {
+	m, ok := a.store.(migrator)
+	if !ok {
+		return nil
+	}
+	current, latest, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current != latest {
+		return fmt.Errorf("schema version is %d, expected %d", current, latest)
+	}
+	return nil
+}
+
+// close releases stores and flushes spans and logs, errors are only logged because nothing can be done about them
+func (a *app) close() {
+	if a.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.HTTP.ShutdownTimeout)
+		if err := a.tracer.Shutdown(ctx); err != nil {
+			a.log.Error("can't flush spans", zap.Error(err))
+		}
+		cancel()
+	}
+	for _, s := range []interface{}{a.limitStore, a.store} {
+		c, ok := s.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			a.log.Error("can't close store", zap.Error(err))
+		}
+	}
+	if a.restoreLog != nil {
+		a.restoreLog()
+	}
+	_ = a.logging.Sync()
+}
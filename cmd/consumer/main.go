@@ -1,5 +1,81 @@
-package main //nolint:goimports,gocritic
+// Command consumer runs consumer service
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nsmak/consumerService/consumer/config"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1 // service failed to start or stopped with error
+	exitConfig  = 2 // invalid flags or config
+)
 
 func main() {
+	ctx, stop := signalContext()
+	code := run(ctx, os.Args[0], os.Args[1:], os.LookupEnv, os.Stdout)
+	stop()
+	os.Exit(code)
+}
+
+// run starts service and blocks until ctx is done or service fails, it returns exit code
+func run(ctx context.Context, name string, args []string, lookupEnv func(string) (string, bool), stdout io.Writer) int {
+	c, opts, err := config.Load(name, args, lookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		log.Printf("load config: %v", err)
+		return exitConfig
+	}
+	if opts.PrintConfig {
+		if err := c.WriteYAML(stdout); err != nil {
+			log.Printf("print config: %v", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	if err := c.Validate(); err != nil {
+		log.Print(err)
+		return exitConfig
+	}
+
+	a, err := newApp(ctx, c)
+	if err != nil {
+		log.Printf("start: %v", err)
+		return exitFailure
+	}
+	if err := a.run(ctx); err != nil {
+		log.Printf("stop: %v", err)
+		return exitFailure
+	}
+	return exitOK
+}
 
+// signalContext returns context which is done on SIGINT or SIGTERM.
+// Default handling is restored after the first signal, so the second one kills the process.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-ch:
+			log.Printf("received %s, shutting down", s)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/rpc/consumerpb"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestRunConfigErrors(t *testing.T) {
+	var out bytes.Buffer
+	require.Equal(t, exitConfig, run(context.Background(), "consumer", []string{"--unknown"}, env(nil), &out))
+	require.Equal(t, exitConfig, run(context.Background(), "consumer", nil, env(nil), &out))
+	require.Equal(t, exitOK, run(context.Background(), "consumer", []string{"--print-config"}, env(nil), &out))
+	require.Contains(t, out.String(), "listen_addr: :8080")
+}
+
+func TestRunStartFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	code := run(context.Background(), "consumer", []string{"--http.listen-addr", l.Addr().String()},
+		env(map[string]string{"CONSUMER_AUTH_SIGNING_KEY": testSigningKey}), &bytes.Buffer{})
+	require.Equal(t, exitFailure, code)
+}
+
+// freeAddr returns address of free local port
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRunLifecycle(t *testing.T) {
+	addr, grpcAddr := freeAddr(t), freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		done <- run(ctx, "consumer", []string{"--http.listen-addr", addr, "--grpc.listen-addr", grpcAddr, "--http.drain-delay", "300ms"},
+			env(map[string]string{"CONSUMER_AUTH_SIGNING_KEY": testSigningKey}), &bytes.Buffer{})
+	}()
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	require.Eventually(t, func() bool {
+		resp, err = http.Get("http://" + addr + "/readyz")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	var ready struct {
+		Status string
+		Checks map[string]struct{ Status string }
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&ready))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "ok", ready.Status)
+	require.Equal(t, "ok", ready.Checks["store"].Status)
+	require.Equal(t, "ok", ready.Checks["signing_key"].Status)
+
+	resp, err = http.Post("http://"+addr+"/v1/consumers", "application/json",
+		strings.NewReader(`{"email": "test@test.com", "pass1": "password", "pass2": "password"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Len(t, resp.Header.Get("X-Request-Id"), 32)
+
+	resp, err = http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	metrics, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(metrics), "consumer_registrations_total 1\n")
+	require.Contains(t, string(metrics), `consumer_http_request_duration_seconds_count{method="POST",route="/v1/consumers",status="201"} 1`)
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	login, err := consumerpb.NewConsumerServiceClient(conn).Authenticate(context.Background(),
+		&consumerpb.AuthenticateRequest{Email: "test@test.com", Password: "password"})
+	require.NoError(t, err)
+	require.NotEmpty(t, login.Token)
+	health := healthpb.NewHealthClient(conn)
+
+	cancel()
+	// readiness fails while connections are still accepted during drain
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/readyz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+	hc, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, hc.Status)
+	select {
+	case code := <-done:
+		require.Equal(t, exitOK, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("service didn't stop")
+	}
+	for _, a := range []string{addr, grpcAddr} {
+		_, err = net.DialTimeout("tcp", a, time.Second)
+		require.Error(t, err, a)
+	}
+}
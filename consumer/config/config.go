@@ -0,0 +1,359 @@
+// Package config defines settings of consumer service and loads them
+// from YAML file, environment variables and command line flags
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/secrets"
+	"github.com/nsmak/consumerService/consumer/web/logging"
+)
+
+// Store drivers
+const (
+	StoreMemory = "memory"
+)
+
+// Rate limit counter stores
+const (
+	LimitStoreMemory = "memory"
+	LimitStoreRedis  = "redis"
+)
+
+// MinSigningKeySize - min size of signing key, referenced key must be checked after it is resolved
+const MinSigningKeySize = 32
+
+// Config - settings of consumer service. Setting's YAML key is its path, e.g. "http.listen_addr",
+// environment variable is the path in upper case with CONSUMER_ prefix, e.g. CONSUMER_HTTP_LISTEN_ADDR,
+// and flag is the path with dashes, e.g. --http.listen-addr. Fields tagged secret are redacted on print,
+// their values may be references resolved by secrets package, e.g. "file:///run/secrets/jwt".
+type Config struct {
+	HTTP      HTTP      `yaml:"http"`
+	GRPC      GRPC      `yaml:"grpc"`
+	Store     Store     `yaml:"store"`
+	Auth      Auth      `yaml:"auth"`
+	Password  Password  `yaml:"password"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Mail      Mail      `yaml:"mail"`
+	Accounts  Accounts  `yaml:"accounts"`
+	Events    Events    `yaml:"events"`
+	Secrets   Secrets   `yaml:"secrets"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Log       Log       `yaml:"log"`
+}
+
+// HTTP - REST API server settings
+type HTTP struct {
+	ListenAddr      string        `yaml:"listen_addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay      time.Duration `yaml:"drain_delay"` // readiness fails for this time before server stops accepting connections
+}
+
+// GRPC - gRPC API server settings, it stops along with REST API server
+type GRPC struct {
+	ListenAddr string `yaml:"listen_addr"` // gRPC API is served if set
+}
+
+// Store - data storage settings
+type Store struct {
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn" secret:"true"` // connection string, not used by memory driver
+}
+
+// Auth - token and WebAuthn settings
+type Auth struct {
+	SigningKey      string        `yaml:"signing_key" secret:"true"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	WebAuthnRPID    string        `yaml:"webauthn_rp_id"`
+	WebAuthnRPName  string        `yaml:"webauthn_rp_name"`
+	WebAuthnOrigins []string      `yaml:"webauthn_origins"`
+	// IntrospectionCacheTTL is how long token introspection responses are cached, zero disables caching
+	IntrospectionCacheTTL time.Duration `yaml:"introspection_cache_ttl"`
+	// OAuthAccessTokenTTL and RefreshTokenTTL are lifetimes of tokens issued to OAuth clients
+	OAuthAccessTokenTTL time.Duration `yaml:"oauth_access_token_ttl"`
+	RefreshTokenTTL     time.Duration `yaml:"refresh_token_ttl"`
+	// Issuer is public url of service as OpenID Connect provider
+	Issuer string `yaml:"issuer"`
+	// IDTokenKey is PEM encoded RSA private key which signs ID tokens.
+	// If it is empty, key is generated on start, so ID tokens can't be verified after restart or by other instances.
+	IDTokenKey string `yaml:"id_token_key" secret:"true"`
+}
+
+// Password - policy of new passwords
+type Password struct {
+	MinLength int `yaml:"min_length"`
+	MaxLength int `yaml:"max_length"` // zero means unlimited
+}
+
+// RateLimit - throttling of authentication attempts
+type RateLimit struct {
+	Store         string        `yaml:"store"`
+	RedisAddr     string        `yaml:"redis_addr"`
+	RedisPassword string        `yaml:"redis_password" secret:"true"`
+	RedisDB       int           `yaml:"redis_db"`
+	IPLimit       int64         `yaml:"ip_limit"`
+	IPWindow      time.Duration `yaml:"ip_window"`
+	AccountLimit  int64         `yaml:"account_limit"`
+	AccountWindow time.Duration `yaml:"account_window"`
+	Lockout       time.Duration `yaml:"lockout"`
+	BaseDelay     time.Duration `yaml:"base_delay"`
+	MaxDelay      time.Duration `yaml:"max_delay"`
+}
+
+// Mail - SMTP settings, mail is disabled if address is empty
+type Mail struct {
+	SMTPAddr     string `yaml:"smtp_addr"`
+	From         string `yaml:"from"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password" secret:"true"`
+	LoginLinkURL string `yaml:"login_link_url"`
+}
+
+// Accounts - registration, deletion and export settings
+type Accounts struct {
+	EnumerationSafeRegistration bool          `yaml:"enumeration_safe_registration"`
+	DeletionGracePeriod         time.Duration `yaml:"deletion_grace_period"`
+	PurgeInterval               time.Duration `yaml:"purge_interval"`
+	ExportInterval              time.Duration `yaml:"export_interval"`
+}
+
+// Events - domain events delivery settings
+type Events struct {
+	OutboxInterval  time.Duration `yaml:"outbox_interval"`
+	WebhookInterval time.Duration `yaml:"webhook_interval"`
+}
+
+// Secrets - secret provider settings
+type Secrets struct {
+	VaultAddr       string        `yaml:"vault_addr"` // "vault:" references are resolved if set
+	VaultToken      string        `yaml:"vault_token" secret:"true"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // resolved secrets are reloaded with this interval
+}
+
+// Tracing - OpenTelemetry settings, spans are exported by OTLP over HTTP if endpoint is set
+type Tracing struct {
+	Endpoint    string  `yaml:"endpoint"` // collector host:port, e.g. "otel-collector:4318"
+	Insecure    bool    `yaml:"insecure"` // use http instead of https
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"` // ratio of sampled traces which aren't continued from sampled request
+}
+
+// Log - logging settings, levels of components can be changed at runtime by admin API
+type Log struct {
+	Format       string   `yaml:"format"`           // "json" or "console"
+	Level        string   `yaml:"level"`            // level of components without own level
+	Levels       []string `yaml:"levels,omitempty"` // component levels, e.g. "domain=debug"
+	RedactEmails bool     `yaml:"redact_emails"`
+}
+
+// ComponentLevels returns levels by component, entries without "=" are skipped
+func (l Log) ComponentLevels() map[string]string {
+	levels := make(map[string]string, len(l.Levels))
+	for _, item := range l.Levels {
+		component, level, ok := splitLevel(item)
+		if ok {
+			levels[component] = level
+		}
+	}
+	return levels
+}
+
+func splitLevel(item string) (component, level string, ok bool) {
+	i := strings.Index(item, "=")
+	if i <= 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:]), true
+}
+
+// Default returns config with default settings. Signing key has no default and must be set.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			ListenAddr:      ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+			DrainDelay:      5 * time.Second,
+		},
+		Store: Store{Driver: StoreMemory},
+		Auth: Auth{
+			AccessTokenTTL:  24 * time.Hour * 365,
+			WebAuthnRPID:    "localhost",
+			WebAuthnRPName:  "Consumer service",
+			WebAuthnOrigins: []string{"https://localhost"},
+
+			IntrospectionCacheTTL: 10 * time.Second,
+			OAuthAccessTokenTTL:   time.Hour,
+			RefreshTokenTTL:       30 * 24 * time.Hour,
+			Issuer:                "http://localhost:8080",
+		},
+		Password: Password{MinLength: 8, MaxLength: 128},
+		RateLimit: RateLimit{
+			Store:         LimitStoreMemory,
+			IPLimit:       100,
+			IPWindow:      time.Hour,
+			AccountLimit:  10,
+			AccountWindow: 15 * time.Minute,
+			Lockout:       15 * time.Minute,
+			BaseDelay:     time.Second,
+			MaxDelay:      30 * time.Second,
+		},
+		Accounts: Accounts{
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			PurgeInterval:       time.Hour,
+			ExportInterval:      5 * time.Second,
+		},
+		Events: Events{
+			OutboxInterval:  time.Second,
+			WebhookInterval: 10 * time.Second,
+		},
+		Secrets: Secrets{RefreshInterval: time.Minute},
+		Tracing: Tracing{ServiceName: "consumer", SampleRatio: 1},
+		Log:     Log{Format: logging.FormatJSON, Level: logging.LevelInfo, RedactEmails: true},
+	}
+}
+
+// FieldError - invalid setting
+type FieldError struct {
+	Field   string // setting's YAML path
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError - list of invalid settings
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Validate returns ValidationError listing all invalid settings
+func (c Config) Validate() error {
+	var errs ValidationError
+	check := func(ok bool, field, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	positive := func(d time.Duration, field string) {
+		check(d > 0, field, "must be positive duration")
+	}
+
+	_, _, err := net.SplitHostPort(c.HTTP.ListenAddr)
+	check(err == nil, "http.listen_addr", "must be host:port, got %q", c.HTTP.ListenAddr)
+	if c.GRPC.ListenAddr != "" {
+		_, _, err := net.SplitHostPort(c.GRPC.ListenAddr)
+		check(err == nil, "grpc.listen_addr", "must be host:port, got %q", c.GRPC.ListenAddr)
+	}
+	positive(c.HTTP.ReadTimeout, "http.read_timeout")
+	positive(c.HTTP.WriteTimeout, "http.write_timeout")
+	positive(c.HTTP.ShutdownTimeout, "http.shutdown_timeout")
+	check(c.HTTP.DrainDelay >= 0, "http.drain_delay", "must not be negative")
+
+	check(c.Store.Driver == StoreMemory, "store.driver", "must be %q, got %q", StoreMemory, c.Store.Driver)
+
+	check(secrets.IsReference(c.Auth.SigningKey) || len(c.Auth.SigningKey) >= MinSigningKeySize,
+		"auth.signing_key", "must be at least %d bytes long", MinSigningKeySize)
+	positive(c.Auth.AccessTokenTTL, "auth.access_token_ttl")
+	check(c.Auth.WebAuthnRPID != "", "auth.webauthn_rp_id", "must not be empty")
+	check(len(c.Auth.WebAuthnOrigins) != 0, "auth.webauthn_origins", "must not be empty")
+	for _, o := range c.Auth.WebAuthnOrigins {
+		check(isURL(o), "auth.webauthn_origins", "%q is not absolute url", o)
+	}
+	check(c.Auth.IntrospectionCacheTTL >= 0, "auth.introspection_cache_ttl", "must not be negative")
+	positive(c.Auth.OAuthAccessTokenTTL, "auth.oauth_access_token_ttl")
+	positive(c.Auth.RefreshTokenTTL, "auth.refresh_token_ttl")
+	check(isIssuer(c.Auth.Issuer), "auth.issuer", "must be absolute url without query and fragment, got %q", c.Auth.Issuer)
+
+	check(c.Password.MinLength >= 1, "password.min_length", "must be at least 1")
+	check(c.Password.MaxLength == 0 || c.Password.MaxLength >= c.Password.MinLength,
+		"password.max_length", "must be zero or not less than password.min_length")
+
+	switch c.RateLimit.Store {
+	case LimitStoreMemory:
+	case LimitStoreRedis:
+		_, _, err := net.SplitHostPort(c.RateLimit.RedisAddr)
+		check(err == nil, "rate_limit.redis_addr", "must be host:port, got %q", c.RateLimit.RedisAddr)
+	default:
+		check(false, "rate_limit.store", "must be %q or %q, got %q", LimitStoreMemory, LimitStoreRedis, c.RateLimit.Store)
+	}
+	check(c.RateLimit.IPLimit >= 0, "rate_limit.ip_limit", "must not be negative")
+	check(c.RateLimit.AccountLimit >= 0, "rate_limit.account_limit", "must not be negative")
+	if c.RateLimit.IPLimit > 0 {
+		positive(c.RateLimit.IPWindow, "rate_limit.ip_window")
+	}
+	if c.RateLimit.AccountLimit > 0 {
+		positive(c.RateLimit.AccountWindow, "rate_limit.account_window")
+		positive(c.RateLimit.Lockout, "rate_limit.lockout")
+	}
+	check(c.RateLimit.MaxDelay >= c.RateLimit.BaseDelay, "rate_limit.max_delay", "must not be less than rate_limit.base_delay")
+
+	if c.Mail.SMTPAddr != "" {
+		_, _, err := net.SplitHostPort(c.Mail.SMTPAddr)
+		check(err == nil, "mail.smtp_addr", "must be host:port, got %q", c.Mail.SMTPAddr)
+		check(c.Mail.From != "", "mail.from", "must be set when mail.smtp_addr is set")
+	}
+	check(c.Mail.LoginLinkURL == "" || isURL(c.Mail.LoginLinkURL), "mail.login_link_url", "must be absolute url")
+
+	positive(c.Accounts.DeletionGracePeriod, "accounts.deletion_grace_period")
+	positive(c.Accounts.PurgeInterval, "accounts.purge_interval")
+	positive(c.Accounts.ExportInterval, "accounts.export_interval")
+	positive(c.Events.OutboxInterval, "events.outbox_interval")
+	positive(c.Events.WebhookInterval, "events.webhook_interval")
+
+	if c.Secrets.VaultAddr != "" {
+		check(isURL(c.Secrets.VaultAddr), "secrets.vault_addr", "must be absolute url")
+		check(c.Secrets.VaultToken != "", "secrets.vault_token", "must be set when secrets.vault_addr is set")
+	}
+	positive(c.Secrets.RefreshInterval, "secrets.refresh_interval")
+
+	if c.Tracing.Endpoint != "" {
+		_, _, err := net.SplitHostPort(c.Tracing.Endpoint)
+		check(err == nil, "tracing.endpoint", "must be host:port, got %q", c.Tracing.Endpoint)
+		check(c.Tracing.ServiceName != "", "tracing.service_name", "must be set when tracing.endpoint is set")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+
+	check(c.Log.Format == logging.FormatJSON || c.Log.Format == logging.FormatConsole,
+		"log.format", "must be %q or %q, got %q", logging.FormatJSON, logging.FormatConsole, c.Log.Format)
+	_, err = logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level", "%v, got %q", err, c.Log.Level)
+	for _, item := range c.Log.Levels {
+		_, level, ok := splitLevel(item)
+		if !ok {
+			check(false, "log.levels", "must be component=level, got %q", item)
+			continue
+		}
+		_, err := logging.ParseLevel(level)
+		check(err == nil, "log.levels", "%v, got %q", err, item)
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// isIssuer reports whether s is valid OpenID Connect issuer identifier
+func isIssuer(s string) bool {
+	return isURL(s) && !strings.ContainsAny(s, "?#")
+}
@@ -0,0 +1,170 @@
+package config_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+const signingKey = "0123456789abcdef0123456789abcdef"
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+http:
+  listen_addr: ":9000"
+  read_timeout: 5s
+auth:
+  signing_key: from-file
+  webauthn_origins: [https://file.test]
+rate_limit:
+  ip_limit: 50
+`)
+	c, opts, err := config.Load("consumer", []string{"--http.listen-addr", ":9100", "-auth.webauthn-origins=https://a.test, https://b.test"},
+		env(map[string]string{
+			"CONSUMER_CONFIG":               path,
+			"CONSUMER_HTTP_LISTEN_ADDR":     ":9050",
+			"CONSUMER_AUTH_SIGNING_KEY":     signingKey,
+			"CONSUMER_RATE_LIMIT_LOCKOUT":   "1h",
+			"CONSUMER_TRACING_SAMPLE_RATIO": "0.1",
+			"CONSUMER_LOG_LEVELS":           "domain=debug, auth=warn",
+		}))
+	require.NoError(t, err)
+	require.Equal(t, config.Options{Path: path}, opts)
+
+	want := config.Default()
+	want.HTTP.ListenAddr = ":9100"
+	want.HTTP.ReadTimeout = 5 * time.Second
+	want.Auth.SigningKey = signingKey
+	want.Auth.WebAuthnOrigins = []string{"https://a.test", "https://b.test"}
+	want.RateLimit.IPLimit = 50
+	want.RateLimit.Lockout = time.Hour
+	want.Tracing.SampleRatio = 0.1
+	want.Log.Levels = []string{"domain=debug", "auth=warn"}
+	require.Equal(t, want, c)
+	require.Equal(t, map[string]string{"domain": "debug", "auth": "warn"}, c.Log.ComponentLevels())
+	require.NoError(t, c.Validate())
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+		file string
+		err  string
+	}{
+		"unknown yaml key": {
+			file: "http:\n  listen: \":80\"\n",
+			err:  "field listen not found",
+		},
+		"invalid env duration": {
+			env: map[string]string{"CONSUMER_AUTH_ACCESS_TOKEN_TTL": "year"},
+			err: `environment variable CONSUMER_AUTH_ACCESS_TOKEN_TTL: invalid duration "year"`,
+		},
+		"invalid flag integer": {
+			args: []string{"--password.min-length=many"},
+			err:  `invalid integer "many"`,
+		},
+		"invalid flag number": {
+			args: []string{"--tracing.sample-ratio=half"},
+			err:  `invalid number "half"`,
+		},
+		"unknown flag": {
+			args: []string{"--listen"},
+			err:  "flag provided but not defined: -listen",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append(args, "--config", writeFile(t, tt.file))
+			}
+			_, _, err := config.Load("consumer", args, env(tt.env))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := config.Default()
+	c.HTTP.ListenAddr = "8080"
+	c.GRPC.ListenAddr = "9090"
+	c.Password.MinLength = 10
+	c.Password.MaxLength = 5
+	c.RateLimit.Store = config.LimitStoreRedis
+	c.Auth.Issuer = "https://id.test/?tenant=1"
+	c.Mail.SMTPAddr = "smtp.test:25"
+	c.Tracing.SampleRatio = 2
+	c.Log.Format = "text"
+	c.Log.Levels = []string{"domain=verbose", "auth"}
+
+	err := c.Validate()
+	require.Equal(t, config.ValidationError{
+		{Field: "http.listen_addr", Message: `must be host:port, got "8080"`},
+		{Field: "grpc.listen_addr", Message: `must be host:port, got "9090"`},
+		{Field: "auth.signing_key", Message: "must be at least 32 bytes long"},
+		{Field: "auth.issuer", Message: `must be absolute url without query and fragment, got "https://id.test/?tenant=1"`},
+		{Field: "password.max_length", Message: "must be zero or not less than password.min_length"},
+		{Field: "rate_limit.redis_addr", Message: `must be host:port, got ""`},
+		{Field: "mail.from", Message: "must be set when mail.smtp_addr is set"},
+		{Field: "tracing.sample_ratio", Message: "must be between 0 and 1"},
+		{Field: "log.format", Message: `must be "json" or "console", got "text"`},
+		{Field: "log.levels", Message: `level must be debug, info, warn or error, got "domain=verbose"`},
+		{Field: "log.levels", Message: `must be component=level, got "auth"`},
+	}, err)
+}
+
+func TestValidateSecretReference(t *testing.T) {
+	c := config.Default()
+	c.Auth.SigningKey = "file:///run/secrets/jwt"
+	require.NoError(t, c.Validate())
+
+	c.Secrets.VaultAddr = "https://vault:8200"
+	require.Equal(t, config.ValidationError{
+		{Field: "secrets.vault_token", Message: "must be set when secrets.vault_addr is set"},
+	}, c.Validate())
+}
+
+func TestPrintConfig(t *testing.T) {
+	c, opts, err := config.Load("consumer", []string{"--print-config", "--auth.signing-key", signingKey}, env(nil))
+	require.NoError(t, err)
+	require.True(t, opts.PrintConfig)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.WriteYAML(&buf))
+	require.Contains(t, buf.String(), "signing_key: '[REDACTED]'")
+	require.Contains(t, buf.String(), "redis_password: \"\"")
+	require.Contains(t, buf.String(), "access_token_ttl: 8760h0m0s")
+	require.NotContains(t, buf.String(), signingKey)
+	require.Equal(t, signingKey, c.Auth.SigningKey)
+
+	path := writeFile(t, buf.String())
+	printed, _, err := config.Load("consumer", []string{"--config", path}, env(nil))
+	require.NoError(t, err)
+	c.Auth.SigningKey = "[REDACTED]"
+	require.Equal(t, c, printed)
+}
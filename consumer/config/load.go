@@ -0,0 +1,225 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix = "CONSUMER_"
+	redacted  = "[REDACTED]"
+)
+
+// Options - command line options which aren't settings
+type Options struct {
+	Path        string // YAML file, config is built from defaults, environment and flags if empty
+	PrintConfig bool   // print effective config and exit
+}
+
+// Load builds config from defaults overridden by YAML file, environment variables and flags in this order.
+// Path of YAML file is taken from --config flag or CONSUMER_CONFIG variable. Config isn't validated.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, Options, error) {
+	var opts Options
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&opts.Path, "config", "", "path to YAML config file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print effective config with secrets redacted and exit")
+
+	c := Default()
+	settings := fields(&c)
+	values := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(flagValue{setting: s, values: values}, s.flag, "overrides "+s.path)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return Config{}, Options{}, err
+	}
+	if fs.NArg() != 0 {
+		return Config{}, Options{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if opts.Path == "" {
+		opts.Path, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+
+	if opts.Path != "" {
+		b, err := ioutil.ReadFile(opts.Path)
+		if err != nil {
+			return Config{}, Options{}, err
+		}
+		if err := decodeYAML(b, &c); err != nil {
+			return Config{}, Options{}, fmt.Errorf("%s: %w", opts.Path, err)
+		}
+	}
+	for _, s := range settings {
+		v, ok := lookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if err := s.set(v); err != nil {
+			return Config{}, Options{}, fmt.Errorf("environment variable %s: %w", s.env, err)
+		}
+	}
+	for _, s := range settings {
+		if v, ok := values[s.path]; ok {
+			if err := s.set(v); err != nil {
+				return Config{}, Options{}, fmt.Errorf("flag --%s: %w", s.flag, err)
+			}
+		}
+	}
+	return c, opts, nil
+}
+
+// WriteYAML writes config to w with secrets redacted
+func (c Config) WriteYAML(w io.Writer) error {
+	r := c.Redacted()
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Redacted returns copy of config whose non-empty secrets are replaced by placeholder
+func (c Config) Redacted() Config {
+	for _, s := range fields(&c) {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString(redacted)
+		}
+	}
+	return c
+}
+
+func decodeYAML(b []byte, c *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err := dec.Decode(c)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// setting - leaf field of Config
+type setting struct {
+	path   string // YAML path
+	env    string
+	flag   string
+	secret bool
+	value  reflect.Value
+}
+
+func (s setting) set(v string) error {
+	parsed, err := parseValue(s.value.Type(), v)
+	if err != nil {
+		return err
+	}
+	s.value.Set(parsed)
+	return nil
+}
+
+// fields returns settings of c ordered as in Config
+func fields(c *Config) []setting {
+	var res []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key)
+				continue
+			}
+			res = append(res, setting{
+				path:   key,
+				env:    envPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(key)),
+				flag:   strings.ReplaceAll(key, "_", "-"),
+				secret: f.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return res
+}
+
+// flagValue validates flag value and records it, so it is applied after file and environment
+type flagValue struct {
+	setting
+	values map[string]string
+}
+
+func (f flagValue) String() string {
+	return ""
+}
+
+func (f flagValue) Set(v string) error {
+	if _, err := parseValue(f.value.Type(), v); err != nil {
+		return err
+	}
+	f.values[f.path] = v
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseValue parses v to value of type t, lists are comma-separated
+func parseValue(t reflect.Type, v string) (reflect.Value, error) {
+	if t == durationType {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid duration %q", v)
+		}
+		return reflect.ValueOf(d), nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(v), nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid boolean %q", v)
+		}
+		return reflect.ValueOf(b), nil
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid integer %q", v)
+		}
+		return reflect.ValueOf(n).Convert(t), nil
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid number %q", v)
+		}
+		return reflect.ValueOf(f), nil
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return reflect.ValueOf(list), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported setting type %s", t)
+}
@@ -1,7 +1,60 @@
 package consumer
 
+import (
+	"errors"
+	"time"
+)
+
+// Error codes
+const (
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeTooManyAttempts    = "too_many_attempts"
+	CodeAccountLocked      = "account_locked"
+	CodeNotFound           = "not_found"
+	CodeInvalidToken       = "invalid_token"
+	CodeTokenRequired      = "token_required"
+	CodeTokenExpired       = "token_expired"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeReauthRequired     = "reauthentication_required"
+	CodeInvalidClient      = "invalid_client"
+
+	CodeInvalidRedirectURI      = "invalid_redirect_uri"
+	CodeInvalidGrant            = "invalid_grant"
+	CodeInvalidScope            = "invalid_scope"
+	CodeUnsupportedGrantType    = "unsupported_grant_type"
+	CodeUnsupportedResponseType = "unsupported_response_type"
+
+	CodeAccountSuspended        = "account_suspended"
+	CodeAccountSecurityLocked   = "account_security_locked"
+	CodeAccountPendingDeletion  = "account_pending_deletion"
+	CodeInvalidStatusTransition = "invalid_status_transition"
+)
+
 // Error interface for application error
 type Error interface {
 	error
 	UserError() bool
 }
+
+// RetryableError interface for application error which is resolved by waiting
+type RetryableError interface {
+	Error
+	RetryAfter() time.Duration
+}
+
+// CodedError interface for application error with machine-readable code
+type CodedError interface {
+	Error
+	ErrorCode() string
+}
+
+// ErrorCode returns the first non-empty code found in err's chain
+func ErrorCode(err error) string {
+	for err != nil {
+		if e, ok := err.(CodedError); ok && e.ErrorCode() != "" {
+			return e.ErrorCode()
+		}
+		err = errors.Unwrap(err)
+	}
+	return ""
+}
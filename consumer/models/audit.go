@@ -0,0 +1,139 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const auditSaltSize = 16
+
+// Audit event types
+const (
+	AuditRegistered        = "registered"
+	AuditLoginSucceeded    = "login_succeeded"
+	AuditLoginFailed       = "login_failed"
+	AuditMFAFailed         = "mfa_failed"
+	AuditTokenIssued       = "token_issued"
+	AuditTokensRevoked     = "tokens_revoked"
+	AuditCredentialChanged = "credential_changed"
+	AuditStatusChanged     = "status_changed"
+)
+
+// AuditEvent - record of security audit log. Records are chained by hashes, so modification
+// or removal of any record breaks the chain. Personal data (email, IP, user agent) is committed
+// to the chain by salted digest, so it can be erased without breaking the chain.
+type AuditEvent struct {
+	ID         int               `json:"id"`
+	Type       string            `json:"type"`
+	ConsumerID int               `json:"consumer_id,omitempty"`
+	ActorID    int               `json:"actor_id,omitempty"` // zero if action was made by the consumer himself or by the system
+	Email      string            `json:"email,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Details    map[string]string `json:"details,omitempty"` // must not contain personal data
+	CreatedAt  int64             `json:"created_at"`
+
+	PIISalt   []byte `json:"-"` // erased with personal data
+	PIIDigest string `json:"pii_digest,omitempty"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+}
+
+// SealPII commits event's personal data to PIIDigest
+func (e *AuditEvent) SealPII() error {
+	e.PIISalt, e.PIIDigest = nil, ""
+	if e.Email == "" && e.IP == "" && e.UserAgent == "" {
+		return nil
+	}
+	e.PIISalt = make([]byte, auditSaltSize)
+	if _, err := rand.Read(e.PIISalt); err != nil {
+		return err
+	}
+	e.PIIDigest = e.piiDigest()
+	return nil
+}
+
+// ErasePII removes event's personal data, digest is kept so the chain stays valid
+func (e *AuditEvent) ErasePII() {
+	e.Email, e.IP, e.UserAgent, e.PIISalt = "", "", "", nil
+}
+
+// Seal links event to previous one in the chain
+func (e *AuditEvent) Seal(prevHash string) {
+	e.PrevHash = prevHash
+	e.Hash = e.hash()
+}
+
+func (e AuditEvent) piiDigest() string {
+	h := sha256.New()
+	h.Write(e.PIISalt)
+	for _, v := range []string{e.Email, e.IP, e.UserAgent} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (e AuditEvent) hash() string {
+	b, _ := json.Marshal(struct {
+		ID         int               `json:"id"`
+		Type       string            `json:"type"`
+		ConsumerID int               `json:"consumer_id"`
+		ActorID    int               `json:"actor_id"`
+		Details    map[string]string `json:"details"`
+		CreatedAt  int64             `json:"created_at"`
+		PIIDigest  string            `json:"pii_digest"`
+		PrevHash   string            `json:"prev_hash"`
+	}{e.ID, e.Type, e.ConsumerID, e.ActorID, e.Details, e.CreatedAt, e.PIIDigest, e.PrevHash})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain returns error if events ordered by id don't form unbroken chain which continues prevHash
+func VerifyAuditChain(prevHash string, events []AuditEvent) error {
+	for _, e := range events {
+		if e.PrevHash != prevHash {
+			return fmt.Errorf("event %d: chain is broken", e.ID)
+		}
+		if e.hash() != e.Hash {
+			return fmt.Errorf("event %d: hash mismatch", e.ID)
+		}
+		if len(e.PIISalt) != 0 && e.piiDigest() != e.PIIDigest {
+			return fmt.Errorf("event %d: personal data digest mismatch", e.ID)
+		}
+		if len(e.PIISalt) == 0 && (e.Email != "" || e.IP != "" || e.UserAgent != "") {
+			return fmt.Errorf("event %d: personal data isn't sealed", e.ID)
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
+
+// AuditFilter - filter of audit log
+type AuditFilter struct {
+	ConsumerID int    // zero means any consumer
+	Type       string // empty means any type
+}
+
+// Match reports whether event satisfies filter
+func (f AuditFilter) Match(e AuditEvent) bool {
+	return (f.ConsumerID == 0 || e.ConsumerID == f.ConsumerID) && (f.Type == "" || e.Type == f.Type)
+}
+
+// AuditPage - page of audit log
+type AuditPage struct {
+	Events     []AuditEvent
+	NextCursor string // empty if there are no more events
+}
+
+// Validate audit event before appending it to the log
+func (e AuditEvent) Validate() error {
+	if e.Type == "" {
+		return errors.New("empty event type")
+	}
+	return nil
+}
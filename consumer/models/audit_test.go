@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func auditChain(t *testing.T) []AuditEvent {
+	events := []AuditEvent{
+		{ID: 1, Type: AuditRegistered, ConsumerID: 1, Email: "test@test.com", IP: "127.0.0.1", CreatedAt: 10},
+		{ID: 2, Type: AuditLoginSucceeded, ConsumerID: 1, Details: map[string]string{"method": "password"}, CreatedAt: 11},
+		{ID: 3, Type: AuditLoginFailed, ConsumerID: 2, UserAgent: "curl", Details: map[string]string{"reason": "invalid_password"}, CreatedAt: 12},
+	}
+	prevHash := ""
+	for i := range events {
+		require.NoError(t, events[i].SealPII())
+		events[i].Seal(prevHash)
+		prevHash = events[i].Hash
+	}
+	return events
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	events := auditChain(t)
+	require.NoError(t, VerifyAuditChain("", events))
+	require.NoError(t, VerifyAuditChain(events[0].Hash, events[1:]))
+	require.Empty(t, events[1].PIIDigest)
+	require.NotEmpty(t, events[0].PIIDigest)
+
+	events[0].ErasePII()
+	require.NoError(t, VerifyAuditChain("", events))
+}
+
+func TestVerifyAuditChainTampered(t *testing.T) {
+	tests := map[string]func([]AuditEvent) []AuditEvent{
+		"type changed": func(e []AuditEvent) []AuditEvent {
+			e[1].Type = AuditLoginFailed
+			return e
+		},
+		"details changed": func(e []AuditEvent) []AuditEvent {
+			e[2].Details["reason"] = "throttled"
+			return e
+		},
+		"ip changed": func(e []AuditEvent) []AuditEvent {
+			e[0].IP = "10.0.0.1"
+			return e
+		},
+		"pii added to erased event": func(e []AuditEvent) []AuditEvent {
+			e[0].ErasePII()
+			e[0].Email = "other@test.com"
+			return e
+		},
+		"event removed": func(e []AuditEvent) []AuditEvent {
+			return append(e[:1], e[2:]...)
+		},
+		"events reordered": func(e []AuditEvent) []AuditEvent {
+			e[1], e[2] = e[2], e[1]
+			return e
+		},
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Error(t, VerifyAuditChain("", tamper(auditChain(t))))
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package models
+
+// DeletionForm is used by consumer to request deletion of his account. Password re-authenticates
+// consumer who didn't log in recently, it is accepted only if his account has no second factor.
+type DeletionForm struct {
+	Pass   string
+	Client ClientInfo
+}
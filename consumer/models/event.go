@@ -0,0 +1,156 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// piiFields - fields of events which contain personal data
+var piiFields = map[string]bool{"email": true, "old_email": true}
+
+// Domain event types
+const (
+	EventConsumerRegistered = "consumer.registered"
+	EventConsumerVerified   = "consumer.verified"
+	EventPasswordChanged    = "consumer.password_changed"
+	EventEmailChanged       = "consumer.email_changed"
+	EventConsumerDeleted    = "consumer.deleted"
+)
+
+// DomainEvent - change of consumer's account which other services can react to
+type DomainEvent interface {
+	EventType() string
+	EventConsumerID() int
+}
+
+// ConsumerRegistered is published when new account is created
+type ConsumerRegistered struct {
+	ConsumerID   int    `json:"consumer_id"`
+	Email        string `json:"email"`
+	RegisteredAt int64  `json:"registered_at"`
+}
+
+// EventType implements DomainEvent
+func (e ConsumerRegistered) EventType() string { return EventConsumerRegistered }
+
+// EventConsumerID implements DomainEvent
+func (e ConsumerRegistered) EventConsumerID() int { return e.ConsumerID }
+
+// ConsumerVerified is published when consumer confirms ownership of email address
+type ConsumerVerified struct {
+	ConsumerID int    `json:"consumer_id"`
+	Email      string `json:"email"`
+	VerifiedAt int64  `json:"verified_at"`
+}
+
+// EventType implements DomainEvent
+func (e ConsumerVerified) EventType() string { return EventConsumerVerified }
+
+// EventConsumerID implements DomainEvent
+func (e ConsumerVerified) EventConsumerID() int { return e.ConsumerID }
+
+// PasswordChanged is published when consumer changes his password
+type PasswordChanged struct {
+	ConsumerID int   `json:"consumer_id"`
+	ChangedAt  int64 `json:"changed_at"`
+}
+
+// EventType implements DomainEvent
+func (e PasswordChanged) EventType() string { return EventPasswordChanged }
+
+// EventConsumerID implements DomainEvent
+func (e PasswordChanged) EventConsumerID() int { return e.ConsumerID }
+
+// EmailChanged is published when consumer changes his email, new email isn't verified yet
+type EmailChanged struct {
+	ConsumerID int    `json:"consumer_id"`
+	OldEmail   string `json:"old_email"`
+	Email      string `json:"email"`
+	ChangedAt  int64  `json:"changed_at"`
+}
+
+// EventType implements DomainEvent
+func (e EmailChanged) EventType() string { return EventEmailChanged }
+
+// EventConsumerID implements DomainEvent
+func (e EmailChanged) EventConsumerID() int { return e.ConsumerID }
+
+// ConsumerDeleted is published when consumer's personal data is erased,
+// subscribers must erase their copies of it
+type ConsumerDeleted struct {
+	ConsumerID int   `json:"consumer_id"`
+	DeletedAt  int64 `json:"deleted_at"`
+}
+
+// EventType implements DomainEvent
+func (e ConsumerDeleted) EventType() string { return EventConsumerDeleted }
+
+// EventConsumerID implements DomainEvent
+func (e ConsumerDeleted) EventConsumerID() int { return e.ConsumerID }
+
+// OutboxMessage - domain event saved together with the change which caused it and waiting to be published
+type OutboxMessage struct {
+	ID          int // assigned by store, subscribers may use it to drop duplicates
+	Type        string
+	ConsumerID  int
+	Payload     []byte // JSON encoded event
+	CreatedAt   int64
+	PublishedAt int64 // zero until message is published
+}
+
+// NewOutboxMessage encodes event to outbox message
+func NewOutboxMessage(e DomainEvent, createdAt int64) (OutboxMessage, error) {
+	if e == nil {
+		return OutboxMessage{}, errors.New("empty event")
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+	return OutboxMessage{
+		Type:       e.EventType(),
+		ConsumerID: e.EventConsumerID(),
+		Payload:    b,
+		CreatedAt:  createdAt,
+	}, nil
+}
+
+// ErasePII removes personal data from message's payload
+func (m *OutboxMessage) ErasePII() {
+	m.Payload = erasePayloadPII(m.Payload)
+}
+
+// erasePayloadPII removes personal data fields at any depth of JSON encoded event,
+// so envelopes which carry event are erased as well. Payload which isn't JSON object is returned unchanged.
+func erasePayloadPII(payload []byte) []byte {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	var v map[string]interface{}
+	if err := d.Decode(&v); err != nil {
+		return payload
+	}
+	eraseFields(v)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return payload
+	}
+	return b
+}
+
+func eraseFields(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, f := range v {
+			if piiFields[k] {
+				delete(v, k)
+				continue
+			}
+			eraseFields(f)
+		}
+	case []interface{}:
+		for _, f := range v {
+			eraseFields(f)
+		}
+	}
+}
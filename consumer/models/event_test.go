@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxMessageErasePII(t *testing.T) {
+	m, err := NewOutboxMessage(ConsumerRegistered{ConsumerID: 1, Email: "test@test.com", RegisteredAt: 1600000000123}, 10)
+	require.NoError(t, err)
+
+	m.ErasePII()
+	require.JSONEq(t, `{"consumer_id":1,"registered_at":1600000000123}`, string(m.Payload))
+
+	m, err = NewOutboxMessage(EmailChanged{ConsumerID: 1, OldEmail: "old@test.com", Email: "new@test.com", ChangedAt: 10}, 10)
+	require.NoError(t, err)
+
+	m.ErasePII()
+	require.JSONEq(t, `{"consumer_id":1,"changed_at":10}`, string(m.Payload), "both addresses are erased")
+}
+
+func TestWebhookDeliveryErasePII(t *testing.T) {
+	d := WebhookDelivery{Payload: []byte(`{"id":1,"type":"consumer.registered","data":{"consumer_id":1,"email":"test@test.com"}}`)}
+
+	d.ErasePII()
+	require.JSONEq(t, `{"id":1,"type":"consumer.registered","data":{"consumer_id":1}}`, string(d.Payload))
+
+	d.Payload = []byte("not json")
+	d.ErasePII()
+	require.Equal(t, "not json", string(d.Payload), "payload which isn't JSON object is kept")
+}
@@ -0,0 +1,74 @@
+package models
+
+import "errors"
+
+// Export formats
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZip  = "zip" // zip archive with single JSON file
+)
+
+// Export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Export - archive of consumer's personal data
+type Export struct {
+	ID         int
+	ConsumerID int
+	Format     string
+	Status     string
+	Data       []byte // archive content, set when export is ready
+	CreatedAt  int64
+	ExpiresAt  int64 // archive is not available for download after this time
+}
+
+// ExportForm is used to request export of consumer's personal data
+type ExportForm struct {
+	Format string
+}
+
+// Validate export form
+func (f ExportForm) Validate() error {
+	if f.Format != ExportFormatJSON && f.Format != ExportFormatZip {
+		return errors.New("format must be json or zip")
+	}
+	return nil
+}
+
+// PersonalData - everything stored about consumer, it is content of export archive
+type PersonalData struct {
+	ExportedAt    int64          `json:"exported_at"`
+	Consumer      PublicConsumer `json:"consumer"`
+	Roles         []string       `json:"roles"`
+	StatusHistory []StatusChange `json:"status_history"`
+	TOTP          *TOTPInfo      `json:"totp,omitempty"`
+	Passkeys      []PasskeyInfo  `json:"passkeys"`
+	OAuthGrants   []OAuthGrant   `json:"oauth_grants"`
+	AuditEvents   []AuditEvent   `json:"audit_events"`
+}
+
+// TOTPInfo - consumer's authenticator app factor without secrets
+type TOTPInfo struct {
+	Confirmed         bool `json:"confirmed"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// OAuthGrant - client which consumer authorized and which still holds refresh token, without the token
+type OAuthGrant struct {
+	ClientID   string   `json:"client_id"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`   // when client was authorized first
+	LastUsedAt int64    `json:"last_used_at"` // when client refreshed access last time
+}
+
+// PasskeyInfo - consumer's passkey without key material
+type PasskeyInfo struct {
+	ID         string `json:"id"` // base64url encoded credential id
+	AAGUID     string `json:"aaguid,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at,omitempty"`
+}
@@ -7,19 +7,48 @@ import (
 
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// Consumer roles
+const (
+	RoleConsumer = "consumer"
+	RoleAdmin    = "admin"
+)
+
 // Consumer model
 type Consumer struct {
-	ID           int
-	Email        string
-	RegTimestamp int64
-	PassHash     string
+	ID            int
+	Email         string
+	EmailVerified bool
+	RegTimestamp  int64
+	PassHash      string `json:"-"`
+	Roles         []string
+	Profile       Profile
+	Status        string
+	Version       int   // incremented on each update, used for optimistic concurrency
+	DeletedAt     int64 // soft deletion time
+
+	DeletionScheduledAt int64 // time when account pending deletion is erased
+	TokensRevokedAt     int64 // tokens issued at or before this time are not valid
+}
+
+// Public returns representation of consumer which is safe to show to the consumer
+func (c Consumer) Public() PublicConsumer {
+	return PublicConsumer{
+		ID:                  c.ID,
+		Email:               c.Email,
+		EmailVerified:       c.EmailVerified,
+		RegTimestamp:        c.RegTimestamp,
+		Status:              c.EffectiveStatus(),
+		DeletionScheduledAt: c.DeletionScheduledAt,
+		Profile:             c.Profile,
+	}
 }
 
 // RegFrom is used in registration process
 type RegFrom struct {
-	Email string
-	Pass1 string
-	Pass2 string
+	Email  string
+	Pass1  string
+	Pass2  string
+	Client ClientInfo
 }
 
 // Validate registration form
@@ -39,10 +68,18 @@ func (f RegFrom) Validate() error {
 	return nil
 }
 
+// ClientInfo describes client which sent request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
 // AuthForm is used in authentication process
 type AuthForm struct {
-	Email string
-	Pass  string
+	Email  string
+	Pass   string
+	Scopes []string
+	Client ClientInfo
 }
 
 // Validate authentication form
@@ -58,3 +95,109 @@ func (f AuthForm) Validate() error {
 	}
 	return nil
 }
+
+// AuthResult is returned by authentication process.
+// If MFARequired is set, Token is short-lived and must be exchanged for access token with MFAForm.
+type AuthResult struct {
+	Token       string
+	MFARequired bool
+}
+
+// MFAForm is used in second step of authentication
+type MFAForm struct {
+	Token  string
+	Code   string
+	Client ClientInfo
+}
+
+// Validate second factor form
+func (f MFAForm) Validate() error {
+	if f.Token == "" {
+		return errors.New("empty token")
+	}
+	if f.Code == "" {
+		return errors.New("empty code")
+	}
+	return nil
+}
+
+// PasskeyLoginForm is used to start passwordless authentication
+type PasskeyLoginForm struct {
+	Email  string
+	Scopes []string
+}
+
+// Validate passkey login form
+func (f PasskeyLoginForm) Validate() error {
+	if f.Email == "" {
+		return errors.New("empty email")
+	}
+	if !emailRegex.MatchString(f.Email) {
+		return errors.New("email is not valid")
+	}
+	return nil
+}
+
+// Passwordless login methods
+const (
+	LoginMethodLink = "link"
+	LoginMethodCode = "code"
+)
+
+// LoginRequestForm is used to request login link or code by email
+type LoginRequestForm struct {
+	Email  string
+	Method string
+	Client ClientInfo
+}
+
+// Validate login request form
+func (f LoginRequestForm) Validate() error {
+	if f.Email == "" {
+		return errors.New("empty email")
+	}
+	if !emailRegex.MatchString(f.Email) {
+		return errors.New("email is not valid")
+	}
+	if f.Method != LoginMethodLink && f.Method != LoginMethodCode {
+		return errors.New("unknown login method")
+	}
+	return nil
+}
+
+// LoginLinkForm is used to authenticate with token from login link
+type LoginLinkForm struct {
+	Token  string
+	Scopes []string
+	Client ClientInfo
+}
+
+// Validate login link form
+func (f LoginLinkForm) Validate() error {
+	if f.Token == "" {
+		return errors.New("empty token")
+	}
+	return nil
+}
+
+// LoginCodeForm is used to authenticate with code sent by email
+type LoginCodeForm struct {
+	Email  string
+	Code   string
+	Scopes []string
+	Client ClientInfo
+}
+
+// Validate login code form
+func (f LoginCodeForm) Validate() error {
+	if f.Email == "" {
+		return errors.New("empty email")
+	}
+	if !emailRegex.MatchString(f.Email) {
+		return errors.New("email is not valid")
+	}
+	if f.Code == "" {
+		return errors.New("empty code")
+	}
+	return nil
+}
@@ -86,3 +86,40 @@ func TestAuthFormValidateSuccess(t *testing.T) {
 	err := form.Validate()
 	require.NoError(t, err)
 }
+
+func TestLoginRequestFormValidate(t *testing.T) {
+	for _, tst := range []LoginRequestForm{
+		{Email: "", Method: LoginMethodCode},
+		{Email: "test", Method: LoginMethodCode},
+		{Email: "test@test.com", Method: ""},
+		{Email: "test@test.com", Method: "sms"},
+	} {
+		err := tst.Validate()
+		require.Error(t, err, "case: ", tst)
+	}
+	require.NoError(t, LoginRequestForm{Email: "test@test.com", Method: LoginMethodLink}.Validate())
+	require.NoError(t, LoginRequestForm{Email: "test@test.com", Method: LoginMethodCode}.Validate())
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	p := PasswordPolicy{MinLength: 3, MaxLength: 4}
+	require.Error(t, p.Check("пп"))
+	require.NoError(t, p.Check("ппп"))
+	require.NoError(t, p.Check("пппп"))
+	require.Error(t, p.Check("ппппп"))
+	require.NoError(t, PasswordPolicy{}.Check(""))
+}
+
+func TestPasswordChangeFormValidate(t *testing.T) {
+	require.Error(t, PasswordChangeForm{NewPass: "new"}.Validate())
+	require.Error(t, PasswordChangeForm{Pass: "old"}.Validate())
+	require.Error(t, PasswordChangeForm{Pass: "old", NewPass: "old"}.Validate())
+	require.NoError(t, PasswordChangeForm{Pass: "old", NewPass: "new"}.Validate())
+}
+
+func TestEmailChangeFormValidate(t *testing.T) {
+	require.Error(t, EmailChangeForm{Pass: "pass"}.Validate())
+	require.Error(t, EmailChangeForm{Email: "test", Pass: "pass"}.Validate())
+	require.Error(t, EmailChangeForm{Email: "test@test.com"}.Validate())
+	require.NoError(t, EmailChangeForm{Email: "test@test.com", Pass: "pass"}.Validate())
+}
@@ -0,0 +1,51 @@
+package models
+
+import "errors"
+
+// MaxPageLimit - max count of items returned in single page
+const MaxPageLimit = 100
+
+// ConsumerFilter - filter of consumers list
+type ConsumerFilter struct {
+	CreatedFrom int64 // inclusive registration time, zero means unbounded
+	CreatedTo   int64 // exclusive registration time, zero means unbounded
+	Verified    *bool
+	Status      string // empty means any status
+}
+
+// Match reports whether consumer satisfies filter
+func (f ConsumerFilter) Match(c Consumer) bool {
+	if f.CreatedFrom != 0 && c.RegTimestamp < f.CreatedFrom {
+		return false
+	}
+	if f.CreatedTo != 0 && c.RegTimestamp >= f.CreatedTo {
+		return false
+	}
+	if f.Verified != nil && c.EmailVerified != *f.Verified {
+		return false
+	}
+	if f.Status != "" && c.EffectiveStatus() != f.Status {
+		return false
+	}
+	return true
+}
+
+// PageRequest - cursor pagination parameters
+type PageRequest struct {
+	Cursor string // opaque cursor returned with previous page, empty for the first page
+	Limit  int
+}
+
+// Validate page request
+func (r PageRequest) Validate() error {
+	if r.Limit <= 0 || r.Limit > MaxPageLimit {
+		return errors.New("limit is out of range")
+	}
+	return nil
+}
+
+// ConsumerPage - page of consumers list
+type ConsumerPage struct {
+	Consumers  []Consumer
+	NextCursor string // empty if there are no more consumers
+}
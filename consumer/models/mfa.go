@@ -0,0 +1,36 @@
+package models
+
+// TOTPFactor - consumer's time-based one-time password factor
+type TOTPFactor struct {
+	ConsumerID    int
+	Secret        string
+	Confirmed     bool
+	LastUsedStep  int64
+	RecoveryCodes []string // hashes of unused recovery codes
+	Version       int      // incremented on each save, used for optimistic concurrency
+}
+
+// TOTPEnrollment contains data required to set up authenticator app
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+	QRCode []byte // PNG image
+}
+
+// WebAuthnCredential - consumer's public key credential (passkey or security key)
+type WebAuthnCredential struct {
+	ID         []byte
+	ConsumerID int
+	PublicKey  []byte // COSE_Key
+	SignCount  uint32
+	AAGUID     []byte
+	CreatedAt  int64
+	LastUsedAt int64
+}
+
+// WebAuthnChallenge - challenge of started WebAuthn ceremony, it is deleted when ceremony is finished
+type WebAuthnChallenge struct {
+	Challenge  string // base64url encoded
+	ConsumerID int
+	ExpiresAt  int64
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxClientNameLength  = 64
+	maxRedirectURIs      = 10
+	maxRedirectURILength = 2000
+	maxNonceLength       = 512
+)
+
+// OAuth grant and response types
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+
+	ResponseTypeCode = "code"
+
+	CodeChallengeS256 = "S256"
+)
+
+// codeVerifierRegex matches PKCE code verifier and S256 code challenge as defined by RFC 7636
+var codeVerifierRegex = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// OAuthClient - application which authenticates to OAuth endpoints with client credentials.
+// Public clients (browser and mobile apps) can't keep secret, they have no secret and use authorization code flow only.
+type OAuthClient struct {
+	ID           string   `json:"client_id"`
+	SecretHash   string   `json:"-"`
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	CreatedAt    int64    `json:"created_at"`
+}
+
+// HasRedirectURI reports whether uri is registered for client, uris are compared exactly
+func (c OAuthClient) HasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthClientForm is used to register OAuth client.
+// Clients without redirect uris can only introspect tokens.
+type OAuthClientForm struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// Validate OAuth client form
+func (f OAuthClientForm) Validate() error {
+	if f.Name == "" {
+		return errors.New("empty name")
+	}
+	if utf8.RuneCountInString(f.Name) > maxClientNameLength {
+		return errors.New("name is too long")
+	}
+	for _, r := range f.Name {
+		if unicode.IsControl(r) {
+			return errors.New("name contains control characters")
+		}
+	}
+	if f.Public && len(f.RedirectURIs) == 0 {
+		return errors.New("public client must have redirect uris")
+	}
+	if len(f.RedirectURIs) > maxRedirectURIs {
+		return errors.New("too many redirect uris")
+	}
+	for _, u := range f.RedirectURIs {
+		if err := validateRedirectURI(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI allows absolute uris without fragment. Plain http is allowed for loopback hosts only,
+// other schemes are allowed for native apps.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || len(uri) > maxRedirectURILength || u.Scheme == "" || u.Fragment != "" || strings.Contains(uri, "#") {
+		return errors.New("redirect uri must be absolute uri without fragment")
+	}
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return errors.New("redirect uri must have host")
+		}
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return errors.New("http redirect uri is allowed for loopback hosts only")
+		}
+	}
+	return nil
+}
+
+// AuthorizationRequest - request of client to authorize it on behalf of consumer, as defined by RFC 6749.
+// PKCE with S256 method is required from all clients.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string // OpenID Connect nonce, it is returned in ID token
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Scopes returns list of requested scopes
+func (r AuthorizationRequest) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+// Validate authorization request parameters, client and redirect uri are checked by caller
+func (r AuthorizationRequest) Validate() error {
+	if r.CodeChallenge == "" {
+		return errors.New("code_challenge is required")
+	}
+	if r.CodeChallengeMethod != CodeChallengeS256 {
+		return errors.New("code_challenge_method must be " + CodeChallengeS256)
+	}
+	if !codeVerifierRegex.MatchString(r.CodeChallenge) {
+		return errors.New("invalid code_challenge")
+	}
+	if len(r.Nonce) > maxNonceLength {
+		return errors.New("nonce is too long")
+	}
+	return nil
+}
+
+// Redirect returns redirect uri of request with params and state added to its query
+func (r AuthorizationRequest) Redirect(params url.Values) string {
+	u, err := url.Parse(r.RedirectURI)
+	if err != nil {
+		return r.RedirectURI
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if r.State != "" {
+		q.Set("state", r.State)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// AuthorizationResult is returned when consumer authorized client.
+// If MFARequired is set, authorization must be completed with second factor which TOTP and Passkey report:
+// MFAToken is sent with second factor code or used to start passkey assertion.
+// Otherwise consumer must be redirected to RedirectURI which carries authorization code.
+type AuthorizationResult struct {
+	RedirectURI string
+	MFAToken    string
+	MFARequired bool
+	TOTP        bool
+	Passkey     bool
+}
+
+// OAuthCode - authorization code issued to client, it is exchanged for tokens once
+type OAuthCode struct {
+	Hash          string
+	ClientID      string
+	ConsumerID    int
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     int64
+
+	// Nonce, AuthTime and AMR (authentication methods) are returned in ID token
+	Nonce    string
+	AuthTime int64
+	AMR      []string
+}
+
+// RefreshToken - refresh token issued to client, only its hash is stored. Token is replaced by a new one
+// of the same family on every use, used token is kept until it expires, so its reuse is detected.
+type RefreshToken struct {
+	Hash       string
+	FamilyID   string // hash of the first token of family which is issued for authorization code
+	ClientID   string
+	ConsumerID int
+	Scope      string
+	CreatedAt  int64
+	ExpiresAt  int64
+	UsedAt     int64
+}
+
+// TokenRequest - request to token endpoint as defined by RFC 6749
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string // refresh token may be exchanged for token with narrower scope
+}
+
+// Validate token request, grant type is checked by caller
+func (r TokenRequest) Validate() error {
+	if r.ClientID == "" {
+		return errors.New("client_id is required")
+	}
+	switch r.GrantType {
+	case GrantAuthorizationCode:
+		if r.Code == "" || r.RedirectURI == "" {
+			return errors.New("code and redirect_uri are required")
+		}
+		if !codeVerifierRegex.MatchString(r.CodeVerifier) {
+			return errors.New("invalid code_verifier")
+		}
+	case GrantRefreshToken:
+		if r.RefreshToken == "" {
+			return errors.New("refresh_token is required")
+		}
+	}
+	return nil
+}
+
+// TokenResponse - successful response of token endpoint as defined by RFC 6749
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // issued for authorization code with openid scope
+	Scope        string `json:"scope"`
+}
+
+// TokenIntrospection - state of token returned to resource servers as defined by RFC 7662,
+// only Active is set if token isn't active
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"` // client which token was issued to, empty for first-party logins
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
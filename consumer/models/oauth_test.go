@@ -0,0 +1,75 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuthClientFormValidate(t *testing.T) {
+	valid := []OAuthClientForm{
+		{Name: "introspection only"},
+		{Name: "web", RedirectURIs: []string{"https://app.test/callback?from=login"}},
+		{Name: "dev", Public: true, RedirectURIs: []string{"http://localhost:8080/cb", "http://127.0.0.1/cb", "http://[::1]:3000/cb"}},
+		{Name: "mobile", Public: true, RedirectURIs: []string{"com.example.app:/oauth"}},
+	}
+	for _, f := range valid {
+		require.NoError(t, f.Validate(), f)
+	}
+
+	invalid := []OAuthClientForm{
+		{Name: ""},
+		{Name: strings.Repeat("a", maxClientNameLength+1)},
+		{Name: "a\tb"},
+		{Name: "spa", Public: true},
+		{Name: "web", RedirectURIs: []string{"/callback"}},
+		{Name: "web", RedirectURIs: []string{"https://app.test/cb#fragment"}},
+		{Name: "web", RedirectURIs: []string{"https://app.test/cb#"}},
+		{Name: "web", RedirectURIs: []string{"http://app.test/cb"}},
+		{Name: "web", RedirectURIs: []string{"https:///cb"}},
+		{Name: "web", RedirectURIs: make([]string, maxRedirectURIs+1)},
+	}
+	for _, f := range invalid {
+		require.Error(t, f.Validate(), f)
+	}
+}
+
+func TestAuthorizationRequest(t *testing.T) {
+	r := AuthorizationRequest{
+		RedirectURI:         "https://app.test/cb?from=login",
+		State:               "xyz",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: CodeChallengeS256,
+	}
+	require.NoError(t, r.Validate())
+
+	u, err := url.Parse(r.Redirect(url.Values{"code": {"abc"}}))
+	require.NoError(t, err)
+	require.Equal(t, "app.test", u.Host)
+	require.Equal(t, url.Values{"from": {"login"}, "code": {"abc"}, "state": {"xyz"}}, u.Query())
+
+	for _, bad := range []AuthorizationRequest{
+		{CodeChallengeMethod: CodeChallengeS256},
+		{CodeChallenge: r.CodeChallenge, CodeChallengeMethod: "plain"},
+		{CodeChallenge: "short", CodeChallengeMethod: CodeChallengeS256},
+	} {
+		require.Error(t, bad.Validate(), bad)
+	}
+}
+
+func TestTokenRequestValidate(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	require.NoError(t, TokenRequest{GrantType: GrantAuthorizationCode, ClientID: "c", Code: "x", RedirectURI: "https://app.test/cb", CodeVerifier: verifier}.Validate())
+	require.NoError(t, TokenRequest{GrantType: GrantRefreshToken, ClientID: "c", RefreshToken: "t"}.Validate())
+
+	for _, bad := range []TokenRequest{
+		{GrantType: GrantRefreshToken, RefreshToken: "t"},
+		{GrantType: GrantRefreshToken, ClientID: "c"},
+		{GrantType: GrantAuthorizationCode, ClientID: "c", RedirectURI: "https://app.test/cb", CodeVerifier: verifier},
+		{GrantType: GrantAuthorizationCode, ClientID: "c", Code: "x", RedirectURI: "https://app.test/cb", CodeVerifier: "short"},
+	} {
+		require.Error(t, bad.Validate(), bad)
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import "strconv"
+
+// OpenID Connect scopes which grant access to claims of UserInfo
+const (
+	scopeEmail   = "email"
+	scopeProfile = "profile"
+)
+
+// UserInfo - claims about consumer returned by OpenID Connect userinfo endpoint.
+// Claims are set only if scope which grants access to them was granted.
+type UserInfo struct {
+	Subject string `json:"sub"`
+
+	// email scope
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+
+	// profile scope
+	Name     string `json:"name,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	Zoneinfo string `json:"zoneinfo,omitempty"`
+	Picture  string `json:"picture,omitempty"`
+}
+
+// NewUserInfo returns claims about consumer allowed by scopes
+func NewUserInfo(c Consumer, scopes []string) UserInfo {
+	info := UserInfo{Subject: strconv.Itoa(c.ID)}
+	for _, s := range scopes {
+		switch s {
+		case scopeEmail:
+			verified := c.EmailVerified
+			info.Email, info.EmailVerified = c.Email, &verified
+		case scopeProfile:
+			info.Name = c.Profile.DisplayName
+			info.Locale = c.Profile.Locale
+			info.Zoneinfo = c.Profile.Timezone
+			info.Picture = c.Profile.AvatarURL
+		}
+	}
+	return info
+}
+
+// OpenIDConfiguration - OpenID Connect provider metadata as defined by OpenID Connect Discovery 1.0
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
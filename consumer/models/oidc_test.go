@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUserInfo(t *testing.T) {
+	c := Consumer{
+		ID:            7,
+		Email:         "test@test.com",
+		EmailVerified: true,
+		Profile:       Profile{DisplayName: "Name", Locale: "en-US", Timezone: "Europe/Berlin", Phone: "+4930123456", AvatarURL: "https://cdn.test/a.png"},
+	}
+	for _, tt := range []struct {
+		scopes []string
+		want   string
+	}{
+		{scopes: []string{"openid"}, want: `{"sub": "7"}`},
+		{scopes: []string{"openid", "email"}, want: `{"sub": "7", "email": "test@test.com", "email_verified": true}`},
+		{scopes: []string{"openid", "profile"}, want: `{"sub": "7", "name": "Name", "locale": "en-US", "zoneinfo": "Europe/Berlin", "picture": "https://cdn.test/a.png"}`},
+		{scopes: []string{"openid", "profile:read"}, want: `{"sub": "7"}`},
+	} {
+		b, err := json.Marshal(NewUserInfo(c, tt.scopes))
+		require.NoError(t, err)
+		require.JSONEq(t, tt.want, string(b), tt.scopes)
+	}
+
+	c.EmailVerified = false
+	b, err := json.Marshal(NewUserInfo(c, []string{"email"}))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"sub": "7", "email": "test@test.com", "email_verified": false}`, string(b), "email_verified must be present when false")
+}
@@ -0,0 +1,21 @@
+package models
+
+// One-time token purposes
+const (
+	PurposeLoginLink = "login_link"
+	PurposeLoginCode = "login_code"
+)
+
+// OneTimeToken - hashed single-use secret sent to consumer's email
+type OneTimeToken struct {
+	ID         int
+	ConsumerID int
+	Email      string // address secret was sent to
+	Purpose    string
+	Hash       string
+	CreatedAt  int64
+	ExpiresAt  int64
+	UsedAt     int64
+	Attempts   int
+	Version    int // incremented on each save, used for optimistic concurrency
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// PasswordPolicy - requirements to new passwords, zero value doesn't restrict passwords
+type PasswordPolicy struct {
+	MinLength int // in characters
+	MaxLength int // in characters, zero means unlimited
+}
+
+// Check returns error if password doesn't satisfy policy
+func (p PasswordPolicy) Check(pass string) error {
+	n := utf8.RuneCountInString(pass)
+	if n < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && n > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters long", p.MaxLength)
+	}
+	return nil
+}
+
+// PasswordChangeForm is used by consumer to change his password, current password is always required
+type PasswordChangeForm struct {
+	Pass    string
+	NewPass string
+	Client  ClientInfo
+}
+
+// Validate password change form
+func (f PasswordChangeForm) Validate() error {
+	if f.Pass == "" {
+		return errors.New("empty password")
+	}
+	if f.NewPass == "" {
+		return errors.New("empty new password")
+	}
+	if f.NewPass == f.Pass {
+		return errors.New("new password must differ from current one")
+	}
+	return nil
+}
+
+// EmailChangeForm is used by consumer to change his email. Password is required because its hash depends on email.
+type EmailChangeForm struct {
+	Email  string
+	Pass   string
+	Client ClientInfo
+}
+
+// Validate email change form
+func (f EmailChangeForm) Validate() error {
+	if f.Email == "" {
+		return errors.New("empty email")
+	}
+	if !emailRegex.MatchString(f.Email) {
+		return errors.New("email is not valid")
+	}
+	if f.Pass == "" {
+		return errors.New("empty password")
+	}
+	return nil
+}
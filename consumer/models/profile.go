@@ -0,0 +1,152 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxAttributes        = 20
+	maxAttributeValueLen = 256
+)
+
+var (
+	localeRegex       = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	phoneRegex        = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	attributeKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+)
+
+// Profile - consumer's personal data
+type Profile struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Locale      string            `json:"locale,omitempty"`
+	Timezone    string            `json:"timezone,omitempty"`
+	Phone       string            `json:"phone,omitempty"`
+	AvatarURL   string            `json:"avatar_url,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// Validate profile
+func (p Profile) Validate() error {
+	if utf8.RuneCountInString(p.DisplayName) > maxDisplayNameLength {
+		return errors.New("display name is too long")
+	}
+	for _, r := range p.DisplayName {
+		if unicode.IsControl(r) {
+			return errors.New("display name contains control characters")
+		}
+	}
+	if p.Locale != "" && !localeRegex.MatchString(p.Locale) {
+		return errors.New("invalid locale")
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return errors.New("invalid timezone")
+		}
+	}
+	if p.Phone != "" && !phoneRegex.MatchString(p.Phone) {
+		return errors.New("phone must be in E.164 format")
+	}
+	if p.AvatarURL != "" {
+		u, err := url.Parse(p.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("avatar url must be absolute https url")
+		}
+	}
+	if len(p.Attributes) > maxAttributes {
+		return fmt.Errorf("too many attributes, max %d", maxAttributes)
+	}
+	for k, v := range p.Attributes {
+		if !attributeKeyRegex.MatchString(k) {
+			return fmt.Errorf("invalid attribute name %q", k)
+		}
+		if len(v) > maxAttributeValueLen {
+			return fmt.Errorf("attribute %q is too long", k)
+		}
+	}
+	return nil
+}
+
+// PublicConsumer - consumer's data which is safe to show to the consumer
+type PublicConsumer struct {
+	ID            int     `json:"id"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	RegTimestamp  int64   `json:"reg_timestamp"`
+	Status        string  `json:"status"`
+	Profile       Profile `json:"profile"`
+
+	DeletionScheduledAt int64 `json:"deletion_scheduled_at,omitempty"`
+}
+
+// ProfileUpdateForm is JSON Merge Patch (RFC 7396) document for consumer's profile
+type ProfileUpdateForm struct {
+	Patch []byte
+}
+
+// Validate profile update form
+func (f ProfileUpdateForm) Validate() error {
+	var v map[string]interface{}
+	if err := json.Unmarshal(f.Patch, &v); err != nil || v == nil {
+		return errors.New("patch must be json object")
+	}
+	return nil
+}
+
+// Apply patch to profile and returns validated result
+func (f ProfileUpdateForm) Apply(p Profile) (Profile, error) {
+	var patch map[string]interface{}
+	if err := json.Unmarshal(f.Patch, &patch); err != nil || patch == nil {
+		return Profile{}, errors.New("patch must be json object")
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return Profile{}, err
+	}
+	var target interface{}
+	if err := json.Unmarshal(b, &target); err != nil {
+		return Profile{}, err
+	}
+	if b, err = json.Marshal(mergePatch(target, patch)); err != nil {
+		return Profile{}, err
+	}
+
+	var res Profile
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return Profile{}, fmt.Errorf("invalid patch: %w", err)
+	}
+	if err := res.Validate(); err != nil {
+		return Profile{}, err
+	}
+	return res, nil
+}
+
+// mergePatch implements MergePatch function of RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileValidateFail(t *testing.T) {
+	for _, tst := range []Profile{
+		{DisplayName: "\x00name"},
+		{Locale: "english"},
+		{Timezone: "Mars/Olympus"},
+		{Phone: "89991234567"},
+		{AvatarURL: "http://example.com/a.png"},
+		{AvatarURL: "/a.png"},
+		{Attributes: map[string]string{"bad key": "v"}},
+	} {
+		err := tst.Validate()
+		require.Error(t, err, "case: ", tst)
+	}
+}
+
+func TestProfileUpdateFormApply(t *testing.T) {
+	profile := Profile{
+		DisplayName: "Name",
+		Locale:      "en-US",
+		Phone:       "+79991234567",
+		Attributes:  map[string]string{"a": "1", "b": "2"},
+	}
+	form := ProfileUpdateForm{Patch: []byte(`{
+		"display_name": "New name",
+		"phone": null,
+		"timezone": "Europe/Moscow",
+		"attributes": {"a": null, "c": "3"}
+	}`)}
+	require.NoError(t, form.Validate())
+
+	res, err := form.Apply(profile)
+	require.NoError(t, err)
+	require.Equal(t, Profile{
+		DisplayName: "New name",
+		Locale:      "en-US",
+		Timezone:    "Europe/Moscow",
+		Attributes:  map[string]string{"b": "2", "c": "3"},
+	}, res)
+	require.Equal(t, "+79991234567", profile.Phone, "source profile must not be changed")
+}
+
+func TestProfileUpdateFormApplyFail(t *testing.T) {
+	for _, patch := range []string{
+		`[]`,
+		`null`,
+		`{"unknown": "field"}`,
+		`{"display_name": 5}`,
+		`{"locale": "english"}`,
+	} {
+		form := ProfileUpdateForm{Patch: []byte(patch)}
+		_, err := form.Apply(Profile{})
+		require.Error(t, err, "case: ", patch)
+	}
+}
+
+func TestPublicConsumerHidesPassHash(t *testing.T) {
+	c := Consumer{ID: 1, Email: "test@test.com", PassHash: "secret"}
+	for _, v := range []interface{}{c, c.Public()} {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		require.NotContains(t, string(b), "secret")
+	}
+}
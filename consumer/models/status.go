@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxStatusReasonLength = 512
+
+// Account statuses
+const (
+	StatusActive          = "active"
+	StatusSuspended       = "suspended"        // disabled by administrator
+	StatusLocked          = "locked"           // disabled for security reasons, e.g. credentials are compromised
+	StatusPendingDeletion = "pending_deletion" // consumer requested deletion of his account
+	StatusDeleted         = "deleted"          // personal data is erased, account can't be restored
+)
+
+// statusTransitions lists statuses which account can be moved to from each status
+var statusTransitions = map[string][]string{
+	StatusActive:          {StatusSuspended, StatusLocked, StatusPendingDeletion},
+	StatusSuspended:       {StatusActive, StatusLocked, StatusPendingDeletion},
+	StatusLocked:          {StatusActive, StatusSuspended},
+	StatusPendingDeletion: {StatusActive, StatusDeleted},
+}
+
+// CanTransition reports whether account in status from can be moved to status to
+func CanTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveStatus returns consumer's status, consumers created before statuses were introduced are active
+func (c Consumer) EffectiveStatus() string {
+	if c.Status == "" {
+		return StatusActive
+	}
+	return c.Status
+}
+
+// StatusChange - record of consumer's status transition
+type StatusChange struct {
+	ID         int    `json:"id"`
+	ConsumerID int    `json:"consumer_id"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Reason     string `json:"reason,omitempty"`
+	ActorID    int    `json:"actor_id,omitempty"` // zero if status was changed by the consumer himself or by the system
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// StatusChangeForm is used by administrators to change consumer's status
+type StatusChangeForm struct {
+	Reason string `json:"reason"`
+}
+
+// Validate status change form
+func (f StatusChangeForm) Validate() error {
+	if strings.TrimSpace(f.Reason) == "" {
+		return errors.New("reason is required")
+	}
+	if utf8.RuneCountInString(f.Reason) > maxStatusReasonLength {
+		return errors.New("reason is too long")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{from: StatusActive, to: StatusSuspended, want: true},
+		{from: StatusActive, to: StatusLocked, want: true},
+		{from: StatusActive, to: StatusPendingDeletion, want: true},
+		{from: StatusActive, to: StatusActive, want: false},
+		{from: StatusSuspended, to: StatusActive, want: true},
+		{from: StatusLocked, to: StatusActive, want: true},
+		{from: StatusLocked, to: StatusPendingDeletion, want: false},
+		{from: StatusPendingDeletion, to: StatusActive, want: true},
+		{from: StatusPendingDeletion, to: StatusSuspended, want: false},
+		{from: StatusPendingDeletion, to: StatusDeleted, want: true},
+		{from: StatusActive, to: StatusDeleted, want: false},
+		{from: StatusDeleted, to: StatusActive, want: false},
+		{from: "unknown", to: StatusActive, want: false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, CanTransition(tt.from, tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
+
+func TestConsumerFilterStatus(t *testing.T) {
+	f := ConsumerFilter{Status: StatusActive}
+	require.True(t, f.Match(Consumer{}))
+	require.True(t, f.Match(Consumer{Status: StatusActive}))
+	require.False(t, f.Match(Consumer{Status: StatusSuspended}))
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // retries are exhausted or webhook is disabled
+)
+
+// Webhook - partner's subscription to domain events
+type Webhook struct {
+	ID                  int      `json:"id"`
+	URL                 string   `json:"url"`
+	Secret              string   `json:"-"`      // key of deliveries signature
+	Events              []string `json:"events"` // empty means all events
+	Enabled             bool     `json:"enabled"`
+	ConsecutiveFailures int      `json:"consecutive_failures"`
+	DisabledAt          int64    `json:"disabled_at,omitempty"` // set when webhook is disabled after failures
+	CreatedAt           int64    `json:"created_at"`
+}
+
+// Subscribed reports whether webhook receives events of type
+func (w Webhook) Subscribed(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookForm is used to create or update webhook
+type WebhookForm struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"` // nil keeps current state, new webhooks are enabled
+}
+
+// Validate webhook form
+func (f WebhookForm) Validate() error {
+	u, err := url.Parse(f.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("url must be absolute https url")
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && IsInternalIP(ip)) {
+		return errors.New("url must not point to internal address")
+	}
+	for _, e := range f.Events {
+		switch e {
+		case EventConsumerRegistered, EventConsumerVerified, EventPasswordChanged, EventEmailChanged, EventConsumerDeleted:
+		default:
+			return errors.New("unknown event type " + e)
+		}
+	}
+	return nil
+}
+
+// privateNetworks - ranges which aren't reachable from internet, besides loopback and link-local ones
+var privateNetworks = parseNetworks("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+// IsInternalIP reports whether ip is loopback, private, link-local, multicast or unspecified address.
+// Webhooks aren't delivered to such addresses, so they can't be used to reach internal services.
+func IsInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	res := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// WebhookDelivery - delivery of outbox message to webhook with log of attempts
+type WebhookDelivery struct {
+	ID            int              `json:"id"`
+	WebhookID     int              `json:"webhook_id"`
+	MessageID     int              `json:"message_id"` // outbox message id, it is unique for webhook
+	EventType     string           `json:"event_type"`
+	Payload       []byte           `json:"-"` // request body
+	Status        string           `json:"status"`
+	Tries         int              `json:"tries"` // attempts made since delivery was created or replayed
+	Attempts      []WebhookAttempt `json:"attempts"`
+	NextAttemptAt int64            `json:"next_attempt_at,omitempty"` // zero unless delivery is pending
+	CreatedAt     int64            `json:"created_at"`
+}
+
+// ErasePII removes personal data from event carried by delivery's request body
+func (d *WebhookDelivery) ErasePII() {
+	d.Payload = erasePayloadPII(d.Payload)
+}
+
+// WebhookAttempt - result of single delivery attempt
+type WebhookAttempt struct {
+	At         int64  `json:"at"`
+	StatusCode int    `json:"status_code,omitempty"` // zero if response wasn't received
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// WebhookDeliveryPage - page of webhook's delivery log
+type WebhookDeliveryPage struct {
+	Deliveries []WebhookDelivery
+	NextCursor string // empty if there are no more deliveries
+}
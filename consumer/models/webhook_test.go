@@ -0,0 +1,38 @@
+package models
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookFormValidate(t *testing.T) {
+	require.NoError(t, WebhookForm{URL: "https://partner.test/hook", Events: []string{EventConsumerVerified, EventPasswordChanged, EventEmailChanged}}.Validate())
+	require.NoError(t, WebhookForm{URL: "https://8.8.8.8/hook"}.Validate())
+
+	for _, u := range []string{
+		"http://partner.test/hook",
+		"https:///hook",
+		"https://localhost/hook",
+		"https://api.localhost/hook",
+		"https://127.0.0.1/hook",
+		"https://10.1.2.3/hook",
+		"https://192.168.0.1/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+		"https://[fd00::1]/hook",
+		"https://0.0.0.0/hook",
+	} {
+		require.Error(t, WebhookForm{URL: u}.Validate(), u)
+	}
+	require.Error(t, WebhookForm{URL: "https://partner.test", Events: []string{"consumer.unknown"}}.Validate())
+}
+
+func TestIsInternalIP(t *testing.T) {
+	require.True(t, IsInternalIP(net.ParseIP("172.20.0.1")))
+	require.True(t, IsInternalIP(net.ParseIP("::ffff:127.0.0.1")))
+	require.True(t, IsInternalIP(net.ParseIP("fe80::1")))
+	require.False(t, IsInternalIP(net.ParseIP("172.32.0.1")))
+	require.False(t, IsInternalIP(net.ParseIP("2001:4860:4860::8888")))
+}
@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultVaultTimeout = 10 * time.Second
+
+// File reads secret from file, reference is file url like "///run/secrets/jwt".
+// Trailing line breaks are trimmed.
+type File struct{}
+
+// Get reads secret file
+func (File) Get(_ context.Context, ref string) ([]byte, error) {
+	u, err := url.Parse("file:" + ref)
+	if err != nil || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return nil, errors.New("reference must be absolute file url")
+	}
+	b, err := ioutil.ReadFile(u.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// Env reads secret from environment variable, reference is variable name
+type Env struct {
+	LookupEnv func(string) (string, bool) // os.LookupEnv is used if nil
+}
+
+// Get returns value of environment variable
+func (e Env) Get(_ context.Context, ref string) ([]byte, error) {
+	lookup := e.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	v, ok := lookup(ref)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(v), nil
+}
+
+// Vault reads secret from key-value engine of Vault-compatible HTTP API,
+// reference is path with key like "secret/data/consumer#signing_key"
+type Vault struct {
+	Addr   string // e.g. "https://vault:8200"
+	Token  string
+	Client *http.Client // client with 10 seconds timeout is used if nil
+}
+
+// Get requests secret from Vault
+func (v Vault) Get(ctx context.Context, ref string) ([]byte, error) {
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return nil, errors.New("reference must be path#key")
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(v.Addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.Token)
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultVaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	// KV v1 returns secret in "data", KV v2 wraps it with metadata in "data.data"
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	data := body.Data
+	if nested, ok := data["data"]; ok {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	raw, ok := data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("secret %s is not string", key)
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,157 @@
+// Package secrets resolves secret references like "file:///run/secrets/jwt", "env:JWT_KEY"
+// or "vault:secret/data/consumer#signing_key" and reloads resolved secrets when they change
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrNotFound is returned by provider when secret doesn't exist
+var ErrNotFound = errors.New("secret not found")
+
+var schemeRegex = regexp.MustCompile(`^([a-z][a-z0-9]*):`)
+
+// Provider returns secret by reference without scheme
+type Provider interface {
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// Resolver resolves references by providers registered for their schemes.
+// Values which don't start with a scheme are literal secrets.
+type Resolver struct {
+	providers map[string]Provider
+
+	mu      sync.Mutex
+	secrets []*Secret
+}
+
+// NewResolver returns resolver of "file:" and "env:" references with additional providers by scheme
+func NewResolver(providers map[string]Provider) *Resolver {
+	r := &Resolver{providers: map[string]Provider{
+		"file": File{},
+		"env":  Env{},
+	}}
+	for scheme, p := range providers {
+		r.providers[scheme] = p
+	}
+	return r
+}
+
+// IsReference reports whether value is reference rather than literal secret
+func IsReference(value string) bool {
+	return schemeRegex.MatchString(value)
+}
+
+// Resolve returns secret value of ref
+func (r *Resolver) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	m := schemeRegex.FindStringSubmatch(ref)
+	if m == nil {
+		return []byte(ref), nil
+	}
+	p, ok := r.providers[m[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret provider %q", m[1])
+	}
+	v, err := p.Get(ctx, ref[len(m[0]):])
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s secret: %w", m[1], err)
+	}
+	return v, nil
+}
+
+// Secret resolves ref and returns secret which is refreshed by Refresh and Watch
+func (r *Resolver) Secret(ctx context.Context, ref string) (*Secret, error) {
+	v, err := r.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	s := &Secret{ref: ref, value: v}
+	r.mu.Lock()
+	r.secrets = append(r.secrets, s)
+	r.mu.Unlock()
+	return s, nil
+}
+
+// Refresh resolves secrets again and notifies subscribers of changed ones.
+// Secret keeps its value if it can't be resolved, the first such error is returned.
+func (r *Resolver) Refresh(ctx context.Context) error {
+	r.mu.Lock()
+	secrets := append([]*Secret(nil), r.secrets...)
+	r.mu.Unlock()
+
+	var firstErr error
+	for _, s := range secrets {
+		v, err := r.Resolve(ctx, s.ref)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		s.set(v)
+	}
+	return firstErr
+}
+
+// Watch calls Refresh every interval until ctx is done, refresh errors are logged to l
+func (r *Resolver) Watch(ctx context.Context, interval time.Duration, l *zap.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			l.Error("can't refresh secrets", zap.Error(err))
+		}
+	}
+}
+
+// Secret - resolved secret value
+type Secret struct {
+	ref string
+
+	mu          sync.RWMutex
+	value       []byte
+	subscribers []func([]byte)
+}
+
+// Value returns current secret value
+func (s *Secret) Value() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+// OnChange registers fn which is called with new value each time secret changes
+func (s *Secret) OnChange(fn func(value []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+func (s *Secret) set(v []byte) {
+	s.mu.Lock()
+	if bytes.Equal(s.value, v) {
+		s.mu.Unlock()
+		return
+	}
+	s.value = v
+	subscribers := make([]func([]byte), len(s.subscribers))
+	copy(subscribers, s.subscribers)
+	s.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(v)
+	}
+}
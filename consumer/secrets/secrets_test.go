@@ -0,0 +1,112 @@
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsmak/consumerService/consumer/secrets"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tempFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "secret")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func vaultStub(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/consumer":
+			_, _ = w.Write([]byte(`{"data": {"data": {"signing_key": "v2 key"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/consumer":
+			_, _ = w.Write([]byte(`{"data": {"redis_password": "v1 password"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestResolve(t *testing.T) {
+	path := tempFile(t, "file secret\n")
+	srv := vaultStub(t)
+	r := secrets.NewResolver(map[string]secrets.Provider{
+		"env":   secrets.Env{LookupEnv: func(k string) (string, bool) { return "env secret", k == "JWT_KEY" }},
+		"vault": secrets.Vault{Addr: srv.URL, Token: "token"},
+	})
+
+	tests := map[string]string{
+		"literal":                                "literal",
+		"file://" + path:                         "file secret",
+		"env:JWT_KEY":                            "env secret",
+		"vault:secret/data/consumer#signing_key": "v2 key",
+		"vault:/kv/consumer#redis_password":      "v1 password",
+	}
+	for ref, want := range tests {
+		v, err := r.Resolve(context.Background(), ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, want, string(v), ref)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	srv := vaultStub(t)
+	r := secrets.NewResolver(map[string]secrets.Provider{
+		"env":   secrets.Env{LookupEnv: func(string) (string, bool) { return "", false }},
+		"vault": secrets.Vault{Addr: srv.URL, Token: "token"},
+	})
+
+	for _, ref := range []string{
+		"file:///not/exists",
+		"env:JWT_KEY",
+		"vault:secret/data/other#key",
+		"vault:secret/data/consumer#other_key",
+	} {
+		_, err := r.Resolve(context.Background(), ref)
+		require.True(t, errors.Is(err, secrets.ErrNotFound), ref)
+	}
+	for _, ref := range []string{"file://relative/path", "vault:secret/data/consumer", "unknown:ref"} {
+		_, err := r.Resolve(context.Background(), ref)
+		require.Error(t, err, ref)
+	}
+
+	_, err := secrets.Vault{Addr: srv.URL, Token: "wrong"}.Get(context.Background(), "secret/data/consumer#signing_key")
+	require.EqualError(t, err, "unexpected response status 403")
+}
+
+func TestSecretRefresh(t *testing.T) {
+	path := tempFile(t, "old")
+	r := secrets.NewResolver(nil)
+	s, err := r.Secret(context.Background(), "file://"+path)
+	require.NoError(t, err)
+	var changes []string
+	s.OnChange(func(v []byte) { changes = append(changes, string(v)) })
+
+	require.NoError(t, r.Refresh(context.Background()))
+	require.Empty(t, changes)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("new\n"), 0600))
+	require.NoError(t, r.Refresh(context.Background()))
+	require.Equal(t, "new", string(s.Value()))
+	require.Equal(t, []string{"new"}, changes)
+
+	require.NoError(t, os.Remove(path))
+	require.Error(t, r.Refresh(context.Background()))
+	require.Equal(t, "new", string(s.Value()))
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/logging"
+)
+
+const adminConsumerPath = "/v1/admin/consumers/"
+
+type pathIDKey struct{}
+
+// consumerRoutes dispatches requests to /v1/admin/consumers/{id}/{action} by action,
+// id is available via pathID
+type consumerRoutes map[string]route
+
+func (rt consumerRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, adminConsumerPath), "/")
+	if len(parts) == 2 {
+		id, err := strconv.Atoi(parts[0])
+		if next, ok := rt[parts[1]]; ok && err == nil && id > 0 {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pathIDKey{}, id)))
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, errorResponse{Code: "not_found", Message: "resource is not found"})
+}
+
+// pathID returns id of consumer or webhook from request path
+func pathID(r *http.Request) int {
+	id, _ := r.Context().Value(pathIDKey{}).(int)
+	return id
+}
+
+type statusChangeFunc func(ctx context.Context, actorID, consumerID int, form models.StatusChangeForm) (models.Consumer, error)
+
+func (h *Handler) changeStatus(change statusChangeFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var form models.StatusChangeForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		claims, _ := token.FromContext(r.Context())
+		c, err := change(r.Context(), claims.ID, pathID(r), form)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Public())
+	}
+}
+
+type statusHistoryResponse struct {
+	Changes []models.StatusChange `json:"changes"`
+}
+
+func (h *Handler) statusHistory(w http.ResponseWriter, r *http.Request) {
+	changes, err := h.domain.StatusHistory(r.Context(), pathID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if changes == nil {
+		changes = []models.StatusChange{}
+	}
+	writeJSON(w, http.StatusOK, statusHistoryResponse{Changes: changes})
+}
+
+type auditResponse struct {
+	Events     []models.AuditEvent `json:"events"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+func (h *Handler) auditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		f    = models.AuditFilter{Type: q.Get("type")}
+		page = models.PageRequest{Cursor: q.Get("cursor"), Limit: defaultPageLimit}
+		err  error
+	)
+	if v := q.Get("consumer_id"); v != "" {
+		f.ConsumerID, err = strconv.Atoi(v)
+	}
+	if v := q.Get("limit"); v != "" && err == nil {
+		page.Limit, err = strconv.Atoi(v)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Code: "bad_request", Message: "invalid query parameters"})
+		return
+	}
+
+	p, err := h.domain.AuditEvents(r.Context(), f, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if p.Events == nil {
+		p.Events = []models.AuditEvent{}
+	}
+	writeJSON(w, http.StatusOK, auditResponse{Events: p.Events, NextCursor: p.NextCursor})
+}
+
+type logLevelsBody struct {
+	Levels map[string]string `json:"levels"`
+}
+
+func (h *Handler) logLevels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelsBody{Levels: h.LogLevels.Levels()})
+}
+
+// setLogLevels changes levels of components from request, nothing is changed if any level is invalid
+func (h *Handler) setLogLevels(w http.ResponseWriter, r *http.Request) {
+	var body logLevelsBody
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	for component, level := range body.Levels {
+		if _, err := logging.ParseLevel(level); err != nil || component == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Code: "invalid_log_level", Message: "invalid level of component " + strconv.Quote(component)})
+			return
+		}
+	}
+	for component, level := range body.Levels {
+		_ = h.LogLevels.SetLevel(component, level)
+	}
+	writeJSON(w, http.StatusOK, logLevelsBody{Levels: h.LogLevels.Levels()})
+}
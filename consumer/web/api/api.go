@@ -0,0 +1,269 @@
+// Package api implements REST API of consumer service
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+)
+
+const maxBodySize = 1 << 20
+
+// LogLevels - levels of component loggers which can be changed at runtime
+type LogLevels interface {
+	Levels() map[string]string
+	SetLevel(component, level string) error
+}
+
+// Opts - REST API options structure
+type Opts struct {
+	LogLevels LogLevels // admin log levels endpoint is served if set
+}
+
+// Handler - http handler of REST API
+type Handler struct {
+	Opts
+	domain *domain.Service
+	auth   *auth.Service
+	mux    *http.ServeMux
+}
+
+// NewHandler returns new instance of REST API handler
+func NewHandler(d *domain.Service, a *auth.Service, opts Opts) *Handler {
+	h := &Handler{Opts: opts, domain: d, auth: a, mux: http.NewServeMux()}
+	h.mux.Handle("/v1/consumers", route{
+		http.MethodPost: http.HandlerFunc(h.register),
+	})
+	h.mux.Handle("/v1/consumers/me", route{
+		http.MethodGet:   h.authorize(token.ScopeProfileRead, h.profile),
+		http.MethodPatch: h.authorize(token.ScopeProfileWrite, h.updateProfile),
+	})
+	h.mux.Handle("/v1/consumers/me/password", route{
+		http.MethodPost: h.authorize(token.ScopeProfileWrite, h.changePassword),
+	})
+	h.mux.Handle("/v1/consumers/me/email", route{
+		http.MethodPost: h.authorize(token.ScopeProfileWrite, h.changeEmail),
+	})
+	h.mux.Handle("/v1/consumers/me/deletion", route{
+		http.MethodPost: h.authorize(token.ScopeProfileWrite, h.requestDeletion),
+	})
+	h.mux.Handle("/v1/consumers/me/export", route{
+		http.MethodGet:  h.authorize(token.ScopeProfileRead, h.lastExport),
+		http.MethodPost: h.authorize(token.ScopeProfileRead, h.requestExport),
+	})
+	h.mux.Handle(exportDownloadPath, route{
+		http.MethodGet: http.HandlerFunc(h.downloadExport),
+	})
+	h.mux.Handle("/v1/consumers/deletion/cancel", route{
+		http.MethodPost: http.HandlerFunc(h.cancelDeletion),
+	})
+	h.mux.Handle("/v1/admin/consumers", route{
+		http.MethodGet: h.authorize(token.ScopeAdmin, h.listConsumers),
+	})
+	h.mux.Handle("/v1/admin/audit", route{
+		http.MethodGet: h.authorize(token.ScopeAdmin, h.auditEvents),
+	})
+	h.mux.Handle(adminConsumerPath, h.authorize(token.ScopeAdmin, consumerRoutes{
+		"suspend":        route{http.MethodPost: h.changeStatus(h.domain.SuspendConsumer)},
+		"lock":           route{http.MethodPost: h.changeStatus(h.domain.LockConsumer)},
+		"reactivate":     route{http.MethodPost: h.changeStatus(h.domain.ReactivateConsumer)},
+		"status-history": route{http.MethodGet: http.HandlerFunc(h.statusHistory)},
+	}.ServeHTTP))
+	h.mux.Handle("/v1/admin/webhooks", route{
+		http.MethodGet:  h.authorize(token.ScopeAdmin, h.webhooks),
+		http.MethodPost: h.authorize(token.ScopeAdmin, h.createWebhook),
+	})
+	h.mux.Handle(adminWebhookPath, h.authorize(token.ScopeAdmin, webhookRoutes{
+		webhook: route{
+			http.MethodGet:    http.HandlerFunc(h.webhook),
+			http.MethodPut:    http.HandlerFunc(h.updateWebhook),
+			http.MethodDelete: http.HandlerFunc(h.deleteWebhook),
+		},
+		deliveries: route{http.MethodGet: http.HandlerFunc(h.webhookDeliveries)},
+		replay:     route{http.MethodPost: http.HandlerFunc(h.replayWebhookDelivery)},
+	}.ServeHTTP))
+	h.mux.Handle("/v1/admin/oauth/clients", route{
+		http.MethodGet:  h.authorize(token.ScopeAdmin, h.oauthClients),
+		http.MethodPost: h.authorize(token.ScopeAdmin, h.createOAuthClient),
+	})
+	h.mux.Handle(adminOAuthClientPath, route{
+		http.MethodDelete: h.authorize(token.ScopeAdmin, h.deleteOAuthClient),
+	})
+	h.mux.Handle("/oauth/authorize", route{
+		http.MethodGet:  http.HandlerFunc(h.oauthAuthorizeForm),
+		http.MethodPost: http.HandlerFunc(h.oauthAuthorize),
+	})
+	h.mux.Handle("/oauth/token", route{
+		http.MethodPost: http.HandlerFunc(h.oauthToken),
+	})
+	h.mux.Handle("/oauth/introspect", route{
+		http.MethodPost: http.HandlerFunc(h.introspect),
+	})
+	h.mux.Handle("/.well-known/openid-configuration", route{
+		http.MethodGet: http.HandlerFunc(h.openIDConfiguration),
+	})
+	h.mux.Handle("/.well-known/jwks.json", route{
+		http.MethodGet: http.HandlerFunc(h.jwks),
+	})
+	h.mux.Handle("/userinfo", route{
+		http.MethodGet:  h.authorize(token.ScopeOpenID, h.userInfo),
+		http.MethodPost: h.authorize(token.ScopeOpenID, h.userInfo),
+	})
+	if h.LogLevels != nil {
+		h.mux.Handle("/v1/admin/log-levels", route{
+			http.MethodGet: h.authorize(token.ScopeAdmin, h.logLevels),
+			http.MethodPut: h.authorize(token.ScopeAdmin, h.setLogLevels),
+		})
+	}
+	h.mux.Handle("/v1/auth/login", route{
+		http.MethodPost: http.HandlerFunc(h.login),
+	})
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Route returns pattern of route which serves request or empty string if there is no such route
+func (h *Handler) Route(r *http.Request) string {
+	_, pattern := h.mux.Handler(r)
+	return pattern
+}
+
+// authorize returns handler which passes request to next only if it carries not revoked bearer token
+// with scope issued to consumer whose account is active. The consumer is available via consumerFromContext.
+func (h *Handler) authorize(scope string, next http.HandlerFunc) http.Handler {
+	return h.auth.RequireScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := token.FromContext(r.Context())
+		c, err := h.domain.TokenConsumer(r.Context(), claims)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), consumerKey{}, c)))
+	}))
+}
+
+type consumerKey struct{}
+
+// consumerFromContext returns consumer who sent request
+func consumerFromContext(ctx context.Context) models.Consumer {
+	c, _ := ctx.Value(consumerKey{}).(models.Consumer)
+	return c
+}
+
+// route dispatches request to handler by method
+type route map[string]http.Handler
+
+func (rt route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if next, ok := rt[r.Method]; ok {
+		next.ServeHTTP(w, r)
+		return
+	}
+	methods := make([]string, 0, len(rt))
+	for m := range rt {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Code: "method_not_allowed", Message: "method is not allowed"})
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes response for application error. Messages of internal errors are not shown to client.
+func writeError(w http.ResponseWriter, err error) {
+	cErr, ok := err.(consumer.Error)
+	if !ok || !cErr.UserError() {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Code: "internal_error", Message: "internal error"})
+		return
+	}
+	code := consumer.ErrorCode(err)
+	status := http.StatusBadRequest
+	switch code {
+	case consumer.CodeInvalidCredentials, consumer.CodeInvalidToken, consumer.CodeReauthRequired:
+		status = http.StatusUnauthorized
+	case consumer.CodeNotFound:
+		status = http.StatusNotFound
+	case consumer.CodeTooManyAttempts, consumer.CodeAccountLocked:
+		status = http.StatusTooManyRequests
+	case consumer.CodeAccountSuspended, consumer.CodeAccountSecurityLocked, consumer.CodeAccountPendingDeletion:
+		status = http.StatusForbidden
+	case consumer.CodeInvalidStatusTransition:
+		status = http.StatusConflict
+	case "":
+		code = "bad_request"
+	}
+	var retryable consumer.RetryableError
+	if errors.As(err, &retryable) && retryable.RetryAfter() > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryable.RetryAfter().Seconds())))
+	}
+	writeJSON(w, status, errorResponse{Code: code, Message: err.Error()})
+}
+
+// decodeJSON decodes request body to v, unknown fields are not allowed
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Code: "bad_request", Message: "invalid request body"})
+		return false
+	}
+	return true
+}
+
+// readBody returns request body if its media type is one of types
+func readBody(w http.ResponseWriter, r *http.Request, types ...string) ([]byte, bool) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	supported := false
+	for _, t := range types {
+		supported = supported || mt == t
+	}
+	if !supported {
+		writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{
+			Code:    "unsupported_media_type",
+			Message: "content type must be one of: " + strings.Join(types, ", "),
+		})
+		return nil, false
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil || len(b) > maxBodySize {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Code: "bad_request", Message: "invalid request body"})
+		return nil, false
+	}
+	return b, true
+}
+
+// clientInfo returns info about client which sent request
+func clientInfo(r *http.Request) models.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return models.ClientInfo{IP: ip, UserAgent: r.UserAgent()}
+}
@@ -0,0 +1,843 @@
+package api_test
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/api"
+	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn/webauthntest"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/logging"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap/zapcore"
+)
+
+// idTokenKey is shared by tests since generation of RSA key is slow
+var idTokenKey = func() *rsa.PrivateKey {
+	key, err := auth.GenerateIDTokenKey()
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+type APISuite struct {
+	suite.Suite
+	mockCtl   *gomock.Controller
+	mockStore *MockDataStore
+	auth      *auth.Service
+	handler   *api.Handler
+	logging   *logging.Logging
+}
+
+func (s *APISuite) SetupTest() {
+	s.mockCtl = gomock.NewController(s.T())
+	s.mockStore = NewMockDataStore(s.mockCtl)
+	s.mockStore.EXPECT().AppendAuditEvent(gomock.Any()).AnyTimes()
+	s.mockStore.EXPECT().Atomic(gomock.Any()).DoAndReturn(func(fn func(store.DataStore) error) error {
+		return fn(s.mockStore)
+	}).AnyTimes()
+	s.mockStore.EXPECT().AddOutboxMessage(gomock.Any()).AnyTimes()
+	s.auth = auth.NewService(auth.Opts{
+		SigningKey: []byte("1"),
+		IDTokenKey: idTokenKey,
+		WebAuthn:   webauthn.Config{RPID: "localhost", Origins: []string{"https://localhost"}},
+	})
+	var err error
+	s.logging, err = logging.New(logging.Opts{Output: zapcore.AddSync(ioutil.Discard), Levels: map[string]string{"domain": "warn"}})
+	s.Require().NoError(err)
+	s.handler = api.NewHandler(domain.NewService(s.mockStore, s.auth, domain.Opts{Issuer: "https://id.test"}), s.auth, api.Opts{LogLevels: s.logging})
+}
+
+func (s *APISuite) TearDownTest() {
+	s.mockCtl.Finish()
+}
+
+func (s *APISuite) do(method, path, contentType, body, accessToken string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *APISuite) expectActive(id int) {
+	s.mockStore.EXPECT().ConsumerByID(id).Return(models.Consumer{ID: id, Status: models.StatusActive}, nil)
+}
+
+func (s *APISuite) accessToken(id int, scope string) string {
+	t, err := s.auth.CreateJWT(token.Claims{
+		ID:    id,
+		Scope: scope,
+		StandardClaims: &jwt.StandardClaims{
+			Subject:   token.SubjectAccess,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	s.Require().NoError(err)
+	return t
+}
+
+func (s *APISuite) TestRegister() {
+	s.mockStore.EXPECT().ConsumerIsExist("test@test.com").Return(false, nil)
+	s.mockStore.EXPECT().CreateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		c.ID = 1
+		return c, nil
+	})
+	w := s.do(http.MethodPost, "/v1/consumers", "application/json",
+		`{"email": "test@test.com", "pass1": "1234", "pass2": "1234"}`, "")
+
+	s.Require().Equal(http.StatusCreated, w.Code)
+	var c models.PublicConsumer
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &c))
+	s.Require().Equal(1, c.ID)
+	s.Require().NotContains(w.Body.String(), "1234")
+}
+
+func (s *APISuite) TestLoginInvalidCredentials() {
+	s.mockStore.EXPECT().Consumer("test@test.com").Return(models.Consumer{}, store.ErrNotFound)
+	w := s.do(http.MethodPost, "/v1/auth/login", "application/json", `{"email": "test@test.com", "pass": "1"}`, "")
+
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().Contains(w.Body.String(), `"code":"invalid_credentials"`)
+}
+
+func (s *APISuite) TestMethodNotAllowed() {
+	w := s.do(http.MethodDelete, "/v1/auth/login", "", "", "")
+
+	s.Require().Equal(http.StatusMethodNotAllowed, w.Code)
+	s.Require().Equal(http.MethodPost, w.Header().Get("Allow"))
+}
+
+func (s *APISuite) TestProfile() {
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{
+		ID: 1, Email: "test@test.com", RegTimestamp: 10, PassHash: "hash", Profile: models.Profile{Locale: "en"},
+	}, nil)
+	w := s.do(http.MethodGet, "/v1/consumers/me", "", "", s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"id": 1, "email": "test@test.com", "email_verified": false, "reg_timestamp": 10, "status": "active", "profile": {"locale": "en"}}`, w.Body.String())
+}
+
+func (s *APISuite) TestProfileNotFound() {
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{}, store.ErrNotFound)
+	w := s.do(http.MethodGet, "/v1/consumers/me", "", "", s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusNotFound, w.Code)
+}
+
+func (s *APISuite) TestUpdateProfile() {
+	c := models.Consumer{ID: 1, Email: "test@test.com", Version: 1, Profile: models.Profile{DisplayName: "Name", Locale: "en"}}
+	updated := c
+	updated.Profile = models.Profile{Locale: "en", Timezone: "UTC"}
+	s.mockStore.EXPECT().ConsumerByID(1).Return(c, nil).Times(2)
+	s.mockStore.EXPECT().UpdateConsumer(updated).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		c.Version++
+		return c, nil
+	})
+	w := s.do(http.MethodPatch, "/v1/consumers/me", "application/merge-patch+json",
+		`{"display_name": null, "timezone": "UTC"}`, s.accessToken(1, token.ScopeProfileWrite))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"id": 1, "email": "test@test.com", "email_verified": false, "reg_timestamp": 0, "status": "active", "profile": {"locale": "en", "timezone": "UTC"}}`, w.Body.String())
+}
+
+func (s *APISuite) TestUpdateProfileInvalidPatch() {
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1}, nil).Times(2)
+	w := s.do(http.MethodPatch, "/v1/consumers/me", "application/merge-patch+json",
+		`{"phone": "123"}`, s.accessToken(1, token.ScopeProfileWrite))
+
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *APISuite) TestUpdateProfileUnsupportedMediaType() {
+	s.expectActive(1)
+	w := s.do(http.MethodPatch, "/v1/consumers/me", "text/plain", `{}`, s.accessToken(1, token.ScopeProfileWrite))
+
+	s.Require().Equal(http.StatusUnsupportedMediaType, w.Code)
+}
+
+func (s *APISuite) TestUpdateProfileRequiresScope() {
+	w := s.do(http.MethodPatch, "/v1/consumers/me", "application/merge-patch+json", `{}`, s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusForbidden, w.Code)
+}
+
+func (s *APISuite) TestListConsumers() {
+	verified := true
+	s.expectActive(1)
+	s.mockStore.EXPECT().ListConsumers(models.ConsumerFilter{CreatedFrom: 10, Verified: &verified, Status: models.StatusActive}, models.PageRequest{Cursor: "abc", Limit: 1}).
+		Return(models.ConsumerPage{Consumers: []models.Consumer{{ID: 2, Email: "test@test.com", PassHash: "hash"}}, NextCursor: "def"}, nil)
+	w := s.do(http.MethodGet, "/v1/admin/consumers?created_from=10&verified=true&status=active&cursor=abc&limit=1", "", "", s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"consumers": [{"id": 2, "email": "test@test.com", "email_verified": false, "reg_timestamp": 0, "status": "active", "profile": {}}], "next_cursor": "def"}`, w.Body.String())
+}
+
+func (s *APISuite) TestListConsumersInvalidQuery() {
+	s.expectActive(1)
+	w := s.do(http.MethodGet, "/v1/admin/consumers?verified=maybe", "", "", s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *APISuite) TestListConsumersRequiresAdmin() {
+	w := s.do(http.MethodGet, "/v1/admin/consumers", "", "", s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusForbidden, w.Code)
+}
+
+func (s *APISuite) TestSuspendedConsumerIsRejected() {
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Status: models.StatusSuspended}, nil)
+	w := s.do(http.MethodGet, "/v1/consumers/me", "", "", s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusForbidden, w.Code)
+	s.Require().Contains(w.Body.String(), `"code":"account_suspended"`)
+}
+
+func (s *APISuite) TestSuspendConsumer() {
+	s.expectActive(1)
+	s.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2, Version: 1, Status: models.StatusActive}, nil)
+	s.mockStore.EXPECT().UpdateConsumer(models.Consumer{ID: 2, Version: 1, Status: models.StatusSuspended}).
+		Return(models.Consumer{ID: 2, Version: 2, Status: models.StatusSuspended}, nil)
+	s.mockStore.EXPECT().SaveStatusChange(gomock.Any()).DoAndReturn(func(ch models.StatusChange) error {
+		s.Require().Equal(1, ch.ActorID)
+		s.Require().Equal(2, ch.ConsumerID)
+		s.Require().Equal(models.StatusActive, ch.From)
+		s.Require().Equal(models.StatusSuspended, ch.To)
+		s.Require().Equal("spam", ch.Reason)
+		return nil
+	})
+	w := s.do(http.MethodPost, "/v1/admin/consumers/2/suspend", "application/json", `{"reason": "spam"}`, s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Contains(w.Body.String(), `"status":"suspended"`)
+}
+
+func (s *APISuite) TestReactivateActiveConsumer() {
+	s.expectActive(1)
+	s.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2, Status: models.StatusActive}, nil)
+	w := s.do(http.MethodPost, "/v1/admin/consumers/2/reactivate", "application/json", `{"reason": "ok"}`, s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusConflict, w.Code)
+	s.Require().Contains(w.Body.String(), `"code":"invalid_status_transition"`)
+}
+
+func (s *APISuite) TestStatusHistory() {
+	s.expectActive(1)
+	s.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2}, nil)
+	s.mockStore.EXPECT().StatusChanges(2).Return([]models.StatusChange{
+		{ID: 1, ConsumerID: 2, From: models.StatusActive, To: models.StatusSuspended, Reason: "spam", ActorID: 1, CreatedAt: 10},
+	}, nil)
+	w := s.do(http.MethodGet, "/v1/admin/consumers/2/status-history", "", "", s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"changes": [{"id": 1, "consumer_id": 2, "from": "active", "to": "suspended", "reason": "spam", "actor_id": 1, "created_at": 10}]}`, w.Body.String())
+}
+
+func (s *APISuite) TestLogLevels() {
+	s.expectActive(1)
+	w := s.do(http.MethodGet, "/v1/admin/log-levels", "", "", s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"levels": {"domain": "warn"}}`, w.Body.String())
+
+	s.expectActive(1)
+	w = s.do(http.MethodPut, "/v1/admin/log-levels", "application/json", `{"levels": {"domain": "debug", "auth": "error"}}`, s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"levels": {"domain": "debug", "auth": "error"}}`, w.Body.String())
+
+	s.expectActive(1)
+	w = s.do(http.MethodPut, "/v1/admin/log-levels", "application/json", `{"levels": {"auth": "info", "http": "verbose"}}`, s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().JSONEq(`{"code": "invalid_log_level", "message": "invalid level of component \"http\""}`, w.Body.String())
+	s.Require().Equal(map[string]string{"domain": "debug", "auth": "error"}, s.logging.Levels())
+
+	w = s.do(http.MethodGet, "/v1/admin/log-levels", "", "", s.accessToken(1, token.ScopeProfileRead))
+	s.Require().Equal(http.StatusForbidden, w.Code)
+}
+
+func (s *APISuite) TestAdminConsumerUnknownPath() {
+	for _, path := range []string{"/v1/admin/consumers/2", "/v1/admin/consumers/x/suspend", "/v1/admin/consumers/2/unknown"} {
+		s.expectActive(1)
+		w := s.do(http.MethodPost, path, "application/json", `{}`, s.accessToken(1, token.ScopeAdmin))
+
+		s.Require().Equal(http.StatusNotFound, w.Code, path)
+	}
+}
+
+func (s *APISuite) TestRequestDeletion() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Version: 1}
+	s.mockStore.EXPECT().ConsumerByID(1).Return(user, nil).Times(3)
+	s.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	s.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	s.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	s.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		return c, nil
+	})
+	s.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(nil)
+	w := s.do(http.MethodPost, "/v1/consumers/me/deletion", "application/json", `{"pass": "password"}`, s.accessToken(1, token.ScopeProfileWrite))
+
+	s.Require().Equal(http.StatusAccepted, w.Code)
+	s.Require().Contains(w.Body.String(), `"status":"pending_deletion"`)
+	s.Require().Contains(w.Body.String(), `"deletion_scheduled_at":`)
+}
+
+func (s *APISuite) TestRequestDeletionRequiresReauthentication() {
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Status: models.StatusActive}, nil).Times(2)
+	w := s.do(http.MethodPost, "/v1/consumers/me/deletion", "application/json", `{}`, s.accessToken(1, token.ScopeProfileWrite))
+
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().Contains(w.Body.String(), `"code":"reauthentication_required"`)
+}
+
+func (s *APISuite) TestChangeEmail() {
+	user := models.Consumer{ID: 1, Email: "old@test.com", PassHash: "old@test.compassword"}
+	s.mockStore.EXPECT().ConsumerByID(1).Return(user, nil).Times(2)
+	s.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	s.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		return c, nil
+	})
+	w := s.do(http.MethodPost, "/v1/consumers/me/email", "application/json", `{"email": "new@test.com", "pass": "password"}`, s.accessToken(1, token.ScopeProfileWrite))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Contains(w.Body.String(), `"email":"new@test.com"`)
+}
+
+func (s *APISuite) TestRevokedTokenIsRejected() {
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, TokensRevokedAt: time.Now().Unix()}, nil)
+	w := s.do(http.MethodGet, "/v1/consumers/me", "", "", s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().Contains(w.Body.String(), `"code":"invalid_token"`)
+}
+
+func (s *APISuite) TestCancelDeletion() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Status: models.StatusPendingDeletion}
+	s.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	s.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	s.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		return c, nil
+	})
+	s.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(nil)
+	w := s.do(http.MethodPost, "/v1/consumers/deletion/cancel", "application/json", `{"email": "test@test.com", "pass": "password"}`, "")
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Contains(w.Body.String(), `"status":"active"`)
+}
+
+func (s *APISuite) TestExport() {
+	var saved models.Export
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Email: "test@test.com"}, nil).AnyTimes()
+	s.mockStore.EXPECT().LastExport(1).Return(models.Export{}, store.ErrNotFound)
+	s.mockStore.EXPECT().StatusChanges(1).Return(nil, nil)
+	s.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	s.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	s.mockStore.EXPECT().ConsumerRefreshTokens(1).Return(nil, nil)
+	s.mockStore.EXPECT().AuditEvents(gomock.Any(), gomock.Any()).Return(models.AuditPage{}, nil)
+	s.mockStore.EXPECT().SaveExport(gomock.Any()).DoAndReturn(func(e models.Export) (models.Export, error) {
+		e.ID = 1
+		saved = e
+		return e, nil
+	}).Times(2)
+	w := s.do(http.MethodPost, "/v1/consumers/me/export?format=zip", "", "", s.accessToken(1, token.ScopeProfileRead))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	var res struct {
+		Status      string `json:"status"`
+		DownloadURL string `json:"download_url"`
+	}
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &res))
+	s.Require().Equal(models.ExportReady, res.Status)
+	s.Require().NotEmpty(res.DownloadURL)
+
+	s.mockStore.EXPECT().Export(1).Return(saved, nil)
+	w = s.do(http.MethodGet, res.DownloadURL, "", "", "")
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Equal("application/zip", w.Header().Get("Content-Type"))
+	s.Require().Equal(saved.Data, w.Body.Bytes())
+}
+
+func (s *APISuite) TestDownloadExportInvalidToken() {
+	w := s.do(http.MethodGet, "/v1/exports/download?token="+s.accessToken(1, token.ScopeProfileRead), "", "", "")
+
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (s *APISuite) TestAuditEvents() {
+	s.expectActive(1)
+	s.mockStore.EXPECT().AuditEvents(models.AuditFilter{ConsumerID: 2, Type: models.AuditLoginFailed}, models.PageRequest{Limit: 20}).
+		Return(models.AuditPage{Events: []models.AuditEvent{{ID: 5, Type: models.AuditLoginFailed, ConsumerID: 2, IP: "127.0.0.1", Hash: "h"}}}, nil)
+	w := s.do(http.MethodGet, "/v1/admin/audit?consumer_id=2&type=login_failed", "", "", s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"events": [{"id": 5, "type": "login_failed", "consumer_id": 2, "ip": "127.0.0.1", "created_at": 0, "prev_hash": "", "hash": "h"}]}`, w.Body.String())
+}
+
+func (s *APISuite) TestCreateWebhook() {
+	s.expectActive(1)
+	s.mockStore.EXPECT().SaveWebhook(gomock.Any()).DoAndReturn(func(w models.Webhook) (models.Webhook, error) {
+		w.ID, w.CreatedAt = 3, 0
+		return w, nil
+	})
+	w := s.do(http.MethodPost, "/v1/admin/webhooks", "application/json",
+		`{"url": "https://partner.test/hook", "events": ["consumer.deleted"]}`, s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusCreated, w.Code)
+	var resp map[string]interface{}
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	s.Require().Len(resp["secret"], 64)
+	delete(resp, "secret")
+	s.Require().Equal(map[string]interface{}{
+		"id":                   float64(3),
+		"url":                  "https://partner.test/hook",
+		"events":               []interface{}{"consumer.deleted"},
+		"enabled":              true,
+		"consecutive_failures": float64(0),
+		"created_at":           float64(0),
+	}, resp)
+}
+
+func (s *APISuite) TestWebhookRoutes() {
+	s.expectActive(1)
+	s.mockStore.EXPECT().WebhookDelivery(7).Return(models.WebhookDelivery{ID: 7, WebhookID: 3, EventType: models.EventConsumerDeleted}, nil)
+	s.mockStore.EXPECT().SaveWebhookDelivery(gomock.Any()).Return(nil)
+	w := s.do(http.MethodPost, "/v1/admin/webhooks/3/deliveries/7/replay", "", "", s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusAccepted, w.Code)
+	s.Require().Contains(w.Body.String(), `"status":"pending"`)
+
+	s.expectActive(1)
+	s.mockStore.EXPECT().DeleteWebhook(4).Return(store.ErrNotFound)
+	w = s.do(http.MethodDelete, "/v1/admin/webhooks/4", "", "", s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusNotFound, w.Code)
+
+	s.expectActive(1)
+	w = s.do(http.MethodGet, "/v1/admin/webhooks/3/unknown", "", "", s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusNotFound, w.Code)
+}
+
+func (s *APISuite) TestCreateOAuthClient() {
+	s.expectActive(1)
+	var saved models.OAuthClient
+	s.mockStore.EXPECT().CreateOAuthClient(gomock.Any()).DoAndReturn(func(c models.OAuthClient) error {
+		saved = c
+		return nil
+	})
+	w := s.do(http.MethodPost, "/v1/admin/oauth/clients", "application/json", `{"name": "billing"}`, s.accessToken(1, token.ScopeAdmin))
+
+	s.Require().Equal(http.StatusCreated, w.Code)
+	var resp map[string]interface{}
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	s.Require().Equal(saved.ID, resp["client_id"])
+	s.Require().Equal("billing", resp["name"])
+	s.Require().True(s.auth.MatchClientSecret(saved.SecretHash, resp["client_secret"].(string)))
+	s.Require().NotContains(w.Body.String(), saved.SecretHash)
+
+	s.expectActive(1)
+	s.mockStore.EXPECT().DeleteOAuthClient("unknown").Return(store.ErrNotFound)
+	w = s.do(http.MethodDelete, "/v1/admin/oauth/clients/unknown", "", "", s.accessToken(1, token.ScopeAdmin))
+	s.Require().Equal(http.StatusNotFound, w.Code)
+}
+
+// introspectionClient returns OAuth client expected to be authenticated by introspection request and its secret
+func (s *APISuite) introspectionClient() (string, string) {
+	id, secret, hash, err := s.auth.NewClientCredentials()
+	s.Require().NoError(err)
+	s.mockStore.EXPECT().OAuthClient(id).Return(models.OAuthClient{ID: id, SecretHash: hash}, nil)
+	return id, secret
+}
+
+func (s *APISuite) TestIntrospect() {
+	id, secret := s.introspectionClient()
+	s.expectActive(1)
+	r := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader("token="+s.accessToken(1, token.ScopeProfileRead)+"&token_type_hint=access_token"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(id, secret)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Equal("no-store", w.Header().Get("Cache-Control"))
+	var resp models.TokenIntrospection
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	s.Require().True(resp.Active)
+	s.Require().Equal("1", resp.Subject)
+	s.Require().Equal(token.ScopeProfileRead, resp.Scope)
+	s.Require().NotZero(resp.ExpiresAt)
+}
+
+func (s *APISuite) TestIntrospectInactiveToken() {
+	id, secret := s.introspectionClient()
+	s.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Status: models.StatusActive, TokensRevokedAt: time.Now().Unix()}, nil)
+	w := s.do(http.MethodPost, "/oauth/introspect", "application/x-www-form-urlencoded",
+		"client_id="+id+"&client_secret="+secret+"&token="+s.accessToken(1, token.ScopeProfileRead), "")
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"active": false}`, w.Body.String())
+}
+
+func (s *APISuite) TestIntrospectErrors() {
+	id, _ := s.introspectionClient()
+	w := s.do(http.MethodPost, "/oauth/introspect", "application/x-www-form-urlencoded", "client_id="+id+"&client_secret=wrong&token=t", "")
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().NotEmpty(w.Header().Get("WWW-Authenticate"))
+	s.Require().Contains(w.Body.String(), `"error":"invalid_client"`)
+
+	w = s.do(http.MethodPost, "/oauth/introspect", "application/x-www-form-urlencoded", "token=t", "")
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+
+	id, secret := s.introspectionClient()
+	w = s.do(http.MethodPost, "/oauth/introspect", "application/x-www-form-urlencoded", "client_id="+id+"&client_secret="+secret, "")
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().Contains(w.Body.String(), `"error":"invalid_request"`)
+
+	w = s.do(http.MethodPost, "/oauth/introspect", "application/json", `{"token": "t"}`, "")
+	s.Require().Equal(http.StatusUnsupportedMediaType, w.Code)
+}
+
+const codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" // RFC 7636 appendix B
+
+func (s *APISuite) expectPublicClient() {
+	s.mockStore.EXPECT().OAuthClient("web").
+		Return(models.OAuthClient{ID: "web", Name: "Web <App>", Public: true, RedirectURIs: []string{"https://app.test/cb"}}, nil).AnyTimes()
+}
+
+func authorizeQuery(change func(url.Values)) url.Values {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"web"},
+		"redirect_uri":          {"https://app.test/cb"},
+		"scope":                 {token.ScopeProfileRead},
+		"state":                 {"xyz"},
+		"nonce":                 {"abc"},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if change != nil {
+		change(q)
+	}
+	return q
+}
+
+var csrfTokenRegex = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// authorizeSession opens authorization page and returns CSRF cookie set for browser and CSRF token of the form
+func (s *APISuite) authorizeSession() (*http.Cookie, string) {
+	w := s.do(http.MethodGet, "/oauth/authorize?"+authorizeQuery(nil).Encode(), "", "", "")
+	s.Require().Equal(http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	s.Require().Len(cookies, 1)
+	m := csrfTokenRegex.FindStringSubmatch(w.Body.String())
+	s.Require().Len(m, 2)
+	return cookies[0], m[1]
+}
+
+// postAuthorize posts authorization form, cookie is sent if it isn't nil
+func (s *APISuite) postAuthorize(form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *APISuite) TestAuthorizePage() {
+	s.expectPublicClient()
+	w := s.do(http.MethodGet, "/oauth/authorize?"+authorizeQuery(nil).Encode(), "", "", "")
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Equal("DENY", w.Header().Get("X-Frame-Options"))
+	cookies := w.Result().Cookies()
+	s.Require().Len(cookies, 1)
+	s.Require().True(cookies[0].HttpOnly)
+	s.Require().True(cookies[0].Secure)
+	s.Require().Equal(http.SameSiteLaxMode, cookies[0].SameSite)
+	s.Require().Regexp(csrfTokenRegex, w.Body.String())
+	s.Require().Contains(w.Body.String(), "Sign in to Web &lt;App&gt;")
+	s.Require().Contains(w.Body.String(), `name="code_challenge" value="`+codeChallenge+`"`)
+	s.Require().Contains(w.Body.String(), `name="nonce" value="abc"`)
+	s.Require().Contains(w.Body.String(), "<li>profile:read</li>")
+}
+
+func (s *APISuite) TestAuthorizeInvalidRequest() {
+	s.expectPublicClient()
+	w := s.do(http.MethodGet, "/oauth/authorize?"+authorizeQuery(func(q url.Values) { q.Set("redirect_uri", "https://evil.test/cb") }).Encode(), "", "", "")
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().Empty(w.Header().Get("Location"))
+
+	w = s.do(http.MethodGet, "/oauth/authorize?"+authorizeQuery(func(q url.Values) { q.Del("code_challenge") }).Encode(), "", "", "")
+	s.Require().Equal(http.StatusSeeOther, w.Code)
+	s.Require().Equal("https://app.test/cb?error=invalid_request&state=xyz", w.Header().Get("Location"))
+
+	w = s.do(http.MethodGet, "/oauth/authorize?"+authorizeQuery(func(q url.Values) { q.Set("response_type", "token") }).Encode(), "", "", "")
+	s.Require().Equal(http.StatusSeeOther, w.Code)
+	s.Require().Equal("https://app.test/cb?error=unsupported_response_type&state=xyz", w.Header().Get("Location"))
+}
+
+func (s *APISuite) TestAuthorizeDeny() {
+	s.expectPublicClient()
+	cookie, csrf := s.authorizeSession()
+	w := s.postAuthorize(authorizeQuery(func(q url.Values) {
+		q.Set("csrf_token", csrf)
+		q.Set("action", "deny")
+	}), cookie)
+
+	s.Require().Equal(http.StatusSeeOther, w.Code)
+	s.Require().Equal("https://app.test/cb?error=access_denied&state=xyz", w.Header().Get("Location"))
+}
+
+func (s *APISuite) TestAuthorize() {
+	s.expectPublicClient()
+	user := s.auth.MakeConsumerModel(models.RegFrom{Email: "test@test.com", Pass1: "password"})
+	user.ID = 1
+	s.mockStore.EXPECT().Consumer("test@test.com").Return(user, nil).Times(2)
+	s.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	s.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	s.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).Return(nil)
+	cookie, csrf := s.authorizeSession()
+
+	w := s.postAuthorize(authorizeQuery(func(q url.Values) {
+		q.Set("csrf_token", csrf)
+		q.Set("email", "test@test.com")
+		q.Set("pass", "wrong")
+		q.Set("action", "allow")
+	}), cookie)
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().Contains(w.Body.String(), "Invalid email or password.")
+	s.Require().Contains(w.Body.String(), `value="test@test.com"`)
+	s.Require().Contains(w.Body.String(), `value="`+csrf+`"`)
+
+	w = s.postAuthorize(authorizeQuery(func(q url.Values) {
+		q.Set("csrf_token", csrf)
+		q.Set("email", "test@test.com")
+		q.Set("pass", "password")
+		q.Set("action", "allow")
+	}), cookie)
+	s.Require().Equal(http.StatusSeeOther, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	s.Require().NoError(err)
+	s.Require().Equal("app.test", location.Host)
+	s.Require().NotEmpty(location.Query().Get("code"))
+	s.Require().Equal("xyz", location.Query().Get("state"))
+}
+
+var (
+	passkeySessionRegex = regexp.MustCompile(`name="passkey_session" value="([^"]+)"`)
+	passkeyOptionsRegex = regexp.MustCompile(`(?s)<script type="application/json" id="passkey-options">(.*?)</script>`)
+)
+
+func (s *APISuite) TestAuthorizeWithPasskey() {
+	s.expectPublicClient()
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	s.Require().NoError(err)
+	user := s.auth.MakeConsumerModel(models.RegFrom{Email: "test@test.com", Pass1: "password"})
+	user.ID = 1
+	user.Status = models.StatusActive
+	cred := models.WebAuthnCredential{ID: authenticator.CredentialID, ConsumerID: 1, PublicKey: authenticator.PublicKey()}
+	var challenge models.WebAuthnChallenge
+	s.mockStore.EXPECT().Consumer("test@test.com").Return(user, nil)
+	s.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	s.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{cred}, nil).Times(2)
+	s.mockStore.EXPECT().SaveWebAuthnChallenge(gomock.Any()).DoAndReturn(func(c models.WebAuthnChallenge) error {
+		challenge = c
+		return nil
+	})
+	cookie, csrf := s.authorizeSession()
+
+	w := s.postAuthorize(authorizeQuery(func(q url.Values) {
+		q.Set("csrf_token", csrf)
+		q.Set("email", "test@test.com")
+		q.Set("pass", "password")
+		q.Set("action", "allow")
+	}), cookie)
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().Contains(w.Header().Get("Content-Security-Policy"), "script-src 'nonce-")
+	s.Require().Contains(w.Body.String(), `id="passkey"`)
+	s.Require().NotContains(w.Body.String(), `name="code"`, "consumer without TOTP isn't asked for code")
+	session := passkeySessionRegex.FindStringSubmatch(w.Body.String())
+	s.Require().Len(session, 2)
+	options := passkeyOptionsRegex.FindStringSubmatch(w.Body.String())
+	s.Require().Len(options, 2)
+	var opts webauthn.RequestOptions
+	s.Require().NoError(json.Unmarshal([]byte(options[1]), &opts))
+	mfaToken := regexp.MustCompile(`name="mfa_token" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	s.Require().Len(mfaToken, 2)
+
+	assertion, err := authenticator.Assert(opts.Challenge)
+	s.Require().NoError(err)
+	body, err := json.Marshal(assertion)
+	s.Require().NoError(err)
+	s.mockStore.EXPECT().TakeWebAuthnChallenge(gomock.Any()).DoAndReturn(func(string) (models.WebAuthnChallenge, error) {
+		return challenge, nil
+	})
+	s.mockStore.EXPECT().WebAuthnCredential(cred.ID).Return(cred, nil)
+	s.mockStore.EXPECT().SaveWebAuthnCredential(gomock.Any()).Return(nil)
+	s.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	s.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).Return(nil)
+	w = s.postAuthorize(authorizeQuery(func(q url.Values) {
+		q.Set("csrf_token", csrf)
+		q.Set("mfa_token", mfaToken[1])
+		q.Set("mfa_passkey", "true")
+		q.Set("passkey_session", session[1])
+		q.Set("passkey_assertion", string(body))
+		q.Set("action", "allow")
+	}), cookie)
+
+	s.Require().Equal(http.StatusSeeOther, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	s.Require().NoError(err)
+	s.Require().NotEmpty(location.Query().Get("code"))
+	s.Require().Equal("xyz", location.Query().Get("state"))
+}
+
+func (s *APISuite) TestAuthorizeCSRF() {
+	s.expectPublicClient()
+	cookie, csrf := s.authorizeSession()
+	tests := map[string]struct {
+		form   url.Values
+		cookie *http.Cookie
+	}{
+		"no cookie": {authorizeQuery(func(q url.Values) { q.Set("csrf_token", csrf) }), nil},
+		"no token":  {authorizeQuery(nil), cookie},
+		"other request": {authorizeQuery(func(q url.Values) {
+			q.Set("csrf_token", csrf)
+			q.Set("state", "other")
+		}), cookie},
+		"other cookie": {authorizeQuery(func(q url.Values) { q.Set("csrf_token", csrf) }), &http.Cookie{Name: cookie.Name, Value: "other"}},
+	}
+	for name, tst := range tests {
+		tst.form.Set("email", "test@test.com")
+		tst.form.Set("pass", "password")
+		tst.form.Set("action", "allow")
+		w := s.postAuthorize(tst.form, tst.cookie)
+
+		s.Require().Equal(http.StatusForbidden, w.Code, name)
+		s.Require().Empty(w.Header().Get("Location"), name)
+		s.Require().Regexp(csrfTokenRegex, w.Body.String(), name)
+	}
+}
+
+func (s *APISuite) TestAuthorizeErrorMessage() {
+	s.expectPublicClient()
+	cookie, csrf := s.authorizeSession()
+
+	w := s.postAuthorize(authorizeQuery(func(q url.Values) {
+		q.Set("csrf_token", csrf)
+		q.Set("mfa_token", "token")
+		q.Set("action", "allow")
+	}), cookie)
+
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().Contains(w.Body.String(), "Sign in failed, try again.")
+	s.Require().NotContains(w.Body.String(), "empty code", "details of error aren't shown")
+}
+
+func (s *APISuite) TestToken() {
+	s.expectPublicClient()
+	s.mockStore.EXPECT().TakeOAuthCode(gomock.Any()).Return(models.OAuthCode{}, store.ErrNotFound)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"web"},
+		"code":          {"code"},
+		"redirect_uri":  {"https://app.test/cb"},
+		"code_verifier": {"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"},
+	}
+	w := s.do(http.MethodPost, "/oauth/token", "application/x-www-form-urlencoded", form.Encode(), "")
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().Equal("no-store", w.Header().Get("Cache-Control"))
+	s.Require().JSONEq(`{"error":"invalid_grant","error_description":"authorization grant is invalid, expired or revoked"}`, w.Body.String(),
+		"messages of domain errors aren't sent to clients")
+
+	form.Set("grant_type", "client_credentials")
+	w = s.do(http.MethodPost, "/oauth/token", "application/x-www-form-urlencoded", form.Encode(), "")
+	s.Require().Equal(http.StatusBadRequest, w.Code)
+	s.Require().Contains(w.Body.String(), `"error":"unsupported_grant_type"`)
+
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_secret", "secret")
+	w = s.do(http.MethodPost, "/oauth/token", "application/x-www-form-urlencoded", form.Encode(), "")
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().Contains(w.Body.String(), `"error":"invalid_client"`)
+}
+
+func (s *APISuite) TestOpenIDConfiguration() {
+	w := s.do(http.MethodGet, "/.well-known/openid-configuration", "", "", "")
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	var c models.OpenIDConfiguration
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &c))
+	s.Require().Equal("https://id.test", c.Issuer)
+	s.Require().Equal("https://id.test/userinfo", c.UserInfoEndpoint)
+	s.Require().Equal("https://id.test/.well-known/jwks.json", c.JWKSURI)
+	s.Require().Contains(c.IDTokenSigningAlgValuesSupported, "RS256")
+}
+
+func (s *APISuite) TestJWKS() {
+	w := s.do(http.MethodGet, "/.well-known/jwks.json", "", "", "")
+
+	s.Require().Equal(http.StatusOK, w.Code)
+	var set auth.JWKSet
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &set))
+	s.Require().Len(set.Keys, 1)
+	s.Require().Equal("sig", set.Keys[0].Use)
+	s.Require().NotEmpty(set.Keys[0].KeyID)
+	s.Require().NotContains(w.Body.String(), `"d"`, "private key must not be published")
+}
+
+func (s *APISuite) TestUserInfo() {
+	c := models.Consumer{ID: 1, Email: "test@test.com", EmailVerified: true, Status: models.StatusActive, Profile: models.Profile{DisplayName: "Name", Locale: "en"}}
+	s.mockStore.EXPECT().ConsumerByID(1).Return(c, nil).Times(3)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w := s.do(method, "/userinfo", "", "", s.accessToken(1, "openid email profile"))
+		s.Require().Equal(http.StatusOK, w.Code, method)
+		s.Require().Equal("no-store", w.Header().Get("Cache-Control"))
+		s.Require().JSONEq(`{"sub": "1", "email": "test@test.com", "email_verified": true, "name": "Name", "locale": "en"}`, w.Body.String())
+	}
+
+	w := s.do(http.MethodGet, "/userinfo", "", "", s.accessToken(1, "openid"))
+	s.Require().Equal(http.StatusOK, w.Code)
+	s.Require().JSONEq(`{"sub": "1"}`, w.Body.String())
+}
+
+func (s *APISuite) TestUserInfoUnauthorized() {
+	w := s.do(http.MethodGet, "/userinfo", "", "", "")
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().Equal("Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = s.do(http.MethodGet, "/userinfo", "", "", "invalid")
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+	s.Require().Equal(`Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+
+	w = s.do(http.MethodGet, "/userinfo", "", "", s.accessToken(1, token.ScopeProfileRead))
+	s.Require().Equal(http.StatusForbidden, w.Code)
+	s.Require().Equal(`Bearer error="insufficient_scope", scope="openid"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestAPISuite(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
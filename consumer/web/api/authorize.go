@@ -0,0 +1,390 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+)
+
+// authorizePage is login and consent page of authorization endpoint.
+// Parameters of authorization request are sent back in hidden fields and validated again,
+// CSRF token bound to them and to browser's CSRF cookie is sent with them.
+// Second factor is entered as TOTP code or asserted with passkey by script which is allowed by nonce.
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+{{if .Client.Name}}<h1>Sign in to {{.Client.Name}}</h1>{{else}}<h1>Sign in</h1>{{end}}
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Request.ClientID}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+{{if .Scopes}}<p>{{.Client.Name}} will be allowed to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .MFAToken}}
+<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+{{if .TOTP}}
+<input type="hidden" name="mfa_totp" value="true">
+<label>Authentication code <input name="code" autocomplete="one-time-code" inputmode="numeric" required autofocus></label>
+<button type="submit" name="action" value="allow">Allow</button>
+{{end}}
+{{if .Passkey}}<input type="hidden" name="mfa_passkey" value="true">{{end}}
+{{if .PasskeySession}}
+<input type="hidden" name="passkey_session" value="{{.PasskeySession}}">
+<input type="hidden" name="passkey_assertion" value="">
+<button type="button" id="passkey">Allow with passkey</button>
+{{end}}
+{{else}}
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="pass" autocomplete="current-password" required></label>
+<button type="submit" name="action" value="allow">Allow</button>
+{{end}}
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</form>
+{{if .PasskeySession}}
+<script type="application/json" id="passkey-options">{{.PasskeyOptions}}</script>
+<script nonce="{{.ScriptNonce}}">
+(function () {
+  var button = document.getElementById("passkey");
+  if (!window.PublicKeyCredential) {
+    button.disabled = true;
+    return;
+  }
+  function decode(s) {
+    return Uint8Array.from(atob(s.replace(/-/g, "+").replace(/_/g, "/")), function (c) { return c.charCodeAt(0); });
+  }
+  function encode(b) {
+    return btoa(String.fromCharCode.apply(null, new Uint8Array(b))).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+  }
+  button.addEventListener("click", function () {
+    var options = JSON.parse(document.getElementById("passkey-options").textContent);
+    options.challenge = decode(options.challenge);
+    (options.allowCredentials || []).forEach(function (c) { c.id = decode(c.id); });
+    navigator.credentials.get({publicKey: options}).then(function (cred) {
+      var r = cred.response;
+      button.form.elements.passkey_assertion.value = JSON.stringify({
+        rawId: encode(cred.rawId),
+        type: cred.type,
+        response: {
+          clientDataJSON: encode(r.clientDataJSON),
+          authenticatorData: encode(r.authenticatorData),
+          signature: encode(r.signature),
+          userHandle: r.userHandle ? encode(r.userHandle) : undefined
+        }
+      });
+      button.form.submit();
+    });
+  });
+})();
+</script>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type authorizePageData struct {
+	Client    models.OAuthClient
+	Request   models.AuthorizationRequest
+	Scopes    []string
+	Email     string
+	MFAToken  string
+	CSRFToken string
+	Error     string
+
+	TOTP           bool
+	Passkey        bool
+	PasskeyOptions webauthn.RequestOptions
+	PasskeySession string
+	ScriptNonce    string
+}
+
+const (
+	csrfCookie     = "authorize_csrf"
+	csrfSecretSize = 32
+	nonceSize      = 16
+)
+
+// randomString returns base64url encoded random bytes of size
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// csrfSecret returns secret of browser's CSRF cookie, new secret is generated and set in cookie if there is none.
+// Cookie isn't sent with cross-site POST requests, so they are rejected.
+func csrfSecret(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	secret, err := randomString(csrfSecretSize)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    secret,
+		Path:     "/oauth/authorize",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return secret, nil
+}
+
+// csrfToken returns CSRF token of authorization form, it is valid only for the same secret and request
+func csrfToken(secret string, req models.AuthorizationRequest) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, v := range []string{req.ResponseType, req.ClientID, req.RedirectURI, req.Scope, req.State, req.Nonce,
+		req.CodeChallenge, req.CodeChallengeMethod} {
+		mac.Write([]byte(v))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF reports whether posted authorization form carries CSRF token of req and browser's CSRF cookie
+func checkCSRF(r *http.Request, req models.AuthorizationRequest) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(r.PostForm.Get("csrf_token")), []byte(csrfToken(c.Value, req)))
+}
+
+// writeAuthorizePage renders authorization page, it can't be framed by other sites.
+// Only script of passkey assertion is allowed to run.
+func writeAuthorizePage(w http.ResponseWriter, status int, data authorizePageData) {
+	csp := "default-src 'none'; frame-ancestors 'none'"
+	if data.PasskeySession != "" {
+		if nonce, err := randomString(nonceSize); err == nil {
+			data.ScriptNonce = nonce
+			csp = "default-src 'none'; script-src 'nonce-" + nonce + "'; frame-ancestors 'none'"
+		} else {
+			data.PasskeySession = ""
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", csp)
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	_ = authorizePage.Execute(w, data)
+}
+
+func authorizationRequest(v url.Values) models.AuthorizationRequest {
+	return models.AuthorizationRequest{
+		ResponseType:        v.Get("response_type"),
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		Scope:               v.Get("scope"),
+		State:               v.Get("state"),
+		Nonce:               v.Get("nonce"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+	}
+}
+
+// checkAuthorization validates authorization request. Errors are reported to consumer if client
+// or redirect uri is invalid and to client via redirect otherwise as required by RFC 6749.
+func (h *Handler) checkAuthorization(w http.ResponseWriter, r *http.Request, req models.AuthorizationRequest) (models.OAuthClient, bool) {
+	c, err := h.domain.CheckAuthorization(r.Context(), req)
+	if err == nil {
+		return c, true
+	}
+	switch consumer.ErrorCode(err) {
+	case consumer.CodeInvalidClient, consumer.CodeInvalidRedirectURI:
+		writeAuthorizePage(w, http.StatusBadRequest, authorizePageData{Error: "The application sent an invalid request: " + err.Error()})
+	default:
+		redirectAuthorizationError(w, r, req, err)
+	}
+	return models.OAuthClient{}, false
+}
+
+// redirectAuthorizationError redirects consumer to client with error code
+func redirectAuthorizationError(w http.ResponseWriter, r *http.Request, req models.AuthorizationRequest, err error) {
+	code := "server_error"
+	if cErr, ok := err.(consumer.Error); ok && cErr.UserError() {
+		switch code = consumer.ErrorCode(err); code {
+		case consumer.CodeInvalidScope, consumer.CodeUnsupportedResponseType:
+		default:
+			code = "invalid_request"
+		}
+	}
+	http.Redirect(w, r, req.Redirect(url.Values{"error": {code}}), http.StatusSeeOther)
+}
+
+func (h *Handler) oauthAuthorizeForm(w http.ResponseWriter, r *http.Request) {
+	req := authorizationRequest(r.URL.Query())
+	c, ok := h.checkAuthorization(w, r, req)
+	if !ok {
+		return
+	}
+	secret, err := csrfSecret(w, r)
+	if err != nil {
+		writeAuthorizePage(w, http.StatusInternalServerError, authorizePageData{Error: "Something went wrong, try again later."})
+		return
+	}
+	writeAuthorizePage(w, http.StatusOK, authorizePageData{Client: c, Request: req, Scopes: req.Scopes(), CSRFToken: csrfToken(secret, req)})
+}
+
+// oauthAuthorize authenticates consumer with password and, if required, second factor code or passkey
+// and redirects him to client with authorization code. Forms without valid CSRF token are shown again.
+func (h *Handler) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeAuthorizePage(w, http.StatusBadRequest, authorizePageData{Error: "Invalid request."})
+		return
+	}
+	req := authorizationRequest(r.PostForm)
+	c, ok := h.checkAuthorization(w, r, req)
+	if !ok {
+		return
+	}
+	if !checkCSRF(r, req) {
+		secret, err := csrfSecret(w, r)
+		if err != nil {
+			writeAuthorizePage(w, http.StatusInternalServerError, authorizePageData{Error: "Something went wrong, try again later."})
+			return
+		}
+		writeAuthorizePage(w, http.StatusForbidden, authorizePageData{
+			Client:    c,
+			Request:   req,
+			Scopes:    req.Scopes(),
+			CSRFToken: csrfToken(secret, req),
+			Error:     "Your session has expired, try again.",
+		})
+		return
+	}
+	if r.PostForm.Get("action") == "deny" {
+		http.Redirect(w, r, req.Redirect(url.Values{"error": {"access_denied"}}), http.StatusSeeOther)
+		return
+	}
+
+	page := authorizePageData{
+		Client:    c,
+		Request:   req,
+		Scopes:    req.Scopes(),
+		Email:     r.PostForm.Get("email"),
+		MFAToken:  r.PostForm.Get("mfa_token"),
+		CSRFToken: r.PostForm.Get("csrf_token"),
+		TOTP:      r.PostForm.Get("mfa_totp") != "",
+		Passkey:   r.PostForm.Get("mfa_passkey") != "",
+	}
+	var (
+		res models.AuthorizationResult
+		err error
+	)
+	switch assertion := r.PostForm.Get("passkey_assertion"); {
+	case page.MFAToken != "" && assertion != "":
+		var resp webauthn.AssertionResponse
+		if err = json.Unmarshal([]byte(assertion), &resp); err != nil {
+			page.Error = authorizeErrorMessage(err)
+			h.writeAuthorizeStep(w, r, http.StatusBadRequest, page)
+			return
+		}
+		res, err = h.domain.AuthorizePasskey(r.Context(), req, r.PostForm.Get("passkey_session"), resp, clientInfo(r))
+	case page.MFAToken != "":
+		res, err = h.domain.AuthorizeMFA(r.Context(), req, models.MFAForm{
+			Token:  page.MFAToken,
+			Code:   r.PostForm.Get("code"),
+			Client: clientInfo(r),
+		})
+	default:
+		res, err = h.domain.Authorize(r.Context(), req, models.AuthForm{
+			Email:  page.Email,
+			Pass:   r.PostForm.Get("pass"),
+			Client: clientInfo(r),
+		})
+	}
+	cErr, ok := err.(consumer.Error)
+	switch {
+	case err == nil && res.MFARequired:
+		page.MFAToken, page.TOTP, page.Passkey = res.MFAToken, res.TOTP, res.Passkey
+		h.writeAuthorizeStep(w, r, http.StatusOK, page)
+	case err == nil:
+		http.Redirect(w, r, res.RedirectURI, http.StatusSeeOther)
+	case !ok || !cErr.UserError() || consumer.ErrorCode(err) == consumer.CodeInvalidScope:
+		redirectAuthorizationError(w, r, req, err)
+	default:
+		page.Error = authorizeErrorMessage(err)
+		h.writeAuthorizeStep(w, r, http.StatusBadRequest, page)
+	}
+}
+
+// writeAuthorizeStep renders authorization page of current step. Passkey assertion is started
+// for second factor step every time the page is rendered, since its challenge can be used once.
+func (h *Handler) writeAuthorizeStep(w http.ResponseWriter, r *http.Request, status int, page authorizePageData) {
+	if page.MFAToken != "" && page.Passkey {
+		opts, session, err := h.domain.BeginPasskeyMFA(r.Context(), page.MFAToken)
+		if err == nil {
+			page.PasskeyOptions, page.PasskeySession = opts, session
+		}
+	}
+	writeAuthorizePage(w, status, page)
+}
+
+// authorizeErrorMessage returns message shown to consumer who failed to authenticate,
+// messages of other errors aren't shown since they may reveal internals
+func authorizeErrorMessage(err error) string {
+	switch consumer.ErrorCode(err) {
+	case consumer.CodeInvalidCredentials:
+		return "Invalid email or password."
+	case consumer.CodeTooManyAttempts, consumer.CodeAccountLocked:
+		return "Too many attempts, try again later."
+	case consumer.CodeAccountSuspended, consumer.CodeAccountSecurityLocked, consumer.CodeAccountPendingDeletion:
+		return "Your account can't be used to sign in."
+	default:
+		return "Sign in failed, try again."
+	}
+}
+
+// oauthToken serves token requests of OAuth clients
+func (h *Handler) oauthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	form, ok := oauthForm(w, r)
+	if !ok {
+		return
+	}
+	req := models.TokenRequest{
+		GrantType:    form.Get("grant_type"),
+		Code:         form.Get("code"),
+		RedirectURI:  form.Get("redirect_uri"),
+		CodeVerifier: form.Get("code_verifier"),
+		RefreshToken: form.Get("refresh_token"),
+		Scope:        form.Get("scope"),
+	}
+	req.ClientID, req.ClientSecret = clientCredentials(r, form)
+	res, err := h.domain.Token(r.Context(), req)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+)
+
+const defaultPageLimit = 20
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+type loginResponse struct {
+	Token       string `json:"token"`
+	MFARequired bool   `json:"mfa_required"`
+}
+
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	var form models.RegFrom
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	form.Client = clientInfo(r)
+	c, err := h.domain.CreateConsumer(r.Context(), form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if h.domain.EnumerationSafeRegistration {
+		writeJSON(w, http.StatusAccepted, messageResponse{Message: "check your email to continue"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, c.Public())
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	var form models.AuthForm
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	form.Client = clientInfo(r)
+	res, err := h.domain.AuthenticateConsumer(r.Context(), form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loginResponse{Token: res.Token, MFARequired: res.MFARequired})
+}
+
+func (h *Handler) updateProfile(w http.ResponseWriter, r *http.Request) {
+	claims, _ := token.FromContext(r.Context())
+	patch, ok := readBody(w, r, "application/merge-patch+json", "application/json")
+	if !ok {
+		return
+	}
+	c, err := h.domain.UpdateProfile(r.Context(), claims.ID, models.ProfileUpdateForm{Patch: patch})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Public())
+}
+
+type passwordChangeRequest struct {
+	Pass    string `json:"pass"`
+	NewPass string `json:"new_pass"`
+}
+
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request) {
+	var req passwordChangeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	claims, _ := token.FromContext(r.Context())
+	c, err := h.domain.ChangePassword(r.Context(), claims.ID, models.PasswordChangeForm{
+		Pass:    req.Pass,
+		NewPass: req.NewPass,
+		Client:  clientInfo(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Public())
+}
+
+type emailChangeRequest struct {
+	Email string `json:"email"`
+	Pass  string `json:"pass"`
+}
+
+func (h *Handler) changeEmail(w http.ResponseWriter, r *http.Request) {
+	var req emailChangeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	claims, _ := token.FromContext(r.Context())
+	c, err := h.domain.ChangeEmail(r.Context(), claims.ID, models.EmailChangeForm{
+		Email:  req.Email,
+		Pass:   req.Pass,
+		Client: clientInfo(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Public())
+}
+
+func (h *Handler) profile(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, consumerFromContext(r.Context()).Public())
+}
+
+type deletionRequest struct {
+	Pass string `json:"pass"`
+}
+
+func (h *Handler) requestDeletion(w http.ResponseWriter, r *http.Request) {
+	var req deletionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	claims, _ := token.FromContext(r.Context())
+	c, err := h.domain.RequestDeletion(r.Context(), claims, models.DeletionForm{
+		Pass:   req.Pass,
+		Client: clientInfo(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, c.Public())
+}
+
+type cancelDeletionRequest struct {
+	Email string `json:"email"`
+	Pass  string `json:"pass"`
+}
+
+func (h *Handler) cancelDeletion(w http.ResponseWriter, r *http.Request) {
+	var req cancelDeletionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	c, err := h.domain.CancelDeletion(r.Context(), models.AuthForm{Email: req.Email, Pass: req.Pass, Client: clientInfo(r)})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Public())
+}
+
+type consumersResponse struct {
+	Consumers  []models.PublicConsumer `json:"consumers"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+func (h *Handler) listConsumers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		f    models.ConsumerFilter
+		page = models.PageRequest{Cursor: q.Get("cursor"), Limit: defaultPageLimit}
+		err  error
+	)
+	if v := q.Get("created_from"); v != "" {
+		f.CreatedFrom, err = strconv.ParseInt(v, 10, 64)
+	}
+	if v := q.Get("created_to"); v != "" && err == nil {
+		f.CreatedTo, err = strconv.ParseInt(v, 10, 64)
+	}
+	if v := q.Get("verified"); v != "" && err == nil {
+		var verified bool
+		verified, err = strconv.ParseBool(v)
+		f.Verified = &verified
+	}
+	f.Status = q.Get("status")
+	if v := q.Get("limit"); v != "" && err == nil {
+		page.Limit, err = strconv.Atoi(v)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Code: "bad_request", Message: "invalid query parameters"})
+		return
+	}
+
+	p, err := h.domain.ListConsumers(r.Context(), f, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := consumersResponse{Consumers: make([]models.PublicConsumer, 0, len(p.Consumers)), NextCursor: p.NextCursor}
+	for _, c := range p.Consumers {
+		res.Consumers = append(res.Consumers, c.Public())
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nsmak/consumerService/consumer/models"
+)
+
+const exportDownloadPath = "/v1/exports/download"
+
+type exportResponse struct {
+	ID          int    `json:"id"`
+	Status      string `json:"status"`
+	Format      string `json:"format"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func (h *Handler) requestExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = models.ExportFormatJSON
+	}
+	e, err := h.domain.RequestExport(r.Context(), consumerFromContext(r.Context()).ID, models.ExportForm{Format: format})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if e.Status == models.ExportPending {
+		status = http.StatusAccepted
+	}
+	h.writeExport(w, status, e)
+}
+
+func (h *Handler) lastExport(w http.ResponseWriter, r *http.Request) {
+	e, err := h.domain.LastExport(r.Context(), consumerFromContext(r.Context()).ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.writeExport(w, http.StatusOK, e)
+}
+
+// writeExport writes export status, download link is included if export is ready
+func (h *Handler) writeExport(w http.ResponseWriter, status int, e models.Export) {
+	res := exportResponse{ID: e.ID, Status: e.Status, Format: e.Format, CreatedAt: e.CreatedAt, ExpiresAt: e.ExpiresAt}
+	if e.Status == models.ExportReady {
+		t, err := h.domain.ExportDownloadToken(e)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.DownloadURL = exportDownloadPath + "?" + url.Values{"token": {t}}.Encode()
+	}
+	writeJSON(w, status, res)
+}
+
+func (h *Handler) downloadExport(w http.ResponseWriter, r *http.Request) {
+	e, err := h.domain.DownloadExport(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	contentType, ext := "application/json", ".json"
+	if e.Format == models.ExportFormatZip {
+		contentType, ext = "application/zip", ".zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="personal_data`+ext+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.Data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.Data)
+}
@@ -0,0 +1,798 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: store.go
+
+// Package api_test is a generated GoMock package.
+package api_test
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	models "github.com/nsmak/consumerService/consumer/models"
+	store "github.com/nsmak/consumerService/consumer/web/store"
+	reflect "reflect"
+)
+
+// MockDataStore is a mock of DataStore interface
+type MockDataStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockDataStoreMockRecorder
+}
+
+// MockDataStoreMockRecorder is the mock recorder for MockDataStore
+type MockDataStoreMockRecorder struct {
+	mock *MockDataStore
+}
+
+// NewMockDataStore creates a new mock instance
+func NewMockDataStore(ctrl *gomock.Controller) *MockDataStore {
+	mock := &MockDataStore{ctrl: ctrl}
+	mock.recorder = &MockDataStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockDataStore) EXPECT() *MockDataStoreMockRecorder {
+	return m.recorder
+}
+
+// Ping mocks base method
+func (m *MockDataStore) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockDataStoreMockRecorder) Ping(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockDataStore)(nil).Ping), ctx)
+}
+
+// Atomic mocks base method
+func (m *MockDataStore) Atomic(fn func(store.DataStore) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Atomic", fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Atomic indicates an expected call of Atomic
+func (mr *MockDataStoreMockRecorder) Atomic(fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Atomic", reflect.TypeOf((*MockDataStore)(nil).Atomic), fn)
+}
+
+// CreateConsumer mocks base method
+func (m *MockDataStore) CreateConsumer(c models.Consumer) (models.Consumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateConsumer", c)
+	ret0, _ := ret[0].(models.Consumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateConsumer indicates an expected call of CreateConsumer
+func (mr *MockDataStoreMockRecorder) CreateConsumer(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateConsumer", reflect.TypeOf((*MockDataStore)(nil).CreateConsumer), c)
+}
+
+// ConsumerIsExist mocks base method
+func (m *MockDataStore) ConsumerIsExist(email string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumerIsExist", email)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumerIsExist indicates an expected call of ConsumerIsExist
+func (mr *MockDataStoreMockRecorder) ConsumerIsExist(email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumerIsExist", reflect.TypeOf((*MockDataStore)(nil).ConsumerIsExist), email)
+}
+
+// Consumer mocks base method
+func (m *MockDataStore) Consumer(email string) (models.Consumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Consumer", email)
+	ret0, _ := ret[0].(models.Consumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Consumer indicates an expected call of Consumer
+func (mr *MockDataStoreMockRecorder) Consumer(email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Consumer", reflect.TypeOf((*MockDataStore)(nil).Consumer), email)
+}
+
+// ConsumerByID mocks base method
+func (m *MockDataStore) ConsumerByID(id int) (models.Consumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumerByID", id)
+	ret0, _ := ret[0].(models.Consumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumerByID indicates an expected call of ConsumerByID
+func (mr *MockDataStoreMockRecorder) ConsumerByID(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumerByID", reflect.TypeOf((*MockDataStore)(nil).ConsumerByID), id)
+}
+
+// UpdateConsumer mocks base method
+func (m *MockDataStore) UpdateConsumer(c models.Consumer) (models.Consumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateConsumer", c)
+	ret0, _ := ret[0].(models.Consumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateConsumer indicates an expected call of UpdateConsumer
+func (mr *MockDataStoreMockRecorder) UpdateConsumer(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConsumer", reflect.TypeOf((*MockDataStore)(nil).UpdateConsumer), c)
+}
+
+// DeleteConsumer mocks base method
+func (m *MockDataStore) DeleteConsumer(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteConsumer", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteConsumer indicates an expected call of DeleteConsumer
+func (mr *MockDataStoreMockRecorder) DeleteConsumer(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConsumer", reflect.TypeOf((*MockDataStore)(nil).DeleteConsumer), id)
+}
+
+// ListConsumers mocks base method
+func (m *MockDataStore) ListConsumers(f models.ConsumerFilter, page models.PageRequest) (models.ConsumerPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListConsumers", f, page)
+	ret0, _ := ret[0].(models.ConsumerPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListConsumers indicates an expected call of ListConsumers
+func (mr *MockDataStoreMockRecorder) ListConsumers(f, page interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListConsumers", reflect.TypeOf((*MockDataStore)(nil).ListConsumers), f, page)
+}
+
+// ConsumersScheduledForDeletion mocks base method
+func (m *MockDataStore) ConsumersScheduledForDeletion(before int64, limit int) ([]models.Consumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumersScheduledForDeletion", before, limit)
+	ret0, _ := ret[0].([]models.Consumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumersScheduledForDeletion indicates an expected call of ConsumersScheduledForDeletion
+func (mr *MockDataStoreMockRecorder) ConsumersScheduledForDeletion(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumersScheduledForDeletion", reflect.TypeOf((*MockDataStore)(nil).ConsumersScheduledForDeletion), before, limit)
+}
+
+// PurgeConsumer mocks base method
+func (m *MockDataStore) PurgeConsumer(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeConsumer", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PurgeConsumer indicates an expected call of PurgeConsumer
+func (mr *MockDataStoreMockRecorder) PurgeConsumer(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeConsumer", reflect.TypeOf((*MockDataStore)(nil).PurgeConsumer), id)
+}
+
+// SaveStatusChange mocks base method
+func (m *MockDataStore) SaveStatusChange(ch models.StatusChange) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveStatusChange", ch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveStatusChange indicates an expected call of SaveStatusChange
+func (mr *MockDataStoreMockRecorder) SaveStatusChange(ch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveStatusChange", reflect.TypeOf((*MockDataStore)(nil).SaveStatusChange), ch)
+}
+
+// StatusChanges mocks base method
+func (m *MockDataStore) StatusChanges(consumerID int) ([]models.StatusChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatusChanges", consumerID)
+	ret0, _ := ret[0].([]models.StatusChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatusChanges indicates an expected call of StatusChanges
+func (mr *MockDataStoreMockRecorder) StatusChanges(consumerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatusChanges", reflect.TypeOf((*MockDataStore)(nil).StatusChanges), consumerID)
+}
+
+// AppendAuditEvent mocks base method
+func (m *MockDataStore) AppendAuditEvent(e models.AuditEvent) (models.AuditEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendAuditEvent", e)
+	ret0, _ := ret[0].(models.AuditEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AppendAuditEvent indicates an expected call of AppendAuditEvent
+func (mr *MockDataStoreMockRecorder) AppendAuditEvent(e interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendAuditEvent", reflect.TypeOf((*MockDataStore)(nil).AppendAuditEvent), e)
+}
+
+// AuditEvents mocks base method
+func (m *MockDataStore) AuditEvents(f models.AuditFilter, page models.PageRequest) (models.AuditPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuditEvents", f, page)
+	ret0, _ := ret[0].(models.AuditPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuditEvents indicates an expected call of AuditEvents
+func (mr *MockDataStoreMockRecorder) AuditEvents(f, page interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuditEvents", reflect.TypeOf((*MockDataStore)(nil).AuditEvents), f, page)
+}
+
+// AddOutboxMessage mocks base method
+func (m_2 *MockDataStore) AddOutboxMessage(m models.OutboxMessage) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "AddOutboxMessage", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddOutboxMessage indicates an expected call of AddOutboxMessage
+func (mr *MockDataStoreMockRecorder) AddOutboxMessage(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOutboxMessage", reflect.TypeOf((*MockDataStore)(nil).AddOutboxMessage), m)
+}
+
+// PendingOutboxMessages mocks base method
+func (m *MockDataStore) PendingOutboxMessages(limit int) ([]models.OutboxMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingOutboxMessages", limit)
+	ret0, _ := ret[0].([]models.OutboxMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PendingOutboxMessages indicates an expected call of PendingOutboxMessages
+func (mr *MockDataStoreMockRecorder) PendingOutboxMessages(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingOutboxMessages", reflect.TypeOf((*MockDataStore)(nil).PendingOutboxMessages), limit)
+}
+
+// MarkOutboxMessagePublished mocks base method
+func (m *MockDataStore) MarkOutboxMessagePublished(id int, publishedAt int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxMessagePublished", id, publishedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxMessagePublished indicates an expected call of MarkOutboxMessagePublished
+func (mr *MockDataStoreMockRecorder) MarkOutboxMessagePublished(id, publishedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxMessagePublished", reflect.TypeOf((*MockDataStore)(nil).MarkOutboxMessagePublished), id, publishedAt)
+}
+
+// SaveWebhook mocks base method
+func (m *MockDataStore) SaveWebhook(w models.Webhook) (models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWebhook", w)
+	ret0, _ := ret[0].(models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveWebhook indicates an expected call of SaveWebhook
+func (mr *MockDataStoreMockRecorder) SaveWebhook(w interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebhook", reflect.TypeOf((*MockDataStore)(nil).SaveWebhook), w)
+}
+
+// Webhook mocks base method
+func (m *MockDataStore) Webhook(id int) (models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Webhook", id)
+	ret0, _ := ret[0].(models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Webhook indicates an expected call of Webhook
+func (mr *MockDataStoreMockRecorder) Webhook(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Webhook", reflect.TypeOf((*MockDataStore)(nil).Webhook), id)
+}
+
+// Webhooks mocks base method
+func (m *MockDataStore) Webhooks() ([]models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Webhooks")
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Webhooks indicates an expected call of Webhooks
+func (mr *MockDataStoreMockRecorder) Webhooks() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Webhooks", reflect.TypeOf((*MockDataStore)(nil).Webhooks))
+}
+
+// DeleteWebhook mocks base method
+func (m *MockDataStore) DeleteWebhook(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook
+func (mr *MockDataStoreMockRecorder) DeleteWebhook(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockDataStore)(nil).DeleteWebhook), id)
+}
+
+// CreateWebhookDelivery mocks base method
+func (m *MockDataStore) CreateWebhookDelivery(d models.WebhookDelivery) (models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebhookDelivery", d)
+	ret0, _ := ret[0].(models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateWebhookDelivery indicates an expected call of CreateWebhookDelivery
+func (mr *MockDataStoreMockRecorder) CreateWebhookDelivery(d interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebhookDelivery", reflect.TypeOf((*MockDataStore)(nil).CreateWebhookDelivery), d)
+}
+
+// SaveWebhookDelivery mocks base method
+func (m *MockDataStore) SaveWebhookDelivery(d models.WebhookDelivery) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWebhookDelivery", d)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveWebhookDelivery indicates an expected call of SaveWebhookDelivery
+func (mr *MockDataStoreMockRecorder) SaveWebhookDelivery(d interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebhookDelivery", reflect.TypeOf((*MockDataStore)(nil).SaveWebhookDelivery), d)
+}
+
+// WebhookDelivery mocks base method
+func (m *MockDataStore) WebhookDelivery(id int) (models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WebhookDelivery", id)
+	ret0, _ := ret[0].(models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WebhookDelivery indicates an expected call of WebhookDelivery
+func (mr *MockDataStoreMockRecorder) WebhookDelivery(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WebhookDelivery", reflect.TypeOf((*MockDataStore)(nil).WebhookDelivery), id)
+}
+
+// WebhookDeliveries mocks base method
+func (m *MockDataStore) WebhookDeliveries(webhookID int, page models.PageRequest) (models.WebhookDeliveryPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WebhookDeliveries", webhookID, page)
+	ret0, _ := ret[0].(models.WebhookDeliveryPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WebhookDeliveries indicates an expected call of WebhookDeliveries
+func (mr *MockDataStoreMockRecorder) WebhookDeliveries(webhookID, page interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WebhookDeliveries", reflect.TypeOf((*MockDataStore)(nil).WebhookDeliveries), webhookID, page)
+}
+
+// DueWebhookDeliveries mocks base method
+func (m *MockDataStore) DueWebhookDeliveries(now int64, limit int) ([]models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DueWebhookDeliveries", now, limit)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DueWebhookDeliveries indicates an expected call of DueWebhookDeliveries
+func (mr *MockDataStoreMockRecorder) DueWebhookDeliveries(now, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DueWebhookDeliveries", reflect.TypeOf((*MockDataStore)(nil).DueWebhookDeliveries), now, limit)
+}
+
+// SaveTOTPFactor mocks base method
+func (m *MockDataStore) SaveTOTPFactor(f models.TOTPFactor) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveTOTPFactor", f)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveTOTPFactor indicates an expected call of SaveTOTPFactor
+func (mr *MockDataStoreMockRecorder) SaveTOTPFactor(f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTOTPFactor", reflect.TypeOf((*MockDataStore)(nil).SaveTOTPFactor), f)
+}
+
+// TOTPFactor mocks base method
+func (m *MockDataStore) TOTPFactor(consumerID int) (models.TOTPFactor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TOTPFactor", consumerID)
+	ret0, _ := ret[0].(models.TOTPFactor)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TOTPFactor indicates an expected call of TOTPFactor
+func (mr *MockDataStoreMockRecorder) TOTPFactor(consumerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TOTPFactor", reflect.TypeOf((*MockDataStore)(nil).TOTPFactor), consumerID)
+}
+
+// SaveWebAuthnCredential mocks base method
+func (m *MockDataStore) SaveWebAuthnCredential(c models.WebAuthnCredential) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWebAuthnCredential", c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveWebAuthnCredential indicates an expected call of SaveWebAuthnCredential
+func (mr *MockDataStoreMockRecorder) SaveWebAuthnCredential(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebAuthnCredential", reflect.TypeOf((*MockDataStore)(nil).SaveWebAuthnCredential), c)
+}
+
+// WebAuthnCredential mocks base method
+func (m *MockDataStore) WebAuthnCredential(id []byte) (models.WebAuthnCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WebAuthnCredential", id)
+	ret0, _ := ret[0].(models.WebAuthnCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WebAuthnCredential indicates an expected call of WebAuthnCredential
+func (mr *MockDataStoreMockRecorder) WebAuthnCredential(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WebAuthnCredential", reflect.TypeOf((*MockDataStore)(nil).WebAuthnCredential), id)
+}
+
+// WebAuthnCredentials mocks base method
+func (m *MockDataStore) WebAuthnCredentials(consumerID int) ([]models.WebAuthnCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WebAuthnCredentials", consumerID)
+	ret0, _ := ret[0].([]models.WebAuthnCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WebAuthnCredentials indicates an expected call of WebAuthnCredentials
+func (mr *MockDataStoreMockRecorder) WebAuthnCredentials(consumerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WebAuthnCredentials", reflect.TypeOf((*MockDataStore)(nil).WebAuthnCredentials), consumerID)
+}
+
+// SaveWebAuthnChallenge mocks base method
+func (m *MockDataStore) SaveWebAuthnChallenge(c models.WebAuthnChallenge) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWebAuthnChallenge", c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveWebAuthnChallenge indicates an expected call of SaveWebAuthnChallenge
+func (mr *MockDataStoreMockRecorder) SaveWebAuthnChallenge(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebAuthnChallenge", reflect.TypeOf((*MockDataStore)(nil).SaveWebAuthnChallenge), c)
+}
+
+// TakeWebAuthnChallenge mocks base method
+func (m *MockDataStore) TakeWebAuthnChallenge(challenge string) (models.WebAuthnChallenge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeWebAuthnChallenge", challenge)
+	ret0, _ := ret[0].(models.WebAuthnChallenge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakeWebAuthnChallenge indicates an expected call of TakeWebAuthnChallenge
+func (mr *MockDataStoreMockRecorder) TakeWebAuthnChallenge(challenge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeWebAuthnChallenge", reflect.TypeOf((*MockDataStore)(nil).TakeWebAuthnChallenge), challenge)
+}
+
+// SaveExport mocks base method
+func (m *MockDataStore) SaveExport(e models.Export) (models.Export, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveExport", e)
+	ret0, _ := ret[0].(models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveExport indicates an expected call of SaveExport
+func (mr *MockDataStoreMockRecorder) SaveExport(e interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveExport", reflect.TypeOf((*MockDataStore)(nil).SaveExport), e)
+}
+
+// Export mocks base method
+func (m *MockDataStore) Export(id int) (models.Export, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Export", id)
+	ret0, _ := ret[0].(models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Export indicates an expected call of Export
+func (mr *MockDataStoreMockRecorder) Export(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Export", reflect.TypeOf((*MockDataStore)(nil).Export), id)
+}
+
+// LastExport mocks base method
+func (m *MockDataStore) LastExport(consumerID int) (models.Export, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastExport", consumerID)
+	ret0, _ := ret[0].(models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastExport indicates an expected call of LastExport
+func (mr *MockDataStoreMockRecorder) LastExport(consumerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastExport", reflect.TypeOf((*MockDataStore)(nil).LastExport), consumerID)
+}
+
+// PendingExports mocks base method
+func (m *MockDataStore) PendingExports(limit int) ([]models.Export, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingExports", limit)
+	ret0, _ := ret[0].([]models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PendingExports indicates an expected call of PendingExports
+func (mr *MockDataStoreMockRecorder) PendingExports(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingExports", reflect.TypeOf((*MockDataStore)(nil).PendingExports), limit)
+}
+
+// SaveOneTimeToken mocks base method
+func (m *MockDataStore) SaveOneTimeToken(t models.OneTimeToken) (models.OneTimeToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveOneTimeToken", t)
+	ret0, _ := ret[0].(models.OneTimeToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveOneTimeToken indicates an expected call of SaveOneTimeToken
+func (mr *MockDataStoreMockRecorder) SaveOneTimeToken(t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveOneTimeToken", reflect.TypeOf((*MockDataStore)(nil).SaveOneTimeToken), t)
+}
+
+// OneTimeToken mocks base method
+func (m *MockDataStore) OneTimeToken(id int) (models.OneTimeToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OneTimeToken", id)
+	ret0, _ := ret[0].(models.OneTimeToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OneTimeToken indicates an expected call of OneTimeToken
+func (mr *MockDataStoreMockRecorder) OneTimeToken(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OneTimeToken", reflect.TypeOf((*MockDataStore)(nil).OneTimeToken), id)
+}
+
+// LastOneTimeToken mocks base method
+func (m *MockDataStore) LastOneTimeToken(consumerID int, purpose string) (models.OneTimeToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastOneTimeToken", consumerID, purpose)
+	ret0, _ := ret[0].(models.OneTimeToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastOneTimeToken indicates an expected call of LastOneTimeToken
+func (mr *MockDataStoreMockRecorder) LastOneTimeToken(consumerID, purpose interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastOneTimeToken", reflect.TypeOf((*MockDataStore)(nil).LastOneTimeToken), consumerID, purpose)
+}
+
+// CreateOAuthClient mocks base method
+func (m *MockDataStore) CreateOAuthClient(c models.OAuthClient) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOAuthClient", c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateOAuthClient indicates an expected call of CreateOAuthClient
+func (mr *MockDataStoreMockRecorder) CreateOAuthClient(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOAuthClient", reflect.TypeOf((*MockDataStore)(nil).CreateOAuthClient), c)
+}
+
+// OAuthClient mocks base method
+func (m *MockDataStore) OAuthClient(id string) (models.OAuthClient, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OAuthClient", id)
+	ret0, _ := ret[0].(models.OAuthClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OAuthClient indicates an expected call of OAuthClient
+func (mr *MockDataStoreMockRecorder) OAuthClient(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OAuthClient", reflect.TypeOf((*MockDataStore)(nil).OAuthClient), id)
+}
+
+// OAuthClients mocks base method
+func (m *MockDataStore) OAuthClients() ([]models.OAuthClient, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OAuthClients")
+	ret0, _ := ret[0].([]models.OAuthClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OAuthClients indicates an expected call of OAuthClients
+func (mr *MockDataStoreMockRecorder) OAuthClients() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OAuthClients", reflect.TypeOf((*MockDataStore)(nil).OAuthClients))
+}
+
+// DeleteOAuthClient mocks base method
+func (m *MockDataStore) DeleteOAuthClient(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOAuthClient", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteOAuthClient indicates an expected call of DeleteOAuthClient
+func (mr *MockDataStoreMockRecorder) DeleteOAuthClient(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOAuthClient", reflect.TypeOf((*MockDataStore)(nil).DeleteOAuthClient), id)
+}
+
+// SaveOAuthCode mocks base method
+func (m *MockDataStore) SaveOAuthCode(c models.OAuthCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveOAuthCode", c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveOAuthCode indicates an expected call of SaveOAuthCode
+func (mr *MockDataStoreMockRecorder) SaveOAuthCode(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveOAuthCode", reflect.TypeOf((*MockDataStore)(nil).SaveOAuthCode), c)
+}
+
+// TakeOAuthCode mocks base method
+func (m *MockDataStore) TakeOAuthCode(hash string) (models.OAuthCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeOAuthCode", hash)
+	ret0, _ := ret[0].(models.OAuthCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakeOAuthCode indicates an expected call of TakeOAuthCode
+func (mr *MockDataStoreMockRecorder) TakeOAuthCode(hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeOAuthCode", reflect.TypeOf((*MockDataStore)(nil).TakeOAuthCode), hash)
+}
+
+// SaveRefreshToken mocks base method
+func (m *MockDataStore) SaveRefreshToken(t models.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRefreshToken", t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRefreshToken indicates an expected call of SaveRefreshToken
+func (mr *MockDataStoreMockRecorder) SaveRefreshToken(t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRefreshToken", reflect.TypeOf((*MockDataStore)(nil).SaveRefreshToken), t)
+}
+
+// RefreshToken mocks base method
+func (m *MockDataStore) RefreshToken(hash string) (models.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshToken", hash)
+	ret0, _ := ret[0].(models.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshToken indicates an expected call of RefreshToken
+func (mr *MockDataStoreMockRecorder) RefreshToken(hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshToken", reflect.TypeOf((*MockDataStore)(nil).RefreshToken), hash)
+}
+
+// UseRefreshToken mocks base method
+func (m *MockDataStore) UseRefreshToken(hash string, usedAt int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRefreshToken", hash, usedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseRefreshToken indicates an expected call of UseRefreshToken
+func (mr *MockDataStoreMockRecorder) UseRefreshToken(hash, usedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRefreshToken", reflect.TypeOf((*MockDataStore)(nil).UseRefreshToken), hash, usedAt)
+}
+
+// RevokeRefreshTokens mocks base method
+func (m *MockDataStore) RevokeRefreshTokens(familyID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshTokens", familyID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshTokens indicates an expected call of RevokeRefreshTokens
+func (mr *MockDataStoreMockRecorder) RevokeRefreshTokens(familyID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshTokens", reflect.TypeOf((*MockDataStore)(nil).RevokeRefreshTokens), familyID)
+}
+
+// ConsumerRefreshTokens mocks base method
+func (m *MockDataStore) ConsumerRefreshTokens(consumerID int) ([]models.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumerRefreshTokens", consumerID)
+	ret0, _ := ret[0].([]models.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumerRefreshTokens indicates an expected call of ConsumerRefreshTokens
+func (mr *MockDataStoreMockRecorder) ConsumerRefreshTokens(consumerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumerRefreshTokens", reflect.TypeOf((*MockDataStore)(nil).ConsumerRefreshTokens), consumerID)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+)
+
+const adminOAuthClientPath = "/v1/admin/oauth/clients/"
+
+// oauthErrorResponse - error response of OAuth endpoints as defined by RFC 6749
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// oauthErrorDescriptions - descriptions of OAuth error codes, messages of domain errors aren't sent to clients
+// because they may reveal why token or credentials were rejected
+var oauthErrorDescriptions = map[string]string{
+	consumer.CodeInvalidClient:        "client authentication failed",
+	consumer.CodeInvalidGrant:         "authorization grant is invalid, expired or revoked",
+	consumer.CodeInvalidScope:         "requested scope is invalid",
+	consumer.CodeUnsupportedGrantType: "grant type is not supported",
+	"invalid_request":                 "request is invalid",
+}
+
+// writeOAuthError writes error response of OAuth endpoints. Clients which failed to authenticate
+// are challenged to use HTTP Basic authentication.
+func writeOAuthError(w http.ResponseWriter, err error) {
+	cErr, ok := err.(consumer.Error)
+	if !ok || !cErr.UserError() {
+		writeJSON(w, http.StatusInternalServerError, oauthErrorResponse{Error: "server_error"})
+		return
+	}
+	status, code := http.StatusBadRequest, consumer.ErrorCode(err)
+	switch code {
+	case consumer.CodeInvalidClient:
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	case consumer.CodeInvalidGrant, consumer.CodeInvalidScope, consumer.CodeUnsupportedGrantType:
+	default:
+		code = "invalid_request"
+	}
+	writeJSON(w, status, oauthErrorResponse{Error: code, Description: oauthErrorDescriptions[code]})
+}
+
+// oauthForm parses form-urlencoded body of OAuth request
+func oauthForm(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	b, ok := readBody(w, r, "application/x-www-form-urlencoded")
+	if !ok {
+		return nil, false
+	}
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, oauthErrorResponse{Error: "invalid_request", Description: "invalid request body"})
+		return nil, false
+	}
+	return form, true
+}
+
+// clientCredentials returns credentials sent by client in Authorization header or, if there is no header, in form.
+// Credentials in header are form-urlencoded as required by RFC 6749.
+func clientCredentials(r *http.Request, form url.Values) (id, secret string) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return form.Get("client_id"), form.Get("client_secret")
+	}
+	if v, err := url.QueryUnescape(id); err == nil {
+		id = v
+	}
+	if v, err := url.QueryUnescape(secret); err == nil {
+		secret = v
+	}
+	return id, secret
+}
+
+// introspect serves token introspection requests of resource servers as defined by RFC 7662.
+// Token type hint is ignored since access token is the only introspectable type.
+func (h *Handler) introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	form, ok := oauthForm(w, r)
+	if !ok {
+		return
+	}
+	id, secret := clientCredentials(r, form)
+	if _, err := h.domain.AuthenticateClient(r.Context(), id, secret); err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	t := form.Get("token")
+	if t == "" {
+		writeJSON(w, http.StatusBadRequest, oauthErrorResponse{Error: "invalid_request", Description: "token is required"})
+		return
+	}
+	res, err := h.domain.IntrospectToken(r.Context(), t)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// createdOAuthClientResponse contains secret which is shown only once
+type createdOAuthClientResponse struct {
+	models.OAuthClient
+	Secret string `json:"client_secret"`
+}
+
+type oauthClientsResponse struct {
+	Clients []models.OAuthClient `json:"clients"`
+}
+
+func (h *Handler) createOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var form models.OAuthClientForm
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	c, secret, err := h.domain.CreateOAuthClient(r.Context(), form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createdOAuthClientResponse{OAuthClient: c, Secret: secret})
+}
+
+func (h *Handler) oauthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.domain.OAuthClients(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if clients == nil {
+		clients = []models.OAuthClient{}
+	}
+	writeJSON(w, http.StatusOK, oauthClientsResponse{Clients: clients})
+}
+
+func (h *Handler) deleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, adminOAuthClientPath)
+	if id == "" || strings.Contains(id, "/") {
+		writeJSON(w, http.StatusNotFound, errorResponse{Code: "not_found", Message: "resource is not found"})
+		return
+	}
+	if err := h.domain.DeleteOAuthClient(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+)
+
+// openIDConfiguration serves OpenID Connect discovery document
+func (h *Handler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.domain.OpenIDConfiguration())
+}
+
+// jwks serves public keys which verify ID tokens
+func (h *Handler) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.auth.JWKS())
+}
+
+// userInfo returns claims about consumer allowed by scopes of access token
+func (h *Handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	claims, _ := token.FromContext(r.Context())
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, models.NewUserInfo(consumerFromContext(r.Context()), claims.Scopes()))
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nsmak/consumerService/consumer/models"
+)
+
+const adminWebhookPath = "/v1/admin/webhooks/"
+
+type deliveryIDKey struct{}
+
+// webhookRoutes dispatches requests to /v1/admin/webhooks/{id}, /v1/admin/webhooks/{id}/deliveries
+// and /v1/admin/webhooks/{id}/deliveries/{delivery_id}/replay, ids are available via pathID and deliveryID
+type webhookRoutes struct {
+	webhook, deliveries, replay route
+}
+
+func (rt webhookRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, adminWebhookPath), "/")
+	ids := make([]int, 0, 2)
+	for i := 0; i < len(parts); i += 2 {
+		id, err := strconv.Atoi(parts[i])
+		if err != nil || id <= 0 {
+			ids = nil
+			break
+		}
+		ids = append(ids, id)
+	}
+	var next http.Handler
+	switch {
+	case len(parts) == 1 && len(ids) == 1:
+		next = rt.webhook
+	case len(parts) == 2 && len(ids) == 1 && parts[1] == "deliveries":
+		next = rt.deliveries
+	case len(parts) == 4 && len(ids) == 2 && parts[1] == "deliveries" && parts[3] == "replay":
+		next = rt.replay
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Code: "not_found", Message: "resource is not found"})
+		return
+	}
+	ctx := context.WithValue(r.Context(), pathIDKey{}, ids[0])
+	if len(ids) == 2 {
+		ctx = context.WithValue(ctx, deliveryIDKey{}, ids[1])
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// deliveryID returns webhook delivery's id from request path
+func deliveryID(r *http.Request) int {
+	id, _ := r.Context().Value(deliveryIDKey{}).(int)
+	return id
+}
+
+// createdWebhookResponse contains secret which is shown only once
+type createdWebhookResponse struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+type webhooksResponse struct {
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+type deliveriesResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var form models.WebhookForm
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	hook, err := h.domain.CreateWebhook(r.Context(), form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createdWebhookResponse{Webhook: hook, Secret: hook.Secret})
+}
+
+func (h *Handler) webhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.domain.Webhooks(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+	writeJSON(w, http.StatusOK, webhooksResponse{Webhooks: hooks})
+}
+
+func (h *Handler) webhook(w http.ResponseWriter, r *http.Request) {
+	hook, err := h.domain.Webhook(r.Context(), pathID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, hook)
+}
+
+func (h *Handler) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	var form models.WebhookForm
+	if !decodeJSON(w, r, &form) {
+		return
+	}
+	hook, err := h.domain.UpdateWebhook(r.Context(), pathID(r), form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, hook)
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := h.domain.DeleteWebhook(r.Context(), pathID(r)); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	page := models.PageRequest{Cursor: r.URL.Query().Get("cursor"), Limit: defaultPageLimit}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if page.Limit, err = strconv.Atoi(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Code: "bad_request", Message: "invalid query parameters"})
+			return
+		}
+	}
+	p, err := h.domain.WebhookDeliveries(r.Context(), pathID(r), page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if p.Deliveries == nil {
+		p.Deliveries = []models.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveriesResponse{Deliveries: p.Deliveries, NextCursor: p.NextCursor})
+}
+
+func (h *Handler) replayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	d, err := h.domain.ReplayWebhookDelivery(r.Context(), pathID(r), deliveryID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, d)
+}
@@ -1,24 +1,36 @@
 package auth
 
 import (
+	"crypto/rsa"
+	"crypto/subtle"
 	"errors"
+	"sync"
 
 	"github.com/nsmak/consumerService/consumer"
 	"github.com/nsmak/consumerService/consumer/models"
 	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/metrics"
 
 	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+const (
+	dummyEmail    = "dummy@consumer.service"
+	dummyPassword = "dummy password"
 )
 
 type authError struct {
 	IsUserError bool   `json:"-"`
+	Code        string `json:"-"`
 	Message     string `json:"message"`
 	Err         error  `json:"err,omitempty"`
 }
 
 func (e *authError) Error() string {
 	if e.Err != nil {
-		e.Message = e.Message + " --> " + e.Err.Error()
+		return e.Message + " --> " + e.Err.Error()
 	}
 	return e.Message
 }
@@ -38,43 +50,158 @@ func (e *authError) Unwrap() error {
 	return e.Err
 }
 
+func (e *authError) ErrorCode() string {
+	return e.Code
+}
+
+// DefaultRoleScopes - scopes allowed for each consumer role when Opts.RoleScopes is not set
+var DefaultRoleScopes = map[string][]string{
+	models.RoleConsumer: {token.ScopeProfileRead, token.ScopeProfileWrite},
+	models.RoleAdmin:    {token.ScopeProfileRead, token.ScopeProfileWrite, token.ScopeAdmin},
+}
+
 // Opts - auth service options stricture
 type Opts struct {
-	SigningKey []byte
+	SigningKey []byte          // use SetSigningKey to replace it after service is created
+	IDTokenKey *rsa.PrivateKey // signs OpenID Connect ID tokens, use SetIDTokenKey to replace it
+	RoleScopes map[string][]string
+	WebAuthn   webauthn.Config
+	Metrics    *metrics.Metrics // rejected tokens are counted if set
+	Logger     *zap.Logger      // nothing is logged if nil
 }
 
 // Service implements authentication and auth-helping methods
 type Service struct {
 	Opts
+	mu            sync.RWMutex
+	previousKey   []byte
+	previousIDKey *rsa.PrivateKey
 }
 
 // NewService returns new instance of authentication service
 func NewService(opts Opts) *Service {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
 	return &Service{Opts: opts}
 }
 
+// SetSigningKey replaces signing key. Tokens and one-time secrets signed with the previous key
+// are still accepted until the next replacement, so rotation doesn't log consumers out.
+func (s *Service) SetSigningKey(key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if string(key) == string(s.SigningKey) {
+		return
+	}
+	s.previousKey, s.SigningKey = s.SigningKey, key
+	s.Logger.Info("signing key rotated", zap.Bool("had_previous", len(s.previousKey) != 0))
+}
+
+// SigningKeyLoaded reports whether service has signing key
+func (s *Service) SigningKeyLoaded() bool {
+	key, _ := s.signingKeys()
+	return len(key) != 0
+}
+
+// signingKeys returns current key and previous one which is nil if key wasn't replaced
+func (s *Service) signingKeys() (current, previous []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.SigningKey, s.previousKey
+}
+
 // MakeConsumerModel creates domain model from registration form
 func (s *Service) MakeConsumerModel(form models.RegFrom) models.Consumer {
 	return models.Consumer{
 		Email:    form.Email,
 		PassHash: s.hashFrom(form.Email, form.Pass1),
+		Roles:    []string{models.RoleConsumer},
+		Status:   models.StatusActive,
 	}
 }
 
+// HashPassword returns hash of consumer's new password
+func (s *Service) HashPassword(email, password string) string {
+	return s.hashFrom(email, password)
+}
+
 // MatchPasswordHash returns error if result of hashing email and password doesn't match with input hash
 func (s *Service) MatchPasswordHash(hash string, form models.AuthForm) error {
 	in := s.hashFrom(form.Email, form.Pass)
-	if hash != in {
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(in)) != 1 {
 		return &authError{IsUserError: true, Message: "invalid password"}
 	}
 	return nil
 }
 
+// MatchDummyPasswordHash does the same work as MatchPasswordHash for consumer who doesn't exist,
+// so response time doesn't reveal whether email is registered. It always returns error.
+func (s *Service) MatchDummyPasswordHash(form models.AuthForm) error {
+	_ = s.MatchPasswordHash(s.hashFrom(dummyEmail, dummyPassword), form)
+	return &authError{IsUserError: true, Message: "invalid password"}
+}
+
 func (s *Service) hashFrom(email, password string) string {
 	// TODO: Not secure! Must be implemented hash algorithm
 	return email + password
 }
 
+func parseJWT(str string, key []byte) (*jwt.Token, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	return parser.ParseWithClaims(str, &token.Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("signing method is not valid")
+		}
+		return key, nil
+	})
+}
+
+// AllowedScopes returns scopes which consumer with roles is allowed to request.
+// Consumers registered before roles were introduced have no roles, they get scopes of consumer role.
+func (s *Service) AllowedScopes(roles []string) []string {
+	roleScopes := s.RoleScopes
+	if roleScopes == nil {
+		roleScopes = DefaultRoleScopes
+	}
+	if len(roles) == 0 {
+		roles = []string{models.RoleConsumer}
+	}
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, r := range roles {
+		for _, sc := range roleScopes[r] {
+			if !seen[sc] {
+				seen[sc] = true
+				scopes = append(scopes, sc)
+			}
+		}
+	}
+	return scopes
+}
+
+// GrantScopes intersects requested scopes with scopes allowed for roles.
+// All allowed scopes are granted when nothing is requested.
+func (s *Service) GrantScopes(roles, requested []string) ([]string, error) {
+	allowed := s.AllowedScopes(roles)
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	var granted []string
+	for _, r := range requested {
+		for _, a := range allowed {
+			if r == a {
+				granted = append(granted, r)
+				break
+			}
+		}
+	}
+	if len(granted) == 0 {
+		return nil, &authError{IsUserError: true, Message: "requested scopes are not allowed"}
+	}
+	return granted, nil
+}
+
 // CreateJWT returns string of jwt
 func (s *Service) CreateJWT(claims token.Claims) (string, error) {
 	if claims.ID == 0 {
@@ -82,7 +209,8 @@ func (s *Service) CreateJWT(claims token.Claims) (string, error) {
 	}
 
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	str, err := t.SignedString(s.SigningKey)
+	key, _ := s.signingKeys()
+	str, err := t.SignedString(key)
 	if err != nil {
 		return "", &authError{Message: "can's signing token", Err: err}
 	}
@@ -91,15 +219,15 @@ func (s *Service) CreateJWT(claims token.Claims) (string, error) {
 
 // ParseToken claims from token string and returns its
 func (s *Service) ParseToken(str string) (token.Claims, error) {
-	parser := jwt.Parser{SkipClaimsValidation: true}
-	t, err := parser.ParseWithClaims(str, &token.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("signing method is not valid")
-		}
-		return s.SigningKey, nil
-	})
+	current, previous := s.signingKeys()
+	t, err := parseJWT(str, current)
+	var vErr *jwt.ValidationError
+	if previous != nil && errors.As(err, &vErr) && vErr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		s.Logger.Debug("token isn't signed with current key, trying previous one")
+		t, err = parseJWT(str, previous)
+	}
 	if err != nil {
-		return token.Claims{}, &authError{Message: "can't parse token", Err: err}
+		return token.Claims{}, &authError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "can't parse token", Err: err}
 	}
 
 	claims, ok := t.Claims.(*token.Claims)
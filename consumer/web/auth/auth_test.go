@@ -0,0 +1,145 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSigningKey(t *testing.T) {
+	a := auth.NewService(auth.Opts{SigningKey: []byte("first")})
+	claims := token.Claims{ID: 1, StandardClaims: &jwt.StandardClaims{Subject: token.SubjectAccess}}
+	first, err := a.CreateJWT(claims)
+	require.NoError(t, err)
+	firstHash := a.HashSecret("123456")
+
+	a.SetSigningKey([]byte("second"))
+	second, err := a.CreateJWT(claims)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+	for _, tok := range []string{first, second} {
+		c, err := a.ParseToken(tok)
+		require.NoError(t, err)
+		require.Equal(t, 1, c.ID)
+	}
+	require.True(t, a.MatchSecret(firstHash, "123456"))
+	require.True(t, a.MatchSecret(a.HashSecret("123456"), "123456"))
+	require.False(t, a.MatchSecret(firstHash, "654321"))
+
+	a.SetSigningKey([]byte("third"))
+	_, err = a.ParseToken(first)
+	require.Error(t, err)
+	_, err = a.ParseToken(second)
+	require.NoError(t, err)
+	require.False(t, a.MatchSecret(firstHash, "123456"))
+}
+
+func TestParseTokenErrorMessage(t *testing.T) {
+	a := auth.NewService(auth.Opts{SigningKey: []byte("key")})
+	_, err := a.ParseToken("invalid")
+	require.Error(t, err)
+
+	msg := err.Error()
+	require.Equal(t, 1, strings.Count(msg, "can't parse token"))
+	require.Equal(t, msg, err.Error(), "message doesn't change on repeated calls")
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	a := auth.NewService(auth.Opts{SigningKey: []byte("1")})
+	// example from RFC 7636 appendix B
+	require.True(t, a.VerifyCodeChallenge("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+	require.False(t, a.VerifyCodeChallenge("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXK"))
+
+	code, hash, err := a.NewAuthorizationCode()
+	require.NoError(t, err)
+	a.SetSigningKey([]byte("2"))
+	require.Equal(t, hash, a.AuthorizationCodeHash(code))
+}
+
+func TestSetIDTokenKey(t *testing.T) {
+	first, err := auth.GenerateIDTokenKey()
+	require.NoError(t, err)
+	second, err := auth.GenerateIDTokenKey()
+	require.NoError(t, err)
+	a := auth.NewService(auth.Opts{SigningKey: []byte("1"), IDTokenKey: first})
+	claims := token.IDClaims{Nonce: "nonce", StandardClaims: &jwt.StandardClaims{Subject: "1", Audience: "client"}}
+	firstToken, err := a.CreateIDToken(claims)
+	require.NoError(t, err)
+
+	// token must be verifiable with published key only
+	keys := a.JWKS().Keys
+	require.Len(t, keys, 1)
+	require.Equal(t, "RSA", keys[0].KeyType)
+	require.Equal(t, "RS256", keys[0].Algorithm)
+	n, err := base64.RawURLEncoding.DecodeString(keys[0].Modulus)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(keys[0].Exponent)
+	require.NoError(t, err)
+	published := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	parsed, err := jwt.ParseWithClaims(firstToken, &token.IDClaims{}, func(tok *jwt.Token) (interface{}, error) {
+		require.Equal(t, keys[0].KeyID, tok.Header["kid"])
+		return published, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "nonce", parsed.Claims.(*token.IDClaims).Nonce)
+
+	a.SetIDTokenKey(second)
+	require.Len(t, a.JWKS().Keys, 2)
+	require.Equal(t, keys[0], a.JWKS().Keys[1], "previous key must be published after rotation")
+	secondToken, err := a.CreateIDToken(claims)
+	require.NoError(t, err)
+	for _, tok := range []string{firstToken, secondToken} {
+		c, err := a.ParseIDToken(tok)
+		require.NoError(t, err)
+		require.Equal(t, "client", c.Audience)
+	}
+
+	third, err := auth.GenerateIDTokenKey()
+	require.NoError(t, err)
+	a.SetIDTokenKey(third)
+	_, err = a.ParseIDToken(firstToken)
+	require.Error(t, err)
+}
+
+func TestParseIDTokenKey(t *testing.T) {
+	key, err := auth.GenerateIDTokenKey()
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		parsed, err := auth.ParseIDTokenKey(pem.EncodeToMemory(block))
+		require.NoError(t, err, block.Type)
+		require.True(t, key.Equal(parsed))
+	}
+
+	short, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = auth.ParseIDTokenKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(short)}))
+	require.Error(t, err)
+	_, err = auth.ParseIDTokenKey([]byte("not a key"))
+	require.Error(t, err)
+}
+
+func TestGrantScopesWithoutRoles(t *testing.T) {
+	a := auth.NewService(auth.Opts{SigningKey: []byte("1")})
+	scopes, err := a.GrantScopes(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, auth.DefaultRoleScopes[models.RoleConsumer], scopes)
+	_, err = a.GrantScopes(nil, []string{token.ScopeAdmin})
+	require.Error(t, err)
+}
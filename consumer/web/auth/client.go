@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const (
+	clientIDSize     = 16
+	clientSecretSize = 32
+)
+
+// NewClientCredentials returns random id and secret of OAuth client and hash of the secret which must be stored.
+// Secret has enough entropy, so its hash isn't keyed and survives signing key rotation.
+func (s *Service) NewClientCredentials() (id, secret, hash string, err error) {
+	b := make([]byte, clientIDSize+clientSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", &authError{Message: "can't generate client credentials", Err: err}
+	}
+	id = hex.EncodeToString(b[:clientIDSize])
+	secret = base64.RawURLEncoding.EncodeToString(b[clientIDSize:])
+	return id, secret, sha256Hex(secret), nil
+}
+
+// MatchClientSecret reports whether secret matches hash returned by NewClientCredentials
+func (s *Service) MatchClientSecret(hash, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(sha256Hex(secret))) == 1
+}
+
+// NewAuthorizationCode returns random OAuth authorization code and its hash by which code is stored and looked up
+func (s *Service) NewAuthorizationCode() (code, hash string, err error) {
+	if code, err = s.NewLinkSecret(); err != nil {
+		return "", "", err
+	}
+	return code, s.AuthorizationCodeHash(code), nil
+}
+
+// AuthorizationCodeHash returns hash of authorization code. It isn't keyed like hash of client secret,
+// so codes issued before signing key rotation can be found.
+func (s *Service) AuthorizationCodeHash(code string) string {
+	return sha256Hex(code)
+}
+
+// NewRefreshToken returns random OAuth refresh token and its hash by which token is stored and looked up
+func (s *Service) NewRefreshToken() (t, hash string, err error) {
+	if t, err = s.NewLinkSecret(); err != nil {
+		return "", "", err
+	}
+	return t, s.RefreshTokenHash(t), nil
+}
+
+// RefreshTokenHash returns hash of refresh token, it isn't keyed for the same reason as hash of authorization code
+func (s *Service) RefreshTokenHash(t string) string {
+	return sha256Hex(t)
+}
+
+// VerifyCodeChallenge reports whether PKCE code verifier matches S256 code challenge as defined by RFC 7636
+func (s *Service) VerifyCodeChallenge(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(base64.RawURLEncoding.EncodeToString(sum[:]))) == 1
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
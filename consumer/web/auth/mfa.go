@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodesCount - count of recovery codes generated for consumer
+const RecoveryCodesCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns one-time recovery codes which must be shown to consumer
+// and their hashes which must be stored
+func (s *Service) NewRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodesCount; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, &authError{Message: "can't generate recovery code", Err: err}
+		}
+		c := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		c = c[:4] + "-" + c[4:]
+		codes = append(codes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
+
+// MatchRecoveryCode returns index of code's hash in hashes or -1 if code doesn't match any of them
+func (s *Service) MatchRecoveryCode(hashes []string, code string) int {
+	h := hashRecoveryCode(code)
+	idx := -1
+	for i := range hashes {
+		if subtle.ConstantTimeCompare([]byte(hashes[i]), []byte(h)) == 1 {
+			idx = i
+		}
+	}
+	return idx
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/logging"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+// RequireScope returns middleware which passes request to the next handler only if it carries
+// a valid bearer token with scope. Claims of the token are available via token.FromContext.
+func (s *Service) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := s.claimsFromRequest(r)
+			if err != nil {
+				s.Metrics.TokenRejected(consumer.ErrorCode(err))
+				logging.WithContext(r.Context(), s.Logger).Debug("request token rejected",
+					zap.String("scope", scope), zap.String("error_code", consumer.ErrorCode(err)), zap.Error(err))
+				// RFC 6750 doesn't allow error code in challenge of request without credentials
+				challenge := `Bearer error="invalid_token"`
+				if consumer.ErrorCode(err) == consumer.CodeTokenRequired {
+					challenge = "Bearer"
+				}
+				w.Header().Set("WWW-Authenticate", challenge)
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+			if !claims.HasScope(scope) {
+				s.Metrics.TokenRejected(consumer.CodeInsufficientScope)
+				logging.WithContext(r.Context(), s.Logger).Debug("request token lacks scope",
+					zap.String("scope", scope), zap.String("subject", claims.Subject))
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				writeError(w, http.StatusForbidden, &authError{IsUserError: true, Code: consumer.CodeInsufficientScope, Message: "insufficient scope"})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(token.NewContext(r.Context(), claims)))
+		})
+	}
+}
+
+func (s *Service) claimsFromRequest(r *http.Request) (token.Claims, error) {
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return token.Claims{}, &authError{IsUserError: true, Code: consumer.CodeTokenRequired, Message: "bearer token is required"}
+	}
+	claims, err := s.ParseToken(h[len(prefix):])
+	if err != nil {
+		return token.Claims{}, err
+	}
+	if err := claims.Valid(); err != nil {
+		return token.Claims{}, &authError{IsUserError: true, Code: claimsErrorCode(err), Message: "invalid token", Err: err}
+	}
+	if !claims.IsAccess() {
+		return token.Claims{}, &authError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "token is not an access token"}
+	}
+	return claims, nil
+}
+
+// claimsErrorCode returns code of claims validation error
+func claimsErrorCode(err error) string {
+	var vErr *jwt.ValidationError
+	if errors.As(err, &vErr) && vErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return consumer.CodeTokenExpired
+	}
+	return consumer.CodeInvalidToken
+}
+
+// writeError writes message which tells client why token was rejected,
+// text of nested parsing and validation errors isn't shown
+func writeError(w http.ResponseWriter, code int, err error) {
+	msg := "invalid token"
+	if aErr, ok := err.(*authError); ok {
+		msg = aErr.Message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{Message: msg})
+}
@@ -0,0 +1,61 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireScope(t *testing.T) {
+	a := auth.NewService(auth.Opts{SigningKey: []byte("1")})
+	handler := a.RequireScope(token.ScopeProfileRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := token.FromContext(r.Context())
+		require.True(t, ok)
+		require.Equal(t, 1, claims.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	newToken := func(scope string) string {
+		str, err := a.CreateJWT(token.Claims{
+			ID:    1,
+			Scope: scope,
+			StandardClaims: &jwt.StandardClaims{
+				Subject:   token.SubjectAccess,
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		})
+		require.NoError(t, err)
+		return str
+	}
+
+	for _, tst := range []struct {
+		name      string
+		header    string
+		code      int
+		challenge string
+	}{
+		{name: "without token", header: "", code: http.StatusUnauthorized, challenge: "Bearer"},
+		{name: "invalid token", header: "Bearer invalid", code: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+		{name: "missing scope", header: "Bearer " + newToken(token.ScopeProfileWrite), code: http.StatusForbidden,
+			challenge: `Bearer error="insufficient_scope", scope="profile:read"`},
+		{name: "granted scope", header: "Bearer " + newToken("profile:write profile:read"), code: http.StatusNoContent},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tst.header != "" {
+				r.Header.Set("Authorization", tst.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tst.code, w.Code)
+			require.Equal(t, tst.challenge, w.Header().Get("WWW-Authenticate"))
+			require.NotContains(t, w.Body.String(), "-->", "nested error must not be shown")
+		})
+	}
+}
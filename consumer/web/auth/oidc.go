@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+// MinIDTokenKeyBits - min size of RSA key which signs ID tokens
+const MinIDTokenKeyBits = 2048
+
+// JWK - public key in JSON Web Key format as defined by RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JWKSet - set of public keys which verify ID tokens
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseIDTokenKey parses PEM encoded RSA private key in PKCS #1 or PKCS #8 form
+func ParseIDTokenKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("key isn't PEM encoded")
+	}
+	var key interface{}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return nil, errors.New("key isn't RSA private key in PKCS #1 or PKCS #8 form")
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key isn't RSA private key")
+	}
+	if rsaKey.N.BitLen() < MinIDTokenKeyBits {
+		return nil, errors.New("key is shorter than 2048 bits")
+	}
+	return rsaKey, nil
+}
+
+// GenerateIDTokenKey returns new random RSA key which signs ID tokens
+func GenerateIDTokenKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, MinIDTokenKeyBits)
+}
+
+// SetIDTokenKey replaces key which signs ID tokens. The previous key is still published
+// in JWKS until the next replacement, so clients can verify ID tokens signed before rotation.
+func (s *Service) SetIDTokenKey(key *rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.IDTokenKey != nil && s.IDTokenKey.Equal(key) {
+		return
+	}
+	s.previousIDKey, s.IDTokenKey = s.IDTokenKey, key
+	s.Logger.Info("id token key rotated", zap.Bool("had_previous", s.previousIDKey != nil))
+}
+
+// idTokenKeys returns current key and previous one which is nil if key wasn't replaced
+func (s *Service) idTokenKeys() (current, previous *rsa.PrivateKey) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.IDTokenKey, s.previousIDKey
+}
+
+// CreateIDToken returns OpenID Connect ID token signed with RS256, key id is set in token header
+func (s *Service) CreateIDToken(claims token.IDClaims) (string, error) {
+	key, _ := s.idTokenKeys()
+	if key == nil {
+		return "", &authError{Message: "id token key isn't loaded"}
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = keyID(&key.PublicKey)
+	str, err := t.SignedString(key)
+	if err != nil {
+		return "", &authError{Message: "can't sign id token", Err: err}
+	}
+	return str, nil
+}
+
+// ParseIDToken verifies signature of ID token issued by service and returns its claims.
+// Claims aren't validated.
+func (s *Service) ParseIDToken(str string) (token.IDClaims, error) {
+	current, previous := s.idTokenKeys()
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}, SkipClaimsValidation: true}
+	t, err := parser.ParseWithClaims(str, &token.IDClaims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, k := range []*rsa.PrivateKey{current, previous} {
+			if k != nil && keyID(&k.PublicKey) == kid {
+				return &k.PublicKey, nil
+			}
+		}
+		return nil, errors.New("unknown key id")
+	})
+	if err != nil {
+		return token.IDClaims{}, &authError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "can't parse id token", Err: err}
+	}
+	return *t.Claims.(*token.IDClaims), nil
+}
+
+// JWKS returns public keys which verify ID tokens, current key goes first
+func (s *Service) JWKS() JWKSet {
+	current, previous := s.idTokenKeys()
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range []*rsa.PrivateKey{current, previous} {
+		if k != nil {
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				Use:       "sig",
+				Algorithm: jwt.SigningMethodRS256.Alg(),
+				KeyID:     keyID(&k.PublicKey),
+				Modulus:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+	}
+	return set
+}
+
+// keyID returns JWK thumbprint of key as defined by RFC 7638
+func keyID(key *rsa.PublicKey) string {
+	// members are in lexicographic order and encoding/json doesn't add whitespace as the RFC requires
+	b, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+	})
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+)
+
+const linkSecretSize = 32
+
+// NewLinkSecret returns random url-safe secret for links sent by email
+func (s *Service) NewLinkSecret() (string, error) {
+	b := make([]byte, linkSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", &authError{Message: "can't generate secret", Err: err}
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewCode returns random numeric code of digits length
+func (s *Service) NewCode(digits int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", &authError{Message: "can't generate code", Err: err}
+	}
+	code := n.String()
+	for len(code) < digits {
+		code = "0" + code
+	}
+	return code, nil
+}
+
+// HashSecret returns keyed hash of one-time secret which is safe to store.
+// Keyed hash doesn't allow to brute force short codes without signing key.
+func (s *Service) HashSecret(secret string) string {
+	key, _ := s.signingKeys()
+	return hashSecret(key, secret)
+}
+
+// MatchSecret reports whether secret matches hash returned by HashSecret with current or previous signing key
+func (s *Service) MatchSecret(hash, secret string) bool {
+	current, previous := s.signingKeys()
+	if hmac.Equal([]byte(hash), []byte(hashSecret(current, secret))) {
+		return true
+	}
+	return previous != nil && hmac.Equal([]byte(hash), []byte(hashSecret(previous, secret)))
+}
+
+func hashSecret(key []byte, secret string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
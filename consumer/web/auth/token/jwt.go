@@ -1,19 +1,61 @@
 package token
 
 import (
+	"context"
 	"errors"
+	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+// Access token scopes
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeAdmin        = "admin"
+)
+
+// OpenID Connect scopes, they grant access to identity claims and are granted regardless of roles
+const (
+	ScopeOpenID  = "openid"
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+// IsIdentityScope reports whether scope is OpenID Connect scope
+func IsIdentityScope(scope string) bool {
+	return scope == ScopeOpenID || scope == ScopeEmail || scope == ScopeProfile
+}
+
+// Token subjects
+const (
+	SubjectAccess     = "access"
+	SubjectRefresh    = "refresh"
+	SubjectMFAPending = "mfa_pending"
+
+	SubjectWebAuthnRegistration = "webauthn_registration"
+	SubjectWebAuthnLogin        = "webauthn_login"
+
+	SubjectExportDownload = "export_download"
+)
+
 // Claims - custom jwt token claims
 type Claims struct {
-	ID int
+	ID    int
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+	// ClientID is id of OAuth client which token was issued to, it is empty for first-party logins
+	ClientID string `json:"client_id,omitempty"`
+	// AuthTime is when consumer authenticated, it is kept when token is refreshed
+	AuthTime int64 `json:"auth_time,omitempty"`
 	*jwt.StandardClaims
 }
 
 // Valid claims
 func (c Claims) Valid() error {
+	if c.StandardClaims == nil {
+		return errors.New("missing standard claims")
+	}
 	if err := c.StandardClaims.Valid(); err != nil {
 		return err
 	}
@@ -22,3 +64,72 @@ func (c Claims) Valid() error {
 	}
 	return nil
 }
+
+// IsAccess reports whether token grants access to resources
+func (c Claims) IsAccess() bool {
+	return c.StandardClaims != nil && c.Subject == SubjectAccess
+}
+
+// AuthenticatedAt returns when consumer authenticated, issue time is used for tokens without auth_time
+func (c Claims) AuthenticatedAt() int64 {
+	if c.AuthTime != 0 || c.StandardClaims == nil {
+		return c.AuthTime
+	}
+	return c.IssuedAt
+}
+
+// Scopes returns list of scopes granted to the token holder
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether scope was granted to the token holder
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the token holder has role
+func (c Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IDClaims - claims of OpenID Connect ID token. Subject is consumer id and Audience is id of client.
+type IDClaims struct {
+	Email         string   `json:"email,omitempty"`
+	EmailVerified *bool    `json:"email_verified,omitempty"` // set only if email scope is granted
+	Nonce         string   `json:"nonce,omitempty"`
+	AuthTime      int64    `json:"auth_time"`
+	AMR           []string `json:"amr,omitempty"`
+	*jwt.StandardClaims
+}
+
+// Valid ID token claims
+func (c IDClaims) Valid() error {
+	if c.StandardClaims == nil {
+		return errors.New("missing standard claims")
+	}
+	return c.StandardClaims.Valid()
+}
+
+type ctxKey struct{}
+
+// NewContext returns copy of ctx which carries claims
+func NewContext(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns claims stored in ctx by NewContext
+func FromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(ctxKey{}).(Claims)
+	return c, ok
+}
@@ -0,0 +1,444 @@
+// Package webauthn implements relying party side of Web Authentication ceremonies (W3C WebAuthn Level 2)
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Attestation types of registered credential
+const (
+	AttestationNone  = "none"
+	AttestationSelf  = "self"
+	AttestationBasic = "basic"
+)
+
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+
+	challengeSize     = 32
+	maxCredentialSize = 1023
+	defaultTimeout    = 5 * time.Minute
+)
+
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// URLEncodedBytes is encoded to JSON as base64url string without padding
+type URLEncodedBytes []byte
+
+// MarshalJSON implements json.Marshaler
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := base64.RawURLEncoding.DecodeString(trimPadding(s))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// Config - relying party settings
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+	Timeout time.Duration
+}
+
+// RelyingParty - PublicKeyCredentialRpEntity
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// User - PublicKeyCredentialUserEntity
+type User struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+// CredentialParameter - PublicKeyCredentialParameters
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor - PublicKeyCredentialDescriptor
+type CredentialDescriptor struct {
+	Type string          `json:"type"`
+	ID   URLEncodedBytes `json:"id"`
+}
+
+// AuthenticatorSelection - AuthenticatorSelectionCriteria
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions - PublicKeyCredentialCreationOptions passed to navigator.credentials.create
+type CreationOptions struct {
+	Challenge              URLEncodedBytes        `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions - PublicKeyCredentialRequestOptions passed to navigator.credentials.get
+type RequestOptions struct {
+	Challenge        URLEncodedBytes        `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse - result of navigator.credentials.create
+type AttestationResponse struct {
+	ID       URLEncodedBytes                  `json:"rawId"`
+	Type     string                           `json:"type"`
+	Response AuthenticatorAttestationResponse `json:"response"`
+}
+
+// AuthenticatorAttestationResponse - response of authenticator on registration
+type AuthenticatorAttestationResponse struct {
+	ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+	AttestationObject URLEncodedBytes `json:"attestationObject"`
+}
+
+// AssertionResponse - result of navigator.credentials.get
+type AssertionResponse struct {
+	ID       URLEncodedBytes                `json:"rawId"`
+	Type     string                         `json:"type"`
+	Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+// AuthenticatorAssertionResponse - response of authenticator on authentication
+type AuthenticatorAssertionResponse struct {
+	ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+	AuthenticatorData URLEncodedBytes `json:"authenticatorData"`
+	Signature         URLEncodedBytes `json:"signature"`
+	UserHandle        URLEncodedBytes `json:"userHandle,omitempty"`
+}
+
+// Credential - verified public key credential which must be stored by relying party
+type Credential struct {
+	ID              []byte
+	PublicKey       []byte // COSE_Key
+	SignCount       uint32
+	AAGUID          []byte
+	AttestationType string
+}
+
+// NewChallenge returns random challenge for ceremony
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// CreationOptions returns options of registration ceremony.
+// Credentials listed in exclude can't be registered again.
+func (c Config) CreationOptions(challenge []byte, user User, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            c.timeout().Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns options of authentication ceremony
+func (c Config) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          c.timeout().Milliseconds(),
+		RPID:             c.RPID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// timeout returns lifetime of ceremony
+func (c Config) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultTimeout
+	}
+	return c.Timeout
+}
+
+// VerifyRegistration verifies response of registration ceremony started with challenge
+// and returns credential. Attestation formats "none" and "packed" are supported.
+func (c Config) VerifyRegistration(challenge []byte, resp AttestationResponse) (Credential, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, errors.New("invalid credential type")
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	v, rest, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return Credential{}, errors.New("invalid attestation object")
+	}
+	format, _ := obj["fmt"].(string)
+	rawAuthData, _ := obj["authData"].([]byte)
+	attStmt, _ := obj["attStmt"].(map[interface{}]interface{})
+
+	authData, err := c.parseAuthData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedCredData == 0 {
+		return Credential{}, errors.New("attested credential data is missing")
+	}
+	if !bytes.Equal(authData.credentialID, resp.ID) {
+		return Credential{}, errors.New("credential id doesn't match")
+	}
+	key, err := parsePublicKey(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	cred := Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+		AAGUID:    authData.aaguid,
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	switch format {
+	case "none":
+		if len(attStmt) != 0 {
+			return Credential{}, errors.New("attestation statement of format none must be empty")
+		}
+		cred.AttestationType = AttestationNone
+	case "packed":
+		signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+		if cred.AttestationType, err = verifyPacked(attStmt, signed, key, authData.aaguid); err != nil {
+			return Credential{}, err
+		}
+	default:
+		return Credential{}, fmt.Errorf("unsupported attestation format %q", format)
+	}
+	return cred, nil
+}
+
+// VerifyAssertion verifies response of authentication ceremony started with challenge
+// against stored credential's public key and sign counter, and returns new sign counter value
+func (c Config) VerifyAssertion(challenge, publicKey []byte, signCount uint32, resp AssertionResponse) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, errors.New("invalid credential type")
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := c.parseAuthData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.verify(signed, resp.Response.Signature); err != nil {
+		return 0, err
+	}
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return 0, errors.New("sign counter is not increased, authenticator may be cloned")
+	}
+	return authData.signCount, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (c Config) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("invalid client data: %w", err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("invalid client data type %q", cd.Type)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(trimPadding(cd.Challenge))
+	if err != nil || !bytes.Equal(got, challenge) {
+		return errors.New("challenge doesn't match")
+	}
+	for _, o := range c.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", cd.Origin)
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (c Config) parseAuthData(b []byte) (authenticatorData, error) {
+	if len(b) < 37 {
+		return authenticatorData{}, errors.New("authenticator data is too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return authenticatorData{}, errors.New("relying party id hash doesn't match")
+	}
+	ad := authenticatorData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if ad.flags&flagUserPresent == 0 {
+		return authenticatorData{}, errors.New("user is not present")
+	}
+	if ad.flags&flagUserVerified == 0 {
+		return authenticatorData{}, errors.New("user is not verified")
+	}
+	if ad.flags&flagAttestedCredData == 0 {
+		return ad, nil
+	}
+
+	b = b[37:]
+	if len(b) < 18 {
+		return authenticatorData{}, errors.New("attested credential data is too short")
+	}
+	ad.aaguid = b[:16]
+	n := int(binary.BigEndian.Uint16(b[16:18]))
+	b = b[18:]
+	if n > maxCredentialSize || len(b) < n {
+		return authenticatorData{}, errors.New("invalid credential id length")
+	}
+	ad.credentialID = b[:n]
+	b = b[n:]
+	_, rest, err := decodeCBOR(b)
+	if err != nil {
+		return authenticatorData{}, fmt.Errorf("invalid credential public key: %w", err)
+	}
+	ad.publicKey = b[:len(b)-len(rest)]
+	return ad, nil
+}
+
+func verifyPacked(stmt map[interface{}]interface{}, signed []byte, credKey publicKey, aaguid []byte) (string, error) {
+	alg, _ := stmt["alg"].(int64)
+	sig, _ := stmt["sig"].([]byte)
+	if len(sig) == 0 {
+		return "", errors.New("packed attestation signature is missing")
+	}
+	x5c, ok := stmt["x5c"].([]interface{})
+	if !ok {
+		if alg != credKey.alg {
+			return "", errors.New("packed self attestation algorithm doesn't match credential")
+		}
+		if err := credKey.verify(signed, sig); err != nil {
+			return "", fmt.Errorf("invalid packed self attestation: %w", err)
+		}
+		return AttestationSelf, nil
+	}
+
+	if len(x5c) == 0 {
+		return "", errors.New("attestation certificate is missing")
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", fmt.Errorf("invalid attestation certificate: %w", err)
+	}
+	sigAlg, err := x509SignatureAlgorithm(alg)
+	if err != nil {
+		return "", err
+	}
+	if err := cert.CheckSignature(sigAlg, signed, sig); err != nil {
+		return "", fmt.Errorf("invalid packed attestation: %w", err)
+	}
+	if err := verifyAttestationCert(cert, aaguid); err != nil {
+		return "", err
+	}
+	return AttestationBasic, nil
+}
+
+// verifyAttestationCert checks packed attestation certificate requirements (WebAuthn §8.2.1).
+// Certificate chain is not verified against trust anchors.
+func verifyAttestationCert(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return errors.New("attestation certificate version must be 3")
+	}
+	if cert.IsCA {
+		return errors.New("attestation certificate must not be CA")
+	}
+	ou := cert.Subject.OrganizationalUnit
+	if len(ou) != 1 || ou[0] != "Authenticator Attestation" {
+		return errors.New("invalid attestation certificate subject")
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		var v []byte
+		if _, err := asn1.Unmarshal(ext.Value, &v); err != nil || !bytes.Equal(v, aaguid) {
+			return errors.New("attestation certificate aaguid doesn't match")
+		}
+	}
+	return nil
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	var d []CredentialDescriptor
+	for _, id := range ids {
+		d = append(d, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return d
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
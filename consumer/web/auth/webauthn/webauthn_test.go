@@ -0,0 +1,185 @@
+package webauthn_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn/webauthntest"
+
+	"github.com/stretchr/testify/require"
+)
+
+var config = webauthn.Config{
+	RPID:    "localhost",
+	RPName:  "Consumer service",
+	Origins: []string{"https://localhost"},
+}
+
+func newAuthenticator(t *testing.T) *webauthntest.Authenticator {
+	a, err := webauthntest.New(config.RPID, config.Origins[0])
+	require.NoError(t, err)
+	return a
+}
+
+func newChallenge(t *testing.T) []byte {
+	c, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+	return c
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	t.Run("none attestation", func(t *testing.T) {
+		a := newAuthenticator(t)
+		a.Format = webauthntest.FormatNone
+		challenge := newChallenge(t)
+		resp, err := a.Register(challenge)
+		require.NoError(t, err)
+
+		cred, err := config.VerifyRegistration(challenge, resp)
+		require.NoError(t, err)
+		require.Equal(t, a.CredentialID, cred.ID)
+		require.Equal(t, a.PublicKey(), cred.PublicKey)
+		require.Equal(t, webauthn.AttestationNone, cred.AttestationType)
+	})
+
+	t.Run("packed self attestation", func(t *testing.T) {
+		a := newAuthenticator(t)
+		challenge := newChallenge(t)
+		resp, err := a.Register(challenge)
+		require.NoError(t, err)
+
+		cred, err := config.VerifyRegistration(challenge, resp)
+		require.NoError(t, err)
+		require.Equal(t, webauthn.AttestationSelf, cred.AttestationType)
+		require.Equal(t, uint32(1), cred.SignCount)
+	})
+
+	t.Run("packed basic attestation", func(t *testing.T) {
+		a := newAuthenticator(t)
+		a.AttestationKey, a.AttestationCert = newAttestationCert(t, "Authenticator Attestation")
+		challenge := newChallenge(t)
+		resp, err := a.Register(challenge)
+		require.NoError(t, err)
+
+		cred, err := config.VerifyRegistration(challenge, resp)
+		require.NoError(t, err)
+		require.Equal(t, webauthn.AttestationBasic, cred.AttestationType)
+	})
+
+	t.Run("packed attestation with invalid certificate", func(t *testing.T) {
+		a := newAuthenticator(t)
+		a.AttestationKey, a.AttestationCert = newAttestationCert(t, "Somebody")
+		challenge := newChallenge(t)
+		resp, err := a.Register(challenge)
+		require.NoError(t, err)
+
+		_, err = config.VerifyRegistration(challenge, resp)
+		require.Error(t, err)
+	})
+
+	t.Run("wrong challenge", func(t *testing.T) {
+		resp, err := newAuthenticator(t).Register(newChallenge(t))
+		require.NoError(t, err)
+
+		_, err = config.VerifyRegistration(newChallenge(t), resp)
+		require.Error(t, err)
+	})
+
+	t.Run("wrong origin", func(t *testing.T) {
+		a := newAuthenticator(t)
+		a.Origin = "https://evil.com"
+		challenge := newChallenge(t)
+		resp, err := a.Register(challenge)
+		require.NoError(t, err)
+
+		_, err = config.VerifyRegistration(challenge, resp)
+		require.Error(t, err)
+	})
+
+	t.Run("wrong relying party", func(t *testing.T) {
+		a := newAuthenticator(t)
+		a.RPID = "evil.com"
+		challenge := newChallenge(t)
+		resp, err := a.Register(challenge)
+		require.NoError(t, err)
+
+		_, err = config.VerifyRegistration(challenge, resp)
+		require.Error(t, err)
+	})
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a := newAuthenticator(t)
+	challenge := newChallenge(t)
+	reg, err := a.Register(challenge)
+	require.NoError(t, err)
+	cred, err := config.VerifyRegistration(challenge, reg)
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		challenge := newChallenge(t)
+		resp, err := a.Assert(challenge)
+		require.NoError(t, err)
+
+		count, err := config.VerifyAssertion(challenge, cred.PublicKey, cred.SignCount, resp)
+		require.NoError(t, err)
+		require.Equal(t, a.SignCount, count)
+	})
+
+	t.Run("sign counter is not increased", func(t *testing.T) {
+		challenge := newChallenge(t)
+		resp, err := a.Assert(challenge)
+		require.NoError(t, err)
+
+		_, err = config.VerifyAssertion(challenge, cred.PublicKey, a.SignCount, resp)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		challenge := newChallenge(t)
+		resp, err := a.Assert(challenge)
+		require.NoError(t, err)
+		resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0xff
+
+		_, err = config.VerifyAssertion(challenge, cred.PublicKey, cred.SignCount, resp)
+		require.Error(t, err)
+	})
+
+	t.Run("registration response is not accepted", func(t *testing.T) {
+		challenge := newChallenge(t)
+		reg, err := a.Register(challenge)
+		require.NoError(t, err)
+		resp := webauthn.AssertionResponse{ID: reg.ID, Type: reg.Type}
+		resp.Response.ClientDataJSON = reg.Response.ClientDataJSON
+
+		_, err = config.VerifyAssertion(challenge, cred.PublicKey, cred.SignCount, resp)
+		require.Error(t, err)
+	})
+}
+
+func newAttestationCert(t *testing.T, ou string) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test"},
+			OrganizationalUnit: []string{ou},
+			CommonName:         "Test authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return key, der
+}
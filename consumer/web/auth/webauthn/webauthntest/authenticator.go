@@ -0,0 +1,168 @@
+// Package webauthntest provides software authenticator for testing WebAuthn ceremonies
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+)
+
+// Attestation formats produced by Authenticator
+const (
+	FormatNone   = "none"
+	FormatPacked = "packed"
+)
+
+// Authenticator - software authenticator with single ES256 credential.
+// Packed attestation is self attestation unless AttestationCert is set.
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	Format       string
+	AAGUID       []byte
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	Key          *ecdsa.PrivateKey
+
+	AttestationCert []byte // DER encoded
+	AttestationKey  *ecdsa.PrivateKey
+}
+
+// New returns authenticator with new credential for relying party
+func New(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		Format:       FormatPacked,
+		AAGUID:       make([]byte, 16),
+		CredentialID: id,
+		Key:          key,
+	}, nil
+}
+
+// Register returns response of navigator.credentials.create for challenge
+func (a *Authenticator) Register(challenge []byte) (webauthn.AttestationResponse, error) {
+	clientData, err := a.clientData("webauthn.create", challenge)
+	if err != nil {
+		return webauthn.AttestationResponse{}, err
+	}
+	a.SignCount++
+	authData := a.authData(true)
+
+	stmt := cborMap{}
+	if a.Format == FormatPacked {
+		key := a.Key
+		if a.AttestationCert != nil {
+			key = a.AttestationKey
+		}
+		sig, err := sign(key, authData, clientData)
+		if err != nil {
+			return webauthn.AttestationResponse{}, err
+		}
+		stmt = cborMap{{"alg", int64(webauthn.AlgES256)}, {"sig", sig}}
+		if a.AttestationCert != nil {
+			stmt = append(stmt, cborPair{"x5c", []interface{}{a.AttestationCert}})
+		}
+	}
+	obj := encodeCBOR(cborMap{
+		{"fmt", a.Format},
+		{"attStmt", stmt},
+		{"authData", authData},
+	})
+	return webauthn.AttestationResponse{
+		ID:   a.CredentialID,
+		Type: "public-key",
+		Response: webauthn.AuthenticatorAttestationResponse{
+			ClientDataJSON:    clientData,
+			AttestationObject: obj,
+		},
+	}, nil
+}
+
+// Assert returns response of navigator.credentials.get for challenge
+func (a *Authenticator) Assert(challenge []byte) (webauthn.AssertionResponse, error) {
+	clientData, err := a.clientData("webauthn.get", challenge)
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+	a.SignCount++
+	authData := a.authData(false)
+	sig, err := sign(a.Key, authData, clientData)
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+	return webauthn.AssertionResponse{
+		ID:   a.CredentialID,
+		Type: "public-key",
+		Response: webauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         sig,
+			UserHandle:        a.UserHandle,
+		},
+	}, nil
+}
+
+// PublicKey returns COSE encoded public key of credential
+func (a *Authenticator) PublicKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.Key.X.FillBytes(x)
+	a.Key.Y.FillBytes(y)
+	return encodeCBOR(cborMap{
+		{int64(1), int64(2)},
+		{int64(3), int64(webauthn.AlgES256)},
+		{int64(-1), int64(1)},
+		{int64(-2), x},
+		{int64(-3), y},
+	})
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.Origin,
+	})
+}
+
+func (a *Authenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	b := append([]byte(nil), rpIDHash[:]...)
+	flags := byte(0x01 | 0x04) // user present, user verified
+	if attested {
+		flags |= 0x40
+	}
+	b = append(b, flags)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], a.SignCount)
+	if !attested {
+		return b
+	}
+	b = append(b, a.AAGUID...)
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(a.CredentialID)))
+	b = append(b, a.CredentialID...)
+	return append(b, a.PublicKey()...)
+}
+
+func sign(key *ecdsa.PrivateKey, authData, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, key, digest[:])
+}
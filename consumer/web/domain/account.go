@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.uber.org/zap"
+)
+
+// ChangePassword replaces password of consumer with new one and publishes PasswordChanged, current password
+// must be entered even if consumer logged in recently. Failed attempts are throttled like failed logins.
+// Update is retried if consumer was modified concurrently.
+func (s *Service) ChangePassword(ctx context.Context, consumerID int, form models.PasswordChangeForm) (models.Consumer, error) {
+	if err := form.Validate(); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't change password", Err: err}
+	}
+	if err := s.PasswordPolicy.Check(form.NewPass); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't change password", Err: err}
+	}
+	c, err := s.ActiveConsumer(ctx, consumerID)
+	if err != nil {
+		return models.Consumer{}, err
+	}
+	if _, err := s.checkCredentials(ctx, models.AuthForm{Email: c.Email, Pass: form.Pass, Client: form.Client}); err != nil {
+		return models.Consumer{}, err
+	}
+	for attempt := 0; ; attempt++ {
+		c.PassHash = s.hashPassword(ctx, c.Email, form.NewPass)
+		err = s.storeFor(ctx).Atomic(func(tx store.DataStore) error {
+			var err error
+			if c, err = tx.UpdateConsumer(c); err != nil {
+				return err
+			}
+			return addEvent(tx, models.PasswordChanged{ConsumerID: c.ID, ChangedAt: time.Now().Unix()})
+		})
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			if c, err = s.ActiveConsumer(ctx, consumerID); err != nil {
+				return models.Consumer{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return models.Consumer{}, &domainError{Message: "can't change password", Err: err}
+		}
+		break
+	}
+	s.log(ctx).Info("password changed", zap.Int("consumer_id", c.ID))
+	s.audit(ctx, models.AuditEvent{
+		Type:       models.AuditCredentialChanged,
+		ConsumerID: c.ID,
+		IP:         form.Client.IP,
+		UserAgent:  form.Client.UserAgent,
+		Details:    map[string]string{"change": "password_changed"},
+	})
+	return c, nil
+}
+
+// ChangeEmail replaces email of consumer with new one which must be verified again and publishes EmailChanged.
+// Password is required to hash it with new email, failed attempts are throttled like failed logins.
+// Update is retried if consumer was modified concurrently.
+func (s *Service) ChangeEmail(ctx context.Context, consumerID int, form models.EmailChangeForm) (models.Consumer, error) {
+	if err := form.Validate(); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't change email", Err: err}
+	}
+	c, err := s.ActiveConsumer(ctx, consumerID)
+	if err != nil {
+		return models.Consumer{}, err
+	}
+	if _, err := s.checkCredentials(ctx, models.AuthForm{Email: c.Email, Pass: form.Pass, Client: form.Client}); err != nil {
+		return models.Consumer{}, err
+	}
+	for attempt := 0; ; attempt++ {
+		if strings.EqualFold(c.Email, form.Email) {
+			return models.Consumer{}, &domainError{IsUserError: true, Message: "new email must differ from current one"}
+		}
+		oldEmail := c.Email
+		c.Email = form.Email
+		c.EmailVerified = false
+		c.PassHash = s.hashPassword(ctx, form.Email, form.Pass)
+		err = s.storeFor(ctx).Atomic(func(tx store.DataStore) error {
+			var err error
+			if c, err = tx.UpdateConsumer(c); err != nil {
+				return err
+			}
+			return addEvent(tx, models.EmailChanged{ConsumerID: c.ID, OldEmail: oldEmail, Email: c.Email, ChangedAt: time.Now().Unix()})
+		})
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			if c, err = s.ActiveConsumer(ctx, consumerID); err != nil {
+				return models.Consumer{}, err
+			}
+			continue
+		}
+		if errors.Is(err, store.ErrAlreadyExists) {
+			return models.Consumer{}, &domainError{IsUserError: true, Message: "user is already exist"}
+		}
+		if err != nil {
+			return models.Consumer{}, &domainError{Message: "can't change email", Err: err}
+		}
+		break
+	}
+	s.log(ctx).Info("email changed", zap.Int("consumer_id", c.ID), zap.String("email", c.Email))
+	s.audit(ctx, models.AuditEvent{
+		Type:       models.AuditCredentialChanged,
+		ConsumerID: c.ID,
+		Email:      c.Email,
+		IP:         form.Client.IP,
+		UserAgent:  form.Client.UserAgent,
+		Details:    map[string]string{"change": "email_changed"},
+	})
+	return c, nil
+}
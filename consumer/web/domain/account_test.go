@@ -0,0 +1,123 @@
+package domain_test
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestChangePassword() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.comold", Version: 1}
+	var saved models.Consumer
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		saved = c
+		c.Version++
+		return c, nil
+	})
+	c, err := d.domain.ChangePassword(context.Background(), 1, models.PasswordChangeForm{
+		Pass:    "old",
+		NewPass: "new",
+		Client:  models.ClientInfo{IP: "127.0.0.1"},
+	})
+
+	d.Require().NoError(err)
+	d.Require().Equal(2, c.Version)
+	d.Require().Equal("test@test.comnew", saved.PassHash)
+	d.Require().Len(d.audit, 1)
+	d.Require().Equal(models.AuditCredentialChanged, d.audit[0].Type)
+	d.Require().Equal("password_changed", d.audit[0].Details["change"])
+	d.Require().Equal("127.0.0.1", d.audit[0].IP)
+	d.Require().Len(d.outbox, 1)
+	d.Require().Equal(models.EventPasswordChanged, d.outbox[0].Type)
+	d.Require().Equal(user.ID, d.outbox[0].ConsumerID)
+}
+
+func (d *DomainSuite) TestChangePasswordRetriesConflict() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.comold", Version: 1}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil).Times(2)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	gomock.InOrder(
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).Return(models.Consumer{}, store.ErrConflict),
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+			return c, nil
+		}),
+	)
+	c, err := d.domain.ChangePassword(context.Background(), 1, models.PasswordChangeForm{Pass: "old", NewPass: "new"})
+
+	d.Require().NoError(err)
+	d.Require().Equal("test@test.comnew", c.PassHash)
+}
+
+func (d *DomainSuite) TestChangePasswordInvalidPassword() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.comold"}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	_, err := d.domain.ChangePassword(context.Background(), 1, models.PasswordChangeForm{Pass: "wrong", NewPass: "new"})
+
+	d.Require().Equal(consumer.CodeInvalidCredentials, consumer.ErrorCode(err))
+	d.Require().Len(d.audit, 1)
+	d.Require().Equal(models.AuditLoginFailed, d.audit[0].Type)
+}
+
+func (d *DomainSuite) TestChangePasswordPolicy() {
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{PasswordPolicy: models.PasswordPolicy{MinLength: 8}})
+	_, err := service.ChangePassword(context.Background(), 1, models.PasswordChangeForm{Pass: "old", NewPass: "new"})
+
+	d.Require().Error(err)
+	cErr, ok := err.(consumer.Error)
+	d.Require().True(ok)
+	d.Require().True(cErr.UserError())
+}
+
+func (d *DomainSuite) TestChangeEmail() {
+	user := models.Consumer{ID: 1, Email: "old@test.com", EmailVerified: true, PassHash: "old@test.compassword", Version: 1}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		c.Version++
+		return c, nil
+	})
+	c, err := d.domain.ChangeEmail(context.Background(), 1, models.EmailChangeForm{Email: "new@test.com", Pass: "password"})
+
+	d.Require().NoError(err)
+	d.Require().Equal("new@test.com", c.Email)
+	d.Require().False(c.EmailVerified, "new email must be verified")
+	d.Require().Equal("new@test.compassword", c.PassHash, "password is hashed with new email")
+	d.Require().Len(d.outbox, 1)
+	d.Require().Equal(models.EventEmailChanged, d.outbox[0].Type)
+	d.Require().JSONEq(`{"consumer_id":1,"old_email":"old@test.com","email":"new@test.com","changed_at":`+
+		strconv.FormatInt(d.outbox[0].CreatedAt, 10)+`}`, string(d.outbox[0].Payload))
+	d.Require().Len(d.audit, 1)
+	d.Require().Equal("email_changed", d.audit[0].Details["change"])
+}
+
+func (d *DomainSuite) TestChangeEmailAlreadyRegistered() {
+	user := models.Consumer{ID: 1, Email: "old@test.com", PassHash: "old@test.compassword"}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).Return(models.Consumer{}, store.ErrAlreadyExists)
+	_, err := d.domain.ChangeEmail(context.Background(), 1, models.EmailChangeForm{Email: "new@test.com", Pass: "password"})
+
+	d.Require().Error(err)
+	cErr, ok := err.(consumer.Error)
+	d.Require().True(ok)
+	d.Require().True(cErr.UserError())
+	d.Require().Empty(d.audit)
+}
+
+func (d *DomainSuite) TestChangeEmailSameEmail() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword"}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	_, err := d.domain.ChangeEmail(context.Background(), 1, models.EmailChangeForm{Email: "TEST@test.com", Pass: "password"})
+
+	d.Require().Error(err)
+}
@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/metrics"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.uber.org/zap"
+)
+
+// Authentication methods recorded in audit log
+const (
+	methodPassword     = "password"
+	methodLoginLink    = "login_link"
+	methodLoginCode    = "login_code"
+	methodPasskey      = "passkey"
+	methodTOTP         = "totp"
+	methodRecoveryCode = "recovery_code"
+)
+
+// AuditEvents returns page of audit log events ordered by id
+func (s *Service) AuditEvents(ctx context.Context, f models.AuditFilter, page models.PageRequest) (models.AuditPage, error) {
+	if err := page.Validate(); err != nil {
+		return models.AuditPage{}, &domainError{IsUserError: true, Message: "can't get audit log", Err: err}
+	}
+	p, err := s.storeFor(ctx).AuditEvents(f, page)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		return models.AuditPage{}, &domainError{IsUserError: true, Message: "can't get audit log", Err: err}
+	}
+	if err != nil {
+		return models.AuditPage{}, &domainError{Message: "can't get audit log", Err: err}
+	}
+	return p, nil
+}
+
+// VerifyAuditLog returns error if audit log was tampered with
+func (s *Service) VerifyAuditLog(ctx context.Context) error {
+	prevHash := ""
+	return s.eachAuditPage(ctx, models.AuditFilter{}, func(events []models.AuditEvent) error {
+		if err := models.VerifyAuditChain(prevHash, events); err != nil {
+			return &domainError{Message: "audit log is corrupted", Err: err}
+		}
+		prevHash = events[len(events)-1].Hash
+		return nil
+	})
+}
+
+// eachAuditPage calls fn for each non-empty page of events which match filter
+func (s *Service) eachAuditPage(ctx context.Context, f models.AuditFilter, fn func([]models.AuditEvent) error) error {
+	page := models.PageRequest{Limit: models.MaxPageLimit}
+	for {
+		p, err := s.AuditEvents(ctx, f, page)
+		if err != nil {
+			return err
+		}
+		if len(p.Events) != 0 {
+			if err := fn(p.Events); err != nil {
+				return err
+			}
+		}
+		if p.NextCursor == "" {
+			return nil
+		}
+		page.Cursor = p.NextCursor
+	}
+}
+
+// audit appends event to audit log. Failure to record event is logged and doesn't fail audited operation.
+func (s *Service) audit(ctx context.Context, e models.AuditEvent) {
+	e.CreatedAt = time.Now().Unix()
+	err := e.SealPII()
+	if err == nil {
+		_, err = s.storeFor(ctx).AppendAuditEvent(e)
+	}
+	if err != nil {
+		s.Logger.Error("can't append audit event", zap.String("type", e.Type), zap.Int("consumer_id", e.ConsumerID), zap.Error(err))
+	}
+}
+
+// auditLoginFailed records failed login in audit log and metrics
+func (s *Service) auditLoginFailed(ctx context.Context, consumerID int, email string, client models.ClientInfo, reason string) {
+	s.Metrics.Login(metrics.LoginFailed, reason)
+	s.audit(ctx, models.AuditEvent{
+		Type:       models.AuditLoginFailed,
+		ConsumerID: consumerID,
+		Email:      email,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		Details:    map[string]string{"reason": reason},
+	})
+}
+
+// auditLoginSucceeded records succeeded login in audit log and metrics
+func (s *Service) auditLoginSucceeded(ctx context.Context, consumerID int, method string, client models.ClientInfo) {
+	s.Metrics.Login(metrics.LoginSucceeded, method)
+	s.audit(ctx, models.AuditEvent{
+		Type:       models.AuditLoginSucceeded,
+		ConsumerID: consumerID,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		Details:    map[string]string{"method": method},
+	})
+}
+
+func (s *Service) auditCredentialChanged(ctx context.Context, consumerID int, change string) {
+	s.audit(ctx, models.AuditEvent{
+		Type:       models.AuditCredentialChanged,
+		ConsumerID: consumerID,
+		Details:    map[string]string{"change": change},
+	})
+}
@@ -0,0 +1,81 @@
+package domain_test
+
+import (
+	"context"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestAuditLoginSucceeded() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword"}
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	_, err := d.domain.AuthenticateConsumer(context.Background(), models.AuthForm{
+		Email:  user.Email,
+		Pass:   "password",
+		Client: models.ClientInfo{IP: "127.0.0.1", UserAgent: "curl"},
+	})
+
+	d.Require().NoError(err)
+	d.Require().Len(d.audit, 2)
+	d.Require().Equal(models.AuditLoginSucceeded, d.audit[0].Type)
+	d.Require().Equal(1, d.audit[0].ConsumerID)
+	d.Require().Equal("127.0.0.1", d.audit[0].IP)
+	d.Require().Equal("curl", d.audit[0].UserAgent)
+	d.Require().Equal("password", d.audit[0].Details["method"])
+	d.Require().NotEmpty(d.audit[0].PIIDigest)
+	d.Require().NotZero(d.audit[0].CreatedAt)
+	d.Require().Equal(models.AuditTokenIssued, d.audit[1].Type)
+}
+
+func (d *DomainSuite) TestAuditLoginFailed() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword"}
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().Consumer("unknown@test.com").Return(models.Consumer{}, store.ErrNotFound)
+	_, err := d.domain.AuthenticateConsumer(context.Background(), models.AuthForm{Email: user.Email, Pass: "wrong"})
+	d.Require().Error(err)
+	_, err = d.domain.AuthenticateConsumer(context.Background(), models.AuthForm{Email: "unknown@test.com", Pass: "wrong"})
+	d.Require().Error(err)
+
+	d.Require().Len(d.audit, 2)
+	d.Require().Equal(models.AuditLoginFailed, d.audit[0].Type)
+	d.Require().Equal(1, d.audit[0].ConsumerID)
+	d.Require().Equal("invalid_password", d.audit[0].Details["reason"])
+	d.Require().Equal(models.AuditLoginFailed, d.audit[1].Type)
+	d.Require().Zero(d.audit[1].ConsumerID)
+	d.Require().Equal("unknown@test.com", d.audit[1].Email)
+	d.Require().Equal("unknown_email", d.audit[1].Details["reason"])
+}
+
+func (d *DomainSuite) TestAuditStatusChanged() {
+	d.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2}, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) { return c, nil })
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(nil)
+	_, err := d.domain.SuspendConsumer(context.Background(), 1, 2, models.StatusChangeForm{Reason: "spam"})
+
+	d.Require().NoError(err)
+	d.Require().Len(d.audit, 1)
+	d.Require().Equal(models.AuditStatusChanged, d.audit[0].Type)
+	d.Require().Equal(1, d.audit[0].ActorID)
+	d.Require().Equal(2, d.audit[0].ConsumerID)
+	d.Require().Equal(map[string]string{"from": models.StatusActive, "to": models.StatusSuspended}, d.audit[0].Details)
+}
+
+func (d *DomainSuite) TestVerifyAuditLog() {
+	events := make([]models.AuditEvent, 3)
+	prevHash := ""
+	for i := range events {
+		events[i] = models.AuditEvent{ID: i + 1, Type: models.AuditRegistered, ConsumerID: i + 1}
+		events[i].Seal(prevHash)
+		prevHash = events[i].Hash
+	}
+	d.mockStore.EXPECT().AuditEvents(models.AuditFilter{}, gomock.Any()).Return(models.AuditPage{Events: events}, nil)
+	d.Require().NoError(d.domain.VerifyAuditLog(context.Background()))
+
+	events[1].ConsumerID = 5
+	d.mockStore.EXPECT().AuditEvents(models.AuditFilter{}, gomock.Any()).Return(models.AuditPage{Events: events}, nil)
+	d.Require().Error(d.domain.VerifyAuditLog(context.Background()))
+}
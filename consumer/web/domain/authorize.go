@@ -0,0 +1,364 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.uber.org/zap"
+)
+
+const (
+	authorizationCodeTTL       = time.Minute
+	defaultOAuthAccessTokenTTL = time.Hour
+	defaultRefreshTokenTTL     = 30 * 24 * time.Hour
+)
+
+var errInvalidGrant = &domainError{IsUserError: true, Code: consumer.CodeInvalidGrant, Message: "authorization grant is invalid, expired or revoked"}
+
+// CheckAuthorization validates authorization request and returns client which sent it.
+// If error has invalid_client or invalid_redirect_uri code, consumer must not be redirected to client.
+func (s *Service) CheckAuthorization(ctx context.Context, req models.AuthorizationRequest) (models.OAuthClient, error) {
+	c, err := s.oauthClient(ctx, req.ClientID)
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+	if req.RedirectURI == "" || !c.HasRedirectURI(req.RedirectURI) {
+		return models.OAuthClient{}, &domainError{IsUserError: true, Code: consumer.CodeInvalidRedirectURI, Message: "redirect uri is not registered"}
+	}
+	if req.ResponseType != models.ResponseTypeCode {
+		return models.OAuthClient{}, &domainError{IsUserError: true, Code: consumer.CodeUnsupportedResponseType, Message: "response type must be " + models.ResponseTypeCode}
+	}
+	if err := req.Validate(); err != nil {
+		return models.OAuthClient{}, &domainError{IsUserError: true, Message: "invalid authorization request", Err: err}
+	}
+	return c, nil
+}
+
+// Authorize checks consumer's credentials and issues authorization code to client which sent req.
+// If consumer has second factor enabled, authorization must be completed by AuthorizeMFA with TOTP code
+// or by AuthorizePasskey with passkey assertion started by BeginPasskeyMFA.
+func (s *Service) Authorize(ctx context.Context, req models.AuthorizationRequest, form models.AuthForm) (models.AuthorizationResult, error) {
+	if _, err := s.CheckAuthorization(ctx, req); err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	c, err := s.checkCredentials(ctx, form)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	if err := checkStatus(c); err != nil {
+		s.auditLoginFailed(ctx, c.ID, c.Email, form.Client, consumer.ErrorCode(err))
+		return models.AuthorizationResult{}, err
+	}
+	scopes, err := s.grantScopes(c, req.Scopes())
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	totp, err := s.hasTOTP(ctx, c.ID)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	passkey, err := s.hasPasskey(ctx, c.ID)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	if totp || passkey {
+		t, err := s.createToken(ctx, token.SubjectMFAPending, c.ID, c.Roles, scopes, mfaTokenTTL)
+		if err != nil {
+			return models.AuthorizationResult{}, err
+		}
+		return models.AuthorizationResult{MFAToken: t, MFARequired: true, TOTP: totp, Passkey: passkey}, nil
+	}
+	s.auditLoginSucceeded(ctx, c.ID, methodPassword, form.Client)
+	return s.issueAuthorizationCode(ctx, req, c, scopes, methodPassword)
+}
+
+// AuthorizeMFA completes authorization started by Authorize with second factor code
+func (s *Service) AuthorizeMFA(ctx context.Context, req models.AuthorizationRequest, form models.MFAForm) (models.AuthorizationResult, error) {
+	if _, err := s.CheckAuthorization(ctx, req); err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	claims, method, err := s.verifyMFA(ctx, form)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	c, err := s.ActiveConsumer(ctx, claims.ID)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	scopes, err := s.grantScopes(c, req.Scopes())
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	s.auditLoginSucceeded(ctx, c.ID, method, form.Client)
+	return s.issueAuthorizationCode(ctx, req, c, scopes, method)
+}
+
+// AuthorizePasskey completes authorization started by Authorize with result of navigator.credentials.get
+// for options and session returned by BeginPasskeyMFA
+func (s *Service) AuthorizePasskey(ctx context.Context, req models.AuthorizationRequest, session string, resp webauthn.AssertionResponse, client models.ClientInfo) (models.AuthorizationResult, error) {
+	if _, err := s.CheckAuthorization(ctx, req); err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	claims, err := s.verifyPasskey(ctx, session, resp, client)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	c, err := s.ActiveConsumer(ctx, claims.ID)
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	scopes, err := s.grantScopes(c, req.Scopes())
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	s.auditLoginSucceeded(ctx, c.ID, methodPasskey, client)
+	return s.issueAuthorizationCode(ctx, req, c, scopes, methodPasskey)
+}
+
+// issueAuthorizationCode saves authorization code bound to PKCE challenge of req
+// and returns redirect uri which carries the code. Consumer has just authenticated with login method.
+func (s *Service) issueAuthorizationCode(ctx context.Context, req models.AuthorizationRequest, c models.Consumer, scopes []string, method string) (models.AuthorizationResult, error) {
+	code, hash, err := s.auth.NewAuthorizationCode()
+	if err != nil {
+		return models.AuthorizationResult{}, err
+	}
+	now := time.Now()
+	err = s.storeFor(ctx).SaveOAuthCode(models.OAuthCode{
+		Hash:          hash,
+		ClientID:      req.ClientID,
+		ConsumerID:    c.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     now.Add(authorizationCodeTTL).Unix(),
+		Nonce:         req.Nonce,
+		AuthTime:      now.Unix(),
+		AMR:           authenticationMethods(method),
+	})
+	if err != nil {
+		return models.AuthorizationResult{}, &domainError{Message: "can't save authorization code", Err: err}
+	}
+	s.log(ctx).Info("authorization code issued", zap.Int("consumer_id", c.ID), zap.String("client_id", req.ClientID))
+	return models.AuthorizationResult{RedirectURI: req.Redirect(url.Values{"code": {code}})}, nil
+}
+
+// Token serves request to token endpoint: exchanges authorization code or refresh token for access and refresh tokens.
+// ID token is also issued for authorization code with openid scope, it isn't reissued on refresh.
+// Confidential clients must authenticate with secret, public clients must not send secret.
+// Refresh token is replaced by a new one on every use, reuse of replaced token revokes all tokens issued
+// for the same authorization code. Refresh tokens are also revoked with consumer's tokens and deletion of client.
+func (s *Service) Token(ctx context.Context, req models.TokenRequest) (models.TokenResponse, error) {
+	if req.GrantType != models.GrantAuthorizationCode && req.GrantType != models.GrantRefreshToken {
+		return models.TokenResponse{}, &domainError{IsUserError: true, Code: consumer.CodeUnsupportedGrantType, Message: "unsupported grant type"}
+	}
+	if err := req.Validate(); err != nil {
+		return models.TokenResponse{}, &domainError{IsUserError: true, Message: "invalid token request", Err: err}
+	}
+	client, err := s.oauthClient(ctx, req.ClientID)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	if client.Public != (req.ClientSecret == "") || (!client.Public && !s.auth.MatchClientSecret(client.SecretHash, req.ClientSecret)) {
+		return models.TokenResponse{}, errInvalidClient
+	}
+
+	var (
+		c      models.Consumer
+		code   models.OAuthCode
+		rt     models.RefreshToken
+		scopes []string
+	)
+	if req.GrantType == models.GrantAuthorizationCode {
+		c, code, err = s.redeemAuthorizationCode(ctx, req)
+		scopes = strings.Fields(code.Scope)
+		rt.Scope = code.Scope
+	} else {
+		c, rt, scopes, err = s.redeemRefreshToken(ctx, req)
+	}
+	if err != nil {
+		s.log(ctx).Info("token request rejected", zap.String("client_id", req.ClientID), zap.String("grant_type", req.GrantType), zap.Error(err))
+		return models.TokenResponse{}, err
+	}
+
+	res := models.TokenResponse{TokenType: "Bearer", ExpiresIn: int64(s.oauthAccessTokenTTL().Seconds()), Scope: strings.Join(scopes, " ")}
+	res.AccessToken, err = s.createClientToken(ctx, token.SubjectAccess, client.ID, c.ID, c.Roles, scopes, s.oauthAccessTokenTTL())
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	res.RefreshToken, err = s.issueRefreshToken(ctx, client.ID, c.ID, rt.FamilyID, rt.Scope)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	if req.GrantType == models.GrantAuthorizationCode && hasScope(scopes, token.ScopeOpenID) {
+		if res.IDToken, err = s.createIDToken(client.ID, c, code); err != nil {
+			return models.TokenResponse{}, err
+		}
+	}
+	s.log(ctx).Info("tokens issued", zap.Int("consumer_id", c.ID), zap.String("client_id", client.ID), zap.String("grant_type", req.GrantType))
+	return res, nil
+}
+
+// redeemAuthorizationCode deletes code and returns it with consumer it was issued for
+func (s *Service) redeemAuthorizationCode(ctx context.Context, req models.TokenRequest) (models.Consumer, models.OAuthCode, error) {
+	code, err := s.storeFor(ctx).TakeOAuthCode(s.auth.AuthorizationCodeHash(req.Code))
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Consumer{}, models.OAuthCode{}, errInvalidGrant
+	}
+	if err != nil {
+		return models.Consumer{}, models.OAuthCode{}, &domainError{Message: "can't get authorization code", Err: err}
+	}
+	if code.ExpiresAt <= time.Now().Unix() || code.ClientID != req.ClientID || code.RedirectURI != req.RedirectURI ||
+		!s.auth.VerifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+		return models.Consumer{}, models.OAuthCode{}, errInvalidGrant
+	}
+	c, err := s.ActiveConsumer(ctx, code.ConsumerID)
+	if err != nil {
+		return models.Consumer{}, models.OAuthCode{}, invalidGrant(err)
+	}
+	return c, code, nil
+}
+
+// redeemRefreshToken returns refresh token with its owner and scopes of new tokens, token is marked used
+// after all checks pass. Scopes which the consumer's roles don't allow anymore are dropped. Token which
+// has already been used must have leaked, so all tokens of its family are revoked.
+func (s *Service) redeemRefreshToken(ctx context.Context, req models.TokenRequest) (models.Consumer, models.RefreshToken, []string, error) {
+	now := time.Now().Unix()
+	hash := s.auth.RefreshTokenHash(req.RefreshToken)
+	rt, err := s.storeFor(ctx).RefreshToken(hash)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Consumer{}, models.RefreshToken{}, nil, errInvalidGrant
+	}
+	if err != nil {
+		return models.Consumer{}, models.RefreshToken{}, nil, &domainError{Message: "can't get refresh token", Err: err}
+	}
+	if rt.ExpiresAt <= now || rt.ClientID != req.ClientID {
+		return models.Consumer{}, models.RefreshToken{}, nil, errInvalidGrant
+	}
+	if rt.UsedAt != 0 {
+		return models.Consumer{}, models.RefreshToken{}, nil, s.revokeRefreshTokens(ctx, rt)
+	}
+	c, err := s.ActiveConsumer(ctx, rt.ConsumerID)
+	if err != nil {
+		return models.Consumer{}, models.RefreshToken{}, nil, invalidGrant(err)
+	}
+	if c.TokensRevokedAt != 0 && rt.CreatedAt <= c.TokensRevokedAt {
+		return models.Consumer{}, models.RefreshToken{}, nil, errInvalidGrant
+	}
+	granted := strings.Fields(rt.Scope)
+	if req.Scope != "" {
+		requested := strings.Fields(req.Scope)
+		for _, r := range requested {
+			if !hasScope(granted, r) {
+				return models.Consumer{}, models.RefreshToken{}, nil, &domainError{IsUserError: true, Code: consumer.CodeInvalidScope, Message: "scope exceeds scope of refresh token"}
+			}
+		}
+		granted = requested
+	}
+	scopes, err := s.grantScopes(c, granted)
+	if err != nil {
+		return models.Consumer{}, models.RefreshToken{}, nil, err
+	}
+	err = s.storeFor(ctx).UseRefreshToken(hash, now)
+	if errors.Is(err, store.ErrConflict) {
+		return models.Consumer{}, models.RefreshToken{}, nil, s.revokeRefreshTokens(ctx, rt)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Consumer{}, models.RefreshToken{}, nil, errInvalidGrant
+	}
+	if err != nil {
+		return models.Consumer{}, models.RefreshToken{}, nil, &domainError{Message: "can't use refresh token", Err: err}
+	}
+	return c, rt, scopes, nil
+}
+
+// revokeRefreshTokens revokes family of refresh token which was used again and returns error of token request
+func (s *Service) revokeRefreshTokens(ctx context.Context, rt models.RefreshToken) error {
+	if err := s.storeFor(ctx).RevokeRefreshTokens(rt.FamilyID); err != nil {
+		return &domainError{Message: "can't revoke refresh tokens", Err: err}
+	}
+	s.audit(ctx, models.AuditEvent{Type: models.AuditTokensRevoked, ConsumerID: rt.ConsumerID, Details: map[string]string{
+		"reason":    "refresh_token_reuse",
+		"client_id": rt.ClientID,
+	}})
+	s.log(ctx).Warn("refresh token reused, its family is revoked", zap.Int("consumer_id", rt.ConsumerID), zap.String("client_id", rt.ClientID))
+	return errInvalidGrant
+}
+
+// issueRefreshToken saves refresh token of family with scope, new family is started if familyID is empty.
+// Replacing token keeps scope of replaced one, even if narrower scope is requested on refresh.
+func (s *Service) issueRefreshToken(ctx context.Context, clientID string, consumerID int, familyID, scope string) (string, error) {
+	t, hash, err := s.auth.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	if familyID == "" {
+		familyID = hash
+	}
+	now := time.Now()
+	err = s.storeFor(ctx).SaveRefreshToken(models.RefreshToken{
+		Hash:       hash,
+		FamilyID:   familyID,
+		ClientID:   clientID,
+		ConsumerID: consumerID,
+		Scope:      scope,
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(s.refreshTokenTTL()).Unix(),
+	})
+	s.Metrics.TokenIssued(token.SubjectRefresh, err)
+	if err != nil {
+		return "", &domainError{Message: "can't save refresh token", Err: err}
+	}
+	return t, nil
+}
+
+// grantScopes returns requested scopes allowed for consumer's roles, invalid scope error is returned if none is allowed.
+// OpenID Connect scopes are always granted, if only they are requested, no other scope is granted.
+func (s *Service) grantScopes(c models.Consumer, requested []string) ([]string, error) {
+	var identity, other []string
+	for _, r := range requested {
+		if token.IsIdentityScope(r) {
+			identity = append(identity, r)
+		} else {
+			other = append(other, r)
+		}
+	}
+	if len(identity) != 0 && len(other) == 0 {
+		return identity, nil
+	}
+	scopes, err := s.auth.GrantScopes(c.Roles, other)
+	if err != nil {
+		return nil, &domainError{IsUserError: true, Code: consumer.CodeInvalidScope, Message: "can't grant scopes", Err: err}
+	}
+	return append(identity, scopes...), nil
+}
+
+// invalidGrant converts user error about grant's owner into invalid grant error, internal errors are returned as is
+func invalidGrant(err error) error {
+	if cErr, ok := err.(consumer.Error); ok && cErr.UserError() {
+		return &domainError{IsUserError: true, Code: consumer.CodeInvalidGrant, Message: errInvalidGrant.Message, Err: err}
+	}
+	return err
+}
+
+func (s *Service) oauthAccessTokenTTL() time.Duration {
+	if s.OAuthAccessTokenTTL > 0 {
+		return s.OAuthAccessTokenTTL
+	}
+	return defaultOAuthAccessTokenTTL
+}
+
+func (s *Service) refreshTokenTTL() time.Duration {
+	if s.RefreshTokenTTL > 0 {
+		return s.RefreshTokenTTL
+	}
+	return defaultRefreshTokenTTL
+}
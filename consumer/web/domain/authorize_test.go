@@ -0,0 +1,364 @@
+package domain_test
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/totp"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn/webauthntest"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/golang/mock/gomock"
+)
+
+// code verifier and challenge from RFC 7636 appendix B
+const (
+	codeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+var (
+	oauthUser = models.Consumer{
+		ID:       1,
+		Email:    "test@test.com",
+		PassHash: "test@test.compassword",
+		Roles:    []string{models.RoleConsumer},
+		Status:   models.StatusActive,
+	}
+	authorizationRequest = models.AuthorizationRequest{
+		ResponseType:        models.ResponseTypeCode,
+		ClientID:            "web",
+		RedirectURI:         "https://app.test/cb",
+		Scope:               token.ScopeProfileRead,
+		State:               "xyz",
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: models.CodeChallengeS256,
+	}
+)
+
+// expectClients makes store return public client with id "web" or confidential client with id "backend"
+// and returns secret of confidential client
+func (d *DomainSuite) expectClients() string {
+	_, secret, hash, err := d.auth.NewClientCredentials()
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().OAuthClient("web").
+		Return(models.OAuthClient{ID: "web", Public: true, RedirectURIs: []string{"https://app.test/cb"}}, nil).AnyTimes()
+	d.mockStore.EXPECT().OAuthClient("backend").
+		Return(models.OAuthClient{ID: "backend", SecretHash: hash, RedirectURIs: []string{"https://backend.test/cb"}}, nil).AnyTimes()
+	d.mockStore.EXPECT().OAuthClient(gomock.Any()).Return(models.OAuthClient{}, store.ErrNotFound).AnyTimes()
+	return secret
+}
+
+// authorize passes authorization request as oauthUser and returns authorization code.
+// Issued code is returned by store once.
+func (d *DomainSuite) authorize(req models.AuthorizationRequest) string {
+	d.mockStore.EXPECT().Consumer(oauthUser.Email).Return(oauthUser, nil)
+	d.mockStore.EXPECT().TOTPFactor(oauthUser.ID).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(oauthUser.ID).Return(nil, nil)
+	var saved models.OAuthCode
+	d.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).DoAndReturn(func(c models.OAuthCode) error {
+		saved = c
+		return nil
+	})
+
+	res, err := d.domain.Authorize(context.Background(), req, models.AuthForm{Email: oauthUser.Email, Pass: "password"})
+	d.Require().NoError(err)
+	d.Require().False(res.MFARequired)
+
+	u, err := url.Parse(res.RedirectURI)
+	d.Require().NoError(err)
+	d.Require().Equal(req.State, u.Query().Get("state"))
+	code := u.Query().Get("code")
+	d.Require().Equal(d.auth.AuthorizationCodeHash(code), saved.Hash)
+	d.Require().Equal(oauthUser.ID, saved.ConsumerID)
+	d.mockStore.EXPECT().TakeOAuthCode(saved.Hash).Return(saved, nil)
+	d.mockStore.EXPECT().TakeOAuthCode(gomock.Any()).Return(models.OAuthCode{}, store.ErrNotFound).AnyTimes()
+	return code
+}
+
+func (d *DomainSuite) TestAuthorizationCodeFlow() {
+	d.expectClients()
+	code := d.authorize(authorizationRequest)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil).Times(3)
+
+	req := models.TokenRequest{
+		GrantType:    models.GrantAuthorizationCode,
+		ClientID:     "web",
+		Code:         code,
+		RedirectURI:  "https://app.test/cb",
+		CodeVerifier: codeVerifier,
+	}
+	res, err := d.domain.Token(context.Background(), req)
+	d.Require().NoError(err)
+	d.Require().Equal("Bearer", res.TokenType)
+	d.Require().Equal(int64(time.Hour.Seconds()), res.ExpiresIn)
+	d.Require().Equal(token.ScopeProfileRead, res.Scope)
+	d.Require().Empty(res.IDToken, "id token is issued for openid scope only")
+	claims, err := d.domain.TokenClaims(context.Background(), res.AccessToken)
+	d.Require().NoError(err)
+	d.Require().Equal("web", claims.ClientID)
+	d.Require().Equal("web", d.audit[len(d.audit)-1].Details["client_id"])
+	_, err = d.domain.TokenClaims(context.Background(), res.RefreshToken)
+	d.Require().Error(err, "refresh token must not grant access")
+
+	_, err = d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err), "code must be used once")
+
+	refreshed, err := d.domain.Token(context.Background(), models.TokenRequest{
+		GrantType:    models.GrantRefreshToken,
+		ClientID:     "web",
+		RefreshToken: res.RefreshToken,
+	})
+	d.Require().NoError(err)
+	d.Require().Equal(token.ScopeProfileRead, refreshed.Scope)
+	d.Require().NotEmpty(refreshed.RefreshToken)
+}
+
+func (d *DomainSuite) TestExchangeAuthorizationCodeInvalidGrant() {
+	d.expectClients()
+	tests := map[string]func(*models.TokenRequest){
+		"wrong verifier":     func(r *models.TokenRequest) { r.CodeVerifier = codeVerifier[1:] + "a" },
+		"wrong redirect uri": func(r *models.TokenRequest) { r.RedirectURI = "https://app.test/other" },
+		"unknown code":       func(r *models.TokenRequest) { r.Code = "unknown" },
+	}
+	for name, change := range tests {
+		req := models.TokenRequest{
+			GrantType:    models.GrantAuthorizationCode,
+			ClientID:     "web",
+			Code:         d.authorize(authorizationRequest),
+			RedirectURI:  "https://app.test/cb",
+			CodeVerifier: codeVerifier,
+		}
+		change(&req)
+		_, err := d.domain.Token(context.Background(), req)
+		d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err), name)
+	}
+}
+
+func (d *DomainSuite) TestTokenClientAuthentication() {
+	secret := d.expectClients()
+	req := models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: "t"}
+	for _, creds := range [][2]string{{"web", "secret"}, {"backend", ""}, {"backend", "wrong"}, {"unknown", ""}} {
+		req.ClientID, req.ClientSecret = creds[0], creds[1]
+		_, err := d.domain.Token(context.Background(), req)
+		d.Require().Equal(consumer.CodeInvalidClient, consumer.ErrorCode(err), creds)
+	}
+
+	req.ClientID, req.ClientSecret = "backend", secret
+	_, err := d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err))
+
+	req.GrantType = "password"
+	_, err = d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeUnsupportedGrantType, consumer.ErrorCode(err))
+}
+
+// refreshToken saves refresh token of oauthUser issued to client at issuedAt and returns it
+func (d *DomainSuite) refreshToken(client string, issuedAt time.Time) string {
+	t, hash, err := d.auth.NewRefreshToken()
+	d.Require().NoError(err)
+	d.refresh[hash] = models.RefreshToken{
+		Hash:       hash,
+		FamilyID:   hash,
+		ClientID:   client,
+		ConsumerID: oauthUser.ID,
+		Scope:      token.ScopeProfileRead,
+		CreatedAt:  issuedAt.Unix(),
+		ExpiresAt:  issuedAt.Add(time.Hour).Unix(),
+	}
+	return t
+}
+
+func (d *DomainSuite) TestRefreshTokenRotation() {
+	d.expectClients()
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil).Times(2)
+	req := models.TokenRequest{GrantType: models.GrantRefreshToken, ClientID: "web", RefreshToken: d.refreshToken("web", time.Now())}
+
+	res, err := d.domain.Token(context.Background(), req)
+	d.Require().NoError(err)
+	d.Require().NotEqual(req.RefreshToken, res.RefreshToken)
+	d.Require().Len(d.refresh, 2)
+	rotated := d.refresh[d.auth.RefreshTokenHash(res.RefreshToken)]
+	d.Require().Equal(d.auth.RefreshTokenHash(req.RefreshToken), rotated.FamilyID, "new token belongs to the same family")
+	d.Require().Equal(token.ScopeProfileRead, rotated.Scope)
+
+	next := models.TokenRequest{GrantType: models.GrantRefreshToken, ClientID: "web", RefreshToken: res.RefreshToken}
+	_, err = d.domain.Token(context.Background(), next)
+	d.Require().NoError(err)
+
+	_, err = d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err), "token must be used once")
+	d.Require().Empty(d.refresh, "reuse revokes the whole family")
+	d.Require().Equal(models.AuditTokensRevoked, d.audit[len(d.audit)-1].Type)
+	d.Require().Equal("refresh_token_reuse", d.audit[len(d.audit)-1].Details["reason"])
+}
+
+func (d *DomainSuite) TestRefreshTokenOfOtherClient() {
+	secret := d.expectClients()
+	rt := d.refreshToken("web", time.Now())
+
+	_, err := d.domain.Token(context.Background(), models.TokenRequest{
+		GrantType:    models.GrantRefreshToken,
+		ClientID:     "backend",
+		ClientSecret: secret,
+		RefreshToken: rt,
+	})
+
+	d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err))
+	d.Require().Zero(d.refresh[d.auth.RefreshTokenHash(rt)].UsedAt, "rejected request doesn't use token")
+}
+
+func (d *DomainSuite) TestRefreshTokenRevoked() {
+	d.expectClients()
+	revoked := oauthUser
+	revoked.TokensRevokedAt = time.Now().Unix()
+	d.mockStore.EXPECT().ConsumerByID(1).Return(revoked, nil)
+	req := models.TokenRequest{GrantType: models.GrantRefreshToken, ClientID: "web", RefreshToken: d.refreshToken("web", time.Now().Add(-time.Minute))}
+
+	_, err := d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err))
+
+	req.RefreshToken = d.refreshToken("web", time.Now().Add(-2*time.Hour))
+	_, err = d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeInvalidGrant, consumer.ErrorCode(err), "token is expired")
+
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+	req.RefreshToken = d.refreshToken("web", time.Now())
+	req.Scope = token.ScopeProfileRead + " " + token.ScopeProfileWrite
+	_, err = d.domain.Token(context.Background(), req)
+	d.Require().Equal(consumer.CodeInvalidScope, consumer.ErrorCode(err))
+	d.Require().Zero(d.refresh[d.auth.RefreshTokenHash(req.RefreshToken)].UsedAt, "rejected request doesn't use token")
+}
+
+func (d *DomainSuite) TestCheckAuthorization() {
+	d.expectClients()
+	_, err := d.domain.CheckAuthorization(context.Background(), authorizationRequest)
+	d.Require().NoError(err)
+
+	tests := map[string]struct {
+		change func(*models.AuthorizationRequest)
+		code   string
+	}{
+		"unknown client":       {func(r *models.AuthorizationRequest) { r.ClientID = "unknown" }, consumer.CodeInvalidClient},
+		"unregistered uri":     {func(r *models.AuthorizationRequest) { r.RedirectURI = "https://evil.test/cb" }, consumer.CodeInvalidRedirectURI},
+		"missing uri":          {func(r *models.AuthorizationRequest) { r.RedirectURI = "" }, consumer.CodeInvalidRedirectURI},
+		"implicit flow":        {func(r *models.AuthorizationRequest) { r.ResponseType = "token" }, consumer.CodeUnsupportedResponseType},
+		"missing pkce":         {func(r *models.AuthorizationRequest) { r.CodeChallenge = "" }, ""},
+		"plain pkce challenge": {func(r *models.AuthorizationRequest) { r.CodeChallengeMethod = "plain" }, ""},
+	}
+	for name, tst := range tests {
+		req := authorizationRequest
+		tst.change(&req)
+		_, err := d.domain.CheckAuthorization(context.Background(), req)
+		d.Require().Error(err, name)
+		d.Require().True(err.(consumer.Error).UserError(), name)
+		d.Require().Equal(tst.code, consumer.ErrorCode(err), name)
+	}
+}
+
+func (d *DomainSuite) TestAuthorizeInvalidScope() {
+	d.expectClients()
+	d.mockStore.EXPECT().Consumer(oauthUser.Email).Return(oauthUser, nil)
+	req := authorizationRequest
+	req.Scope = token.ScopeAdmin
+
+	_, err := d.domain.Authorize(context.Background(), req, models.AuthForm{Email: oauthUser.Email, Pass: "password"})
+
+	d.Require().Equal(consumer.CodeInvalidScope, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestAuthorizeWithSecondFactor() {
+	d.expectClients()
+	secret, err := totp.GenerateSecret()
+	d.Require().NoError(err)
+	factor := models.TOTPFactor{ConsumerID: 1, Secret: secret, Confirmed: true}
+	d.mockStore.EXPECT().Consumer(oauthUser.Email).Return(oauthUser, nil)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(factor, nil).Times(2)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+
+	res, err := d.domain.Authorize(context.Background(), authorizationRequest, models.AuthForm{Email: oauthUser.Email, Pass: "password"})
+	d.Require().NoError(err)
+	d.Require().True(res.MFARequired)
+	d.Require().True(res.TOTP)
+	d.Require().False(res.Passkey)
+	d.Require().Empty(res.RedirectURI)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().SaveTOTPFactor(gomock.Any()).Return(nil)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+	d.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).Return(nil)
+	res, err = d.domain.AuthorizeMFA(context.Background(), authorizationRequest, models.MFAForm{
+		Token:  res.MFAToken,
+		Code:   code,
+		Client: models.ClientInfo{IP: "127.0.0.1", UserAgent: "browser"},
+	})
+	d.Require().NoError(err)
+	d.Require().Contains(res.RedirectURI, "https://app.test/cb?code=")
+	login := d.audit[len(d.audit)-1]
+	d.Require().Equal(models.AuditLoginSucceeded, login.Type)
+	d.Require().Equal("127.0.0.1", login.IP)
+	d.Require().Equal("browser", login.UserAgent)
+}
+
+func (d *DomainSuite) TestAuthorizeWithPasskeyOnly() {
+	d.expectClients()
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+	cred := models.WebAuthnCredential{ID: authenticator.CredentialID, ConsumerID: 1, PublicKey: authenticator.PublicKey()}
+	d.mockStore.EXPECT().Consumer(oauthUser.Email).Return(oauthUser, nil)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{cred}, nil).Times(2)
+
+	res, err := d.domain.Authorize(context.Background(), authorizationRequest, models.AuthForm{Email: oauthUser.Email, Pass: "password"})
+	d.Require().NoError(err)
+	d.Require().True(res.MFARequired)
+	d.Require().True(res.Passkey)
+	d.Require().False(res.TOTP)
+	d.Require().Empty(res.RedirectURI)
+
+	opts, session, err := d.domain.BeginPasskeyMFA(context.Background(), res.MFAToken)
+	d.Require().NoError(err)
+	assertion, err := authenticator.Assert(opts.Challenge)
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().WebAuthnCredential(cred.ID).Return(cred, nil)
+	d.mockStore.EXPECT().SaveWebAuthnCredential(gomock.Any()).Return(nil)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+	d.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).Return(nil)
+	client := models.ClientInfo{IP: "127.0.0.1", UserAgent: "browser"}
+	res, err = d.domain.AuthorizePasskey(context.Background(), authorizationRequest, session, assertion, client)
+
+	d.Require().NoError(err)
+	d.Require().Contains(res.RedirectURI, "https://app.test/cb?code=")
+	login := d.audit[len(d.audit)-1]
+	d.Require().Equal(models.AuditLoginSucceeded, login.Type)
+	d.Require().Equal("127.0.0.1", login.IP)
+}
+
+func (d *DomainSuite) TestAuthorizePasskeyReplayedSession() {
+	d.expectClients()
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+	cred := models.WebAuthnCredential{ID: authenticator.CredentialID, ConsumerID: 1, PublicKey: authenticator.PublicKey()}
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{cred}, nil)
+	opts, session, err := d.domain.BeginPasskeyMFA(context.Background(), d.mfaPendingToken(1))
+	d.Require().NoError(err)
+	assertion, err := authenticator.Assert(opts.Challenge)
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().WebAuthnCredential(cred.ID).Return(cred, nil)
+	d.mockStore.EXPECT().SaveWebAuthnCredential(gomock.Any()).Return(nil)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+	d.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).Return(nil)
+	_, err = d.domain.AuthorizePasskey(context.Background(), authorizationRequest, session, assertion, models.ClientInfo{})
+	d.Require().NoError(err)
+
+	res, err := d.domain.AuthorizePasskey(context.Background(), authorizationRequest, session, assertion, models.ClientInfo{})
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+	d.Require().Empty(res.RedirectURI)
+}
@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeletionGracePeriod = 30 * 24 * time.Hour
+	purgeBatchSize             = 100
+)
+
+const (
+	deletionRequestedReason = "deletion requested by consumer"
+	deletionCancelledReason = "deletion cancelled by consumer"
+	deletionPurgedReason    = "deletion grace period expired"
+)
+
+// RequestDeletion schedules erasure of account of access token owner after grace period.
+// Consumer must have logged in recently with any of his credentials, otherwise password is
+// required to confirm the request if his account has no second factor. All consumer's tokens
+// are revoked, login is impossible until deletion is cancelled with CancelDeletion.
+func (s *Service) RequestDeletion(ctx context.Context, claims token.Claims, form models.DeletionForm) (models.Consumer, error) {
+	c, err := s.ActiveConsumer(ctx, claims.ID)
+	if err != nil {
+		return models.Consumer{}, err
+	}
+	if err := s.reauthenticate(ctx, claims, c, form); err != nil {
+		return models.Consumer{}, err
+	}
+	now := time.Now()
+	c, err = s.changeStatus(ctx, 0, c.ID, models.StatusActive, models.StatusPendingDeletion, deletionRequestedReason, func(c *models.Consumer) {
+		c.DeletionScheduledAt = now.Add(s.deletionGracePeriod()).Unix()
+		c.TokensRevokedAt = now.Unix()
+	})
+	if err != nil {
+		return models.Consumer{}, err
+	}
+	s.audit(ctx, models.AuditEvent{Type: models.AuditTokensRevoked, ConsumerID: c.ID, Details: map[string]string{"reason": "deletion_requested"}})
+	return c, nil
+}
+
+// CancelDeletion restores account pending deletion, consumer must log in again afterwards.
+// Accounts in other statuses can't be changed by the consumer.
+func (s *Service) CancelDeletion(ctx context.Context, form models.AuthForm) (models.Consumer, error) {
+	c, err := s.checkCredentials(ctx, form)
+	if err != nil {
+		return models.Consumer{}, err
+	}
+	return s.changeStatus(ctx, 0, c.ID, models.StatusPendingDeletion, models.StatusActive, deletionCancelledReason, clearDeletion)
+}
+
+// reauthenticate confirms that dangerous request is made by consumer himself: he must have logged in recently
+// or enter password if his account has no second factor
+func (s *Service) reauthenticate(ctx context.Context, claims token.Claims, c models.Consumer, form models.DeletionForm) error {
+	err := recentAuth(claims)
+	if err == nil || form.Pass == "" || consumer.ErrorCode(err) != consumer.CodeReauthRequired {
+		return err
+	}
+	mfa, err := s.hasSecondFactor(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	if mfa {
+		return &domainError{IsUserError: true, Code: consumer.CodeReauthRequired, Message: "log in again with second factor to continue"}
+	}
+	_, err = s.checkCredentials(ctx, models.AuthForm{Email: c.Email, Pass: form.Pass, Client: form.Client})
+	return err
+}
+
+// PurgeDeletedConsumers erases accounts whose deletion grace period expired before now
+// and returns count of erased accounts
+func (s *Service) PurgeDeletedConsumers(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.PurgeDeletedConsumers")
+	defer func() { tracing.End(span, err) }()
+
+	purged := 0
+	for {
+		batch, err := s.storeFor(ctx).ConsumersScheduledForDeletion(now.Unix(), purgeBatchSize)
+		if err != nil {
+			return purged, &domainError{Message: "can't get consumers scheduled for deletion", Err: err}
+		}
+		for _, c := range batch {
+			if err := s.purgeConsumer(ctx, c.ID, now); err != nil {
+				return purged, err
+			}
+			s.audit(ctx, models.AuditEvent{
+				Type:       models.AuditStatusChanged,
+				ConsumerID: c.ID,
+				Details:    map[string]string{"from": models.StatusPendingDeletion, "to": models.StatusDeleted},
+			})
+			purged++
+		}
+		if len(batch) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// RunPurger calls PurgeDeletedConsumers every interval until ctx is done
+func (s *Service) RunPurger(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := s.PurgeDeletedConsumers(ctx, time.Now())
+		if err != nil {
+			s.Logger.Error("can't purge deleted consumers", zap.Error(err))
+		} else if n != 0 {
+			s.Logger.Info("deleted consumers purged", zap.Int("consumers", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// purgeConsumer erases consumer's data and records status change and event atomically
+func (s *Service) purgeConsumer(ctx context.Context, id int, now time.Time) error {
+	return s.storeFor(ctx).Atomic(func(tx store.DataStore) error {
+		if err := tx.PurgeConsumer(id); err != nil {
+			return &domainError{Message: "can't purge consumer", Err: err}
+		}
+		err := tx.SaveStatusChange(models.StatusChange{
+			ConsumerID: id,
+			From:       models.StatusPendingDeletion,
+			To:         models.StatusDeleted,
+			Reason:     deletionPurgedReason,
+			CreatedAt:  now.Unix(),
+		})
+		if err != nil {
+			return &domainError{Message: "can't save status change", Err: err}
+		}
+		return addEvent(tx, models.ConsumerDeleted{ConsumerID: id, DeletedAt: now.Unix()})
+	})
+}
+
+func (s *Service) deletionGracePeriod() time.Duration {
+	if s.DeletionGracePeriod > 0 {
+		return s.DeletionGracePeriod
+	}
+	return defaultDeletionGracePeriod
+}
+
+func clearDeletion(c *models.Consumer) {
+	c.DeletionScheduledAt = 0
+}
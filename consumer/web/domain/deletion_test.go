@@ -0,0 +1,171 @@
+package domain_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestRequestDeletion() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", Version: 1, Status: models.StatusActive}
+	var change models.StatusChange
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil).Times(2)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		c.Version++
+		return c, nil
+	})
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).DoAndReturn(func(ch models.StatusChange) error {
+		change = ch
+		return nil
+	})
+	c, err := d.domain.RequestDeletion(context.Background(), d.claims(1, time.Now()), models.DeletionForm{})
+
+	now := time.Now()
+	d.Require().NoError(err)
+	d.Require().Equal(models.StatusPendingDeletion, c.Status)
+	d.Require().InDelta(now.Add(30*24*time.Hour).Unix(), c.DeletionScheduledAt, 1)
+	d.Require().InDelta(now.Unix(), c.TokensRevokedAt, 1)
+	d.Require().Equal(models.StatusActive, change.From)
+	d.Require().Equal(models.StatusPendingDeletion, change.To)
+	d.Require().Zero(change.ActorID)
+}
+
+func (d *DomainSuite) TestRequestDeletionWithPassword() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Status: models.StatusActive}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil).Times(2)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		return c, nil
+	})
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(nil)
+	c, err := d.domain.RequestDeletion(context.Background(), d.claims(1, time.Now().Add(-time.Hour)), models.DeletionForm{Pass: "password"})
+
+	d.Require().NoError(err)
+	d.Require().Equal(models.StatusPendingDeletion, c.Status)
+}
+
+func (d *DomainSuite) TestRequestDeletionInvalidPassword() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword"}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	_, err := d.domain.RequestDeletion(context.Background(), d.claims(1, time.Now().Add(-time.Hour)), models.DeletionForm{Pass: "wrong"})
+
+	d.Require().Equal(consumer.CodeInvalidCredentials, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestRequestDeletionRequiresRecentLogin() {
+	d.expectActive(1)
+	_, err := d.domain.RequestDeletion(context.Background(), d.claims(1, time.Now().Add(-time.Hour)), models.DeletionForm{})
+
+	d.Require().Equal(consumer.CodeReauthRequired, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestRequestDeletionPasswordDoesNotSkipSecondFactor() {
+	d.expectActive(1)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{ConsumerID: 1, Confirmed: true}, nil)
+	_, err := d.domain.RequestDeletion(context.Background(), d.claims(1, time.Now().Add(-time.Hour)), models.DeletionForm{Pass: "password"})
+
+	d.Require().Equal(consumer.CodeReauthRequired, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestCancelDeletion() {
+	user := models.Consumer{
+		ID:                  1,
+		Email:               "test@test.com",
+		PassHash:            "test@test.compassword",
+		Status:              models.StatusPendingDeletion,
+		DeletionScheduledAt: time.Now().Add(time.Hour).Unix(),
+	}
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		return c, nil
+	})
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(nil)
+	c, err := d.domain.CancelDeletion(context.Background(), models.AuthForm{Email: user.Email, Pass: "password"})
+
+	d.Require().NoError(err)
+	d.Require().Equal(models.StatusActive, c.Status)
+	d.Require().Zero(c.DeletionScheduledAt)
+}
+
+func (d *DomainSuite) TestCancelDeletionOfActiveAccount() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Status: models.StatusActive}
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+	_, err := d.domain.CancelDeletion(context.Background(), models.AuthForm{Email: user.Email, Pass: "password"})
+
+	d.Require().Equal(consumer.CodeInvalidStatusTransition, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestCancelDeletionOfInactiveAccount() {
+	for _, status := range []string{models.StatusSuspended, models.StatusLocked} {
+		user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Status: status}
+		d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+		d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil)
+		_, err := d.domain.CancelDeletion(context.Background(), models.AuthForm{Email: user.Email, Pass: "password"})
+
+		d.Require().Equal(consumer.CodeInvalidStatusTransition, consumer.ErrorCode(err), status)
+	}
+}
+
+func (d *DomainSuite) TestValidateTokenRevoked() {
+	revokedAt := time.Now().Add(-time.Minute)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, TokensRevokedAt: revokedAt.Unix()}, nil).Times(2)
+
+	old := d.accessToken(1, revokedAt)
+	err := d.domain.ValidateToken(context.Background(), old)
+	d.Require().Equal(consumer.CodeInvalidToken, consumer.ErrorCode(err))
+
+	fresh := d.accessToken(1, revokedAt.Add(time.Second))
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), fresh))
+}
+
+func (d *DomainSuite) TestPurgeDeletedConsumers() {
+	now := time.Now()
+	var purged []int
+	d.mockStore.EXPECT().ConsumersScheduledForDeletion(now.Unix(), gomock.Any()).
+		Return([]models.Consumer{{ID: 1}, {ID: 2}}, nil)
+	d.mockStore.EXPECT().PurgeConsumer(gomock.Any()).DoAndReturn(func(id int) error {
+		purged = append(purged, id)
+		return nil
+	}).Times(2)
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).DoAndReturn(func(ch models.StatusChange) error {
+		d.Require().Equal(models.StatusDeleted, ch.To)
+		return nil
+	}).Times(2)
+	n, err := d.domain.PurgeDeletedConsumers(context.Background(), now)
+
+	d.Require().NoError(err)
+	d.Require().Equal(2, n)
+	d.Require().Equal([]int{1, 2}, purged)
+}
+
+func (d *DomainSuite) accessToken(id int, issuedAt time.Time) string {
+	t, err := d.auth.CreateJWT(d.claims(id, issuedAt))
+	d.Require().NoError(err)
+	return t
+}
+
+// claims returns claims of access token issued to consumer who logged in at issuedAt
+func (d *DomainSuite) claims(id int, issuedAt time.Time) token.Claims {
+	return token.Claims{
+		ID: id,
+		StandardClaims: &jwt.StandardClaims{
+			Subject:   token.SubjectAccess,
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: issuedAt.Add(time.Hour).Unix(),
+		},
+	}
+}
@@ -1,26 +1,64 @@
 package domain
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nsmak/consumerService/consumer"
 	"github.com/nsmak/consumerService/consumer/models"
 	"github.com/nsmak/consumerService/consumer/web/auth"
 	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/totp"
+	"github.com/nsmak/consumerService/consumer/web/events"
+	"github.com/nsmak/consumerService/consumer/web/logging"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/metrics"
+	"github.com/nsmak/consumerService/consumer/web/ratelimit"
 	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
 
 	"github.com/dgrijalva/jwt-go"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	issuer                = "consumer_service"
+	defaultAccessTokenTTL = 24 * time.Hour * 365
+	mfaTokenTTL           = 5 * time.Minute
+	ceremonyTTL           = 5 * time.Minute
+	recentAuthWindow      = 10 * time.Minute
+	qrCodeSize            = 256
+	updateAttempts        = 3
+	mailQueueSize         = 100
+)
+
+var (
+	registrationWelcomeMessage = mailer.Message{
+		Subject: "Welcome",
+		Body:    "Your account has been created.",
+	}
+	registrationConflictMessage = mailer.Message{
+		Subject: "Registration attempt",
+		Body: "Somebody tried to create an account with your email address, but you already have one.\n\n" +
+			"If it was you, log in or use passwordless login. Otherwise you can ignore this message.",
+	}
 )
 
 type domainError struct {
 	IsUserError bool   `json:"-"`
+	Code        string `json:"code,omitempty"`
 	Message     string `json:"message"`
 	Err         error  `json:"err,omitempty"`
 }
 
 func (e *domainError) Error() string {
 	if e.Err != nil {
-		e.Message = e.Message + " --> " + e.Err.Error()
+		return e.Message + " --> " + e.Err.Error()
 	}
 	return e.Message
 }
@@ -40,84 +78,658 @@ func (e *domainError) Unwrap() error {
 	return e.Err
 }
 
+func (e *domainError) ErrorCode() string {
+	return e.Code
+}
+
+// Opts - domain service options structure
+type Opts struct {
+	Mailer       mailer.Mailer
+	LoginLinkURL string // token is passed to it in "token" query parameter
+	Limiter      *ratelimit.Limiter
+
+	// AccessTokenTTL is lifetime of access tokens, one year by default
+	AccessTokenTTL time.Duration
+	// PasswordPolicy is checked on registration and password change
+	PasswordPolicy models.PasswordPolicy
+
+	// EnumerationSafeRegistration hides whether email is registered from CreateConsumer callers
+	EnumerationSafeRegistration bool
+	// Publisher receives domain events from outbox relay, events are delivered to webhooks in any case
+	Publisher events.Publisher
+	// WebhookClient is used to deliver webhooks, webhook.NewClient with 10 seconds timeout is used if nil
+	WebhookClient *http.Client
+	// Metrics records registrations, logins and tokens if set
+	Metrics *metrics.Metrics
+	// TracerProvider creates spans of operations, global provider is used if nil
+	TracerProvider trace.TracerProvider
+	// Logger receives log entries, nothing is logged if nil
+	Logger *zap.Logger
+	// DeletionGracePeriod is time during which consumer can cancel deletion of his account, 30 days by default
+	DeletionGracePeriod time.Duration
+	// IntrospectionCacheTTL is how long responses on active tokens are cached by IntrospectToken, zero disables caching
+	IntrospectionCacheTTL time.Duration
+	// OAuthAccessTokenTTL is lifetime of access tokens issued to OAuth clients, one hour by default
+	OAuthAccessTokenTTL time.Duration
+	// RefreshTokenTTL is lifetime of refresh tokens issued to OAuth clients, 30 days by default
+	RefreshTokenTTL time.Duration
+	// Issuer is url of OpenID Connect provider, it is iss claim of ID tokens and base of endpoints in discovery
+	Issuer string
+}
+
 // Service - domain service for work with user's account data
 type Service struct {
+	Opts
 	store store.DataStore
 	auth  *auth.Service
+
+	introspection introspectionCache
+	mailQueue     chan mailer.Message
 }
 
 // NewService returns new instance of domain service
-func NewService(s store.DataStore, a *auth.Service) *Service {
-	return &Service{store: s, auth: a}
+func NewService(s store.DataStore, a *auth.Service, opts Opts) *Service {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return &Service{Opts: opts, store: s, auth: a, mailQueue: make(chan mailer.Message, mailQueueSize)}
 }
 
-// CreateConsumer creates new consumer and returns his data model or error
-func (s *Service) CreateConsumer(form models.RegFrom) (models.Consumer, error) {
+// CreateConsumer creates new consumer and returns his data model or error.
+// In enumeration-safe mode consumer is notified by email and empty model is returned
+// whether email was already registered or not, so response must not depend on it.
+func (s *Service) CreateConsumer(ctx context.Context, form models.RegFrom) (_ models.Consumer, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.CreateConsumer")
+	defer func() { tracing.End(span, err) }()
+	st := s.storeFor(ctx)
+
 	if err := form.Validate(); err != nil {
-		return models.Consumer{}, err
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't create user", Err: err}
+	}
+	if err := s.PasswordPolicy.Check(form.Pass1); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't create user", Err: err}
 	}
-	isExist, err := s.store.ConsumerIsExist(form.Email)
+	isExist, err := st.ConsumerIsExist(form.Email)
 	if err != nil {
 		return models.Consumer{}, &domainError{Message: "can't get user", Err: err}
 	}
 	if isExist {
+		if s.EnumerationSafeRegistration {
+			// password is hashed anyway, so response time is the same as for new consumer
+			s.makeConsumerModel(ctx, form)
+			return models.Consumer{}, s.queueNotification(ctx, form.Email, registrationConflictMessage)
+		}
 		return models.Consumer{}, &domainError{IsUserError: true, Message: "user is already exist"}
 	}
-	c, err := s.store.CreateConsumer(s.auth.MakeConsumerModel(form))
+	// password is hashed before transaction, so store isn't locked while it is computed
+	c := s.makeConsumerModel(ctx, form)
+	err = st.Atomic(func(tx store.DataStore) error {
+		if c, err = tx.CreateConsumer(c); err != nil {
+			return &domainError{Message: "can't create user", Err: err}
+		}
+		return addEvent(tx, models.ConsumerRegistered{ConsumerID: c.ID, Email: c.Email, RegisteredAt: c.RegTimestamp})
+	})
 	if err != nil {
-		return models.Consumer{}, &domainError{Message: "can't create user", Err: err}
+		return models.Consumer{}, err
+	}
+	s.Metrics.Registered()
+	s.log(ctx).Info("consumer registered", zap.Int("consumer_id", c.ID), zap.String("email", c.Email))
+	s.audit(ctx, models.AuditEvent{
+		Type:       models.AuditRegistered,
+		ConsumerID: c.ID,
+		Email:      c.Email,
+		IP:         form.Client.IP,
+		UserAgent:  form.Client.UserAgent,
+	})
+	if s.EnumerationSafeRegistration {
+		return models.Consumer{}, s.queueNotification(ctx, c.Email, registrationWelcomeMessage)
 	}
 	return c, nil
 }
 
+func (s *Service) accessTokenTTL() time.Duration {
+	if s.AccessTokenTTL > 0 {
+		return s.AccessTokenTTL
+	}
+	return defaultAccessTokenTTL
+}
+
+// log returns logger whose entries have request and trace ids of ctx
+func (s *Service) log(ctx context.Context) *zap.Logger {
+	return logging.WithContext(ctx, s.Logger)
+}
+
+func (s *Service) tracer() trace.Tracer {
+	return tracing.Tracer(s.TracerProvider)
+}
+
+// storeFor returns store whose spans are children of span in ctx
+func (s *Service) storeFor(ctx context.Context) store.DataStore {
+	return tracing.WithContext(ctx, s.store)
+}
+
+// makeConsumerModel hashes password from registration form within span
+func (s *Service) makeConsumerModel(ctx context.Context, form models.RegFrom) models.Consumer {
+	_, span := s.tracer().Start(ctx, "password.hash")
+	defer span.End()
+	return s.auth.MakeConsumerModel(form)
+}
+
+// hashPassword hashes consumer's new password within span
+func (s *Service) hashPassword(ctx context.Context, email, password string) string {
+	_, span := s.tracer().Start(ctx, "password.hash")
+	defer span.End()
+	return s.auth.HashPassword(email, password)
+}
+
+// verifyPassword matches password from form with consumer's hash within span.
+// If consumer isn't known, the same work is done with dummy hash and error is returned.
+func (s *Service) verifyPassword(ctx context.Context, c models.Consumer, known bool, form models.AuthForm) error {
+	_, span := s.tracer().Start(ctx, "password.verify")
+	defer span.End()
+	if !known {
+		return s.auth.MatchDummyPasswordHash(form)
+	}
+	return s.auth.MatchPasswordHash(c.PassHash, form)
+}
+
+// queueNotification queues message to email which is sent by RunMailer, so response time
+// doesn't depend on mail server. Message is dropped if queue is full.
+func (s *Service) queueNotification(ctx context.Context, email string, m mailer.Message) error {
+	if s.Mailer == nil {
+		return &domainError{Message: "mailer is not configured"}
+	}
+	select {
+	case s.mailQueue <- addressMessage(ctx, email, m):
+	default:
+		s.log(ctx).Error("mail queue is full, message is dropped", zap.String("subject", m.Subject))
+	}
+	return nil
+}
+
+// RunMailer sends messages queued by requests until ctx is done, then sends messages left in queue
+func (s *Service) RunMailer(ctx context.Context) {
+	for {
+		select {
+		case m := <-s.mailQueue:
+			s.sendQueued(m)
+		case <-ctx.Done():
+			for {
+				select {
+				case m := <-s.mailQueue:
+					s.sendQueued(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Service) sendQueued(m mailer.Message) {
+	if err := s.Mailer.Send(m); err != nil {
+		s.Logger.Error("can't send email", zap.String("subject", m.Subject), zap.Error(err))
+	}
+}
+
+// addressMessage returns copy of m sent to email, trace context of ctx is passed in message headers
+func addressMessage(ctx context.Context, email string, m mailer.Message) mailer.Message {
+	m.To = email
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	tracing.InjectMap(ctx, headers)
+	if len(headers) != 0 {
+		m.Headers = headers
+	}
+	return m
+}
+
 // Consumer finds consumer model by email address and returns his model or error
-func (s *Service) Consumer(email string) (models.Consumer, error) {
-	c, err := s.store.Consumer(email)
+func (s *Service) Consumer(ctx context.Context, email string) (models.Consumer, error) {
+	c, err := s.storeFor(ctx).Consumer(email)
 	if err != nil {
 		return models.Consumer{}, err
 	}
 	return c, nil
 }
 
-// AuthenticateConsumer creates authentication token and returns it or returns error
-func (s *Service) AuthenticateConsumer(form models.AuthForm) (tokenString string, err error) {
+// AuthenticateConsumer checks consumer's credentials and returns authentication token or error.
+// If consumer has second factor enabled, returned token must be exchanged by CompleteMFA.
+func (s *Service) AuthenticateConsumer(ctx context.Context, form models.AuthForm) (_ models.AuthResult, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.AuthenticateConsumer")
+	defer func() { tracing.End(span, err) }()
+
+	c, err := s.checkCredentials(ctx, form)
+	if err != nil {
+		return models.AuthResult{}, err
+	}
+	res, err := s.completeLogin(ctx, c, form.Scopes, methodPassword, form.Client)
+	if err != nil {
+		s.log(ctx).Info("login rejected", zap.Int("consumer_id", c.ID), zap.Error(err))
+		return models.AuthResult{}, err
+	}
+	s.log(ctx).Info("login succeeded", zap.Int("consumer_id", c.ID), zap.Bool("mfa_required", res.MFARequired))
+	return res, nil
+}
+
+// checkCredentials returns consumer if email and password from form match.
+// Failures are throttled and reported uniformly whether email is registered or not.
+func (s *Service) checkCredentials(ctx context.Context, form models.AuthForm) (c models.Consumer, err error) {
 	err = form.Validate()
 	if err != nil {
 		err = &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
 		return
 	}
-	c, err := s.store.Consumer(form.Email)
+	err = s.allowAttempt(form.Email, form.Client.IP)
 	if err != nil {
+		s.log(ctx).Info("login throttled", zap.String("email", form.Email), zap.String("ip", form.Client.IP), zap.Error(err))
+		s.auditLoginFailed(ctx, 0, form.Email, form.Client, consumer.ErrorCode(err))
+		return
+	}
+	reason := "invalid_password"
+	c, err = s.storeFor(ctx).Consumer(form.Email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		reason = "unknown_email"
+		err = s.verifyPassword(ctx, c, false, form)
+	case err != nil:
 		err = &domainError{IsUserError: false, Message: "can't get user", Err: err}
 		return
+	default:
+		err = s.verifyPassword(ctx, c, true, form)
 	}
-	err = s.auth.MatchPasswordHash(c.PassHash, form)
+	if err != nil {
+		s.log(ctx).Info("login failed", zap.String("email", form.Email), zap.String("reason", reason))
+		s.failAttempt(form.Email)
+		s.auditLoginFailed(ctx, c.ID, form.Email, form.Client, reason)
+		err = &domainError{IsUserError: true, Code: consumer.CodeInvalidCredentials, Message: "invalid credentials"}
+		return
+	}
+	s.succeedAttempt(form.Email)
+	return
+}
+
+// completeLogin issues token for consumer who passed first authentication factor
+func (s *Service) completeLogin(ctx context.Context, c models.Consumer, requestedScopes []string, method string, client models.ClientInfo) (res models.AuthResult, err error) {
+	if err = checkStatus(c); err != nil {
+		s.auditLoginFailed(ctx, c.ID, c.Email, client, consumer.ErrorCode(err))
+		return
+	}
+	scopes, err := s.auth.GrantScopes(c.Roles, requestedScopes)
 	if err != nil {
 		err = &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
 		return
 	}
-	claims := token.Claims{
-		ID: c.ID,
+	res.MFARequired, err = s.hasSecondFactor(ctx, c.ID)
+	if err != nil {
+		return
+	}
+	if res.MFARequired {
+		s.Metrics.Login(metrics.LoginMFARequired, method)
+		res.Token, err = s.createToken(ctx, token.SubjectMFAPending, c.ID, c.Roles, scopes, mfaTokenTTL)
+		return
+	}
+	s.auditLoginSucceeded(ctx, c.ID, method, client)
+	res.Token, err = s.createToken(ctx, token.SubjectAccess, c.ID, c.Roles, scopes, s.accessTokenTTL())
+	return
+}
+
+// CompleteMFA exchanges token returned by AuthenticateConsumer and valid second factor code for access token
+func (s *Service) CompleteMFA(ctx context.Context, form models.MFAForm) (string, error) {
+	claims, method, err := s.verifyMFA(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.ActiveConsumer(ctx, claims.ID); err != nil {
+		return "", err
+	}
+	s.auditLoginSucceeded(ctx, claims.ID, method, form.Client)
+	return s.createToken(ctx, token.SubjectAccess, claims.ID, claims.Roles, claims.Scopes(), s.accessTokenTTL())
+}
+
+// verifyMFA returns claims of mfa pending token and method of second factor if its code is valid
+func (s *Service) verifyMFA(ctx context.Context, form models.MFAForm) (token.Claims, string, error) {
+	if err := form.Validate(); err != nil {
+		return token.Claims{}, "", &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	claims, err := s.auth.ParseToken(form.Token)
+	if err != nil {
+		return token.Claims{}, "", err
+	}
+	if err := claims.Valid(); err != nil {
+		return token.Claims{}, "", &domainError{IsUserError: true, Message: "invalid token", Err: err}
+	}
+	if claims.Subject != token.SubjectMFAPending {
+		return token.Claims{}, "", &domainError{IsUserError: true, Message: "token is not an mfa pending token"}
+	}
+	account := mfaAccount(claims.ID)
+	if err := s.allowAttempt(account, ""); err != nil {
+		return token.Claims{}, "", err
+	}
+	// factor is saved only if it wasn't changed since it was read, so concurrent requests can't use the same code twice
+	for attempt := 0; ; attempt++ {
+		f, err := s.storeFor(ctx).TOTPFactor(claims.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return token.Claims{}, "", &domainError{IsUserError: false, Message: "can't get second factor", Err: err}
+		}
+		if !f.Confirmed {
+			return token.Claims{}, "", &domainError{IsUserError: true, Message: "second factor is not enabled"}
+		}
+		method := methodTOTP
+		if step, ok := totp.Validate(f.Secret, form.Code, time.Now()); ok && step > f.LastUsedStep {
+			f.LastUsedStep = step
+		} else if idx := s.auth.MatchRecoveryCode(f.RecoveryCodes, form.Code); idx >= 0 {
+			f.RecoveryCodes = append(f.RecoveryCodes[:idx:idx], f.RecoveryCodes[idx+1:]...)
+			method = methodRecoveryCode
+		} else {
+			s.failAttempt(account)
+			s.Metrics.Login(metrics.LoginFailed, "invalid_mfa_code")
+			s.audit(ctx, models.AuditEvent{Type: models.AuditMFAFailed, ConsumerID: claims.ID, Details: map[string]string{"method": methodTOTP}})
+			return token.Claims{}, "", &domainError{IsUserError: true, Message: "invalid code"}
+		}
+		err = s.storeFor(ctx).SaveTOTPFactor(f)
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return token.Claims{}, "", &domainError{IsUserError: false, Message: "can't save second factor", Err: err}
+		}
+		s.succeedAttempt(account)
+		return claims, method, nil
+	}
+}
+
+// EnrollTOTP starts enrollment of authenticator app of access token owner, he must have logged in recently.
+// Factor is not used for authentication until it is confirmed by ConfirmTOTP.
+func (s *Service) EnrollTOTP(ctx context.Context, accessToken string) (models.TOTPEnrollment, error) {
+	claims, c, err := s.accessToken(ctx, accessToken)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	if err := recentAuth(claims); err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	f, err := s.storeFor(ctx).TOTPFactor(c.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return models.TOTPEnrollment{}, &domainError{Message: "can't get second factor", Err: err}
+	}
+	if err == nil && f.Confirmed {
+		return models.TOTPEnrollment{}, &domainError{IsUserError: true, Message: "second factor is already enabled"}
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, &domainError{Message: "can't generate secret", Err: err}
+	}
+	uri := totp.URI(issuer, c.Email, secret)
+	qr, err := totp.QRCode(uri, qrCodeSize)
+	if err != nil {
+		return models.TOTPEnrollment{}, &domainError{Message: "can't generate qr code", Err: err}
+	}
+	if err := s.storeFor(ctx).SaveTOTPFactor(models.TOTPFactor{ConsumerID: c.ID, Secret: secret, Version: f.Version}); err != nil {
+		return models.TOTPEnrollment{}, &domainError{Message: "can't save second factor", Err: err}
+	}
+	return models.TOTPEnrollment{Secret: secret, URI: uri, QRCode: qr}, nil
+}
+
+// ConfirmTOTP enables second factor of access token owner if code is valid and returns one-time recovery codes
+func (s *Service) ConfirmTOTP(ctx context.Context, accessToken, code string) ([]string, error) {
+	claims, c, err := s.accessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := recentAuth(claims); err != nil {
+		return nil, err
+	}
+	f, err := s.storeFor(ctx).TOTPFactor(c.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, &domainError{IsUserError: true, Message: "second factor enrollment is not started"}
+	}
+	if err != nil {
+		return nil, &domainError{Message: "can't get second factor", Err: err}
+	}
+	if f.Confirmed {
+		return nil, &domainError{IsUserError: true, Message: "second factor is already enabled"}
+	}
+	step, ok := totp.Validate(f.Secret, code, time.Now())
+	if !ok {
+		return nil, &domainError{IsUserError: true, Message: "invalid code"}
+	}
+	codes, hashes, err := s.auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	f.Confirmed = true
+	f.LastUsedStep = step
+	f.RecoveryCodes = hashes
+	if err := s.storeFor(ctx).SaveTOTPFactor(f); err != nil {
+		return nil, &domainError{Message: "can't save second factor", Err: err}
+	}
+	s.auditCredentialChanged(ctx, c.ID, "totp_enabled")
+	return codes, nil
+}
+
+// allowAttempt returns error if authentication attempt for account from ip must be rejected
+func (s *Service) allowAttempt(account, ip string) error {
+	if s.Limiter == nil {
+		return nil
+	}
+	if err := s.Limiter.Allow(account, ip); err != nil {
+		return &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	return nil
+}
+
+// failAttempt registers failed authentication attempt.
+// Error is ignored because the attempt is already rejected.
+func (s *Service) failAttempt(account string) {
+	if s.Limiter != nil {
+		_ = s.Limiter.Fail(account)
+	}
+}
+
+// succeedAttempt resets failures of account.
+// Error is ignored because failure counters expire by themselves.
+func (s *Service) succeedAttempt(account string) {
+	if s.Limiter != nil {
+		_ = s.Limiter.Succeed(account)
+	}
+}
+
+func mfaAccount(id int) string {
+	return "mfa:" + strconv.Itoa(id)
+}
+
+// hasSecondFactor reports whether consumer has confirmed TOTP factor or registered passkey
+func (s *Service) hasSecondFactor(ctx context.Context, id int) (bool, error) {
+	if totp, err := s.hasTOTP(ctx, id); err != nil || totp {
+		return totp, err
+	}
+	return s.hasPasskey(ctx, id)
+}
+
+// hasTOTP reports whether consumer has confirmed TOTP factor
+func (s *Service) hasTOTP(ctx context.Context, id int) (bool, error) {
+	f, err := s.storeFor(ctx).TOTPFactor(id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return false, &domainError{Message: "can't get second factor", Err: err}
+	}
+	return err == nil && f.Confirmed, nil
+}
+
+// hasPasskey reports whether consumer has registered passkey
+func (s *Service) hasPasskey(ctx context.Context, id int) (bool, error) {
+	creds, err := s.storeFor(ctx).WebAuthnCredentials(id)
+	if err != nil {
+		return false, &domainError{Message: "can't get passkeys", Err: err}
+	}
+	return len(creds) != 0, nil
+}
+
+func (s *Service) createToken(ctx context.Context, subject string, id int, roles, scopes []string, ttl time.Duration) (string, error) {
+	return s.createClientToken(ctx, subject, "", id, roles, scopes, ttl)
+}
+
+// createClientToken creates token issued to OAuth client, issuance of access token is audited
+func (s *Service) createClientToken(ctx context.Context, subject, clientID string, id int, roles, scopes []string, ttl time.Duration) (string, error) {
+	return s.signToken(ctx, subject, clientID, id, roles, scopes, time.Now().Unix(), ttl)
+}
+
+// signToken creates token of consumer who authenticated at authTime, issuance of access token is audited
+func (s *Service) signToken(ctx context.Context, subject, clientID string, id int, roles, scopes []string, authTime int64, ttl time.Duration) (string, error) {
+	now := time.Now()
+	t, err := s.auth.CreateJWT(token.Claims{
+		ID:       id,
+		Roles:    roles,
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+		AuthTime: authTime,
 		StandardClaims: &jwt.StandardClaims{
-			Subject:   "access",
-			IssuedAt:  time.Now().Unix(),
-			ExpiresAt: time.Now().Add(24 * time.Hour * 365).Unix(),
-			Issuer:    "consumer_service",
+			Subject:   subject,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+			Issuer:    issuer,
 		},
+	})
+	s.Metrics.TokenIssued(subject, err)
+	if err == nil && subject == token.SubjectAccess {
+		details := map[string]string{"scope": strings.Join(scopes, " "), "expires_at": strconv.FormatInt(now.Add(ttl).Unix(), 10)}
+		if clientID != "" {
+			details["client_id"] = clientID
+		}
+		s.audit(ctx, models.AuditEvent{Type: models.AuditTokenIssued, ConsumerID: id, Details: details})
 	}
-	tokenString, err = s.auth.CreateJWT(claims)
-
-	return
+	return t, err
 }
 
 // ValidateToken - validate authentication token and return error if token is not valid
-func (s *Service) ValidateToken(t string) error {
+// or revoked or its owner's account isn't active
+func (s *Service) ValidateToken(ctx context.Context, t string) error {
+	_, err := s.TokenClaims(ctx, t)
+	return err
+}
+
+// TokenClaims returns claims of access token, error is returned if token is not valid
+// or revoked or its owner's account isn't active
+func (s *Service) TokenClaims(ctx context.Context, t string) (token.Claims, error) {
+	claims, _, err := s.accessToken(ctx, t)
+	return claims, err
+}
+
+// accessToken returns claims and owner of access token
+func (s *Service) accessToken(ctx context.Context, t string) (_ token.Claims, _ models.Consumer, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.ValidateToken")
+	defer func() {
+		if err != nil {
+			s.log(ctx).Debug("token rejected", zap.Error(err))
+		}
+		tracing.End(span, err)
+	}()
+
 	claims, err := s.auth.ParseToken(t)
 	if err != nil {
-		return err
+		s.Metrics.TokenRejected(consumer.CodeInvalidToken)
+		return token.Claims{}, models.Consumer{}, err
 	}
 	if err := claims.Valid(); err != nil {
-		return err
+		s.Metrics.TokenRejected(consumer.CodeInvalidToken)
+		code := consumer.CodeInvalidToken
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Errors&jwt.ValidationErrorExpired != 0 {
+			code = consumer.CodeTokenExpired
+		}
+		return token.Claims{}, models.Consumer{}, &domainError{IsUserError: true, Code: code, Message: "invalid token", Err: err}
+	}
+	if !claims.IsAccess() {
+		s.Metrics.TokenRejected(consumer.CodeInvalidToken)
+		return token.Claims{}, models.Consumer{}, &domainError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "token is not an access token"}
+	}
+	c, err := s.TokenConsumer(ctx, claims)
+	if err != nil {
+		return token.Claims{}, models.Consumer{}, err
+	}
+	return claims, c, nil
+}
+
+// recentAuth returns error if consumer authenticated with access token claims too long ago
+// to change credentials without logging in again. Token must be issued to consumer himself.
+func recentAuth(claims token.Claims) error {
+	if claims.ClientID != "" {
+		return &domainError{IsUserError: true, Code: consumer.CodeInsufficientScope, Message: "token is issued to oauth client"}
+	}
+	if time.Since(time.Unix(claims.AuthenticatedAt(), 0)) > recentAuthWindow {
+		return &domainError{IsUserError: true, Code: consumer.CodeReauthRequired, Message: "log in again to continue"}
 	}
 	return nil
 }
+
+// TokenConsumer returns owner of access token, error is returned if token was revoked
+// or owner's account isn't active
+func (s *Service) TokenConsumer(ctx context.Context, claims token.Claims) (models.Consumer, error) {
+	c, err := s.ActiveConsumer(ctx, claims.ID)
+	if err != nil {
+		if code := consumer.ErrorCode(err); code != "" {
+			s.Metrics.TokenRejected(code)
+		}
+		return models.Consumer{}, err
+	}
+	if c.TokensRevokedAt != 0 && (claims.StandardClaims == nil || claims.IssuedAt <= c.TokensRevokedAt) {
+		s.Metrics.TokenRejected(consumer.CodeInvalidToken)
+		return models.Consumer{}, &domainError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "token is revoked"}
+	}
+	return c, nil
+}
+
+// ConsumerByID finds consumer model by id and returns his model or error
+func (s *Service) ConsumerByID(ctx context.Context, id int) (models.Consumer, error) {
+	c, err := s.storeFor(ctx).ConsumerByID(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Consumer{}, &domainError{IsUserError: true, Code: consumer.CodeNotFound, Message: "user not found", Err: err}
+	}
+	if err != nil {
+		return models.Consumer{}, &domainError{Message: "can't get user", Err: err}
+	}
+	return c, nil
+}
+
+// ListConsumers returns page of consumers which match filter
+func (s *Service) ListConsumers(ctx context.Context, f models.ConsumerFilter, page models.PageRequest) (models.ConsumerPage, error) {
+	if err := page.Validate(); err != nil {
+		return models.ConsumerPage{}, &domainError{IsUserError: true, Message: "can't list users", Err: err}
+	}
+	p, err := s.storeFor(ctx).ListConsumers(f, page)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		return models.ConsumerPage{}, &domainError{IsUserError: true, Message: "can't list users", Err: err}
+	}
+	if err != nil {
+		return models.ConsumerPage{}, &domainError{Message: "can't list users", Err: err}
+	}
+	return p, nil
+}
+
+// UpdateProfile applies changes from form to consumer's profile and returns updated consumer.
+// Update is retried if consumer was modified concurrently.
+func (s *Service) UpdateProfile(ctx context.Context, consumerID int, form models.ProfileUpdateForm) (models.Consumer, error) {
+	if err := form.Validate(); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't update profile", Err: err}
+	}
+	for attempt := 0; ; attempt++ {
+		c, err := s.ConsumerByID(ctx, consumerID)
+		if err != nil {
+			return models.Consumer{}, err
+		}
+		c.Profile, err = form.Apply(c.Profile)
+		if err != nil {
+			return models.Consumer{}, &domainError{IsUserError: true, Message: "can't update profile", Err: err}
+		}
+		c, err = s.storeFor(ctx).UpdateConsumer(c)
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return models.Consumer{}, &domainError{Message: "can't update profile", Err: err}
+		}
+		return c, nil
+	}
+}
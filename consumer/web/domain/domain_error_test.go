@@ -4,6 +4,8 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/nsmak/consumerService/consumer"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,9 +29,23 @@ func TestDomainError_Error(t *testing.T) {
 		require.Equal(t, err.IsUserError, err.UserError())
 	})
 
+	t.Run("repeated calls", func(t *testing.T) {
+		err := &domainError{Message: "err2", Err: &domainError{Message: "err1", Err: errors.New("error")}}
+		require.Equal(t, "err2 --> err1 --> error", err.Error())
+		require.Equal(t, "err2 --> err1 --> error", err.Error())
+		require.Equal(t, "err2", err.Message)
+	})
+
 	t.Run("when dependent error is with another IsUserError flag", func(t *testing.T) {
 		err := &domainError{IsUserError: true, Message: "err", Err: &domainError{IsUserError: false, Message: "error"}}
 		require.Equal(t, "err --> error", err.Error())
 		require.False(t, err.UserError())
 	})
 }
+
+func TestDomainError_ErrorCode(t *testing.T) {
+	inner := &domainError{IsUserError: true, Code: "inner", Message: "err1"}
+	require.Equal(t, "inner", consumer.ErrorCode(&domainError{Message: "err2", Err: inner}))
+	require.Equal(t, "outer", consumer.ErrorCode(&domainError{Code: "outer", Message: "err2", Err: inner}))
+	require.Empty(t, consumer.ErrorCode(errors.New("error")))
+}
@@ -1,14 +1,27 @@
 package domain_test
 
 import (
+	"context"
+	"crypto/rsa"
 	"errors"
+	"net/http"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nsmak/consumerService/consumer"
 	"github.com/nsmak/consumerService/consumer/models"
 	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/totp"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn/webauthntest"
 	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/ratelimit"
+	"github.com/nsmak/consumerService/consumer/web/store"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/suite"
 )
@@ -19,19 +32,107 @@ const (
 	invalidToken = "invalid token"
 )
 
+// idTokenKey is shared by tests since generation of RSA key is slow
+var idTokenKey = func() *rsa.PrivateKey {
+	key, err := auth.GenerateIDTokenKey()
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
 type DomainSuite struct {
 	suite.Suite
-	mockCtl   *gomock.Controller
-	mockStore *MockDataStore
-	auth      *auth.Service
-	domain    *domain.Service
+	mockCtl    *gomock.Controller
+	mockStore  *MockDataStore
+	mockMailer *MockMailer
+	auth       *auth.Service
+	domain     *domain.Service
+	audit      []models.AuditEvent
+	outbox     []models.OutboxMessage
+	challenges map[string]models.WebAuthnChallenge
+	refresh    map[string]models.RefreshToken
+	mu         sync.Mutex // guards audit and outbox appended by concurrent calls
 }
 
 func (d *DomainSuite) SetupTest() {
 	d.mockCtl = gomock.NewController(d.T())
 	d.mockStore = NewMockDataStore(d.mockCtl)
-	d.auth = auth.NewService(auth.Opts{SigningKey: []byte("1")})
-	d.domain = domain.NewService(d.mockStore, d.auth)
+	d.mockMailer = NewMockMailer(d.mockCtl)
+	d.audit = nil
+	d.outbox = nil
+	d.challenges = make(map[string]models.WebAuthnChallenge)
+	d.refresh = make(map[string]models.RefreshToken)
+	d.mockStore.EXPECT().Atomic(gomock.Any()).DoAndReturn(func(fn func(store.DataStore) error) error {
+		return fn(d.mockStore)
+	}).AnyTimes()
+	d.mockStore.EXPECT().AddOutboxMessage(gomock.Any()).DoAndReturn(func(m models.OutboxMessage) error {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.outbox = append(d.outbox, m)
+		return nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().AppendAuditEvent(gomock.Any()).DoAndReturn(func(e models.AuditEvent) (models.AuditEvent, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.audit = append(d.audit, e)
+		return e, nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().SaveWebAuthnChallenge(gomock.Any()).DoAndReturn(func(c models.WebAuthnChallenge) error {
+		d.challenges[c.Challenge] = c
+		return nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().TakeWebAuthnChallenge(gomock.Any()).DoAndReturn(func(challenge string) (models.WebAuthnChallenge, error) {
+		c, ok := d.challenges[challenge]
+		if !ok {
+			return models.WebAuthnChallenge{}, store.ErrNotFound
+		}
+		delete(d.challenges, challenge)
+		return c, nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().SaveRefreshToken(gomock.Any()).DoAndReturn(func(t models.RefreshToken) error {
+		d.refresh[t.Hash] = t
+		return nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().RefreshToken(gomock.Any()).DoAndReturn(func(hash string) (models.RefreshToken, error) {
+		t, ok := d.refresh[hash]
+		if !ok {
+			return models.RefreshToken{}, store.ErrNotFound
+		}
+		return t, nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().UseRefreshToken(gomock.Any(), gomock.Any()).DoAndReturn(func(hash string, usedAt int64) error {
+		t, ok := d.refresh[hash]
+		if !ok {
+			return store.ErrNotFound
+		}
+		if t.UsedAt != 0 {
+			return store.ErrConflict
+		}
+		t.UsedAt = usedAt
+		d.refresh[hash] = t
+		return nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().RevokeRefreshTokens(gomock.Any()).DoAndReturn(func(familyID string) error {
+		for k, t := range d.refresh {
+			if t.FamilyID == familyID {
+				delete(d.refresh, k)
+			}
+		}
+		return nil
+	}).AnyTimes()
+	d.auth = auth.NewService(auth.Opts{
+		SigningKey: []byte("1"),
+		IDTokenKey: idTokenKey,
+		WebAuthn:   webauthn.Config{RPID: "localhost", Origins: []string{"https://localhost"}},
+	})
+	d.domain = domain.NewService(d.mockStore, d.auth, domain.Opts{
+		Mailer:       d.mockMailer,
+		LoginLinkURL: "https://localhost/login",
+		Issuer:       "https://id.test",
+		// webhooks are delivered to test servers on loopback address which default client refuses
+		WebhookClient: &http.Client{Timeout: time.Second},
+	})
 }
 
 func (d *DomainSuite) TeardownTest() {
@@ -49,16 +150,26 @@ func (d *DomainSuite) TestCreateConsumerSuccess() {
 
 	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(false, nil)
 	d.mockStore.EXPECT().CreateConsumer(newUser).Return(newUser, nil)
-	u, err := d.domain.CreateConsumer(reg)
+	u, err := d.domain.CreateConsumer(context.Background(), reg)
 
 	d.Require().NoError(err)
 	d.Require().Equal(u, newUser)
 }
 
+func (d *DomainSuite) TestCreateConsumerPasswordPolicy() {
+	d.domain.PasswordPolicy = models.PasswordPolicy{MinLength: 8}
+	_, err := d.domain.CreateConsumer(context.Background(), models.RegFrom{Email: "test@test.com", Pass1: "1234", Pass2: "1234"})
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+	d.Require().Contains(err.Error(), "at least 8 characters")
+}
+
 func (d *DomainSuite) TestCreateConsumerInvalidRegForm() {
-	_, err := d.domain.CreateConsumer(models.RegFrom{})
+	_, err := d.domain.CreateConsumer(context.Background(), models.RegFrom{})
 
 	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
 }
 
 func (d *DomainSuite) TestCreateConsumerUserIsExist() {
@@ -69,7 +180,7 @@ func (d *DomainSuite) TestCreateConsumerUserIsExist() {
 	}
 
 	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(true, nil)
-	newUser, err := d.domain.CreateConsumer(reg)
+	newUser, err := d.domain.CreateConsumer(context.Background(), reg)
 
 	d.Require().Error(err)
 	d.Require().NotEqual(newUser, d.auth.MakeConsumerModel(reg))
@@ -84,7 +195,7 @@ func (d *DomainSuite) TestCreateConsumerStoreIsExistErr() {
 	dbErr := errors.New("db_error")
 
 	d.mockStore.EXPECT().ConsumerIsExist(gomock.Any()).Return(false, dbErr)
-	newUser, err := d.domain.CreateConsumer(reg)
+	newUser, err := d.domain.CreateConsumer(context.Background(), reg)
 
 	ok := errors.Is(err, dbErr)
 	d.Require().True(ok)
@@ -101,7 +212,7 @@ func (d *DomainSuite) TestCreateConsumerStoreCreateConsumerErr() {
 
 	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(false, nil)
 	d.mockStore.EXPECT().CreateConsumer(gomock.Any()).Return(models.Consumer{}, dbErr)
-	newUser, err := d.domain.CreateConsumer(reg)
+	newUser, err := d.domain.CreateConsumer(context.Background(), reg)
 
 	ok := errors.Is(err, dbErr)
 	d.Require().True(ok)
@@ -117,7 +228,7 @@ func (d *DomainSuite) TestConsumerSuccess() {
 	}
 
 	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
-	sUser, err := d.domain.Consumer(user.Email)
+	sUser, err := d.domain.Consumer(context.Background(), user.Email)
 
 	d.Require().NoError(err)
 	d.Require().Equal(sUser, user)
@@ -128,13 +239,77 @@ func (d *DomainSuite) TestConsumerFail() {
 	dbErr := errors.New("db_error")
 
 	d.mockStore.EXPECT().Consumer(gomock.Any()).Return(emptyUser, dbErr)
-	user, err := d.domain.Consumer("test@test.com")
+	user, err := d.domain.Consumer(context.Background(), "test@test.com")
 
 	d.Require().Error(err)
 	d.Require().True(errors.Is(err, dbErr))
 	d.Require().Equal(user, emptyUser)
 }
 
+func (d *DomainSuite) TestConsumerByIDNotFound() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{}, store.ErrNotFound)
+	_, err := d.domain.ConsumerByID(context.Background(), 1)
+
+	var uErr consumer.Error
+	d.Require().True(errors.As(err, &uErr))
+	d.Require().True(uErr.UserError())
+	d.Require().Equal(consumer.CodeNotFound, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestListConsumers() {
+	page := models.PageRequest{Limit: 10}
+	res := models.ConsumerPage{Consumers: []models.Consumer{{ID: 1}}, NextCursor: "next"}
+	d.mockStore.EXPECT().ListConsumers(models.ConsumerFilter{}, page).Return(res, nil)
+	p, err := d.domain.ListConsumers(context.Background(), models.ConsumerFilter{}, page)
+
+	d.Require().NoError(err)
+	d.Require().Equal(res, p)
+}
+
+func (d *DomainSuite) TestListConsumersInvalidPage() {
+	_, err := d.domain.ListConsumers(context.Background(), models.ConsumerFilter{}, models.PageRequest{Limit: models.MaxPageLimit + 1})
+
+	var uErr consumer.Error
+	d.Require().True(errors.As(err, &uErr))
+	d.Require().True(uErr.UserError())
+
+	d.mockStore.EXPECT().ListConsumers(gomock.Any(), gomock.Any()).Return(models.ConsumerPage{}, store.ErrInvalidCursor)
+	_, err = d.domain.ListConsumers(context.Background(), models.ConsumerFilter{}, models.PageRequest{Cursor: "!", Limit: 1})
+
+	d.Require().True(errors.As(err, &uErr))
+	d.Require().True(uErr.UserError())
+}
+
+func (d *DomainSuite) TestUpdateProfileRetriesOnConflict() {
+	stale := models.Consumer{ID: 1, Version: 1}
+	fresh := models.Consumer{ID: 1, Version: 2, Profile: models.Profile{DisplayName: "Name"}}
+	form := models.ProfileUpdateForm{Patch: []byte(`{"locale": "en"}`)}
+
+	gomock.InOrder(
+		d.mockStore.EXPECT().ConsumerByID(1).Return(stale, nil),
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).Return(models.Consumer{}, store.ErrConflict),
+		d.mockStore.EXPECT().ConsumerByID(1).Return(fresh, nil),
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+			d.Require().Equal(2, c.Version)
+			c.Version++
+			return c, nil
+		}),
+	)
+	c, err := d.domain.UpdateProfile(context.Background(), 1, form)
+
+	d.Require().NoError(err)
+	d.Require().Equal(3, c.Version)
+	d.Require().Equal(models.Profile{DisplayName: "Name", Locale: "en"}, c.Profile)
+}
+
+func (d *DomainSuite) TestUpdateProfileConflictRetriesExhausted() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1}, nil).AnyTimes()
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).Return(models.Consumer{}, store.ErrConflict).AnyTimes()
+	_, err := d.domain.UpdateProfile(context.Background(), 1, models.ProfileUpdateForm{Patch: []byte(`{}`)})
+
+	d.Require().True(errors.Is(err, store.ErrConflict))
+}
+
 func (d *DomainSuite) TestAuthenticateConsumerSuccess() {
 	authForm := models.AuthForm{
 		Email: "test@test.com",
@@ -148,18 +323,69 @@ func (d *DomainSuite) TestAuthenticateConsumerSuccess() {
 	}
 
 	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil)
-	token, err := d.domain.AuthenticateConsumer(authForm)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return(nil, nil)
+	res, err := d.domain.AuthenticateConsumer(context.Background(), authForm)
 
 	d.Require().NoError(err)
-	d.Require().NotEmpty(token)
+	d.Require().NotEmpty(res.Token)
+	d.Require().False(res.MFARequired)
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerGrantsRequestedScopes() {
+	authForm := models.AuthForm{
+		Email:  "test@test.com",
+		Pass:   "password",
+		Scopes: []string{"profile:read", "admin"},
+	}
+	user := models.Consumer{
+		ID:       1,
+		Email:    "test@test.com",
+		PassHash: "test@test.compassword",
+		Roles:    []string{models.RoleConsumer},
+	}
+
+	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return(nil, nil)
+	res, err := d.domain.AuthenticateConsumer(context.Background(), authForm)
+	d.Require().NoError(err)
+
+	claims, err := d.auth.ParseToken(res.Token)
+	d.Require().NoError(err)
+	d.Require().Equal([]string{"profile:read"}, claims.Scopes())
+	d.Require().True(claims.HasRole(models.RoleConsumer))
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerScopesNotAllowed() {
+	authForm := models.AuthForm{
+		Email:  "test@test.com",
+		Pass:   "password",
+		Scopes: []string{"admin"},
+	}
+	user := models.Consumer{
+		ID:       1,
+		Email:    "test@test.com",
+		PassHash: "test@test.compassword",
+		Roles:    []string{models.RoleConsumer},
+	}
+
+	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil)
+	res, err := d.domain.AuthenticateConsumer(context.Background(), authForm)
+	cErr, ok := err.(consumer.Error)
+
+	d.Require().Error(err)
+	d.Require().Empty(res.Token)
+	d.Require().True(ok)
+	d.Require().True(cErr.UserError())
 }
 
 func (d *DomainSuite) TestAuthenticateConsumerFormInvalid() {
-	token, err := d.domain.AuthenticateConsumer(models.AuthForm{})
+	res, err := d.domain.AuthenticateConsumer(context.Background(), models.AuthForm{})
 	_, ok := err.(consumer.Error)
 
 	d.Require().Error(err)
-	d.Require().Empty(token)
+	d.Require().Empty(res.Token)
 	d.Require().True(ok)
 }
 
@@ -171,11 +397,11 @@ func (d *DomainSuite) TestAuthenticateConsumerGetConsumerErr() {
 	dbErr := errors.New("db_error")
 
 	d.mockStore.EXPECT().Consumer(authForm.Email).Return(models.Consumer{}, dbErr)
-	token, err := d.domain.AuthenticateConsumer(authForm)
+	res, err := d.domain.AuthenticateConsumer(context.Background(), authForm)
 	_, ok := err.(consumer.Error)
 
 	d.Require().Error(err)
-	d.Require().Empty(token)
+	d.Require().Empty(res.Token)
 	d.Require().True(ok)
 	d.Require().True(errors.Is(err, dbErr))
 }
@@ -193,22 +419,423 @@ func (d *DomainSuite) TestAuthenticateConsumerInvalidPassword() {
 	}
 
 	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil)
-	token, err := d.domain.AuthenticateConsumer(authForm)
+	res, err := d.domain.AuthenticateConsumer(context.Background(), authForm)
 	_, ok := err.(consumer.Error)
 
 	d.Require().Error(err)
-	d.Require().Empty(token)
+	d.Require().Empty(res.Token)
+	d.Require().True(ok)
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerUniformFailures() {
+	authForm := models.AuthForm{
+		Email: "test@test.com",
+		Pass:  "password",
+	}
+	user := models.Consumer{
+		ID:       1,
+		Email:    "test@test.com",
+		PassHash: "hash",
+	}
+
+	d.mockStore.EXPECT().Consumer(authForm.Email).Return(models.Consumer{}, store.ErrNotFound)
+	_, unknownErr := d.domain.AuthenticateConsumer(context.Background(), authForm)
+	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil)
+	_, passwordErr := d.domain.AuthenticateConsumer(context.Background(), authForm)
+
+	for _, err := range []error{unknownErr, passwordErr} {
+		cErr, ok := err.(consumer.Error)
+		d.Require().True(ok)
+		d.Require().True(cErr.UserError())
+		d.Require().Equal(consumer.CodeInvalidCredentials, consumer.ErrorCode(err))
+	}
+	d.Require().Equal(unknownErr.Error(), passwordErr.Error())
+}
+
+func (d *DomainSuite) TestCreateConsumerEnumerationSafe() {
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{
+		Mailer:                      d.mockMailer,
+		EnumerationSafeRegistration: true,
+	})
+	reg := models.RegFrom{
+		Email: "test@test.com",
+		Pass1: "1234",
+		Pass2: "1234",
+	}
+	var sent []mailer.Message
+
+	d.mockMailer.EXPECT().Send(gomock.Any()).DoAndReturn(func(m mailer.Message) error {
+		sent = append(sent, m)
+		return nil
+	}).Times(2)
+	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(false, nil)
+	d.mockStore.EXPECT().CreateConsumer(gomock.Any()).Return(models.Consumer{ID: 1, Email: reg.Email}, nil)
+	created, createdErr := service.CreateConsumer(context.Background(), reg)
+	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(true, nil)
+	existing, existingErr := service.CreateConsumer(context.Background(), reg)
+	d.Require().Empty(sent)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.RunMailer(ctx)
+
+	d.Require().NoError(createdErr)
+	d.Require().NoError(existingErr)
+	d.Require().Equal(created, existing)
+	d.Require().Len(sent, 2)
+	d.Require().Equal(reg.Email, sent[1].To)
+	d.Require().NotEqual(sent[0].Subject, sent[1].Subject)
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerLockout() {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Opts{
+		Account: ratelimit.Rule{Limit: 3, Window: time.Hour},
+		Lockout: time.Hour,
+	})
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{Limiter: limiter})
+	authForm := models.AuthForm{
+		Email: "test@test.com",
+		Pass:  "password",
+	}
+	user := models.Consumer{
+		ID:       1,
+		Email:    "test@test.com",
+		PassHash: "hash",
+	}
+
+	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil).Times(3)
+	for i := 0; i < 3; i++ {
+		_, err := service.AuthenticateConsumer(context.Background(), authForm)
+		d.Require().Error(err)
+	}
+	_, err := service.AuthenticateConsumer(context.Background(), authForm)
+	var retryable consumer.RetryableError
+
+	d.Require().True(errors.As(err, &retryable))
+	d.Require().True(retryable.UserError())
+	d.Require().Equal(time.Hour, retryable.RetryAfter())
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerMFARequired() {
+	d.expectActive(1)
+	authForm := models.AuthForm{
+		Email: "test@test.com",
+		Pass:  "password",
+	}
+	user := models.Consumer{
+		ID:       1,
+		Email:    "test@test.com",
+		PassHash: "test@test.compassword",
+		Roles:    []string{models.RoleConsumer},
+	}
+	secret, err := totp.GenerateSecret()
+	d.Require().NoError(err)
+	factor := models.TOTPFactor{ConsumerID: user.ID, Secret: secret, Confirmed: true}
+
+	d.mockStore.EXPECT().Consumer(authForm.Email).Return(user, nil)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(factor, nil)
+	res, err := d.domain.AuthenticateConsumer(context.Background(), authForm)
+	d.Require().NoError(err)
+	d.Require().True(res.MFARequired)
+	d.Require().Error(d.domain.ValidateToken(context.Background(), res.Token))
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(factor, nil)
+	d.mockStore.EXPECT().SaveTOTPFactor(gomock.Any()).DoAndReturn(func(f models.TOTPFactor) error {
+		d.Require().Equal(totp.Step(time.Now()), f.LastUsedStep)
+		return nil
+	})
+	access, err := d.domain.CompleteMFA(context.Background(), models.MFAForm{Token: res.Token, Code: code})
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), access))
+
+	claims, err := d.auth.ParseToken(access)
+	d.Require().NoError(err)
+	d.Require().Equal([]string{"profile:read", "profile:write"}, claims.Scopes())
+}
+
+func (d *DomainSuite) TestCompleteMFAReplayedCode() {
+	secret, err := totp.GenerateSecret()
+	d.Require().NoError(err)
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	d.Require().NoError(err)
+	factor := models.TOTPFactor{ConsumerID: 1, Secret: secret, Confirmed: true, LastUsedStep: step}
+	pending := d.mfaPendingToken(1)
+
+	d.mockStore.EXPECT().TOTPFactor(1).Return(factor, nil)
+	access, err := d.domain.CompleteMFA(context.Background(), models.MFAForm{Token: pending, Code: code})
+	cErr, ok := err.(consumer.Error)
+
+	d.Require().Error(err)
+	d.Require().Empty(access)
 	d.Require().True(ok)
+	d.Require().True(cErr.UserError())
+}
+
+func (d *DomainSuite) TestCompleteMFAWithoutTOTP() {
+	// consumer whose second factor is passkey has no TOTP factor
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{}, store.ErrNotFound)
+	access, err := d.domain.CompleteMFA(context.Background(), models.MFAForm{Token: d.mfaPendingToken(1), Code: "123456"})
+	cErr, ok := err.(consumer.Error)
+
+	d.Require().Error(err)
+	d.Require().Empty(access)
+	d.Require().True(ok)
+	d.Require().True(cErr.UserError())
+}
+
+func (d *DomainSuite) TestCompleteMFARecoveryCode() {
+	d.expectActive(1)
+	codes, hashes, err := d.auth.NewRecoveryCodes()
+	d.Require().NoError(err)
+	factor := models.TOTPFactor{ConsumerID: 1, Secret: "GEZDGNBVGY3TQOJQ", Confirmed: true, RecoveryCodes: hashes}
+	pending := d.mfaPendingToken(1)
+
+	d.mockStore.EXPECT().TOTPFactor(1).Return(factor, nil)
+	d.mockStore.EXPECT().SaveTOTPFactor(gomock.Any()).DoAndReturn(func(f models.TOTPFactor) error {
+		d.Require().Len(f.RecoveryCodes, len(hashes)-1)
+		d.Require().NotContains(f.RecoveryCodes, hashes[3])
+		return nil
+	})
+	access, err := d.domain.CompleteMFA(context.Background(), models.MFAForm{Token: pending, Code: codes[3]})
+
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), access))
+}
+
+func (d *DomainSuite) TestCompleteMFAConcurrentRecoveryCode() {
+	codes, hashes, err := d.auth.NewRecoveryCodes()
+	d.Require().NoError(err)
+	factor := models.TOTPFactor{ConsumerID: 1, Secret: "GEZDGNBVGY3TQOJQ", Confirmed: true, RecoveryCodes: hashes, Version: 1}
+	used := factor
+	used.RecoveryCodes = append(hashes[:3:3], hashes[4:]...)
+	used.Version = 2
+
+	// concurrent request used the code after factor was read
+	gomock.InOrder(
+		d.mockStore.EXPECT().TOTPFactor(1).Return(factor, nil),
+		d.mockStore.EXPECT().SaveTOTPFactor(gomock.Any()).Return(store.ErrConflict),
+		d.mockStore.EXPECT().TOTPFactor(1).Return(used, nil),
+	)
+	_, err = d.domain.CompleteMFA(context.Background(), models.MFAForm{Token: d.mfaPendingToken(1), Code: codes[3]})
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+}
+
+func (d *DomainSuite) TestCompleteMFAWithAccessToken() {
+	access, err := d.domain.CompleteMFA(context.Background(), models.MFAForm{Token: validToken, Code: "123456"})
+
+	d.Require().Error(err)
+	d.Require().Empty(access)
+}
+
+func (d *DomainSuite) TestEnrollAndConfirmTOTP() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", Status: models.StatusActive}
+	var saved models.TOTPFactor
+	access := d.accessToken(user.ID, time.Now())
+
+	d.mockStore.EXPECT().ConsumerByID(user.ID).Return(user, nil).Times(2)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().SaveTOTPFactor(gomock.Any()).DoAndReturn(func(f models.TOTPFactor) error {
+		saved = f
+		return nil
+	}).Times(2)
+	enrollment, err := d.domain.EnrollTOTP(context.Background(), access)
+	d.Require().NoError(err)
+	d.Require().Equal(enrollment.Secret, saved.Secret)
+	d.Require().False(saved.Confirmed)
+	d.Require().NotEmpty(enrollment.QRCode)
+	d.Require().Contains(enrollment.URI, "otpauth://totp/")
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(saved, nil)
+	codes, err := d.domain.ConfirmTOTP(context.Background(), access, code)
+
+	d.Require().NoError(err)
+	d.Require().Len(codes, 10)
+	d.Require().True(saved.Confirmed)
+	d.Require().Len(saved.RecoveryCodes, 10)
+	d.Require().NotContains(saved.RecoveryCodes, codes[0])
+}
+
+func (d *DomainSuite) TestConfirmTOTPInvalidCode() {
+	d.expectActive(1)
+	factor := models.TOTPFactor{ConsumerID: 1, Secret: "GEZDGNBVGY3TQOJQ"}
+
+	d.mockStore.EXPECT().TOTPFactor(1).Return(factor, nil)
+	codes, err := d.domain.ConfirmTOTP(context.Background(), d.accessToken(1, time.Now()), "000000x")
+
+	d.Require().Error(err)
+	d.Require().Empty(codes)
+}
+
+func (d *DomainSuite) TestEnrollTOTPRequiresRecentLogin() {
+	d.expectActive(1)
+
+	_, err := d.domain.EnrollTOTP(context.Background(), d.accessToken(1, time.Now().Add(-time.Hour)))
+
+	d.Require().Error(err)
+	d.Require().Equal(consumer.CodeReauthRequired, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestPasskeyRegistrationAndLogin() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", Roles: []string{models.RoleConsumer}, Status: models.StatusActive}
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+	var saved models.WebAuthnCredential
+	access := d.accessToken(user.ID, time.Now())
+
+	d.mockStore.EXPECT().ConsumerByID(user.ID).Return(user, nil).AnyTimes()
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return(nil, nil)
+	opts, session, err := d.domain.BeginPasskeyRegistration(context.Background(), access)
+	d.Require().NoError(err)
+	d.Require().Equal("localhost", opts.RP.ID)
+	d.Require().Equal(user.Email, opts.User.Name)
+
+	d.mockStore.EXPECT().WebAuthnCredential(authenticator.CredentialID).Return(models.WebAuthnCredential{}, store.ErrNotFound)
+	d.mockStore.EXPECT().SaveWebAuthnCredential(gomock.Any()).DoAndReturn(func(c models.WebAuthnCredential) error {
+		saved = c
+		return nil
+	}).Times(2)
+	attestation, err := authenticator.Register(opts.Challenge)
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.FinishPasskeyRegistration(context.Background(), access, session, attestation))
+	d.Require().Equal(user.ID, saved.ConsumerID)
+	d.Require().Equal(authenticator.PublicKey(), saved.PublicKey)
+
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return([]models.WebAuthnCredential{saved}, nil)
+	reqOpts, session, err := d.domain.BeginPasskeyLogin(context.Background(), models.PasskeyLoginForm{Email: user.Email})
+	d.Require().NoError(err)
+	d.Require().Len(reqOpts.AllowCredentials, 1)
+
+	d.mockStore.EXPECT().WebAuthnCredential(authenticator.CredentialID).Return(saved, nil)
+	assertion, err := authenticator.Assert(reqOpts.Challenge)
+	d.Require().NoError(err)
+	access, err = d.domain.FinishPasskeyLogin(context.Background(), session, assertion)
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), access))
+	d.Require().Equal(authenticator.SignCount, saved.SignCount)
+}
+
+func (d *DomainSuite) TestPasskeyRegistrationRequiresRecentLogin() {
+	d.expectActive(1)
+
+	_, _, err := d.domain.BeginPasskeyRegistration(context.Background(), d.accessToken(1, time.Now().Add(-time.Hour)))
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+	d.Require().Equal(consumer.CodeReauthRequired, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestPasskeyRegistrationSessionOfAnotherUser() {
+	d.expectActive(1)
+	d.expectActive(2)
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	opts, session, err := d.domain.BeginPasskeyRegistration(context.Background(), d.accessToken(1, time.Now()))
+	d.Require().NoError(err)
+	attestation, err := authenticator.Register(opts.Challenge)
+	d.Require().NoError(err)
+	err = d.domain.FinishPasskeyRegistration(context.Background(), d.accessToken(2, time.Now()), session, attestation)
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+}
+
+func (d *DomainSuite) TestFinishPasskeyLoginReplay() {
+	d.expectActive(1)
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+	cred := models.WebAuthnCredential{ID: authenticator.CredentialID, ConsumerID: 1, PublicKey: authenticator.PublicKey()}
+
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{cred}, nil)
+	opts, session, err := d.domain.BeginPasskeyMFA(context.Background(), d.mfaPendingToken(1))
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().WebAuthnCredential(cred.ID).Return(cred, nil)
+	d.mockStore.EXPECT().SaveWebAuthnCredential(gomock.Any()).Return(nil)
+	assertion, err := authenticator.Assert(opts.Challenge)
+	d.Require().NoError(err)
+	_, err = d.domain.FinishPasskeyLogin(context.Background(), session, assertion)
+	d.Require().NoError(err)
+
+	access, err := d.domain.FinishPasskeyLogin(context.Background(), session, assertion)
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+	d.Require().Empty(access)
+}
+
+func (d *DomainSuite) TestPasskeyAsSecondFactor() {
+	d.expectActive(1)
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+	cred := models.WebAuthnCredential{ID: authenticator.CredentialID, ConsumerID: 1, PublicKey: authenticator.PublicKey()}
+
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{cred}, nil)
+	opts, session, err := d.domain.BeginPasskeyMFA(context.Background(), d.mfaPendingToken(1))
+	d.Require().NoError(err)
+
+	d.mockStore.EXPECT().WebAuthnCredential(cred.ID).Return(cred, nil)
+	d.mockStore.EXPECT().SaveWebAuthnCredential(gomock.Any()).Return(nil)
+	assertion, err := authenticator.Assert(opts.Challenge)
+	d.Require().NoError(err)
+	access, err := d.domain.FinishPasskeyLogin(context.Background(), session, assertion)
+
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), access))
+}
+
+func (d *DomainSuite) TestFinishPasskeyLoginForeignCredential() {
+	authenticator, err := webauthntest.New("localhost", "https://localhost")
+	d.Require().NoError(err)
+	own := models.WebAuthnCredential{ID: []byte("own"), ConsumerID: 1}
+	foreign := models.WebAuthnCredential{ID: authenticator.CredentialID, ConsumerID: 2, PublicKey: authenticator.PublicKey()}
+
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{own}, nil)
+	opts, session, err := d.domain.BeginPasskeyMFA(context.Background(), d.mfaPendingToken(1))
+	d.Require().NoError(err)
+
+	d.mockStore.EXPECT().WebAuthnCredential(foreign.ID).Return(foreign, nil)
+	assertion, err := authenticator.Assert(opts.Challenge)
+	d.Require().NoError(err)
+	access, err := d.domain.FinishPasskeyLogin(context.Background(), session, assertion)
+
+	d.Require().Error(err)
+	d.Require().Empty(access)
+}
+
+// expectActive allows lookups of consumer with active account
+func (d *DomainSuite) expectActive(id int) {
+	d.mockStore.EXPECT().ConsumerByID(id).Return(models.Consumer{ID: id, Status: models.StatusActive}, nil).AnyTimes()
+}
+
+func (d *DomainSuite) mfaPendingToken(id int) string {
+	t, err := d.auth.CreateJWT(token.Claims{
+		ID: id,
+		StandardClaims: &jwt.StandardClaims{
+			Subject:   token.SubjectMFAPending,
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	})
+	d.Require().NoError(err)
+	return t
 }
 
 func (d *DomainSuite) TestValidateTokenSuccess() {
-	err := d.domain.ValidateToken(validToken)
+	d.expectActive(1)
+	err := d.domain.ValidateToken(context.Background(), validToken)
 
 	d.Require().NoError(err)
 }
 
 func (d *DomainSuite) TestValidateTokenInvalidToken() {
-	err := d.domain.ValidateToken(invalidToken)
+	err := d.domain.ValidateToken(context.Background(), invalidToken)
 	_, ok := err.(consumer.Error)
 
 	d.Require().Error(err)
@@ -216,7 +843,7 @@ func (d *DomainSuite) TestValidateTokenInvalidToken() {
 }
 
 func (d *DomainSuite) TestValidateTokenExpiredToken() {
-	err := d.domain.ValidateToken(expiredToken)
+	err := d.domain.ValidateToken(context.Background(), expiredToken)
 
 	d.Require().Error(err)
 }
@@ -0,0 +1,337 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+const (
+	exportTTL             = 7 * 24 * time.Hour
+	exportDownloadLinkTTL = 15 * time.Minute
+	exportFileName        = "personal_data.json"
+	// exportSyncLimit is max count of history records which are exported synchronously,
+	// archives of larger histories are built by RunExportBuilder
+	exportSyncLimit = 100
+	exportBatchSize = 10
+)
+
+var errExportNotFound = &domainError{IsUserError: true, Code: consumer.CodeNotFound, Message: "export not found"}
+
+// RequestExport starts export of consumer's personal data. Small archives are ready on return,
+// large ones are built by RunExportBuilder, their status is available via LastExport.
+// If previous export is still being built, it is returned instead of starting new one.
+func (s *Service) RequestExport(ctx context.Context, consumerID int, form models.ExportForm) (models.Export, error) {
+	if err := form.Validate(); err != nil {
+		return models.Export{}, &domainError{IsUserError: true, Message: "can't export data", Err: err}
+	}
+	c, err := s.ActiveConsumer(ctx, consumerID)
+	if err != nil {
+		return models.Export{}, err
+	}
+	last, err := s.LastExport(ctx, consumerID)
+	if err == nil && last.Status == models.ExportPending {
+		return last, nil
+	}
+
+	data, err := s.personalData(ctx, c)
+	if err != nil {
+		return models.Export{}, err
+	}
+	now := time.Now()
+	e, err := s.storeFor(ctx).SaveExport(models.Export{
+		ConsumerID: consumerID,
+		Format:     form.Format,
+		Status:     models.ExportPending,
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(exportTTL).Unix(),
+	})
+	if err != nil {
+		return models.Export{}, &domainError{Message: "can't save export", Err: err}
+	}
+	if len(data.StatusHistory)+len(data.Passkeys)+len(data.AuditEvents) > exportSyncLimit {
+		return e, nil
+	}
+	e, err = s.buildExport(ctx, e, data)
+	if err != nil {
+		return models.Export{}, err
+	}
+	e.Data = nil
+	return e, nil
+}
+
+// BuildPendingExports builds exports which were too large to be built on request and returns count of built exports.
+// Export of consumer who doesn't exist anymore is marked as failed.
+func (s *Service) BuildPendingExports(ctx context.Context) (_ int, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.BuildPendingExports")
+	defer func() { tracing.End(span, err) }()
+
+	built := 0
+	for {
+		batch, err := s.storeFor(ctx).PendingExports(exportBatchSize)
+		if err != nil {
+			return built, &domainError{Message: "can't get pending exports", Err: err}
+		}
+		for _, e := range batch {
+			if err := ctx.Err(); err != nil {
+				return built, err
+			}
+			c, err := s.ConsumerByID(ctx, e.ConsumerID)
+			if consumer.ErrorCode(err) == consumer.CodeNotFound {
+				e.Status = models.ExportFailed
+				if _, err := s.storeFor(ctx).SaveExport(e); err != nil {
+					return built, &domainError{Message: "can't save export", Err: err}
+				}
+				continue
+			}
+			if err != nil {
+				return built, err
+			}
+			data, err := s.personalData(ctx, c)
+			if err != nil {
+				return built, err
+			}
+			if _, err := s.buildExport(ctx, e, data); err != nil {
+				return built, err
+			}
+			built++
+		}
+		if len(batch) < exportBatchSize {
+			return built, nil
+		}
+	}
+}
+
+// RunExportBuilder calls BuildPendingExports every interval until ctx is done.
+// Exports interrupted by shutdown stay pending and are built after restart.
+func (s *Service) RunExportBuilder(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := s.BuildPendingExports(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.Logger.Error("can't build exports", zap.Error(err))
+		} else if n != 0 {
+			s.Logger.Debug("exports built", zap.Int("exports", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// LastExport returns the most recent not expired consumer's export without its content
+func (s *Service) LastExport(ctx context.Context, consumerID int) (models.Export, error) {
+	e, err := s.storeFor(ctx).LastExport(consumerID)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Export{}, errExportNotFound
+	}
+	if err != nil {
+		return models.Export{}, &domainError{Message: "can't get export", Err: err}
+	}
+	if e.ExpiresAt <= time.Now().Unix() {
+		return models.Export{}, errExportNotFound
+	}
+	e.Data = nil
+	return e, nil
+}
+
+// ExportDownloadToken returns short-lived token which allows to download ready export without authentication
+func (s *Service) ExportDownloadToken(e models.Export) (string, error) {
+	if e.Status != models.ExportReady {
+		return "", &domainError{IsUserError: true, Message: "export is not ready"}
+	}
+	now := time.Now()
+	expiresAt := now.Add(exportDownloadLinkTTL)
+	if expiresAt.Unix() > e.ExpiresAt {
+		expiresAt = time.Unix(e.ExpiresAt, 0)
+	}
+	return s.auth.CreateJWT(token.Claims{
+		ID: e.ConsumerID,
+		StandardClaims: &jwt.StandardClaims{
+			Id:        strconv.Itoa(e.ID),
+			Subject:   token.SubjectExportDownload,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Issuer:    issuer,
+		},
+	})
+}
+
+// DownloadExport returns ready export with content by token from ExportDownloadToken
+func (s *Service) DownloadExport(ctx context.Context, t string) (models.Export, error) {
+	claims, err := s.auth.ParseToken(t)
+	if err != nil {
+		return models.Export{}, err
+	}
+	if err := claims.Valid(); err != nil {
+		return models.Export{}, &domainError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "invalid token", Err: err}
+	}
+	id, err := strconv.Atoi(claims.Id)
+	if claims.Subject != token.SubjectExportDownload || err != nil {
+		return models.Export{}, &domainError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "invalid token"}
+	}
+	if _, err := s.ActiveConsumer(ctx, claims.ID); err != nil {
+		return models.Export{}, err
+	}
+	e, err := s.storeFor(ctx).Export(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Export{}, errExportNotFound
+	}
+	if err != nil {
+		return models.Export{}, &domainError{Message: "can't get export", Err: err}
+	}
+	if e.ConsumerID != claims.ID || e.Status != models.ExportReady || e.ExpiresAt <= time.Now().Unix() {
+		return models.Export{}, errExportNotFound
+	}
+	return e, nil
+}
+
+// personalData collects everything stored about consumer
+func (s *Service) personalData(ctx context.Context, c models.Consumer) (models.PersonalData, error) {
+	data := models.PersonalData{
+		ExportedAt:    time.Now().Unix(),
+		Consumer:      c.Public(),
+		Roles:         c.Roles,
+		StatusHistory: []models.StatusChange{},
+		Passkeys:      []models.PasskeyInfo{},
+		OAuthGrants:   []models.OAuthGrant{},
+		AuditEvents:   []models.AuditEvent{},
+	}
+	history, err := s.storeFor(ctx).StatusChanges(c.ID)
+	if err != nil {
+		return models.PersonalData{}, &domainError{Message: "can't get status history", Err: err}
+	}
+	data.StatusHistory = append(data.StatusHistory, history...)
+
+	f, err := s.storeFor(ctx).TOTPFactor(c.ID)
+	switch {
+	case err == nil:
+		data.TOTP = &models.TOTPInfo{Confirmed: f.Confirmed, RecoveryCodesLeft: len(f.RecoveryCodes)}
+	case !errors.Is(err, store.ErrNotFound):
+		return models.PersonalData{}, &domainError{Message: "can't get second factor", Err: err}
+	}
+
+	creds, err := s.storeFor(ctx).WebAuthnCredentials(c.ID)
+	if err != nil {
+		return models.PersonalData{}, &domainError{Message: "can't get passkeys", Err: err}
+	}
+	for _, cred := range creds {
+		data.Passkeys = append(data.Passkeys, models.PasskeyInfo{
+			ID:         base64.RawURLEncoding.EncodeToString(cred.ID),
+			AAGUID:     hex.EncodeToString(cred.AAGUID),
+			CreatedAt:  cred.CreatedAt,
+			LastUsedAt: cred.LastUsedAt,
+		})
+	}
+	if data.OAuthGrants, err = s.oauthGrants(ctx, c.ID); err != nil {
+		return models.PersonalData{}, err
+	}
+	err = s.eachAuditPage(ctx, models.AuditFilter{ConsumerID: c.ID}, func(events []models.AuditEvent) error {
+		data.AuditEvents = append(data.AuditEvents, events...)
+		return nil
+	})
+	if err != nil {
+		return models.PersonalData{}, err
+	}
+	return data, nil
+}
+
+// oauthGrants returns clients which hold unexpired refresh tokens of consumer, one grant per client.
+// Scopes of all client's token families are merged.
+func (s *Service) oauthGrants(ctx context.Context, consumerID int) ([]models.OAuthGrant, error) {
+	tokens, err := s.storeFor(ctx).ConsumerRefreshTokens(consumerID)
+	if err != nil {
+		return nil, &domainError{Message: "can't get refresh tokens", Err: err}
+	}
+	now := time.Now().Unix()
+	grants := []models.OAuthGrant{}
+	index := make(map[string]int)
+	for _, t := range tokens {
+		if t.ExpiresAt <= now {
+			continue
+		}
+		i, ok := index[t.ClientID]
+		if !ok {
+			i = len(grants)
+			index[t.ClientID] = i
+			grants = append(grants, models.OAuthGrant{ClientID: t.ClientID, Scopes: []string{}, CreatedAt: t.CreatedAt})
+		}
+		g := &grants[i]
+		for _, scope := range strings.Fields(t.Scope) {
+			if !hasScope(g.Scopes, scope) {
+				g.Scopes = append(g.Scopes, scope)
+			}
+		}
+		if t.CreatedAt < g.CreatedAt {
+			g.CreatedAt = t.CreatedAt
+		}
+		if t.CreatedAt > g.LastUsedAt {
+			g.LastUsedAt = t.CreatedAt
+		}
+		if t.UsedAt > g.LastUsedAt {
+			g.LastUsedAt = t.UsedAt
+		}
+	}
+	return grants, nil
+}
+
+// buildExport encodes data to archive and saves it to export, export is marked as failed on error
+func (s *Service) buildExport(ctx context.Context, e models.Export, data models.PersonalData) (models.Export, error) {
+	content, err := encodeExport(e.Format, data)
+	if err != nil {
+		e.Status = models.ExportFailed
+		if _, saveErr := s.storeFor(ctx).SaveExport(e); saveErr != nil {
+			return models.Export{}, &domainError{Message: "can't save export", Err: saveErr}
+		}
+		return models.Export{}, &domainError{Message: "can't build export", Err: err}
+	}
+	e.Status = models.ExportReady
+	e.Data = content
+	e, err = s.storeFor(ctx).SaveExport(e)
+	if err != nil {
+		return models.Export{}, &domainError{Message: "can't save export", Err: err}
+	}
+	return e, nil
+}
+
+func encodeExport(format string, data models.PersonalData) ([]byte, error) {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if format == models.ExportFormatJSON {
+		return b, nil
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(exportFileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
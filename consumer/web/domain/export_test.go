@@ -0,0 +1,158 @@
+package domain_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/golang/mock/gomock"
+)
+
+// expectExportStore makes store keep saved exports in exports
+func (d *DomainSuite) expectExportStore(exports map[int]models.Export) {
+	d.mockStore.EXPECT().SaveExport(gomock.Any()).DoAndReturn(func(e models.Export) (models.Export, error) {
+		if e.ID == 0 {
+			e.ID = len(exports) + 1
+		}
+		exports[e.ID] = e
+		return e, nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().Export(gomock.Any()).DoAndReturn(func(id int) (models.Export, error) {
+		e, ok := exports[id]
+		if !ok {
+			return models.Export{}, store.ErrNotFound
+		}
+		return e, nil
+	}).AnyTimes()
+}
+
+func (d *DomainSuite) expectPersonalData(history []models.StatusChange) {
+	d.mockStore.EXPECT().StatusChanges(1).Return(history, nil)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{ConsumerID: 1, Secret: "secret", Confirmed: true, RecoveryCodes: []string{"a", "b"}}, nil)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return([]models.WebAuthnCredential{
+		{ID: []byte{1, 2}, ConsumerID: 1, PublicKey: []byte("key"), AAGUID: []byte{0xab}, CreatedAt: 10},
+	}, nil)
+	expires := time.Now().Add(time.Hour).Unix()
+	d.mockStore.EXPECT().ConsumerRefreshTokens(1).Return([]models.RefreshToken{
+		{Hash: "a", FamilyID: "a", ClientID: "web", ConsumerID: 1, Scope: "profile:read", CreatedAt: 10, ExpiresAt: expires, UsedAt: 20},
+		{Hash: "b", FamilyID: "a", ClientID: "web", ConsumerID: 1, Scope: "profile:read", CreatedAt: 20, ExpiresAt: expires},
+		{Hash: "c", FamilyID: "c", ClientID: "web", ConsumerID: 1, Scope: "openid profile:read", CreatedAt: 30, ExpiresAt: expires},
+		{Hash: "d", FamilyID: "d", ClientID: "cli", ConsumerID: 1, Scope: "profile:read", CreatedAt: 40, ExpiresAt: 50},
+	}, nil)
+	d.mockStore.EXPECT().AuditEvents(models.AuditFilter{ConsumerID: 1}, models.PageRequest{Limit: models.MaxPageLimit}).
+		Return(models.AuditPage{Events: []models.AuditEvent{{ID: 1, Type: models.AuditRegistered, ConsumerID: 1}}}, nil)
+}
+
+func (d *DomainSuite) TestRequestExportAndDownload() {
+	exports := map[int]models.Export{}
+	d.expectActive(1)
+	d.expectExportStore(exports)
+	d.mockStore.EXPECT().LastExport(1).Return(models.Export{}, store.ErrNotFound)
+	d.expectPersonalData([]models.StatusChange{{ID: 1, ConsumerID: 1, From: models.StatusActive, To: models.StatusSuspended}})
+
+	e, err := d.domain.RequestExport(context.Background(), 1, models.ExportForm{Format: models.ExportFormatJSON})
+	d.Require().NoError(err)
+	d.Require().Equal(models.ExportReady, e.Status)
+	d.Require().Nil(e.Data)
+
+	t, err := d.domain.ExportDownloadToken(e)
+	d.Require().NoError(err)
+	downloaded, err := d.domain.DownloadExport(context.Background(), t)
+	d.Require().NoError(err)
+
+	var data models.PersonalData
+	d.Require().NoError(json.Unmarshal(downloaded.Data, &data))
+	d.Require().Equal(1, data.Consumer.ID)
+	d.Require().Len(data.StatusHistory, 1)
+	d.Require().Equal(&models.TOTPInfo{Confirmed: true, RecoveryCodesLeft: 2}, data.TOTP)
+	d.Require().Equal([]models.PasskeyInfo{{ID: "AQI", AAGUID: "ab", CreatedAt: 10}}, data.Passkeys)
+	d.Require().Equal([]models.OAuthGrant{
+		{ClientID: "web", Scopes: []string{"profile:read", "openid"}, CreatedAt: 10, LastUsedAt: 30},
+	}, data.OAuthGrants, "expired tokens aren't grants")
+	d.Require().Len(data.AuditEvents, 1)
+	d.Require().NotContains(string(downloaded.Data), "secret")
+}
+
+func (d *DomainSuite) TestRequestExportZip() {
+	exports := map[int]models.Export{}
+	d.expectActive(1)
+	d.expectExportStore(exports)
+	d.mockStore.EXPECT().LastExport(1).Return(models.Export{}, store.ErrNotFound)
+	d.expectPersonalData(nil)
+
+	e, err := d.domain.RequestExport(context.Background(), 1, models.ExportForm{Format: models.ExportFormatZip})
+	d.Require().NoError(err)
+
+	zr, err := zip.NewReader(bytes.NewReader(exports[e.ID].Data), int64(len(exports[e.ID].Data)))
+	d.Require().NoError(err)
+	d.Require().Len(zr.File, 1)
+	f, err := zr.File[0].Open()
+	d.Require().NoError(err)
+	b, err := ioutil.ReadAll(f)
+	d.Require().NoError(err)
+	d.Require().Contains(string(b), `"status_history": []`)
+}
+
+func (d *DomainSuite) TestRequestExportLargeHistoryByBuilder() {
+	exports := map[int]models.Export{}
+	d.expectActive(1)
+	d.expectExportStore(exports)
+	d.mockStore.EXPECT().LastExport(1).Return(models.Export{}, store.ErrNotFound)
+	d.expectPersonalData(make([]models.StatusChange, 101))
+
+	e, err := d.domain.RequestExport(context.Background(), 1, models.ExportForm{Format: models.ExportFormatJSON})
+	d.Require().NoError(err)
+	d.Require().Equal(models.ExportPending, e.Status)
+	d.Require().Equal(models.ExportPending, exports[e.ID].Status, "export isn't built within request")
+
+	// builder gathers data itself, so export doesn't depend on request which started it
+	d.mockStore.EXPECT().PendingExports(gomock.Any()).Return([]models.Export{exports[e.ID]}, nil)
+	d.expectPersonalData(make([]models.StatusChange, 101))
+	n, err := d.domain.BuildPendingExports(context.Background())
+	d.Require().NoError(err)
+	d.Require().Equal(1, n)
+	d.Require().Equal(models.ExportReady, exports[e.ID].Status)
+	d.Require().NotEmpty(exports[e.ID].Data)
+}
+
+func (d *DomainSuite) TestBuildPendingExportOfDeletedConsumer() {
+	exports := map[int]models.Export{1: {ID: 1, ConsumerID: 2, Status: models.ExportPending}}
+	d.expectExportStore(exports)
+	d.mockStore.EXPECT().PendingExports(gomock.Any()).Return([]models.Export{exports[1]}, nil)
+	d.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{}, store.ErrNotFound)
+
+	n, err := d.domain.BuildPendingExports(context.Background())
+	d.Require().NoError(err)
+	d.Require().Zero(n)
+	d.Require().Equal(models.ExportFailed, exports[1].Status)
+}
+
+func (d *DomainSuite) TestRequestExportReturnsPending() {
+	pending := models.Export{ID: 1, ConsumerID: 1, Status: models.ExportPending, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	d.expectActive(1)
+	d.mockStore.EXPECT().LastExport(1).Return(pending, nil)
+
+	e, err := d.domain.RequestExport(context.Background(), 1, models.ExportForm{Format: models.ExportFormatJSON})
+	d.Require().NoError(err)
+	d.Require().Equal(pending, e)
+}
+
+func (d *DomainSuite) TestLastExportExpired() {
+	d.mockStore.EXPECT().LastExport(1).Return(models.Export{ID: 1, ExpiresAt: time.Now().Add(-time.Second).Unix()}, nil)
+	_, err := d.domain.LastExport(context.Background(), 1)
+
+	d.Require().Equal(consumer.CodeNotFound, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestDownloadExportWithAccessToken() {
+	_, err := d.domain.DownloadExport(context.Background(), validToken)
+
+	d.Require().Equal(consumer.CodeInvalidToken, consumer.ErrorCode(err))
+}
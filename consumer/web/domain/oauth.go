@@ -0,0 +1,191 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.uber.org/zap"
+)
+
+// maxIntrospectionCacheSize bounds count of cached introspection responses, the cache is flushed when it is full
+const maxIntrospectionCacheSize = 10000
+
+var (
+	errOAuthClientNotFound = &domainError{IsUserError: true, Code: consumer.CodeNotFound, Message: "client not found"}
+	errInvalidClient       = &domainError{IsUserError: true, Code: consumer.CodeInvalidClient, Message: "client authentication failed"}
+)
+
+// CreateOAuthClient registers OAuth client. Its secret is returned only here, only hash of it is stored.
+// Secret is empty for public clients.
+func (s *Service) CreateOAuthClient(ctx context.Context, form models.OAuthClientForm) (models.OAuthClient, string, error) {
+	if err := form.Validate(); err != nil {
+		return models.OAuthClient{}, "", &domainError{IsUserError: true, Message: "can't create client", Err: err}
+	}
+	id, secret, hash, err := s.auth.NewClientCredentials()
+	if err != nil {
+		return models.OAuthClient{}, "", &domainError{Message: "can't create client", Err: err}
+	}
+	if form.Public {
+		secret, hash = "", ""
+	}
+	c := models.OAuthClient{
+		ID:           id,
+		SecretHash:   hash,
+		Name:         form.Name,
+		Public:       form.Public,
+		RedirectURIs: form.RedirectURIs,
+		CreatedAt:    time.Now().Unix(),
+	}
+	if err := s.storeFor(ctx).CreateOAuthClient(c); err != nil {
+		return models.OAuthClient{}, "", &domainError{Message: "can't save client", Err: err}
+	}
+	return c, secret, nil
+}
+
+// OAuthClients returns all OAuth clients
+func (s *Service) OAuthClients(ctx context.Context) ([]models.OAuthClient, error) {
+	clients, err := s.storeFor(ctx).OAuthClients()
+	if err != nil {
+		return nil, &domainError{Message: "can't get clients", Err: err}
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient deletes OAuth client, it can't authenticate afterwards
+func (s *Service) DeleteOAuthClient(ctx context.Context, id string) error {
+	err := s.storeFor(ctx).DeleteOAuthClient(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return errOAuthClientNotFound
+	}
+	if err != nil {
+		return &domainError{Message: "can't delete client", Err: err}
+	}
+	return nil
+}
+
+// AuthenticateClient returns confidential OAuth client if secret is its secret.
+// The same error is returned for unknown client and wrong secret.
+func (s *Service) AuthenticateClient(ctx context.Context, id, secret string) (models.OAuthClient, error) {
+	if secret == "" {
+		return models.OAuthClient{}, errInvalidClient
+	}
+	c, err := s.oauthClient(ctx, id)
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+	if c.Public || !s.auth.MatchClientSecret(c.SecretHash, secret) {
+		return models.OAuthClient{}, errInvalidClient
+	}
+	return c, nil
+}
+
+// oauthClient returns client by id, invalid client error is returned if there is no such client
+func (s *Service) oauthClient(ctx context.Context, id string) (models.OAuthClient, error) {
+	if id == "" {
+		return models.OAuthClient{}, errInvalidClient
+	}
+	c, err := s.storeFor(ctx).OAuthClient(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.OAuthClient{}, errInvalidClient
+	}
+	if err != nil {
+		return models.OAuthClient{}, &domainError{Message: "can't get client", Err: err}
+	}
+	return c, nil
+}
+
+// IntrospectToken returns state of access token as defined by RFC 7662. Invalid, expired and revoked tokens
+// and tokens of inactive accounts aren't errors, they are reported as inactive.
+// Responses are cached for IntrospectionCacheTTL, but not after token expires. Cached responses of consumer
+// are dropped when his tokens are revoked or status is changed by this instance.
+func (s *Service) IntrospectToken(ctx context.Context, t string) (models.TokenIntrospection, error) {
+	key := sha256.Sum256([]byte(t))
+	now := time.Now()
+	if res, ok := s.introspection.get(key, now); ok {
+		return res, nil
+	}
+	claims, c, err := s.accessToken(ctx, t)
+	if err != nil {
+		if cErr, ok := err.(consumer.Error); ok && cErr.UserError() {
+			return models.TokenIntrospection{Active: false}, nil
+		}
+		return models.TokenIntrospection{}, err
+	}
+	res := models.TokenIntrospection{
+		Active:    true,
+		Subject:   strconv.Itoa(c.ID),
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		TokenType: "Bearer",
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		Issuer:    claims.Issuer,
+	}
+	if s.IntrospectionCacheTTL > 0 {
+		expires := now.Add(s.IntrospectionCacheTTL)
+		if exp := time.Unix(claims.ExpiresAt, 0); claims.ExpiresAt != 0 && exp.Before(expires) {
+			expires = exp
+		}
+		s.introspection.put(key, c.ID, res, expires)
+	}
+	s.log(ctx).Debug("token introspected", zap.Int("consumer_id", c.ID))
+	return res, nil
+}
+
+// introspectionCache keeps responses on active tokens by hash of token.
+// Responses on inactive tokens aren't cached, so cache can't be filled by garbage.
+type introspectionCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]introspectionEntry
+}
+
+type introspectionEntry struct {
+	consumerID int
+	res        models.TokenIntrospection
+	expires    time.Time
+}
+
+func (c *introspectionCache) get(key [sha256.Size]byte, now time.Time) (models.TokenIntrospection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return models.TokenIntrospection{}, false
+	}
+	if !now.Before(e.expires) {
+		delete(c.entries, key)
+		return models.TokenIntrospection{}, false
+	}
+	return e.res, true
+}
+
+func (c *introspectionCache) put(key [sha256.Size]byte, consumerID int, res models.TokenIntrospection, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil || len(c.entries) >= maxIntrospectionCacheSize {
+		c.entries = make(map[[sha256.Size]byte]introspectionEntry)
+	}
+	c.entries[key] = introspectionEntry{consumerID: consumerID, res: res, expires: expires}
+}
+
+// forget drops cached responses on tokens of consumer
+func (c *introspectionCache) forget(consumerID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.entries {
+		if e.consumerID == consumerID {
+			delete(c.entries, k)
+		}
+	}
+}
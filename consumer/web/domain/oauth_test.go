@@ -0,0 +1,112 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestCreateOAuthClient() {
+	var saved models.OAuthClient
+	d.mockStore.EXPECT().CreateOAuthClient(gomock.Any()).DoAndReturn(func(c models.OAuthClient) error {
+		saved = c
+		return nil
+	})
+
+	c, secret, err := d.domain.CreateOAuthClient(context.Background(), models.OAuthClientForm{Name: "billing"})
+
+	d.Require().NoError(err)
+	d.Require().Equal(saved, c)
+	d.Require().Len(c.ID, 32)
+	d.Require().NotEqual(secret, c.SecretHash)
+	d.Require().True(d.auth.MatchClientSecret(c.SecretHash, secret))
+
+	_, _, err = d.domain.CreateOAuthClient(context.Background(), models.OAuthClientForm{Name: "bad\nname"})
+	d.Require().True(err.(consumer.Error).UserError())
+}
+
+func (d *DomainSuite) TestAuthenticateClient() {
+	id, secret, hash, err := d.auth.NewClientCredentials()
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().OAuthClient(id).Return(models.OAuthClient{ID: id, SecretHash: hash}, nil).Times(2)
+	d.mockStore.EXPECT().OAuthClient("unknown").Return(models.OAuthClient{}, store.ErrNotFound)
+
+	c, err := d.domain.AuthenticateClient(context.Background(), id, secret)
+	d.Require().NoError(err)
+	d.Require().Equal(id, c.ID)
+
+	for _, creds := range [][2]string{{id, "wrong"}, {"unknown", secret}, {id, ""}} {
+		_, err = d.domain.AuthenticateClient(context.Background(), creds[0], creds[1])
+		d.Require().Equal(consumer.CodeInvalidClient, consumer.ErrorCode(err), creds)
+	}
+}
+
+func (d *DomainSuite) TestIntrospectToken() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Status: models.StatusActive}, nil)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	res, err := d.domain.IntrospectToken(context.Background(), d.accessToken(1, issuedAt))
+
+	d.Require().NoError(err)
+	d.Require().Equal(models.TokenIntrospection{
+		Active:    true,
+		Subject:   "1",
+		TokenType: "Bearer",
+		ExpiresAt: issuedAt.Add(time.Hour).Unix(),
+		IssuedAt:  issuedAt.Unix(),
+	}, res)
+}
+
+func (d *DomainSuite) TestIntrospectInactiveToken() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Status: models.StatusSuspended}, nil)
+	d.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2, Status: models.StatusActive, TokensRevokedAt: time.Now().Unix()}, nil)
+
+	for _, t := range []string{"invalid", expiredToken, d.accessToken(1, time.Now()), d.accessToken(2, time.Now().Add(-time.Minute))} {
+		res, err := d.domain.IntrospectToken(context.Background(), t)
+		d.Require().NoError(err)
+		d.Require().Equal(models.TokenIntrospection{Active: false}, res)
+	}
+}
+
+func (d *DomainSuite) TestIntrospectTokenStoreError() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{}, errors.New("connection refused"))
+
+	_, err := d.domain.IntrospectToken(context.Background(), d.accessToken(1, time.Now()))
+
+	d.Require().Error(err)
+	d.Require().False(err.(consumer.Error).UserError())
+}
+
+func (d *DomainSuite) TestIntrospectionCache() {
+	s := domain.NewService(d.mockStore, d.auth, domain.Opts{IntrospectionCacheTTL: time.Minute})
+	user := models.Consumer{ID: 1, Status: models.StatusActive}
+	gomock.InOrder(
+		d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil),
+		// RevokeTokens
+		d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil),
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+			user = c
+			return c, nil
+		}),
+		d.mockStore.EXPECT().ConsumerByID(1).DoAndReturn(func(int) (models.Consumer, error) { return user, nil }),
+	)
+	t := d.accessToken(1, time.Now().Add(-time.Minute))
+
+	for i := 0; i < 3; i++ {
+		res, err := s.IntrospectToken(context.Background(), t)
+		d.Require().NoError(err)
+		d.Require().True(res.Active)
+	}
+	d.Require().NoError(s.RevokeTokens(context.Background(), 1))
+
+	res, err := s.IntrospectToken(context.Background(), t)
+	d.Require().NoError(err)
+	d.Require().False(res.Active)
+}
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// subjectIDToken labels issued ID tokens in metrics
+const subjectIDToken = "id"
+
+// OpenIDConfiguration returns metadata of OpenID Connect provider, endpoints are relative to Issuer
+func (s *Service) OpenIDConfiguration() models.OpenIDConfiguration {
+	base := strings.TrimSuffix(s.Issuer, "/")
+	scopes := []string{token.ScopeOpenID, token.ScopeEmail, token.ScopeProfile}
+	return models.OpenIDConfiguration{
+		Issuer:                            s.Issuer,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserInfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		ScopesSupported:                   append(scopes, s.auth.AllowedScopes([]string{models.RoleConsumer})...),
+		ResponseTypesSupported:            []string{models.ResponseTypeCode},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{models.CodeChallengeS256},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr",
+			"email", "email_verified", "name", "locale", "zoneinfo", "picture",
+		},
+	}
+}
+
+// createIDToken returns ID token which tells client who authorized code and how he authenticated
+func (s *Service) createIDToken(clientID string, c models.Consumer, code models.OAuthCode) (string, error) {
+	now := time.Now()
+	claims := token.IDClaims{
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime,
+		AMR:      code.AMR,
+		StandardClaims: &jwt.StandardClaims{
+			Issuer:    s.Issuer,
+			Subject:   strconv.Itoa(c.ID),
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.oauthAccessTokenTTL()).Unix(),
+		},
+	}
+	info := models.NewUserInfo(c, strings.Fields(code.Scope))
+	claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+	t, err := s.auth.CreateIDToken(claims)
+	s.Metrics.TokenIssued(subjectIDToken, err)
+	return t, err
+}
+
+// authenticationMethods returns values of amr claim as defined by RFC 8176 for login method
+func authenticationMethods(method string) []string {
+	switch method {
+	case methodTOTP:
+		return []string{"pwd", "otp", "mfa"}
+	case methodRecoveryCode:
+		return []string{"pwd", "mfa"}
+	default:
+		return []string{"pwd"}
+	}
+}
+
+// hasScope reports whether scopes contain scope
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
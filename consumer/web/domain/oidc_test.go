@@ -0,0 +1,137 @@
+package domain_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/totp"
+
+	"github.com/golang/mock/gomock"
+)
+
+// exchangeCode exchanges authorization code issued to public client "web"
+func (d *DomainSuite) exchangeCode(code string) (models.TokenResponse, error) {
+	return d.domain.Token(context.Background(), models.TokenRequest{
+		GrantType:    models.GrantAuthorizationCode,
+		ClientID:     "web",
+		Code:         code,
+		RedirectURI:  "https://app.test/cb",
+		CodeVerifier: codeVerifier,
+	})
+}
+
+func (d *DomainSuite) TestIDToken() {
+	d.expectClients()
+	req := authorizationRequest
+	req.Scope = "openid email"
+	req.Nonce = "n-0S6_WzA2Mj"
+	authorizedAt := time.Now().Unix()
+	code := d.authorize(req)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil).Times(2)
+
+	res, err := d.exchangeCode(code)
+	d.Require().NoError(err)
+	d.Require().Equal("openid email", res.Scope, "only identity scopes are granted if only they are requested")
+	d.Require().NotEmpty(res.IDToken)
+
+	claims, err := d.auth.ParseIDToken(res.IDToken)
+	d.Require().NoError(err)
+	d.Require().NoError(claims.Valid())
+	d.Require().Equal("https://id.test", claims.Issuer)
+	d.Require().Equal("1", claims.Subject)
+	d.Require().Equal("web", claims.Audience)
+	d.Require().Equal("n-0S6_WzA2Mj", claims.Nonce)
+	d.Require().NotZero(claims.IssuedAt)
+	d.Require().Greater(claims.ExpiresAt, claims.IssuedAt)
+	d.Require().GreaterOrEqual(claims.AuthTime, authorizedAt)
+	d.Require().LessOrEqual(claims.AuthTime, claims.IssuedAt)
+	d.Require().Equal([]string{"pwd"}, claims.AMR)
+	d.Require().Equal(oauthUser.Email, claims.Email)
+	d.Require().NotNil(claims.EmailVerified)
+	d.Require().False(*claims.EmailVerified)
+
+	refreshed, err := d.domain.Token(context.Background(), models.TokenRequest{
+		GrantType:    models.GrantRefreshToken,
+		ClientID:     "web",
+		RefreshToken: res.RefreshToken,
+	})
+	d.Require().NoError(err)
+	d.Require().Equal("openid email", refreshed.Scope)
+	d.Require().Empty(refreshed.IDToken, "id token isn't reissued on refresh")
+}
+
+func (d *DomainSuite) TestIDTokenRequiresOpenIDScope() {
+	d.expectClients()
+	req := authorizationRequest
+	req.Scope = "email profile"
+	code := d.authorize(req)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+
+	res, err := d.exchangeCode(code)
+	d.Require().NoError(err)
+	d.Require().Equal("email profile", res.Scope)
+	d.Require().Empty(res.IDToken)
+}
+
+func (d *DomainSuite) TestIDTokenWithoutEmailScope() {
+	d.expectClients()
+	req := authorizationRequest
+	req.Scope = "openid " + token.ScopeProfileRead
+	code := d.authorize(req)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+
+	res, err := d.exchangeCode(code)
+	d.Require().NoError(err)
+	d.Require().Equal("openid profile:read", res.Scope)
+	claims, err := d.auth.ParseIDToken(res.IDToken)
+	d.Require().NoError(err)
+	d.Require().Empty(claims.Nonce)
+	d.Require().Empty(claims.Email)
+	d.Require().Nil(claims.EmailVerified)
+}
+
+func (d *DomainSuite) TestIDTokenWithSecondFactor() {
+	d.expectClients()
+	secret, err := totp.GenerateSecret()
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().Consumer(oauthUser.Email).Return(oauthUser, nil)
+	d.mockStore.EXPECT().TOTPFactor(1).Return(models.TOTPFactor{ConsumerID: 1, Secret: secret, Confirmed: true}, nil).Times(2)
+	d.mockStore.EXPECT().WebAuthnCredentials(1).Return(nil, nil)
+	d.mockStore.EXPECT().SaveTOTPFactor(gomock.Any()).Return(nil)
+	d.mockStore.EXPECT().ConsumerByID(1).Return(oauthUser, nil)
+	var saved models.OAuthCode
+	d.mockStore.EXPECT().SaveOAuthCode(gomock.Any()).DoAndReturn(func(c models.OAuthCode) error {
+		saved = c
+		return nil
+	})
+
+	req := authorizationRequest
+	req.Scope = "openid"
+	req.Nonce = "nonce"
+	res, err := d.domain.Authorize(context.Background(), req, models.AuthForm{Email: oauthUser.Email, Pass: "password"})
+	d.Require().NoError(err)
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	d.Require().NoError(err)
+	_, err = d.domain.AuthorizeMFA(context.Background(), req, models.MFAForm{Token: res.MFAToken, Code: code})
+	d.Require().NoError(err)
+
+	d.Require().Equal("nonce", saved.Nonce)
+	d.Require().Equal([]string{"pwd", "otp", "mfa"}, saved.AMR)
+	d.Require().NotZero(saved.AuthTime)
+}
+
+func (d *DomainSuite) TestOpenIDConfiguration() {
+	c := d.domain.OpenIDConfiguration()
+
+	d.Require().Equal("https://id.test", c.Issuer)
+	d.Require().Equal("https://id.test/oauth/authorize", c.AuthorizationEndpoint)
+	d.Require().Equal("https://id.test/oauth/token", c.TokenEndpoint)
+	d.Require().Equal("https://id.test/userinfo", c.UserInfoEndpoint)
+	d.Require().Equal("https://id.test/.well-known/jwks.json", c.JWKSURI)
+	d.Require().Contains(c.ScopesSupported, token.ScopeOpenID)
+	d.Require().Equal([]string{"code"}, c.ResponseTypesSupported)
+	d.Require().Equal([]string{"public"}, c.SubjectTypesSupported)
+	d.Require().Contains(c.IDTokenSigningAlgValuesSupported, "RS256", "RS256 must be supported")
+}
@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+)
+
+const oneTimeTokenMaxAttempts = 5
+
+// issueOneTimeToken stores hash of secret issued to consumer for purpose.
+// Previously issued active token for the same purpose is revoked.
+func (s *Service) issueOneTimeToken(ctx context.Context, c models.Consumer, purpose, secret string, ttl time.Duration) (models.OneTimeToken, error) {
+	now := time.Now()
+	for attempt := 0; ; attempt++ {
+		last, err := s.storeFor(ctx).LastOneTimeToken(c.ID, purpose)
+		if errors.Is(err, store.ErrNotFound) || (err == nil && (last.UsedAt != 0 || last.ExpiresAt <= now.Unix())) {
+			break
+		}
+		if err != nil {
+			return models.OneTimeToken{}, &domainError{Message: "can't get one-time token", Err: err}
+		}
+		last.UsedAt = now.Unix()
+		_, err = s.storeFor(ctx).SaveOneTimeToken(last)
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return models.OneTimeToken{}, &domainError{Message: "can't revoke one-time token", Err: err}
+		}
+		break
+	}
+	t, err := s.storeFor(ctx).SaveOneTimeToken(models.OneTimeToken{
+		ConsumerID: c.ID,
+		Email:      c.Email,
+		Purpose:    purpose,
+		Hash:       s.auth.HashSecret(secret),
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return models.OneTimeToken{}, &domainError{Message: "can't save one-time token", Err: err}
+	}
+	return t, nil
+}
+
+// consumeOneTimeToken marks token as used if secret matches it.
+// Token is invalidated after too many failed attempts. Token is saved only if it wasn't changed since it was read,
+// so concurrent requests can neither use it twice nor exceed the attempts limit.
+func (s *Service) consumeOneTimeToken(ctx context.Context, t models.OneTimeToken, purpose, secret string) error {
+	now := time.Now().Unix()
+	for attempt := 0; ; attempt++ {
+		if t.Purpose != purpose || t.UsedAt != 0 || t.ExpiresAt <= now || t.Attempts >= oneTimeTokenMaxAttempts {
+			return &domainError{IsUserError: true, Message: "token is expired or already used"}
+		}
+		matched := s.auth.MatchSecret(t.Hash, secret)
+		if matched {
+			t.UsedAt = now
+		} else {
+			t.Attempts++
+		}
+		_, err := s.storeFor(ctx).SaveOneTimeToken(t)
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			if t, err = s.storeFor(ctx).OneTimeToken(t.ID); err != nil {
+				return &domainError{Message: "can't get one-time token", Err: err}
+			}
+			continue
+		}
+		if err != nil {
+			return &domainError{Message: "can't save one-time token", Err: err}
+		}
+		if !matched {
+			return &domainError{IsUserError: true, Message: "invalid token"}
+		}
+		return nil
+	}
+}
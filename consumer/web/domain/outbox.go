@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+
+	"go.uber.org/zap"
+)
+
+const outboxBatchSize = 100
+
+// addEvent saves event to outbox of tx, so it is published only if tx is committed
+func addEvent(tx store.DataStore, e models.DomainEvent) error {
+	m, err := models.NewOutboxMessage(e, time.Now().Unix())
+	if err != nil {
+		return &domainError{Message: "can't encode event", Err: err}
+	}
+	if err := tx.AddOutboxMessage(m); err != nil {
+		return &domainError{Message: "can't save event", Err: err}
+	}
+	return nil
+}
+
+// PublishOutbox publishes pending outbox messages in order to Publisher and queues their delivery to webhooks,
+// it returns count of published messages. It stops at the first failure, failed message is published again on the next call.
+func (s *Service) PublishOutbox(ctx context.Context) (_ int, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.PublishOutbox")
+	defer func() { tracing.End(span, err) }()
+
+	hooks, err := s.storeFor(ctx).Webhooks()
+	if err != nil {
+		return 0, &domainError{Message: "can't get webhooks", Err: err}
+	}
+	published := 0
+	for {
+		batch, err := s.storeFor(ctx).PendingOutboxMessages(outboxBatchSize)
+		if err != nil {
+			return published, &domainError{Message: "can't get outbox messages", Err: err}
+		}
+		for _, m := range batch {
+			if s.Publisher != nil {
+				if err := s.Publisher.Publish(ctx, m); err != nil {
+					return published, &domainError{Message: "can't publish event", Err: err}
+				}
+			}
+			if err := s.enqueueWebhooks(ctx, hooks, m); err != nil {
+				return published, err
+			}
+			if err := s.storeFor(ctx).MarkOutboxMessagePublished(m.ID, time.Now().Unix()); err != nil {
+				return published, &domainError{Message: "can't mark event as published", Err: err}
+			}
+			published++
+		}
+		if len(batch) < outboxBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// RunOutboxRelay calls PublishOutbox every interval until ctx is done
+func (s *Service) RunOutboxRelay(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := s.PublishOutbox(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.Logger.Error("can't publish outbox", zap.Error(err))
+		} else if n != 0 {
+			s.Logger.Debug("outbox published", zap.Int("messages", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package domain_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/events"
+
+	"github.com/golang/mock/gomock"
+)
+
+type failingPublisher struct {
+	published []int
+	failID    int
+}
+
+func (p *failingPublisher) Publish(_ context.Context, m models.OutboxMessage) error {
+	if m.ID == p.failID {
+		return errors.New("broker is unavailable")
+	}
+	p.published = append(p.published, m.ID)
+	return nil
+}
+
+func (d *DomainSuite) TestCreateConsumerAddsEvent() {
+	reg := models.RegFrom{Email: "test@test.com", Pass1: "1234", Pass2: "1234"}
+	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(false, nil)
+	d.mockStore.EXPECT().CreateConsumer(gomock.Any()).Return(models.Consumer{ID: 1, Email: reg.Email, RegTimestamp: 10}, nil)
+	_, err := d.domain.CreateConsumer(context.Background(), reg)
+
+	d.Require().NoError(err)
+	d.Require().Len(d.outbox, 1)
+	d.Require().Equal(models.EventConsumerRegistered, d.outbox[0].Type)
+	d.Require().Equal(1, d.outbox[0].ConsumerID)
+	d.Require().JSONEq(`{"consumer_id": 1, "email": "test@test.com", "registered_at": 10}`, string(d.outbox[0].Payload))
+}
+
+func (d *DomainSuite) TestPurgeDeletedConsumersAddsEvent() {
+	now := time.Now()
+	d.mockStore.EXPECT().ConsumersScheduledForDeletion(now.Unix(), gomock.Any()).Return([]models.Consumer{{ID: 2}}, nil)
+	d.mockStore.EXPECT().PurgeConsumer(2).Return(nil)
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(nil)
+	_, err := d.domain.PurgeDeletedConsumers(context.Background(), now)
+
+	d.Require().NoError(err)
+	d.Require().Len(d.outbox, 1)
+	var e models.ConsumerDeleted
+	d.Require().NoError(json.Unmarshal(d.outbox[0].Payload, &e))
+	d.Require().Equal(models.ConsumerDeleted{ConsumerID: 2, DeletedAt: now.Unix()}, e)
+}
+
+func (d *DomainSuite) TestPublishOutbox() {
+	ch := events.NewChannel(2)
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{Publisher: ch})
+	d.mockStore.EXPECT().Webhooks().Return(nil, nil)
+	d.mockStore.EXPECT().PendingOutboxMessages(gomock.Any()).Return([]models.OutboxMessage{
+		{ID: 1, Type: models.EventConsumerRegistered},
+		{ID: 2, Type: models.EventConsumerDeleted},
+	}, nil)
+	d.mockStore.EXPECT().MarkOutboxMessagePublished(1, gomock.Any()).Return(nil)
+	d.mockStore.EXPECT().MarkOutboxMessagePublished(2, gomock.Any()).Return(nil)
+	n, err := service.PublishOutbox(context.Background())
+
+	d.Require().NoError(err)
+	d.Require().Equal(2, n)
+	d.Require().Equal(1, (<-ch.C()).ID)
+	d.Require().Equal(2, (<-ch.C()).ID)
+}
+
+func (d *DomainSuite) TestPublishOutboxStopsOnFailure() {
+	p := &failingPublisher{failID: 2}
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{Publisher: p})
+	d.mockStore.EXPECT().Webhooks().Return(nil, nil)
+	d.mockStore.EXPECT().PendingOutboxMessages(gomock.Any()).Return([]models.OutboxMessage{{ID: 1}, {ID: 2}, {ID: 3}}, nil)
+	d.mockStore.EXPECT().MarkOutboxMessagePublished(1, gomock.Any()).Return(nil)
+	n, err := service.PublishOutbox(context.Background())
+
+	d.Require().Error(err)
+	d.Require().Equal(1, n)
+	d.Require().Equal([]int{1}, p.published)
+}
@@ -0,0 +1,213 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.uber.org/zap"
+)
+
+const (
+	loginLinkTTL    = 15 * time.Minute
+	loginCodeTTL    = 10 * time.Minute
+	loginCodeDigits = 6
+)
+
+// errInvalidLoginToken is returned on every failed passwordless login,
+// so response doesn't reveal whether email is registered or token was issued
+var errInvalidLoginToken = &domainError{
+	IsUserError: true,
+	Code:        consumer.CodeInvalidCredentials,
+	Message:     "invalid credentials",
+}
+
+// RequestLogin queues login link or code to consumer's email, message is sent by RunMailer.
+// Unknown email is not reported and mail isn't sent within request, so the method can't be used
+// to find out registered addresses. Requests are throttled per email and client address like failed logins,
+// so codes can't be requested again and again to guess them.
+func (s *Service) RequestLogin(ctx context.Context, form models.LoginRequestForm) error {
+	if err := form.Validate(); err != nil {
+		return &domainError{IsUserError: true, Message: "can't request login", Err: err}
+	}
+	if s.Mailer == nil || (form.Method == models.LoginMethodLink && s.LoginLinkURL == "") {
+		return &domainError{IsUserError: true, Message: "login method is not available"}
+	}
+	account := loginRequestAccount(form.Email)
+	if err := s.allowAttempt(account, form.Client.IP); err != nil {
+		s.log(ctx).Info("login request throttled", zap.String("email", form.Email), zap.String("ip", form.Client.IP), zap.Error(err))
+		return err
+	}
+	s.failAttempt(account)
+	c, err := s.storeFor(ctx).Consumer(form.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return &domainError{Message: "can't get user", Err: err}
+	}
+
+	var msg mailer.Message
+	switch form.Method {
+	case models.LoginMethodLink:
+		secret, err := s.auth.NewLinkSecret()
+		if err != nil {
+			return err
+		}
+		t, err := s.issueOneTimeToken(ctx, c, models.PurposeLoginLink, secret, loginLinkTTL)
+		if err != nil {
+			return err
+		}
+		link, err := url.Parse(s.LoginLinkURL)
+		if err != nil {
+			return &domainError{Message: "invalid login link url", Err: err}
+		}
+		q := link.Query()
+		q.Set("token", fmt.Sprintf("%d.%s", t.ID, secret))
+		link.RawQuery = q.Encode()
+		msg = mailer.Message{
+			Subject: "Your login link",
+			Body:    fmt.Sprintf("Follow the link to log in:\n%s\n\nThe link expires in %d minutes.", link, int(loginLinkTTL.Minutes())),
+		}
+	case models.LoginMethodCode:
+		code, err := s.auth.NewCode(loginCodeDigits)
+		if err != nil {
+			return err
+		}
+		if _, err := s.issueOneTimeToken(ctx, c, models.PurposeLoginCode, code, loginCodeTTL); err != nil {
+			return err
+		}
+		msg = mailer.Message{
+			Subject: "Your login code",
+			Body:    fmt.Sprintf("Your login code is %s\n\nThe code expires in %d minutes.", code, int(loginCodeTTL.Minutes())),
+		}
+	}
+	return s.queueNotification(ctx, c.Email, msg)
+}
+
+// LoginWithLink authenticates consumer with token from login link. Attempts are throttled per client address,
+// link secret is too long to be guessed within the limit.
+func (s *Service) LoginWithLink(ctx context.Context, form models.LoginLinkForm) (models.AuthResult, error) {
+	if err := form.Validate(); err != nil {
+		return models.AuthResult{}, &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	if err := s.allowAttempt("", form.Client.IP); err != nil {
+		s.log(ctx).Info("login throttled", zap.String("ip", form.Client.IP), zap.Error(err))
+		return models.AuthResult{}, err
+	}
+	parts := strings.SplitN(form.Token, ".", 2)
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) != 2 {
+		return models.AuthResult{}, errInvalidLoginToken
+	}
+	t, err := s.storeFor(ctx).OneTimeToken(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.AuthResult{}, errInvalidLoginToken
+	}
+	if err != nil {
+		return models.AuthResult{}, &domainError{Message: "can't get one-time token", Err: err}
+	}
+	if err := s.consumeOneTimeToken(ctx, t, models.PurposeLoginLink, parts[1]); err != nil {
+		if cErr, ok := err.(consumer.Error); ok && cErr.UserError() {
+			return models.AuthResult{}, errInvalidLoginToken
+		}
+		return models.AuthResult{}, err
+	}
+	c, err := s.storeFor(ctx).Consumer(t.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.AuthResult{}, errInvalidLoginToken
+	}
+	if err != nil {
+		return models.AuthResult{}, &domainError{Message: "can't get user", Err: err}
+	}
+	if c.ID != t.ConsumerID {
+		return models.AuthResult{}, errInvalidLoginToken
+	}
+	if c, err = s.verifyEmail(ctx, c); err != nil {
+		return models.AuthResult{}, err
+	}
+	return s.completeLogin(ctx, c, form.Scopes, methodLoginLink, form.Client)
+}
+
+// LoginWithCode authenticates consumer with code sent by email.
+// Attempts are throttled per email and client address, failures count towards the same lockout as failed passwords.
+func (s *Service) LoginWithCode(ctx context.Context, form models.LoginCodeForm) (models.AuthResult, error) {
+	if err := form.Validate(); err != nil {
+		return models.AuthResult{}, &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	if err := s.allowAttempt(form.Email, form.Client.IP); err != nil {
+		s.log(ctx).Info("login throttled", zap.String("email", form.Email), zap.String("ip", form.Client.IP), zap.Error(err))
+		s.auditLoginFailed(ctx, 0, form.Email, form.Client, consumer.ErrorCode(err))
+		return models.AuthResult{}, err
+	}
+	c, err := s.storeFor(ctx).Consumer(form.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		s.failAttempt(form.Email)
+		return models.AuthResult{}, errInvalidLoginToken
+	}
+	if err != nil {
+		return models.AuthResult{}, &domainError{Message: "can't get user", Err: err}
+	}
+	t, err := s.storeFor(ctx).LastOneTimeToken(c.ID, models.PurposeLoginCode)
+	if errors.Is(err, store.ErrNotFound) {
+		s.failAttempt(form.Email)
+		return models.AuthResult{}, errInvalidLoginToken
+	}
+	if err != nil {
+		return models.AuthResult{}, &domainError{Message: "can't get one-time token", Err: err}
+	}
+	if err := s.consumeOneTimeToken(ctx, t, models.PurposeLoginCode, form.Code); err != nil {
+		s.auditLoginFailed(ctx, c.ID, c.Email, form.Client, "invalid_code")
+		if cErr, ok := err.(consumer.Error); ok && cErr.UserError() {
+			s.failAttempt(form.Email)
+			return models.AuthResult{}, errInvalidLoginToken
+		}
+		return models.AuthResult{}, err
+	}
+	s.succeedAttempt(form.Email)
+	if c, err = s.verifyEmail(ctx, c); err != nil {
+		return models.AuthResult{}, err
+	}
+	return s.completeLogin(ctx, c, form.Scopes, methodLoginCode, form.Client)
+}
+
+// loginRequestAccount returns limiter account of login requests to email, they are counted apart from failed logins
+func loginRequestAccount(email string) string {
+	return "login_request:" + email
+}
+
+// verifyEmail marks consumer's email as verified when he proves its ownership with login link or code
+// and publishes ConsumerVerified. Update is retried if consumer was modified concurrently.
+func (s *Service) verifyEmail(ctx context.Context, c models.Consumer) (models.Consumer, error) {
+	for attempt := 0; !c.EmailVerified; attempt++ {
+		verified := c
+		verified.EmailVerified = true
+		err := s.storeFor(ctx).Atomic(func(tx store.DataStore) error {
+			var err error
+			if verified, err = tx.UpdateConsumer(verified); err != nil {
+				return &domainError{Message: "can't verify email", Err: err}
+			}
+			return addEvent(tx, models.ConsumerVerified{ConsumerID: c.ID, Email: c.Email, VerifiedAt: time.Now().Unix()})
+		})
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			if c, err = s.storeFor(ctx).ConsumerByID(c.ID); err != nil {
+				return models.Consumer{}, &domainError{Message: "can't get user", Err: err}
+			}
+			continue
+		}
+		if err != nil {
+			return models.Consumer{}, err
+		}
+		return verified, nil
+	}
+	return c, nil
+}
@@ -0,0 +1,327 @@
+package domain_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/ratelimit"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/store/memory"
+
+	"github.com/golang/mock/gomock"
+)
+
+var codeRegex = regexp.MustCompile(`\b\d{6}\b`)
+
+func (d *DomainSuite) TestRequestLoginCodeAndLogin() {
+	d.expectActive(1)
+	user := models.Consumer{ID: 1, Email: "test@test.com", Roles: []string{models.RoleConsumer}}
+	var saved models.OneTimeToken
+	var msg mailer.Message
+
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil).Times(2)
+	d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginCode).Return(models.OneTimeToken{}, store.ErrNotFound)
+	d.mockStore.EXPECT().SaveOneTimeToken(gomock.Any()).DoAndReturn(func(t models.OneTimeToken) (models.OneTimeToken, error) {
+		t.ID = 7
+		saved = t
+		return t, nil
+	}).Times(2)
+	err := d.domain.RequestLogin(context.Background(), models.LoginRequestForm{Email: user.Email, Method: models.LoginMethodCode})
+	d.Require().NoError(err)
+	d.mockMailer.EXPECT().Send(gomock.Any()).DoAndReturn(func(m mailer.Message) error {
+		msg = m
+		return nil
+	})
+	d.sendQueuedMail()
+	d.Require().Equal(user.Email, msg.To)
+	code := codeRegex.FindString(msg.Body)
+	d.Require().NotEmpty(code)
+	d.Require().NotContains(saved.Hash, code)
+
+	d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginCode).Return(saved, nil)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return(nil, nil)
+	var updated models.Consumer
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		updated = c
+		return c, nil
+	})
+	res, err := d.domain.LoginWithCode(context.Background(), models.LoginCodeForm{Email: user.Email, Code: code})
+
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), res.Token))
+	d.Require().NotZero(saved.UsedAt)
+	d.Require().True(updated.EmailVerified, "code proves ownership of email")
+	d.Require().Len(d.outbox, 1)
+	d.Require().Equal(models.EventConsumerVerified, d.outbox[0].Type)
+	d.Require().Equal(user.ID, d.outbox[0].ConsumerID)
+}
+
+func (d *DomainSuite) TestRequestLoginLinkAndLogin() {
+	d.expectActive(1)
+	user := models.Consumer{ID: 1, Email: "test@test.com", EmailVerified: true, Roles: []string{models.RoleConsumer}}
+	active := models.OneTimeToken{ID: 3, ConsumerID: user.ID, Purpose: models.PurposeLoginLink, ExpiresAt: 1 << 40}
+	var saved []models.OneTimeToken
+	var msg mailer.Message
+
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil).Times(2)
+	d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginLink).Return(active, nil)
+	d.mockStore.EXPECT().SaveOneTimeToken(gomock.Any()).DoAndReturn(func(t models.OneTimeToken) (models.OneTimeToken, error) {
+		if t.ID == 0 {
+			t.ID = 4
+		}
+		saved = append(saved, t)
+		return t, nil
+	}).Times(3)
+	err := d.domain.RequestLogin(context.Background(), models.LoginRequestForm{Email: user.Email, Method: models.LoginMethodLink})
+	d.Require().NoError(err)
+	d.mockMailer.EXPECT().Send(gomock.Any()).DoAndReturn(func(m mailer.Message) error {
+		msg = m
+		return nil
+	})
+	d.sendQueuedMail()
+	d.Require().Equal(active.ID, saved[0].ID)
+	d.Require().NotZero(saved[0].UsedAt, "previous token must be revoked")
+
+	link := msg.Body[strings.Index(msg.Body, "https://"):]
+	link = link[:strings.Index(link, "\n")]
+	u, err := url.Parse(link)
+	d.Require().NoError(err)
+	tokenString := u.Query().Get("token")
+	d.Require().True(strings.HasPrefix(tokenString, "4."))
+
+	d.mockStore.EXPECT().OneTimeToken(4).Return(saved[1], nil)
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(models.TOTPFactor{}, store.ErrNotFound)
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return(nil, nil)
+	res, err := d.domain.LoginWithLink(context.Background(), models.LoginLinkForm{Token: tokenString})
+
+	d.Require().NoError(err)
+	d.Require().NoError(d.domain.ValidateToken(context.Background(), res.Token))
+	d.Require().Empty(d.outbox, "verified email isn't verified again")
+}
+
+func (d *DomainSuite) TestRequestLoginUnknownEmail() {
+	d.mockStore.EXPECT().Consumer("test@test.com").Return(models.Consumer{}, store.ErrNotFound)
+	err := d.domain.RequestLogin(context.Background(), models.LoginRequestForm{Email: "test@test.com", Method: models.LoginMethodCode})
+	d.sendQueuedMail()
+
+	d.Require().NoError(err)
+}
+
+// sendQueuedMail sends messages queued by domain and returns when queue is empty
+func (d *DomainSuite) sendQueuedMail() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.domain.RunMailer(ctx)
+}
+
+func (d *DomainSuite) TestLoginWithCodeAttemptsLimit() {
+	user := models.Consumer{ID: 1, Email: "test@test.com"}
+	t := models.OneTimeToken{
+		ID:         1,
+		ConsumerID: user.ID,
+		Purpose:    models.PurposeLoginCode,
+		Hash:       d.auth.HashSecret("123456"),
+		ExpiresAt:  1 << 40,
+	}
+
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil).AnyTimes()
+	d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginCode).DoAndReturn(func(int, string) (models.OneTimeToken, error) {
+		return t, nil
+	}).AnyTimes()
+	d.mockStore.EXPECT().SaveOneTimeToken(gomock.Any()).DoAndReturn(func(saved models.OneTimeToken) (models.OneTimeToken, error) {
+		t = saved
+		return saved, nil
+	}).AnyTimes()
+	for i := 0; i < 5; i++ {
+		_, err := d.domain.LoginWithCode(context.Background(), models.LoginCodeForm{Email: user.Email, Code: "000000"})
+		d.Require().Error(err)
+	}
+	_, err := d.domain.LoginWithCode(context.Background(), models.LoginCodeForm{Email: user.Email, Code: "123456"})
+
+	d.Require().Error(err)
+	d.Require().Equal(5, t.Attempts)
+	d.Require().Zero(t.UsedAt)
+}
+
+func (d *DomainSuite) TestPasswordlessLoginErrorsAreSame() {
+	user := models.Consumer{ID: 1, Email: "test@test.com"}
+	other := models.Consumer{ID: 2, Email: "other@test.com"}
+	code := models.OneTimeToken{
+		ID:         1,
+		ConsumerID: user.ID,
+		Email:      user.Email,
+		Purpose:    models.PurposeLoginCode,
+		Hash:       d.auth.HashSecret("123456"),
+		ExpiresAt:  1 << 40,
+	}
+	link := models.OneTimeToken{
+		ID:         2,
+		ConsumerID: user.ID,
+		Email:      user.Email,
+		Purpose:    models.PurposeLoginLink,
+		Hash:       d.auth.HashSecret("secret"),
+		ExpiresAt:  1 << 40,
+		UsedAt:     1,
+	}
+
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil).AnyTimes()
+	d.mockStore.EXPECT().Consumer(other.Email).Return(other, nil).AnyTimes()
+	d.mockStore.EXPECT().Consumer("unknown@test.com").Return(models.Consumer{}, store.ErrNotFound).AnyTimes()
+	d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginCode).Return(code, nil).AnyTimes()
+	d.mockStore.EXPECT().LastOneTimeToken(other.ID, models.PurposeLoginCode).Return(models.OneTimeToken{}, store.ErrNotFound).AnyTimes()
+	d.mockStore.EXPECT().OneTimeToken(code.ID).Return(code, nil).AnyTimes()
+	d.mockStore.EXPECT().OneTimeToken(link.ID).Return(link, nil).AnyTimes()
+	d.mockStore.EXPECT().OneTimeToken(100).Return(models.OneTimeToken{}, store.ErrNotFound).AnyTimes()
+	d.mockStore.EXPECT().SaveOneTimeToken(gomock.Any()).DoAndReturn(func(t models.OneTimeToken) (models.OneTimeToken, error) {
+		return t, nil
+	}).AnyTimes()
+	var errs []error
+	for _, form := range []models.LoginCodeForm{
+		{Email: "unknown@test.com", Code: "123456"},
+		{Email: other.Email, Code: "123456"},
+		{Email: user.Email, Code: "000000"},
+	} {
+		_, err := d.domain.LoginWithCode(context.Background(), form)
+		errs = append(errs, err)
+	}
+	for _, token := range []string{"x.secret", "100.secret", fmt.Sprintf("%d.secret", link.ID), fmt.Sprintf("%d.secret", code.ID)} {
+		_, err := d.domain.LoginWithLink(context.Background(), models.LoginLinkForm{Token: token})
+		errs = append(errs, err)
+	}
+
+	for _, err := range errs {
+		d.Require().Error(err)
+		d.Require().Equal(consumer.CodeInvalidCredentials, consumer.ErrorCode(err))
+		d.Require().Equal(errs[0].Error(), err.Error())
+	}
+}
+
+// expectOneTimeTokens makes store keep one-time tokens in memory store and returns it
+func (d *DomainSuite) expectOneTimeTokens() *memory.Store {
+	tokens := memory.New()
+	d.mockStore.EXPECT().SaveOneTimeToken(gomock.Any()).DoAndReturn(tokens.SaveOneTimeToken).AnyTimes()
+	d.mockStore.EXPECT().OneTimeToken(gomock.Any()).DoAndReturn(tokens.OneTimeToken).AnyTimes()
+	d.mockStore.EXPECT().LastOneTimeToken(gomock.Any(), gomock.Any()).DoAndReturn(tokens.LastOneTimeToken).AnyTimes()
+	return tokens
+}
+
+func (d *DomainSuite) TestLoginWithCodeConcurrently() {
+	d.expectActive(1)
+	user := models.Consumer{ID: 1, Email: "test@test.com", EmailVerified: true, Roles: []string{models.RoleConsumer}}
+	tokens := d.expectOneTimeTokens()
+	_, err := tokens.SaveOneTimeToken(models.OneTimeToken{
+		ConsumerID: user.ID,
+		Purpose:    models.PurposeLoginCode,
+		Hash:       d.auth.HashSecret("123456"),
+		ExpiresAt:  1 << 40,
+	})
+	d.Require().NoError(err)
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil).AnyTimes()
+	d.mockStore.EXPECT().TOTPFactor(user.ID).Return(models.TOTPFactor{}, store.ErrNotFound).AnyTimes()
+	d.mockStore.EXPECT().WebAuthnCredentials(user.ID).Return(nil, nil).AnyTimes()
+
+	login := func(code string, n int) (succeeded int) {
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := d.domain.LoginWithCode(context.Background(), models.LoginCodeForm{Email: user.Email, Code: code})
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return succeeded
+	}
+
+	d.Require().Zero(login("000000", 3))
+	t, err := tokens.LastOneTimeToken(user.ID, models.PurposeLoginCode)
+	d.Require().NoError(err)
+	d.Require().Equal(3, t.Attempts, "every failed attempt is counted")
+
+	d.Require().Equal(1, login("123456", 10), "code can be used once")
+}
+
+func (d *DomainSuite) TestLoginWithCodeConflict() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", Roles: []string{models.RoleConsumer}}
+	t := models.OneTimeToken{
+		ID:         1,
+		ConsumerID: user.ID,
+		Purpose:    models.PurposeLoginCode,
+		Hash:       d.auth.HashSecret("123456"),
+		ExpiresAt:  1 << 40,
+		Version:    1,
+	}
+	used := t
+	used.UsedAt = 1
+	used.Version = 2
+
+	// concurrent request used the code after token was read
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	gomock.InOrder(
+		d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginCode).Return(t, nil),
+		d.mockStore.EXPECT().SaveOneTimeToken(gomock.Any()).Return(models.OneTimeToken{}, store.ErrConflict),
+		d.mockStore.EXPECT().OneTimeToken(t.ID).Return(used, nil),
+	)
+	_, err := d.domain.LoginWithCode(context.Background(), models.LoginCodeForm{Email: user.Email, Code: "123456"})
+
+	d.Require().Error(err)
+	d.Require().True(err.(consumer.Error).UserError())
+}
+
+func (d *DomainSuite) TestRequestLoginThrottled() {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Opts{
+		Account: ratelimit.Rule{Limit: 2, Window: time.Hour},
+		Lockout: time.Hour,
+	})
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{Limiter: limiter, Mailer: d.mockMailer})
+	form := models.LoginRequestForm{Email: "test@test.com", Method: models.LoginMethodCode}
+
+	d.mockStore.EXPECT().Consumer(form.Email).Return(models.Consumer{}, store.ErrNotFound).Times(2)
+	for i := 0; i < 2; i++ {
+		d.Require().NoError(service.RequestLogin(context.Background(), form))
+	}
+	err := service.RequestLogin(context.Background(), form)
+
+	d.Require().Equal(consumer.CodeAccountLocked, consumer.ErrorCode(err), "unknown email is throttled as well")
+}
+
+func (d *DomainSuite) TestLoginWithCodeThrottled() {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Opts{
+		IP:      ratelimit.Rule{Limit: 100, Window: time.Hour},
+		Account: ratelimit.Rule{Limit: 3, Window: time.Hour},
+		Lockout: time.Hour,
+	})
+	service := domain.NewService(d.mockStore, d.auth, domain.Opts{Limiter: limiter})
+	user := models.Consumer{ID: 1, Email: "test@test.com"}
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil).Times(3)
+	d.mockStore.EXPECT().LastOneTimeToken(user.ID, models.PurposeLoginCode).Return(models.OneTimeToken{}, store.ErrNotFound).Times(3)
+	form := models.LoginCodeForm{Email: user.Email, Code: "000000", Client: models.ClientInfo{IP: "10.0.0.1"}}
+
+	for i := 0; i < 3; i++ {
+		_, err := service.LoginWithCode(context.Background(), form)
+		d.Require().Error(err)
+	}
+	_, err := service.LoginWithCode(context.Background(), form)
+	d.Require().Equal(consumer.CodeAccountLocked, consumer.ErrorCode(err))
+
+	_, err = service.AuthenticateConsumer(context.Background(), models.AuthForm{Email: user.Email, Pass: "password"})
+	d.Require().Equal(consumer.CodeAccountLocked, consumer.ErrorCode(err), "failed codes lock password login too")
+}
@@ -0,0 +1,134 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+)
+
+// ActiveConsumer returns consumer by id, error is returned if his account isn't active
+func (s *Service) ActiveConsumer(ctx context.Context, id int) (models.Consumer, error) {
+	c, err := s.ConsumerByID(ctx, id)
+	if err != nil {
+		return models.Consumer{}, err
+	}
+	if err := checkStatus(c); err != nil {
+		return models.Consumer{}, err
+	}
+	return c, nil
+}
+
+// SuspendConsumer disables consumer's account by administrator
+func (s *Service) SuspendConsumer(ctx context.Context, actorID, consumerID int, form models.StatusChangeForm) (models.Consumer, error) {
+	return s.changeStatusByAdmin(ctx, actorID, consumerID, models.StatusSuspended, form)
+}
+
+// LockConsumer disables consumer's account for security reasons
+func (s *Service) LockConsumer(ctx context.Context, actorID, consumerID int, form models.StatusChangeForm) (models.Consumer, error) {
+	return s.changeStatusByAdmin(ctx, actorID, consumerID, models.StatusLocked, form)
+}
+
+// ReactivateConsumer enables suspended or locked consumer's account or cancels its deletion
+func (s *Service) ReactivateConsumer(ctx context.Context, actorID, consumerID int, form models.StatusChangeForm) (models.Consumer, error) {
+	if err := form.Validate(); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't change status", Err: err}
+	}
+	return s.changeStatus(ctx, actorID, consumerID, "", models.StatusActive, form.Reason, clearDeletion)
+}
+
+// StatusHistory returns consumer's status transitions ordered by time
+func (s *Service) StatusHistory(ctx context.Context, consumerID int) ([]models.StatusChange, error) {
+	if _, err := s.ConsumerByID(ctx, consumerID); err != nil {
+		return nil, err
+	}
+	h, err := s.storeFor(ctx).StatusChanges(consumerID)
+	if err != nil {
+		return nil, &domainError{Message: "can't get status history", Err: err}
+	}
+	return h, nil
+}
+
+func (s *Service) changeStatusByAdmin(ctx context.Context, actorID, consumerID int, to string, form models.StatusChangeForm) (models.Consumer, error) {
+	if err := form.Validate(); err != nil {
+		return models.Consumer{}, &domainError{IsUserError: true, Message: "can't change status", Err: err}
+	}
+	return s.changeStatus(ctx, actorID, consumerID, "", to, form.Reason, nil)
+}
+
+// changeStatus moves consumer's account to status to and records transition in history atomically.
+// If requiredFrom isn't empty, account must be in this status. If update isn't nil, it is applied
+// to consumer along with status. Update is retried if consumer was modified concurrently.
+func (s *Service) changeStatus(ctx context.Context, actorID, consumerID int, requiredFrom, to, reason string, update func(*models.Consumer)) (models.Consumer, error) {
+	for attempt := 0; ; attempt++ {
+		c, err := s.ConsumerByID(ctx, consumerID)
+		if err != nil {
+			return models.Consumer{}, err
+		}
+		from := c.EffectiveStatus()
+		if !models.CanTransition(from, to) || (requiredFrom != "" && from != requiredFrom) {
+			return models.Consumer{}, &domainError{
+				IsUserError: true,
+				Code:        consumer.CodeInvalidStatusTransition,
+				Message:     "can't change status from " + from + " to " + to,
+			}
+		}
+		c.Status = to
+		if update != nil {
+			update(&c)
+		}
+		err = s.storeFor(ctx).Atomic(func(tx store.DataStore) error {
+			var err error
+			if c, err = tx.UpdateConsumer(c); err != nil {
+				return &domainError{Message: "can't change status", Err: err}
+			}
+			err = tx.SaveStatusChange(models.StatusChange{
+				ConsumerID: consumerID,
+				From:       from,
+				To:         to,
+				Reason:     reason,
+				ActorID:    actorID,
+				CreatedAt:  time.Now().Unix(),
+			})
+			if err != nil {
+				return &domainError{Message: "can't save status change", Err: err}
+			}
+			return nil
+		})
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return models.Consumer{}, err
+		}
+		s.introspection.forget(consumerID)
+		s.audit(ctx, models.AuditEvent{
+			Type:       models.AuditStatusChanged,
+			ConsumerID: consumerID,
+			ActorID:    actorID,
+			Details:    map[string]string{"from": from, "to": to},
+		})
+		return c, nil
+	}
+}
+
+// checkStatus returns error with code describing why consumer's account can't be used
+func checkStatus(c models.Consumer) error {
+	switch c.EffectiveStatus() {
+	case models.StatusActive:
+		return nil
+	case models.StatusSuspended:
+		return &domainError{IsUserError: true, Code: consumer.CodeAccountSuspended, Message: "account is suspended"}
+	case models.StatusLocked:
+		return &domainError{IsUserError: true, Code: consumer.CodeAccountSecurityLocked, Message: "account is locked for security reasons"}
+	case models.StatusPendingDeletion:
+		return &domainError{IsUserError: true, Code: consumer.CodeAccountPendingDeletion, Message: "account is scheduled for deletion"}
+	case models.StatusDeleted:
+		return &domainError{IsUserError: true, Code: consumer.CodeNotFound, Message: "user not found"}
+	default:
+		return &domainError{Message: "unknown account status " + c.Status}
+	}
+}
@@ -0,0 +1,110 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestAuthenticateConsumerInactiveAccount() {
+	tests := map[string]string{
+		models.StatusSuspended:       consumer.CodeAccountSuspended,
+		models.StatusLocked:          consumer.CodeAccountSecurityLocked,
+		models.StatusPendingDeletion: consumer.CodeAccountPendingDeletion,
+	}
+	for status, code := range tests {
+		user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Status: status}
+		d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+		res, err := d.domain.AuthenticateConsumer(context.Background(), models.AuthForm{Email: user.Email, Pass: "password"})
+
+		d.Require().Error(err, status)
+		d.Require().Empty(res.Token, status)
+		d.Require().Equal(code, consumer.ErrorCode(err), status)
+	}
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerInactiveAccountWrongPassword() {
+	user := models.Consumer{ID: 1, Email: "test@test.com", PassHash: "test@test.compassword", Status: models.StatusSuspended}
+	d.mockStore.EXPECT().Consumer(user.Email).Return(user, nil)
+	_, err := d.domain.AuthenticateConsumer(context.Background(), models.AuthForm{Email: user.Email, Pass: "wrong"})
+
+	d.Require().Equal(consumer.CodeInvalidCredentials, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestValidateTokenSuspendedAccount() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{ID: 1, Status: models.StatusSuspended}, nil)
+	err := d.domain.ValidateToken(context.Background(), validToken)
+
+	d.Require().Equal(consumer.CodeAccountSuspended, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestSuspendAndReactivateConsumer() {
+	c := models.Consumer{ID: 2, Version: 1, Status: models.StatusActive}
+	var changes []models.StatusChange
+	d.mockStore.EXPECT().ConsumerByID(2).DoAndReturn(func(int) (models.Consumer, error) { return c, nil }).Times(2)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(u models.Consumer) (models.Consumer, error) {
+		u.Version++
+		c = u
+		return u, nil
+	}).Times(2)
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).DoAndReturn(func(ch models.StatusChange) error {
+		changes = append(changes, ch)
+		return nil
+	}).Times(2)
+
+	suspended, err := d.domain.SuspendConsumer(context.Background(), 1, 2, models.StatusChangeForm{Reason: "spam"})
+	d.Require().NoError(err)
+	d.Require().Equal(models.StatusSuspended, suspended.Status)
+
+	active, err := d.domain.ReactivateConsumer(context.Background(), 1, 2, models.StatusChangeForm{Reason: "appeal"})
+	d.Require().NoError(err)
+	d.Require().Equal(models.StatusActive, active.Status)
+	d.Require().Equal(3, active.Version)
+
+	d.Require().Len(changes, 2)
+	d.Require().Equal(models.StatusActive, changes[0].From)
+	d.Require().Equal(models.StatusSuspended, changes[0].To)
+	d.Require().Equal("spam", changes[0].Reason)
+	d.Require().Equal(1, changes[0].ActorID)
+	d.Require().Equal(models.StatusSuspended, changes[1].From)
+	d.Require().Equal(models.StatusActive, changes[1].To)
+}
+
+func (d *DomainSuite) TestChangeStatusHistoryFailure() {
+	d.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2, Status: models.StatusActive}, nil)
+	d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(u models.Consumer) (models.Consumer, error) {
+		return u, nil
+	})
+	d.mockStore.EXPECT().SaveStatusChange(gomock.Any()).Return(errors.New("disk is full"))
+	_, err := d.domain.SuspendConsumer(context.Background(), 1, 2, models.StatusChangeForm{Reason: "spam"})
+
+	d.Require().Error(err)
+	d.Require().Empty(d.audit, "status change isn't audited if it is rolled back")
+}
+
+func (d *DomainSuite) TestChangeStatusInvalidTransition() {
+	d.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{ID: 2, Status: models.StatusPendingDeletion}, nil)
+	_, err := d.domain.SuspendConsumer(context.Background(), 1, 2, models.StatusChangeForm{Reason: "spam"})
+
+	d.Require().Equal(consumer.CodeInvalidStatusTransition, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestChangeStatusReasonRequired() {
+	_, err := d.domain.LockConsumer(context.Background(), 1, 2, models.StatusChangeForm{Reason: " "})
+
+	var uErr consumer.Error
+	d.Require().True(errors.As(err, &uErr))
+	d.Require().True(uErr.UserError())
+}
+
+func (d *DomainSuite) TestStatusHistoryNotFound() {
+	d.mockStore.EXPECT().ConsumerByID(2).Return(models.Consumer{}, store.ErrNotFound)
+	_, err := d.domain.StatusHistory(context.Background(), 2)
+
+	d.Require().Equal(consumer.CodeNotFound, consumer.ErrorCode(err))
+}
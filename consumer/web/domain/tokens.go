@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.uber.org/zap"
+)
+
+// RefreshToken exchanges valid access token for a new one with the same scopes, scopes which
+// the consumer's roles don't allow anymore are dropped. The old token stays valid until it expires.
+// New token keeps authentication time of the old one, so refresh doesn't count as recent login.
+// Tokens issued to OAuth clients aren't accepted, clients refresh them with refresh token at token endpoint.
+func (s *Service) RefreshToken(ctx context.Context, t string) (string, error) {
+	claims, c, err := s.accessToken(ctx, t)
+	if err != nil {
+		return "", err
+	}
+	if claims.ClientID != "" {
+		return "", &domainError{IsUserError: true, Code: consumer.CodeInvalidToken, Message: "token issued to oauth client can't be refreshed"}
+	}
+	scopes, err := s.auth.GrantScopes(c.Roles, claims.Scopes())
+	if err != nil {
+		return "", &domainError{IsUserError: true, Message: "can't refresh token", Err: err}
+	}
+	return s.signToken(ctx, token.SubjectAccess, "", c.ID, c.Roles, scopes, claims.AuthenticatedAt(), s.accessTokenTTL())
+}
+
+// RevokeTokens revokes all tokens issued to consumer, he must log in again afterwards
+func (s *Service) RevokeTokens(ctx context.Context, consumerID int) error {
+	for attempt := 0; ; attempt++ {
+		c, err := s.ConsumerByID(ctx, consumerID)
+		if err != nil {
+			return err
+		}
+		c.TokensRevokedAt = time.Now().Unix()
+		_, err = s.storeFor(ctx).UpdateConsumer(c)
+		if errors.Is(err, store.ErrConflict) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return &domainError{Message: "can't revoke tokens", Err: err}
+		}
+		break
+	}
+	s.introspection.forget(consumerID)
+	s.audit(ctx, models.AuditEvent{Type: models.AuditTokensRevoked, ConsumerID: consumerID, Details: map[string]string{"reason": "consumer_request"}})
+	s.log(ctx).Info("tokens revoked", zap.Int("consumer_id", consumerID))
+	return nil
+}
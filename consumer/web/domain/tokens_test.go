@@ -0,0 +1,102 @@
+package domain_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestRefreshToken() {
+	// admin role was taken away after the token was issued
+	d.mockStore.EXPECT().ConsumerByID(1).
+		Return(models.Consumer{ID: 1, Roles: []string{models.RoleConsumer}, Status: models.StatusActive}, nil)
+	old, err := d.auth.CreateJWT(token.Claims{
+		ID:    1,
+		Roles: []string{models.RoleAdmin},
+		Scope: token.ScopeProfileRead + " " + token.ScopeAdmin,
+		StandardClaims: &jwt.StandardClaims{
+			Subject:   token.SubjectAccess,
+			IssuedAt:  time.Now().Add(-time.Minute).Unix(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	})
+	d.Require().NoError(err)
+
+	t, err := d.domain.RefreshToken(context.Background(), old)
+
+	d.Require().NoError(err)
+	claims, err := d.auth.ParseToken(t)
+	d.Require().NoError(err)
+	d.Require().Equal(token.ScopeProfileRead, claims.Scope)
+	d.Require().Equal([]string{models.RoleConsumer}, claims.Roles)
+	d.Require().True(claims.ExpiresAt > time.Now().Add(time.Hour).Unix())
+	d.Require().Equal(models.AuditTokenIssued, d.audit[len(d.audit)-1].Type)
+}
+
+func (d *DomainSuite) TestRefreshTokenExpired() {
+	_, err := d.domain.RefreshToken(context.Background(), expiredToken)
+
+	d.Require().Equal(consumer.CodeTokenExpired, consumer.ErrorCode(err))
+	d.Require().True(err.(consumer.Error).UserError())
+}
+
+func (d *DomainSuite) TestRefreshTokenNotAccessToken() {
+	t, err := d.auth.CreateJWT(token.Claims{
+		ID:             1,
+		StandardClaims: &jwt.StandardClaims{Subject: token.SubjectMFAPending, ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	})
+	d.Require().NoError(err)
+
+	_, err = d.domain.RefreshToken(context.Background(), t)
+
+	d.Require().Equal(consumer.CodeInvalidToken, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestRefreshTokenOfOAuthClient() {
+	d.expectActive(1)
+	t, err := d.auth.CreateJWT(token.Claims{
+		ID:             1,
+		Scope:          token.ScopeProfileRead,
+		ClientID:       "web",
+		StandardClaims: &jwt.StandardClaims{Subject: token.SubjectAccess, ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	})
+	d.Require().NoError(err)
+
+	_, err = d.domain.RefreshToken(context.Background(), t)
+
+	d.Require().Equal(consumer.CodeInvalidToken, consumer.ErrorCode(err))
+}
+
+func (d *DomainSuite) TestRevokeTokens() {
+	user := models.Consumer{ID: 1, Status: models.StatusActive, Version: 1}
+	d.mockStore.EXPECT().ConsumerByID(1).Return(user, nil).Times(2)
+	var saved models.Consumer
+	gomock.InOrder(
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).Return(models.Consumer{}, store.ErrConflict),
+		d.mockStore.EXPECT().UpdateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+			saved = c
+			return c, nil
+		}),
+	)
+
+	d.Require().NoError(d.domain.RevokeTokens(context.Background(), 1))
+
+	d.Require().InDelta(time.Now().Unix(), saved.TokensRevokedAt, 1)
+	d.Require().Equal(models.AuditTokensRevoked, d.audit[len(d.audit)-1].Type)
+	d.Require().Equal("consumer_request", d.audit[len(d.audit)-1].Details["reason"])
+}
+
+func (d *DomainSuite) TestRevokeTokensNotFound() {
+	d.mockStore.EXPECT().ConsumerByID(1).Return(models.Consumer{}, store.ErrNotFound)
+
+	err := d.domain.RevokeTokens(context.Background(), 1)
+
+	d.Require().Equal(consumer.CodeNotFound, consumer.ErrorCode(err))
+}
@@ -0,0 +1,147 @@
+package domain_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+
+	"github.com/golang/mock/gomock"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// tracedService returns service with traced store whose spans are recorded
+func (d *DomainSuite) tracedService(opts domain.Opts) (*domain.Service, *tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	opts.TracerProvider = tp
+	return domain.NewService(tracing.Store(tp, d.mockStore), d.auth, opts), rec, tp
+}
+
+// spanParents returns parent span name by span name, root spans have empty parent
+func spanParents(spans []sdktrace.ReadOnlySpan) map[string]string {
+	names := make(map[string]string, len(spans))
+	for _, s := range spans {
+		names[s.SpanContext().SpanID().String()] = s.Name()
+	}
+	parents := make(map[string]string, len(spans))
+	for _, s := range spans {
+		parents[s.Name()] = names[s.Parent().SpanID().String()]
+	}
+	return parents
+}
+
+func (d *DomainSuite) TestCreateConsumerSpans() {
+	service, rec, tp := d.tracedService(domain.Opts{Mailer: d.mockMailer, EnumerationSafeRegistration: true})
+	reg := models.RegFrom{Email: "test@test.com", Pass1: "1234", Pass2: "1234"}
+	var msg mailer.Message
+
+	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(false, nil)
+	d.mockStore.EXPECT().CreateConsumer(gomock.Any()).DoAndReturn(func(c models.Consumer) (models.Consumer, error) {
+		c.ID = 1
+		return c, nil
+	})
+	d.mockMailer.EXPECT().Send(gomock.Any()).DoAndReturn(func(m mailer.Message) error {
+		msg = m
+		return nil
+	})
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "POST /v1/consumers")
+	_, err := service.CreateConsumer(ctx, reg)
+	parent.End()
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.RunMailer(done)
+
+	d.Require().NoError(err)
+	d.Require().Equal(map[string]string{
+		"POST /v1/consumers":     "",
+		"domain.CreateConsumer":  "POST /v1/consumers",
+		"store.ConsumerIsExist":  "domain.CreateConsumer",
+		"store.Atomic":           "domain.CreateConsumer",
+		"password.hash":          "domain.CreateConsumer",
+		"store.CreateConsumer":   "store.Atomic",
+		"store.AddOutboxMessage": "store.Atomic",
+		"store.AppendAuditEvent": "domain.CreateConsumer",
+	}, spanParents(rec.Ended()))
+	traceID := parent.SpanContext().TraceID().String()
+	d.Require().Contains(msg.Headers["traceparent"], "00-"+traceID+"-")
+}
+
+func (d *DomainSuite) TestCreateExistingConsumerSpans() {
+	service, rec, _ := d.tracedService(domain.Opts{Mailer: d.mockMailer, EnumerationSafeRegistration: true})
+	reg := models.RegFrom{Email: "test@test.com", Pass1: "1234", Pass2: "1234"}
+
+	d.mockStore.EXPECT().ConsumerIsExist(reg.Email).Return(true, nil)
+	_, err := service.CreateConsumer(context.Background(), reg)
+
+	d.Require().NoError(err)
+	d.Require().Equal(map[string]string{
+		"domain.CreateConsumer": "",
+		"store.ConsumerIsExist": "domain.CreateConsumer",
+		"password.hash":         "domain.CreateConsumer",
+	}, spanParents(rec.Ended()))
+}
+
+func (d *DomainSuite) TestAuthenticateConsumerSpans() {
+	service, rec, _ := d.tracedService(domain.Opts{})
+	form := models.AuthForm{Email: "test@test.com", Pass: "password"}
+
+	d.mockStore.EXPECT().Consumer(form.Email).Return(models.Consumer{}, store.ErrNotFound)
+	_, err := service.AuthenticateConsumer(context.Background(), form)
+	d.Require().Error(err)
+	d.Require().Error(service.ValidateToken(context.Background(), invalidToken))
+
+	spans := rec.Ended()
+	d.Require().Equal(map[string]string{
+		"store.Consumer":              "domain.AuthenticateConsumer",
+		"password.verify":             "domain.AuthenticateConsumer",
+		"store.AppendAuditEvent":      "domain.AuthenticateConsumer",
+		"domain.AuthenticateConsumer": "",
+		"domain.ValidateToken":        "",
+	}, spanParents(spans))
+	for _, s := range spans {
+		switch s.Name() {
+		case "domain.AuthenticateConsumer", "domain.ValidateToken":
+			d.Require().Equal("Error", s.Status().Code.String(), s.Name())
+		default:
+			d.Require().Equal("Unset", s.Status().Code.String(), s.Name())
+		}
+	}
+}
+
+func (d *DomainSuite) TestValidateTokenSpans() {
+	service, rec, _ := d.tracedService(domain.Opts{})
+	d.expectActive(1)
+
+	d.Require().NoError(service.ValidateToken(context.Background(), d.accessToken(1, time.Now())))
+
+	d.Require().Equal(map[string]string{
+		"domain.ValidateToken": "",
+		"store.ConsumerByID":   "domain.ValidateToken",
+	}, spanParents(rec.Ended()))
+}
+
+func (d *DomainSuite) TestBackgroundWorkSpans() {
+	service, rec, _ := d.tracedService(domain.Opts{})
+	d.mockStore.EXPECT().Webhooks().Return(nil, nil)
+	d.mockStore.EXPECT().PendingOutboxMessages(gomock.Any()).Return(nil, nil)
+	d.mockStore.EXPECT().DueWebhookDeliveries(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	_, err := service.PublishOutbox(context.Background())
+	d.Require().NoError(err)
+	_, err = service.DeliverWebhooks(context.Background(), time.Now())
+	d.Require().NoError(err)
+
+	d.Require().Equal(map[string]string{
+		"domain.PublishOutbox":        "",
+		"store.Webhooks":              "domain.PublishOutbox",
+		"store.PendingOutboxMessages": "domain.PublishOutbox",
+		"domain.DeliverWebhooks":      "",
+		"store.DueWebhookDeliveries":  "domain.DeliverWebhooks",
+	}, spanParents(rec.Ended()))
+}
@@ -0,0 +1,266 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/auth/webauthn"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// errPasskeyNotRegistered doesn't reveal whether consumer exists
+var errPasskeyNotRegistered = &domainError{
+	IsUserError: true,
+	Code:        consumer.CodeInvalidCredentials,
+	Message:     "passkey is not registered",
+}
+
+// BeginPasskeyRegistration starts registration of passkey of access token owner, he must have logged in recently.
+// Returned options must be passed to navigator.credentials.create and session must be sent back
+// with its result to FinishPasskeyRegistration.
+func (s *Service) BeginPasskeyRegistration(ctx context.Context, accessToken string) (webauthn.CreationOptions, string, error) {
+	claims, c, err := s.accessToken(ctx, accessToken)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+	if err := recentAuth(claims); err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+	creds, err := s.storeFor(ctx).WebAuthnCredentials(c.ID)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", &domainError{Message: "can't get passkeys", Err: err}
+	}
+	challenge, session, err := s.newCeremony(ctx, token.SubjectWebAuthnRegistration, c.ID, nil, nil)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+	user := webauthn.User{ID: userHandle(c.ID), Name: c.Email, DisplayName: c.Email}
+	return s.auth.WebAuthn.CreationOptions(challenge, user, credentialIDs(creds)), session, nil
+}
+
+// FinishPasskeyRegistration verifies result of navigator.credentials.create and stores passkey of access token owner
+func (s *Service) FinishPasskeyRegistration(ctx context.Context, accessToken, session string, resp webauthn.AttestationResponse) error {
+	tokenClaims, c, err := s.accessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if err := recentAuth(tokenClaims); err != nil {
+		return err
+	}
+	claims, challenge, err := s.parseCeremony(ctx, session, token.SubjectWebAuthnRegistration)
+	if err != nil {
+		return err
+	}
+	if c.ID != claims.ID {
+		return &domainError{IsUserError: true, Message: "session belongs to another user"}
+	}
+	_, err = s.storeFor(ctx).WebAuthnCredential(resp.ID)
+	if err == nil {
+		return &domainError{IsUserError: true, Message: "passkey is already registered"}
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return &domainError{Message: "can't get passkey", Err: err}
+	}
+	cred, err := s.auth.WebAuthn.VerifyRegistration(challenge, resp)
+	if err != nil {
+		return &domainError{IsUserError: true, Message: "can't register passkey", Err: err}
+	}
+	err = s.storeFor(ctx).SaveWebAuthnCredential(models.WebAuthnCredential{
+		ID:         cred.ID,
+		ConsumerID: c.ID,
+		PublicKey:  cred.PublicKey,
+		SignCount:  cred.SignCount,
+		AAGUID:     cred.AAGUID,
+		CreatedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		return &domainError{Message: "can't save passkey", Err: err}
+	}
+	s.auditCredentialChanged(ctx, c.ID, "passkey_added")
+	return nil
+}
+
+// BeginPasskeyLogin starts passwordless authentication. Returned options must be passed
+// to navigator.credentials.get and session must be sent back with its result to FinishPasskeyLogin.
+func (s *Service) BeginPasskeyLogin(ctx context.Context, form models.PasskeyLoginForm) (webauthn.RequestOptions, string, error) {
+	if err := form.Validate(); err != nil {
+		return webauthn.RequestOptions{}, "", &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	c, err := s.storeFor(ctx).Consumer(form.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		return webauthn.RequestOptions{}, "", errPasskeyNotRegistered
+	}
+	if err != nil {
+		return webauthn.RequestOptions{}, "", &domainError{Message: "can't get user", Err: err}
+	}
+	scopes, err := s.auth.GrantScopes(c.Roles, form.Scopes)
+	if err != nil {
+		return webauthn.RequestOptions{}, "", &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	return s.beginPasskeyAssertion(ctx, c.ID, c.Roles, scopes)
+}
+
+// BeginPasskeyMFA starts authentication with passkey as second factor for token returned by AuthenticateConsumer
+// or Authorize. Ceremony is finished by FinishPasskeyLogin or AuthorizePasskey respectively.
+func (s *Service) BeginPasskeyMFA(ctx context.Context, mfaToken string) (webauthn.RequestOptions, string, error) {
+	claims, err := s.auth.ParseToken(mfaToken)
+	if err != nil {
+		return webauthn.RequestOptions{}, "", err
+	}
+	if err := claims.Valid(); err != nil {
+		return webauthn.RequestOptions{}, "", &domainError{IsUserError: true, Message: "invalid token", Err: err}
+	}
+	if claims.Subject != token.SubjectMFAPending {
+		return webauthn.RequestOptions{}, "", &domainError{IsUserError: true, Message: "token is not an mfa pending token"}
+	}
+	return s.beginPasskeyAssertion(ctx, claims.ID, claims.Roles, claims.Scopes())
+}
+
+// FinishPasskeyLogin verifies result of navigator.credentials.get and returns access token
+func (s *Service) FinishPasskeyLogin(ctx context.Context, session string, resp webauthn.AssertionResponse) (string, error) {
+	claims, err := s.verifyPasskey(ctx, session, resp, models.ClientInfo{})
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.ActiveConsumer(ctx, claims.ID); err != nil {
+		return "", err
+	}
+	s.auditLoginSucceeded(ctx, claims.ID, methodPasskey, models.ClientInfo{})
+	return s.createToken(ctx, token.SubjectAccess, claims.ID, claims.Roles, claims.Scopes(), s.accessTokenTTL())
+}
+
+// verifyPasskey verifies assertion of passkey ceremony session and returns claims of the session.
+// Sign count of passkey is updated, so the assertion can't be replayed.
+func (s *Service) verifyPasskey(ctx context.Context, session string, resp webauthn.AssertionResponse, client models.ClientInfo) (token.Claims, error) {
+	claims, challenge, err := s.parseCeremony(ctx, session, token.SubjectWebAuthnLogin)
+	if err != nil {
+		return token.Claims{}, err
+	}
+	cred, err := s.storeFor(ctx).WebAuthnCredential(resp.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return token.Claims{}, &domainError{IsUserError: true, Message: "unknown passkey"}
+	}
+	if err != nil {
+		return token.Claims{}, &domainError{Message: "can't get passkey", Err: err}
+	}
+	if cred.ConsumerID != claims.ID {
+		return token.Claims{}, &domainError{IsUserError: true, Message: "passkey belongs to another user"}
+	}
+	if h := resp.Response.UserHandle; len(h) != 0 && !bytes.Equal(h, userHandle(claims.ID)) {
+		return token.Claims{}, &domainError{IsUserError: true, Message: "user handle doesn't match"}
+	}
+	count, err := s.auth.WebAuthn.VerifyAssertion(challenge, cred.PublicKey, cred.SignCount, resp)
+	if err != nil {
+		s.auditLoginFailed(ctx, claims.ID, "", client, "invalid_passkey_assertion")
+		return token.Claims{}, &domainError{IsUserError: true, Message: "can't authenticate user", Err: err}
+	}
+	cred.SignCount = count
+	cred.LastUsedAt = time.Now().Unix()
+	if err := s.storeFor(ctx).SaveWebAuthnCredential(cred); err != nil {
+		return token.Claims{}, &domainError{Message: "can't save passkey", Err: err}
+	}
+	return claims, nil
+}
+
+func (s *Service) beginPasskeyAssertion(ctx context.Context, id int, roles, scopes []string) (webauthn.RequestOptions, string, error) {
+	creds, err := s.storeFor(ctx).WebAuthnCredentials(id)
+	if err != nil {
+		return webauthn.RequestOptions{}, "", &domainError{Message: "can't get passkeys", Err: err}
+	}
+	if len(creds) == 0 {
+		return webauthn.RequestOptions{}, "", errPasskeyNotRegistered
+	}
+	challenge, session, err := s.newCeremony(ctx, token.SubjectWebAuthnLogin, id, roles, scopes)
+	if err != nil {
+		return webauthn.RequestOptions{}, "", err
+	}
+	return s.auth.WebAuthn.RequestOptions(challenge, credentialIDs(creds)), session, nil
+}
+
+// newCeremony returns challenge and signed session which carries it. Challenge is stored
+// until the ceremony is finished, so session can't be replayed.
+func (s *Service) newCeremony(ctx context.Context, subject string, id int, roles, scopes []string) ([]byte, string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, "", &domainError{Message: "can't generate challenge", Err: err}
+	}
+	now := time.Now()
+	err = s.storeFor(ctx).SaveWebAuthnChallenge(models.WebAuthnChallenge{
+		Challenge:  base64.RawURLEncoding.EncodeToString(challenge),
+		ConsumerID: id,
+		ExpiresAt:  now.Add(ceremonyTTL).Unix(),
+	})
+	if err != nil {
+		return nil, "", &domainError{Message: "can't save challenge", Err: err}
+	}
+	session, err := s.auth.CreateJWT(token.Claims{
+		ID:    id,
+		Roles: roles,
+		Scope: strings.Join(scopes, " "),
+		StandardClaims: &jwt.StandardClaims{
+			Id:        base64.RawURLEncoding.EncodeToString(challenge),
+			Subject:   subject,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ceremonyTTL).Unix(),
+			Issuer:    issuer,
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return challenge, session, nil
+}
+
+// parseCeremony returns claims and challenge of session, stored challenge is deleted so session can be used once
+func (s *Service) parseCeremony(ctx context.Context, session, subject string) (token.Claims, []byte, error) {
+	claims, err := s.auth.ParseToken(session)
+	if err != nil {
+		return token.Claims{}, nil, err
+	}
+	if err := claims.Valid(); err != nil {
+		return token.Claims{}, nil, &domainError{IsUserError: true, Message: "invalid session", Err: err}
+	}
+	if claims.Subject != subject {
+		return token.Claims{}, nil, &domainError{IsUserError: true, Message: "invalid session"}
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(claims.Id)
+	if err != nil || len(challenge) == 0 {
+		return token.Claims{}, nil, &domainError{IsUserError: true, Message: "invalid session"}
+	}
+	stored, err := s.storeFor(ctx).TakeWebAuthnChallenge(claims.Id)
+	if errors.Is(err, store.ErrNotFound) {
+		return token.Claims{}, nil, &domainError{IsUserError: true, Message: "session is already used or expired"}
+	}
+	if err != nil {
+		return token.Claims{}, nil, &domainError{Message: "can't get challenge", Err: err}
+	}
+	if stored.ConsumerID != claims.ID || stored.ExpiresAt <= time.Now().Unix() {
+		return token.Claims{}, nil, &domainError{IsUserError: true, Message: "session is already used or expired"}
+	}
+	return claims, challenge, nil
+}
+
+// userHandle returns WebAuthn user handle of consumer which doesn't contain personal data
+func userHandle(id int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+func credentialIDs(creds []models.WebAuthnCredential) [][]byte {
+	ids := make([][]byte, 0, len(creds))
+	for _, c := range creds {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
@@ -0,0 +1,344 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/events"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+	"github.com/nsmak/consumerService/consumer/web/webhook"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookSecretSize  = 32
+	webhookBatchSize   = 100
+	webhookMaxTries    = 8
+	webhookRetryBase   = time.Minute
+	webhookRetryMax    = time.Hour
+	maxWebhookFailures = 20 // webhook is disabled after this count of consecutive failed attempts
+	webhookConcurrency = 10 // count of webhooks which deliveries are attempted in parallel
+)
+
+var (
+	errWebhookNotFound  = &domainError{IsUserError: true, Code: consumer.CodeNotFound, Message: "webhook not found"}
+	errDeliveryNotFound = &domainError{IsUserError: true, Code: consumer.CodeNotFound, Message: "delivery not found"}
+)
+
+// CreateWebhook subscribes url to events. Secret of deliveries signature is returned only here.
+func (s *Service) CreateWebhook(ctx context.Context, form models.WebhookForm) (models.Webhook, error) {
+	if err := form.Validate(); err != nil {
+		return models.Webhook{}, &domainError{IsUserError: true, Message: "can't create webhook", Err: err}
+	}
+	secret := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return models.Webhook{}, &domainError{Message: "can't generate webhook secret", Err: err}
+	}
+	w, err := s.storeFor(ctx).SaveWebhook(models.Webhook{
+		URL:       form.URL,
+		Secret:    hex.EncodeToString(secret),
+		Events:    form.Events,
+		Enabled:   form.Enabled == nil || *form.Enabled,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return models.Webhook{}, &domainError{Message: "can't save webhook", Err: err}
+	}
+	return w, nil
+}
+
+// Webhooks returns all webhooks
+func (s *Service) Webhooks(ctx context.Context) ([]models.Webhook, error) {
+	hooks, err := s.storeFor(ctx).Webhooks()
+	if err != nil {
+		return nil, &domainError{Message: "can't get webhooks", Err: err}
+	}
+	return hooks, nil
+}
+
+// Webhook returns webhook by id
+func (s *Service) Webhook(ctx context.Context, id int) (models.Webhook, error) {
+	w, err := s.storeFor(ctx).Webhook(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.Webhook{}, errWebhookNotFound
+	}
+	if err != nil {
+		return models.Webhook{}, &domainError{Message: "can't get webhook", Err: err}
+	}
+	return w, nil
+}
+
+// UpdateWebhook replaces webhook's url and events. Enabling webhook resets its failures counter.
+func (s *Service) UpdateWebhook(ctx context.Context, id int, form models.WebhookForm) (models.Webhook, error) {
+	if err := form.Validate(); err != nil {
+		return models.Webhook{}, &domainError{IsUserError: true, Message: "can't update webhook", Err: err}
+	}
+	w, err := s.Webhook(ctx, id)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	w.URL, w.Events = form.URL, form.Events
+	if form.Enabled != nil {
+		if *form.Enabled && !w.Enabled {
+			w.ConsecutiveFailures, w.DisabledAt = 0, 0
+		}
+		w.Enabled = *form.Enabled
+	}
+	if w, err = s.storeFor(ctx).SaveWebhook(w); err != nil {
+		return models.Webhook{}, &domainError{Message: "can't save webhook", Err: err}
+	}
+	return w, nil
+}
+
+// DeleteWebhook deletes webhook with its delivery log
+func (s *Service) DeleteWebhook(ctx context.Context, id int) error {
+	err := s.storeFor(ctx).DeleteWebhook(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return errWebhookNotFound
+	}
+	if err != nil {
+		return &domainError{Message: "can't delete webhook", Err: err}
+	}
+	return nil
+}
+
+// WebhookDeliveries returns page of webhook's delivery log
+func (s *Service) WebhookDeliveries(ctx context.Context, webhookID int, page models.PageRequest) (models.WebhookDeliveryPage, error) {
+	if err := page.Validate(); err != nil {
+		return models.WebhookDeliveryPage{}, &domainError{IsUserError: true, Message: "can't get deliveries", Err: err}
+	}
+	if _, err := s.Webhook(ctx, webhookID); err != nil {
+		return models.WebhookDeliveryPage{}, err
+	}
+	p, err := s.storeFor(ctx).WebhookDeliveries(webhookID, page)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		return models.WebhookDeliveryPage{}, &domainError{IsUserError: true, Message: "can't get deliveries", Err: err}
+	}
+	if err != nil {
+		return models.WebhookDeliveryPage{}, &domainError{Message: "can't get deliveries", Err: err}
+	}
+	return p, nil
+}
+
+// ReplayWebhookDelivery schedules delivery of webhook's message once more, attempts log is kept
+func (s *Service) ReplayWebhookDelivery(ctx context.Context, webhookID, deliveryID int) (models.WebhookDelivery, error) {
+	d, err := s.storeFor(ctx).WebhookDelivery(deliveryID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && d.WebhookID != webhookID) {
+		return models.WebhookDelivery{}, errDeliveryNotFound
+	}
+	if err != nil {
+		return models.WebhookDelivery{}, &domainError{Message: "can't get delivery", Err: err}
+	}
+	d.Status, d.Tries, d.NextAttemptAt = models.DeliveryPending, 0, time.Now().Unix()
+	if err := s.storeFor(ctx).SaveWebhookDelivery(d); err != nil {
+		return models.WebhookDelivery{}, &domainError{Message: "can't save delivery", Err: err}
+	}
+	return d, nil
+}
+
+// enqueueWebhooks queues delivery of message to subscribed webhooks, message already queued for webhook is skipped
+func (s *Service) enqueueWebhooks(ctx context.Context, hooks []models.Webhook, m models.OutboxMessage) error {
+	var body []byte
+	for _, w := range hooks {
+		if !w.Enabled || !w.Subscribed(m.Type) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = events.Encode(m); err != nil {
+				return &domainError{Message: "can't encode event", Err: err}
+			}
+		}
+		now := time.Now().Unix()
+		_, err := s.storeFor(ctx).CreateWebhookDelivery(models.WebhookDelivery{
+			WebhookID:     w.ID,
+			MessageID:     m.ID,
+			EventType:     m.Type,
+			Payload:       body,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil && !errors.Is(err, store.ErrAlreadyExists) {
+			return &domainError{Message: "can't save delivery", Err: err}
+		}
+	}
+	return nil
+}
+
+// DeliverWebhooks makes attempts of deliveries due at now and returns count of successful ones.
+// Failed deliveries are retried with exponential backoff, webhook is disabled after many consecutive failures.
+func (s *Service) DeliverWebhooks(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, span := s.tracer().Start(ctx, "domain.DeliverWebhooks")
+	defer func() { tracing.End(span, err) }()
+
+	delivered := 0
+	for {
+		batch, err := s.storeFor(ctx).DueWebhookDeliveries(now.Unix(), webhookBatchSize)
+		if err != nil {
+			return delivered, &domainError{Message: "can't get deliveries", Err: err}
+		}
+		n, err := s.deliverBatch(ctx, batch)
+		delivered += n
+		if err != nil {
+			return delivered, err
+		}
+		if len(batch) < webhookBatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// deliverBatch makes attempts of deliveries to different webhooks in parallel, so slow or unreachable endpoint
+// delays only its own deliveries. Deliveries to the same webhook are attempted one by one in order.
+func (s *Service) deliverBatch(ctx context.Context, batch []models.WebhookDelivery) (int, error) {
+	var order []int
+	byWebhook := make(map[int][]models.WebhookDelivery)
+	for _, d := range batch {
+		if _, ok := byWebhook[d.WebhookID]; !ok {
+			order = append(order, d.WebhookID)
+		}
+		byWebhook[d.WebhookID] = append(byWebhook[d.WebhookID], d)
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		delivered int
+		firstErr  error
+	)
+	sem := make(chan struct{}, webhookConcurrency)
+	for _, id := range order {
+		deliveries := byWebhook[id]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			for _, d := range deliveries {
+				ok, err := s.deliver(ctx, d)
+				mu.Lock()
+				if ok {
+					delivered++
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return delivered, firstErr
+}
+
+// RunWebhookDispatcher calls DeliverWebhooks every interval until ctx is done
+func (s *Service) RunWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := s.DeliverWebhooks(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			s.Logger.Error("can't deliver webhooks", zap.Error(err))
+		} else if n != 0 {
+			s.Logger.Debug("webhooks delivered", zap.Int("deliveries", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// deliver makes single attempt of delivery and saves its result
+func (s *Service) deliver(ctx context.Context, d models.WebhookDelivery) (bool, error) {
+	w, err := s.storeFor(ctx).Webhook(d.WebhookID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return false, &domainError{Message: "can't get webhook", Err: err}
+	}
+	if err != nil || !w.Enabled {
+		d.Status, d.NextAttemptAt = models.DeliveryFailed, 0
+		d.Attempts = append(d.Attempts, models.WebhookAttempt{At: time.Now().Unix(), Error: "webhook is disabled"})
+		return false, s.saveDelivery(ctx, d)
+	}
+
+	start := time.Now()
+	ctx, span := s.tracer().Start(ctx, "webhook.deliver", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.Int("webhook.id", w.ID),
+		attribute.Int("webhook.delivery_id", d.ID),
+		attribute.String("webhook.event", d.EventType),
+	))
+	code, sendErr := webhook.Sender{Client: s.WebhookClient}.Send(ctx, webhook.Request{
+		URL:        w.URL,
+		Secret:     w.Secret,
+		DeliveryID: d.ID,
+		Event:      d.EventType,
+		Body:       d.Payload,
+	})
+	tracing.End(span, sendErr)
+	attempt := models.WebhookAttempt{At: start.Unix(), StatusCode: code, DurationMS: time.Since(start).Milliseconds()}
+	d.Tries++
+	failures := w.ConsecutiveFailures
+	switch {
+	case sendErr == nil:
+		d.Status, d.NextAttemptAt = models.DeliverySucceeded, 0
+		w.ConsecutiveFailures = 0
+	case d.Tries >= webhookMaxTries:
+		attempt.Error = sendErr.Error()
+		d.Status, d.NextAttemptAt = models.DeliveryFailed, 0
+		w.ConsecutiveFailures++
+	default:
+		attempt.Error = sendErr.Error()
+		d.NextAttemptAt = time.Now().Add(webhookRetryDelay(d.Tries)).Unix()
+		w.ConsecutiveFailures++
+	}
+	d.Attempts = append(d.Attempts, attempt)
+	if err := s.saveDelivery(ctx, d); err != nil {
+		return false, err
+	}
+
+	if w.ConsecutiveFailures >= maxWebhookFailures {
+		w.Enabled, w.DisabledAt = false, time.Now().Unix()
+	}
+	if w.ConsecutiveFailures != failures {
+		if _, err := s.storeFor(ctx).SaveWebhook(w); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return false, &domainError{Message: "can't save webhook", Err: err}
+		}
+	}
+	return sendErr == nil, nil
+}
+
+func (s *Service) saveDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	if err := s.storeFor(ctx).SaveWebhookDelivery(d); err != nil {
+		return &domainError{Message: "can't save delivery", Err: err}
+	}
+	return nil
+}
+
+// webhookRetryDelay returns delay before next attempt after tries failed ones
+func webhookRetryDelay(tries int) time.Duration {
+	d := webhookRetryBase
+	for i := 1; i < tries && d < webhookRetryMax; i++ {
+		d *= 2
+	}
+	if d > webhookRetryMax {
+		return webhookRetryMax
+	}
+	return d
+}
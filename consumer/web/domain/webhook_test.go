@@ -0,0 +1,194 @@
+package domain_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/webhook"
+
+	"github.com/golang/mock/gomock"
+)
+
+func (d *DomainSuite) TestCreateWebhook() {
+	d.mockStore.EXPECT().SaveWebhook(gomock.Any()).DoAndReturn(func(w models.Webhook) (models.Webhook, error) {
+		w.ID = 1
+		return w, nil
+	})
+	w, err := d.domain.CreateWebhook(context.Background(), models.WebhookForm{URL: "https://partner.test/hook", Events: []string{models.EventConsumerDeleted}})
+
+	d.Require().NoError(err)
+	d.Require().Equal(1, w.ID)
+	d.Require().True(w.Enabled)
+	d.Require().Len(w.Secret, 64)
+
+	_, err = d.domain.CreateWebhook(context.Background(), models.WebhookForm{URL: "ftp://partner.test"})
+	d.Require().Error(err)
+	_, err = d.domain.CreateWebhook(context.Background(), models.WebhookForm{URL: "https://partner.test", Events: []string{"unknown"}})
+	d.Require().Error(err)
+}
+
+func (d *DomainSuite) TestUpdateWebhookEnableResetsFailures() {
+	enabled := true
+	d.mockStore.EXPECT().Webhook(1).Return(models.Webhook{ID: 1, ConsecutiveFailures: 20, DisabledAt: 100}, nil)
+	d.mockStore.EXPECT().SaveWebhook(gomock.Any()).DoAndReturn(func(w models.Webhook) (models.Webhook, error) { return w, nil })
+	w, err := d.domain.UpdateWebhook(context.Background(), 1, models.WebhookForm{URL: "https://partner.test/new", Enabled: &enabled})
+
+	d.Require().NoError(err)
+	d.Require().Equal(models.Webhook{ID: 1, URL: "https://partner.test/new", Enabled: true}, w)
+}
+
+func (d *DomainSuite) TestPublishOutboxEnqueuesWebhooks() {
+	d.mockStore.EXPECT().Webhooks().Return([]models.Webhook{
+		{ID: 1, Enabled: true},
+		{ID: 2, Enabled: true, Events: []string{models.EventConsumerDeleted}},
+		{ID: 3},
+	}, nil)
+	d.mockStore.EXPECT().PendingOutboxMessages(gomock.Any()).Return([]models.OutboxMessage{
+		{ID: 5, Type: models.EventConsumerRegistered, Payload: []byte(`{"consumer_id":1}`)},
+	}, nil)
+	d.mockStore.EXPECT().CreateWebhookDelivery(gomock.Any()).DoAndReturn(func(dl models.WebhookDelivery) (models.WebhookDelivery, error) {
+		d.Require().Equal(1, dl.WebhookID)
+		d.Require().Equal(5, dl.MessageID)
+		d.Require().Equal(models.DeliveryPending, dl.Status)
+		d.Require().JSONEq(`{"id": 5, "type": "consumer.registered", "created_at": 0, "data": {"consumer_id": 1}}`, string(dl.Payload))
+		return dl, store.ErrAlreadyExists
+	})
+	d.mockStore.EXPECT().MarkOutboxMessagePublished(5, gomock.Any()).Return(nil)
+	n, err := d.domain.PublishOutbox(context.Background())
+
+	d.Require().NoError(err)
+	d.Require().Equal(1, n)
+}
+
+func (d *DomainSuite) TestDeliverWebhooks() {
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	d.mockStore.EXPECT().DueWebhookDeliveries(now.Unix(), gomock.Any()).Return([]models.WebhookDelivery{
+		{ID: 7, WebhookID: 1, EventType: models.EventConsumerRegistered, Payload: []byte("{}"), Status: models.DeliveryPending},
+	}, nil)
+	d.mockStore.EXPECT().Webhook(1).Return(models.Webhook{ID: 1, URL: srv.URL, Secret: "secret", Enabled: true, ConsecutiveFailures: 2}, nil)
+	d.mockStore.EXPECT().SaveWebhookDelivery(gomock.Any()).DoAndReturn(func(dl models.WebhookDelivery) error {
+		d.Require().Equal(models.DeliverySucceeded, dl.Status)
+		d.Require().Equal(1, dl.Tries)
+		d.Require().Len(dl.Attempts, 1)
+		d.Require().Equal(http.StatusOK, dl.Attempts[0].StatusCode)
+		return nil
+	})
+	d.mockStore.EXPECT().SaveWebhook(gomock.Any()).DoAndReturn(func(w models.Webhook) (models.Webhook, error) {
+		d.Require().Zero(w.ConsecutiveFailures)
+		return w, nil
+	})
+	n, err := d.domain.DeliverWebhooks(context.Background(), now)
+
+	d.Require().NoError(err)
+	d.Require().Equal(1, n)
+	d.Require().Equal("7", received.Header.Get(webhook.HeaderID))
+	d.Require().NoError(webhook.Verify("secret", received.Header, body, time.Minute, time.Now()))
+}
+
+func (d *DomainSuite) TestDeliverWebhooksInParallel() {
+	fastDone := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// responds only after delivery to other webhook, so sequential delivery would time out
+		<-fastDone
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fastDone)
+	}))
+	defer fast.Close()
+
+	now := time.Now()
+	d.mockStore.EXPECT().DueWebhookDeliveries(now.Unix(), gomock.Any()).Return([]models.WebhookDelivery{
+		{ID: 7, WebhookID: 1, Status: models.DeliveryPending},
+		{ID: 8, WebhookID: 2, Status: models.DeliveryPending},
+	}, nil)
+	d.mockStore.EXPECT().Webhook(1).Return(models.Webhook{ID: 1, URL: slow.URL, Enabled: true}, nil)
+	d.mockStore.EXPECT().Webhook(2).Return(models.Webhook{ID: 2, URL: fast.URL, Enabled: true}, nil)
+	d.mockStore.EXPECT().SaveWebhookDelivery(gomock.Any()).Return(nil).Times(2)
+	n, err := d.domain.DeliverWebhooks(context.Background(), now)
+
+	d.Require().NoError(err)
+	d.Require().Equal(2, n)
+}
+
+func (d *DomainSuite) TestDeliverWebhooksRetry() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	d.mockStore.EXPECT().DueWebhookDeliveries(now.Unix(), gomock.Any()).Return([]models.WebhookDelivery{
+		{ID: 7, WebhookID: 1, Status: models.DeliveryPending, Tries: 2},
+	}, nil)
+	d.mockStore.EXPECT().Webhook(1).Return(models.Webhook{ID: 1, URL: srv.URL, Enabled: true}, nil)
+	d.mockStore.EXPECT().SaveWebhookDelivery(gomock.Any()).DoAndReturn(func(dl models.WebhookDelivery) error {
+		d.Require().Equal(models.DeliveryPending, dl.Status)
+		d.Require().Equal(3, dl.Tries)
+		d.Require().InDelta(time.Now().Add(4*time.Minute).Unix(), dl.NextAttemptAt, 1)
+		d.Require().Equal(http.StatusInternalServerError, dl.Attempts[0].StatusCode)
+		d.Require().NotEmpty(dl.Attempts[0].Error)
+		return nil
+	})
+	d.mockStore.EXPECT().SaveWebhook(gomock.Any()).DoAndReturn(func(w models.Webhook) (models.Webhook, error) {
+		d.Require().Equal(1, w.ConsecutiveFailures)
+		d.Require().True(w.Enabled)
+		return w, nil
+	})
+	n, err := d.domain.DeliverWebhooks(context.Background(), now)
+
+	d.Require().NoError(err)
+	d.Require().Zero(n)
+}
+
+func (d *DomainSuite) TestDeliverWebhooksDisablesWebhook() {
+	now := time.Now()
+	d.mockStore.EXPECT().DueWebhookDeliveries(now.Unix(), gomock.Any()).Return([]models.WebhookDelivery{
+		{ID: 7, WebhookID: 1, Status: models.DeliveryPending, Tries: 7},
+	}, nil)
+	d.mockStore.EXPECT().Webhook(1).Return(models.Webhook{ID: 1, URL: "http://127.0.0.1:1", Enabled: true, ConsecutiveFailures: 19}, nil)
+	d.mockStore.EXPECT().SaveWebhookDelivery(gomock.Any()).DoAndReturn(func(dl models.WebhookDelivery) error {
+		d.Require().Equal(models.DeliveryFailed, dl.Status)
+		d.Require().Zero(dl.NextAttemptAt)
+		d.Require().Zero(dl.Attempts[0].StatusCode)
+		return nil
+	})
+	d.mockStore.EXPECT().SaveWebhook(gomock.Any()).DoAndReturn(func(w models.Webhook) (models.Webhook, error) {
+		d.Require().False(w.Enabled)
+		d.Require().NotZero(w.DisabledAt)
+		return w, nil
+	})
+	_, err := d.domain.DeliverWebhooks(context.Background(), now)
+
+	d.Require().NoError(err)
+}
+
+func (d *DomainSuite) TestReplayWebhookDelivery() {
+	attempts := []models.WebhookAttempt{{At: 1, StatusCode: 500}}
+	d.mockStore.EXPECT().WebhookDelivery(7).Return(models.WebhookDelivery{ID: 7, WebhookID: 1, Status: models.DeliveryFailed, Tries: 8, Attempts: attempts}, nil).Times(2)
+	d.mockStore.EXPECT().SaveWebhookDelivery(gomock.Any()).Return(nil)
+	dl, err := d.domain.ReplayWebhookDelivery(context.Background(), 1, 7)
+
+	d.Require().NoError(err)
+	d.Require().Equal(models.DeliveryPending, dl.Status)
+	d.Require().Zero(dl.Tries)
+	d.Require().NotZero(dl.NextAttemptAt)
+	d.Require().Equal(attempts, dl.Attempts)
+
+	_, err = d.domain.ReplayWebhookDelivery(context.Background(), 2, 7)
+	d.Require().Equal(consumer.CodeNotFound, consumer.ErrorCode(err))
+}
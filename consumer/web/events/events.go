@@ -0,0 +1,55 @@
+// Package events delivers domain events from outbox to other services
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nsmak/consumerService/consumer/models"
+)
+
+// Publisher delivers outbox message to subscribers. Message may be published more than once,
+// subscribers can drop duplicates by message id.
+type Publisher interface {
+	Publish(ctx context.Context, m models.OutboxMessage) error
+}
+
+// Channel - in-process publisher, messages are received from channel returned by C
+type Channel struct {
+	c chan models.OutboxMessage
+}
+
+var _ Publisher = (*Channel)(nil)
+
+// NewChannel creates Channel with buffer of size messages
+func NewChannel(size int) *Channel {
+	return &Channel{c: make(chan models.OutboxMessage, size)}
+}
+
+// Publish sends message to channel, it blocks until message is received or buffered or ctx is done
+func (p *Channel) Publish(ctx context.Context, m models.OutboxMessage) error {
+	select {
+	case p.c <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// C returns channel of published messages
+func (p *Channel) C() <-chan models.OutboxMessage {
+	return p.c
+}
+
+// Envelope - message format used by broker publishers
+type Envelope struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt int64           `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Encode wraps event payload with message metadata
+func Encode(m models.OutboxMessage) ([]byte, error) {
+	return json.Marshal(Envelope{ID: m.ID, Type: m.Type, CreatedAt: m.CreatedAt, Data: m.Payload})
+}
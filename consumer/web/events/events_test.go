@@ -0,0 +1,83 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/events"
+
+	"github.com/stretchr/testify/require"
+)
+
+var message = models.OutboxMessage{
+	ID:         7,
+	Type:       models.EventConsumerRegistered,
+	ConsumerID: 3,
+	Payload:    []byte(`{"consumer_id":3}`),
+	CreatedAt:  10,
+}
+
+const envelope = `{"id": 7, "type": "consumer.registered", "created_at": 10, "data": {"consumer_id": 3}}`
+
+type natsConn struct {
+	subject string
+	data    []byte
+	flushed bool
+}
+
+func (c *natsConn) Publish(subject string, data []byte) error {
+	c.subject, c.data = subject, data
+	return nil
+}
+
+func (c *natsConn) FlushWithContext(context.Context) error {
+	c.flushed = true
+	return nil
+}
+
+type kafkaProducer struct {
+	topic      string
+	key, value []byte
+	err        error
+}
+
+func (p *kafkaProducer) Produce(_ context.Context, topic string, key, value []byte) error {
+	p.topic, p.key, p.value = topic, key, value
+	return p.err
+}
+
+func TestChannel(t *testing.T) {
+	p := events.NewChannel(1)
+	require.NoError(t, p.Publish(context.Background(), message))
+	require.Equal(t, message, <-p.C())
+
+	require.NoError(t, p.Publish(context.Background(), message))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, p.Publish(ctx, message))
+}
+
+func TestNATS(t *testing.T) {
+	conn := &natsConn{}
+	p := events.NATS{Conn: conn, SubjectPrefix: "events."}
+	require.NoError(t, p.Publish(context.Background(), message))
+
+	require.Equal(t, "events.consumer.registered", conn.subject)
+	require.JSONEq(t, envelope, string(conn.data))
+	require.True(t, conn.flushed)
+}
+
+func TestKafka(t *testing.T) {
+	producer := &kafkaProducer{}
+	p := events.Kafka{Producer: producer, Topic: "consumers"}
+	require.NoError(t, p.Publish(context.Background(), message))
+
+	require.Equal(t, "consumers", producer.topic)
+	require.Equal(t, "3", string(producer.key))
+	require.JSONEq(t, envelope, string(producer.value))
+
+	producer.err = errors.New("not enough replicas")
+	require.Error(t, p.Publish(context.Background(), message))
+}
@@ -0,0 +1,31 @@
+package events
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/nsmak/consumerService/consumer/models"
+)
+
+// KafkaProducer - synchronous Kafka producer used by publisher,
+// it returns after message is acknowledged by brokers
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, key, value []byte) error
+}
+
+// Kafka publishes messages to Topic keyed by consumer id, so events of one consumer keep their order
+type Kafka struct {
+	Producer KafkaProducer
+	Topic    string
+}
+
+var _ Publisher = Kafka{}
+
+// Publish sends message to Kafka
+func (p Kafka) Publish(ctx context.Context, m models.OutboxMessage) error {
+	b, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	return p.Producer.Produce(ctx, p.Topic, []byte(strconv.Itoa(m.ConsumerID)), b)
+}
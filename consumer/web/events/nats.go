@@ -0,0 +1,33 @@
+package events
+
+import (
+	"context"
+
+	"github.com/nsmak/consumerService/consumer/models"
+)
+
+// NATSConn - part of NATS client used by publisher, it is implemented by *nats.Conn
+type NATSConn interface {
+	Publish(subject string, data []byte) error
+	FlushWithContext(ctx context.Context) error
+}
+
+// NATS publishes messages to subject SubjectPrefix + event type, e.g. "events.consumer.registered"
+type NATS struct {
+	Conn          NATSConn
+	SubjectPrefix string
+}
+
+var _ Publisher = NATS{}
+
+// Publish sends message to NATS and waits until server receives it
+func (p NATS) Publish(ctx context.Context, m models.OutboxMessage) error {
+	b, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	if err := p.Conn.Publish(p.SubjectPrefix+m.Type, b); err != nil {
+		return err
+	}
+	return p.Conn.FlushWithContext(ctx)
+}
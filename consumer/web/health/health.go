@@ -0,0 +1,120 @@
+// Package health implements liveness and readiness probes
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of service and its dependencies
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusDraining = "draining" // service is shutting down and doesn't accept new requests
+)
+
+const defaultCheckTimeout = 2 * time.Second
+
+// Check - readiness check of dependency
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// Handler serves liveness probe on /healthz and readiness probe on /readyz
+type Handler struct {
+	checks   []Check
+	timeout  time.Duration
+	draining int32
+	mux      *http.ServeMux
+}
+
+// NewHandler returns handler whose readiness depends on checks, each check is limited by timeout
+// which is 2 seconds if zero
+func NewHandler(timeout time.Duration, checks ...Check) *Handler {
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	h := &Handler{checks: checks, timeout: timeout, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/healthz", h.liveness)
+	h.mux.HandleFunc("/readyz", h.readiness)
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Drain makes readiness probe fail, so load balancer stops sending requests before shutdown
+func (h *Handler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// CheckResult - status of single dependency
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report - readiness probe response
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Ready runs all checks concurrently and returns report, its status is ok only if all checks pass
+func (h *Handler) Ready(ctx context.Context) Report {
+	if atomic.LoadInt32(&h.draining) == 1 {
+		return Report{Status: StatusDraining}
+	}
+	rep := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(h.checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			res := CheckResult{Status: StatusOK}
+			if err := c.Func(ctx); err != nil {
+				res = CheckResult{Status: StatusFailing, Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			rep.Checks[c.Name] = res
+			if res.Status != StatusOK {
+				rep.Status = StatusFailing
+			}
+		}(c)
+	}
+	wg.Wait()
+	return rep
+}
+
+func (h *Handler) liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+func (h *Handler) readiness(w http.ResponseWriter, r *http.Request) {
+	rep := h.Ready(r.Context())
+	code := http.StatusOK
+	if rep.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, rep)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, h http.Handler, path string) (int, Report) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var rep Report
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rep))
+	return w.Code, rep
+}
+
+func TestLiveness(t *testing.T) {
+	h := NewHandler(0, Check{Name: "store", Func: func(context.Context) error { return errors.New("down") }})
+	h.Drain()
+
+	code, rep := serve(t, h, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, Report{Status: StatusOK}, rep)
+}
+
+func TestReadiness(t *testing.T) {
+	storeErr := errors.New("connection refused")
+	var err error
+	h := NewHandler(0,
+		Check{Name: "store", Func: func(context.Context) error { return err }},
+		Check{Name: "signing_key", Func: func(context.Context) error { return nil }},
+	)
+
+	code, rep := serve(t, h, "/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, Report{Status: StatusOK, Checks: map[string]CheckResult{
+		"store":       {Status: StatusOK},
+		"signing_key": {Status: StatusOK},
+	}}, rep)
+
+	err = storeErr
+	code, rep = serve(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, Report{Status: StatusFailing, Checks: map[string]CheckResult{
+		"store":       {Status: StatusFailing, Error: "connection refused"},
+		"signing_key": {Status: StatusOK},
+	}}, rep)
+
+	err = nil
+	h.Drain()
+	code, rep = serve(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, Report{Status: StatusDraining}, rep)
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	h := NewHandler(10*time.Millisecond, Check{Name: "mailer", Func: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	rep := h.Ready(context.Background())
+	require.Equal(t, StatusFailing, rep.Status)
+	require.Equal(t, CheckResult{Status: StatusFailing, Error: context.DeadlineExceeded.Error()}, rep.Checks["mailer"])
+}
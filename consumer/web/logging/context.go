@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/middleware"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// HeaderRequestID - header of request id, it is accepted from client and returned in response
+const HeaderRequestID = "X-Request-Id"
+
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// WithRequestID returns context carrying request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns request id of ctx or empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithContext returns logger whose entries have request id and trace id of ctx
+func WithContext(ctx context.Context, l *zap.Logger) *zap.Logger {
+	var fields []zap.Field
+	if id := RequestID(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+	if len(fields) == 0 {
+		return l
+	}
+	return l.With(fields...)
+}
+
+// ValidRequestID returns id if it is well-formed request id sent by client, otherwise it returns new random id
+func ValidRequestID(id string) string {
+	if requestIDRegex.MatchString(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// newRequestID returns random request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware assigns id to request, returns it in response header and logs completed request.
+// Id sent by client in X-Request-Id header is kept if it is well-formed.
+func Middleware(l *zap.Logger, route func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := ValidRequestID(r.Header.Get(HeaderRequestID))
+		w.Header().Set(HeaderRequestID, id)
+		ctx := WithRequestID(r.Context(), id)
+
+		sw := middleware.NewStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		log := WithContext(ctx, l)
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("route", route(r)),
+			zap.Int("status", sw.Status()),
+			zap.Duration("duration", time.Since(start)),
+		}
+		switch {
+		case sw.Status() >= http.StatusInternalServerError:
+			log.Error("request failed", fields...)
+		default:
+			log.Info("request served", fields...)
+		}
+	})
+}
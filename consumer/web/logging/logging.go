@@ -0,0 +1,168 @@
+// Package logging creates structured loggers of service components whose levels can be changed at runtime.
+// Passwords, tokens and optionally email addresses are redacted from log entries.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Formats
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Levels
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// ErrInvalidLevel is returned when level name is unknown
+var ErrInvalidLevel = errors.New("level must be debug, info, warn or error")
+
+// Opts - logging options structure
+type Opts struct {
+	Format       string            // json by default
+	Level        string            // level of components without own level, info by default
+	Levels       map[string]string // level by component
+	RedactEmails bool
+	Output       zapcore.WriteSyncer // stderr is used if nil
+}
+
+// Logging creates component loggers sharing output and redaction settings
+type Logging struct {
+	core         zapcore.Core
+	redactEmails bool
+	defaultLevel zapcore.Level
+
+	mu     sync.Mutex
+	levels map[string]zap.AtomicLevel
+}
+
+// New returns logging with options
+func New(opts Opts) (*Logging, error) {
+	encCfg := zap.NewProductionEncoderConfig()
+	encCfg.TimeKey = "time"
+	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	var enc zapcore.Encoder
+	switch opts.Format {
+	case FormatJSON, "":
+		enc = zapcore.NewJSONEncoder(encCfg)
+	case FormatConsole:
+		encCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		enc = zapcore.NewConsoleEncoder(encCfg)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	out := opts.Output
+	if out == nil {
+		out = zapcore.Lock(os.Stderr)
+	}
+	defaultLevel, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	l := &Logging{
+		// level is checked by component cores, so the shared one accepts everything
+		core:         zapcore.NewCore(enc, out, zapcore.DebugLevel),
+		redactEmails: opts.RedactEmails,
+		defaultLevel: defaultLevel,
+		levels:       make(map[string]zap.AtomicLevel, len(opts.Levels)),
+	}
+	for component, name := range opts.Levels {
+		if err := l.SetLevel(component, name); err != nil {
+			return nil, fmt.Errorf("component %s: %w", component, err)
+		}
+	}
+	return l, nil
+}
+
+// ParseLevel returns zap level by name, empty name means info
+func ParseLevel(name string) (zapcore.Level, error) {
+	switch strings.ToLower(name) {
+	case LevelDebug:
+		return zapcore.DebugLevel, nil
+	case LevelInfo, "":
+		return zapcore.InfoLevel, nil
+	case LevelWarn:
+		return zapcore.WarnLevel, nil
+	case LevelError:
+		return zapcore.ErrorLevel, nil
+	}
+	return 0, ErrInvalidLevel
+}
+
+// Logger returns logger of component, its entries have "component" field
+func (l *Logging) Logger(component string) *zap.Logger {
+	level := l.level(component)
+	core := &redactCore{Core: &levelCore{Core: l.core, level: level}, emails: l.redactEmails}
+	return zap.New(core, zap.AddCaller()).With(zap.String("component", component))
+}
+
+// Levels returns current level names by component
+func (l *Logging) Levels() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	levels := make(map[string]string, len(l.levels))
+	for component, level := range l.levels {
+		levels[component] = level.String()
+	}
+	return levels
+}
+
+// SetLevel changes level of component's loggers including already created ones
+func (l *Logging) SetLevel(component, name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	l.level(component).SetLevel(level)
+	return nil
+}
+
+// Sync flushes buffered entries
+func (l *Logging) Sync() error {
+	return l.core.Sync()
+}
+
+func (l *Logging) level(component string) zap.AtomicLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level, ok := l.levels[component]
+	if !ok {
+		level = zap.NewAtomicLevelAt(l.defaultLevel)
+		l.levels[component] = level
+	}
+	return level
+}
+
+// levelCore filters entries of shared core by component's level
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.level.Enabled(lvl)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys - names of fields whose values are always redacted
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"pass":          true,
+	"pass1":         true,
+	"pass2":         true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+	"code":          true,
+	"signing_key":   true,
+}
+
+var (
+	jwtRegex   = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	emailRegex = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+\.)+[A-Za-z]{2,}`)
+)
+
+// redactCore removes secrets from message and fields before they are written
+type redactCore struct {
+	zapcore.Core
+	emails bool // mask email addresses, their domains are kept
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redactFields(fields)), emails: c.emails}
+}
+
+func (c *redactCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = c.redactString(e.Message)
+	return c.Core.Write(e, c.redactFields(fields))
+}
+
+func (c *redactCore) redactFields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		out[i] = c.redactField(f)
+	}
+	return out
+}
+
+func (c *redactCore) redactField(f zapcore.Field) zapcore.Field {
+	if sensitiveKeys[strings.ToLower(f.Key)] {
+		return zap.String(f.Key, redacted)
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = c.redactString(f.String)
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok {
+			return zap.String(f.Key, c.redactString(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok {
+			return zap.String(f.Key, c.redactString(s.String()))
+		}
+	}
+	return f
+}
+
+// redactString replaces tokens and, if enabled, local parts of email addresses in s
+func (c *redactCore) redactString(s string) string {
+	s = jwtRegex.ReplaceAllString(s, redacted)
+	if c.emails {
+		s = emailRegex.ReplaceAllStringFunc(s, func(email string) string {
+			return "***" + email[strings.LastIndexByte(email, '@'):]
+		})
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:generate mockgen -destination=../domain/mock_mailer_test.go -package=domain_test -source=mailer.go Mailer
+
+// Message - plain text email message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	Headers map[string]string // additional headers, e.g. trace context
+}
+
+// Mailer - interface of email delivery service
+type Mailer interface {
+	Send(m Message) error
+}
+
+// SMTPOpts - smtp mailer options structure
+type SMTPOpts struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// SMTP sends messages through smtp server
+type SMTP struct {
+	SMTPOpts
+}
+
+// NewSMTP returns new instance of smtp mailer
+func NewSMTP(opts SMTPOpts) *SMTP {
+	return &SMTP{SMTPOpts: opts}
+}
+
+// Ping checks that smtp server accepts connections
+func (s *SMTP) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("can't connect to smtp server: %w", err)
+	}
+	return conn.Close()
+}
+
+// Send message
+func (s *SMTP) Send(m Message) error {
+	var a smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address: %w", err)
+		}
+		a = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return fmt.Errorf("invalid message header")
+	}
+	names := make([]string, 0, len(m.Headers))
+	for name, v := range m.Headers {
+		if name == "" || strings.ContainsAny(name, ": \r\n") || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid message header")
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + m.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	for _, name := range names {
+		b.WriteString(textproto.CanonicalMIMEHeaderKey(name) + ": " + m.Headers[name] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(s.Addr, a, s.From, []string{m.To}, []byte(b.String()))
+}
@@ -0,0 +1,157 @@
+// Package metrics collects Prometheus metrics of consumer service.
+// Methods of nil *Metrics do nothing, so instrumented components work without metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "consumer"
+
+// Login outcomes
+const (
+	LoginSucceeded   = "succeeded"
+	LoginFailed      = "failed"
+	LoginMFARequired = "mfa_required"
+)
+
+// Metrics - collectors of service metrics
+type Metrics struct {
+	registry *prometheus.Registry
+
+	registrations      prometheus.Counter
+	logins             *prometheus.CounterVec
+	tokensIssued       *prometheus.CounterVec
+	tokenIssueFailures prometheus.Counter
+	tokenRejections    *prometheus.CounterVec
+	httpRequests       *prometheus.HistogramVec
+	storeCalls         *prometheus.HistogramVec
+	storeErrors        *prometheus.CounterVec
+}
+
+// New returns metrics registered in new registry together with process and Go runtime collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Number of registered consumers.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Number of login attempts by outcome and reason, reason of succeeded login is authentication method.",
+		}, []string{"outcome", "reason"}),
+		tokensIssued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_issued_total",
+			Help:      "Number of issued tokens by subject.",
+		}, []string{"subject"}),
+		tokenIssueFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_issue_failures_total",
+			Help:      "Number of tokens which failed to be issued.",
+		}),
+		tokenRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_validation_failures_total",
+			Help:      "Number of rejected tokens by error code.",
+		}, []string{"code"}),
+		httpRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests by route, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		storeCalls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_call_duration_seconds",
+			Help:      "Duration of data store calls by method.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"method"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_errors_total",
+			Help:      "Number of failed data store calls by method and error.",
+		}, []string{"method", "error"}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		m.registrations, m.logins, m.tokensIssued, m.tokenIssueFailures, m.tokenRejections,
+		m.httpRequests, m.storeCalls, m.storeErrors,
+	)
+	return m
+}
+
+// Handler serves metrics in Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Registry returns registry of metrics, e.g. to register collectors of other components
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Registered counts registered consumer
+func (m *Metrics) Registered() {
+	if m == nil {
+		return
+	}
+	m.registrations.Inc()
+}
+
+// Login counts login attempt with outcome, reason is failure reason or authentication method of succeeded login
+func (m *Metrics) Login(outcome, reason string) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(outcome, reason).Inc()
+}
+
+// TokenIssued counts issued token, err is error of issuing
+func (m *Metrics) TokenIssued(subject string, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.tokenIssueFailures.Inc()
+		return
+	}
+	m.tokensIssued.WithLabelValues(subject).Inc()
+}
+
+// TokenRejected counts token which failed validation with error code
+func (m *Metrics) TokenRejected(code string) {
+	if m == nil {
+		return
+	}
+	m.tokenRejections.WithLabelValues(code).Inc()
+}
+
+// InstrumentHandler returns handler which records duration of requests to next.
+// route returns pattern of request's route, it must have few distinct values to keep label cardinality low.
+func (m *Metrics) InstrumentHandler(route func(*http.Request) string, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := middleware.NewStatusWriter(w)
+		next.ServeHTTP(sw, r)
+		rt := route(r)
+		if rt == "" {
+			rt = "unmatched"
+		}
+		m.httpRequests.WithLabelValues(rt, middleware.Method(r), strconv.Itoa(sw.Status())).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/store/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns metrics exposition lines which start with prefix
+func scrape(t *testing.T, m *Metrics, prefix string) []string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	b, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	var lines []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(l, prefix) {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	s := memory.New()
+	h := http.NotFoundHandler()
+
+	m.Registered()
+	m.Login(LoginFailed, "invalid_password")
+	m.TokenIssued("access", nil)
+	m.TokenRejected("invalid_token")
+	require.Equal(t, store.DataStore(s), m.Store(s))
+	require.NotNil(t, m.InstrumentHandler(nil, h))
+}
+
+func TestCounters(t *testing.T) {
+	m := New()
+	m.Registered()
+	m.Login(LoginFailed, "invalid_password")
+	m.Login(LoginFailed, "invalid_password")
+	m.Login(LoginFailed, "account_locked")
+	m.Login(LoginMFARequired, "password")
+	m.Login(LoginSucceeded, "totp")
+	m.TokenIssued("access", nil)
+	m.TokenIssued("access", errors.New("can't sign"))
+	m.TokenRejected("token_expired")
+
+	require.Equal(t, []string{"consumer_registrations_total 1"}, scrape(t, m, "consumer_registrations_total"))
+	require.Equal(t, []string{
+		`consumer_logins_total{outcome="failed",reason="account_locked"} 1`,
+		`consumer_logins_total{outcome="failed",reason="invalid_password"} 2`,
+		`consumer_logins_total{outcome="mfa_required",reason="password"} 1`,
+		`consumer_logins_total{outcome="succeeded",reason="totp"} 1`,
+	}, scrape(t, m, "consumer_logins_total"))
+	require.Equal(t, []string{`consumer_tokens_issued_total{subject="access"} 1`}, scrape(t, m, "consumer_tokens_issued_total"))
+	require.Equal(t, []string{"consumer_token_issue_failures_total 1"}, scrape(t, m, "consumer_token_issue_failures_total"))
+	require.Equal(t, []string{`consumer_token_validation_failures_total{code="token_expired"} 1`},
+		scrape(t, m, "consumer_token_validation_failures_total"))
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/consumers/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	h := m.InstrumentHandler(func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}, mux)
+
+	for _, path := range []string{"/v1/consumers/1", "/v1/consumers/2", "/unknown"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOO", "/v1/consumers/", nil))
+
+	require.Equal(t, []string{
+		`consumer_http_request_duration_seconds_count{method="POST",route="/v1/consumers/",status="201"} 2`,
+		`consumer_http_request_duration_seconds_count{method="POST",route="unmatched",status="404"} 1`,
+		`consumer_http_request_duration_seconds_count{method="other",route="/v1/consumers/",status="201"} 1`,
+	}, scrape(t, m, "consumer_http_request_duration_seconds_count"))
+}
+
+func TestStore(t *testing.T) {
+	m := New()
+	s := m.Store(memory.New())
+
+	_, err := s.Consumer("test@test.com")
+	require.True(t, errors.Is(err, store.ErrNotFound))
+	err = s.Atomic(func(tx store.DataStore) error {
+		_, err := tx.CreateConsumer(models.Consumer{Email: "test@test.com"})
+		return err
+	})
+	require.NoError(t, err)
+	_, err = s.Consumer("test@test.com")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		`consumer_store_call_duration_seconds_count{method="Atomic"} 1`,
+		`consumer_store_call_duration_seconds_count{method="Consumer"} 2`,
+		`consumer_store_call_duration_seconds_count{method="CreateConsumer"} 1`,
+	}, scrape(t, m, "consumer_store_call_duration_seconds_count"))
+	require.Equal(t, []string{`consumer_store_errors_total{error="not_found",method="Consumer"} 1`},
+		scrape(t, m, "consumer_store_errors_total"))
+}
@@ -0,0 +1,414 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+)
+
+// Store returns data store which records latency and errors of calls to s
+func (m *Metrics) Store(s store.DataStore) store.DataStore {
+	if m == nil {
+		return s
+	}
+	return &instrumentedStore{next: s, m: m}
+}
+
+type instrumentedStore struct {
+	next store.DataStore
+	m    *Metrics
+}
+
+// observe records call of method which started at start and failed with err if it isn't nil
+func (s *instrumentedStore) observe(method string, start time.Time, err error) {
+	s.m.storeCalls.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.m.storeErrors.WithLabelValues(method, errorLabel(err)).Inc()
+	}
+}
+
+// errorLabel returns low cardinality label of store error
+func errorLabel(err error) string {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, store.ErrAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, store.ErrConflict):
+		return "conflict"
+	case errors.Is(err, store.ErrInvalidCursor):
+		return "invalid_cursor"
+	default:
+		return "other"
+	}
+}
+
+// Atomic records duration of the whole transaction, calls within it are recorded too
+func (s *instrumentedStore) Atomic(fn func(tx store.DataStore) error) error {
+	start := time.Now()
+	err := s.next.Atomic(func(tx store.DataStore) error {
+		return fn(&instrumentedStore{next: tx, m: s.m})
+	})
+	s.observe("Atomic", start, err)
+	return err
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
+
+func (s *instrumentedStore) CreateConsumer(c models.Consumer) (models.Consumer, error) {
+	start := time.Now()
+	v, err := s.next.CreateConsumer(c)
+	s.observe("CreateConsumer", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) ConsumerIsExist(email string) (bool, error) {
+	start := time.Now()
+	v, err := s.next.ConsumerIsExist(email)
+	s.observe("ConsumerIsExist", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) Consumer(email string) (models.Consumer, error) {
+	start := time.Now()
+	v, err := s.next.Consumer(email)
+	s.observe("Consumer", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) ConsumerByID(id int) (models.Consumer, error) {
+	start := time.Now()
+	v, err := s.next.ConsumerByID(id)
+	s.observe("ConsumerByID", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) UpdateConsumer(c models.Consumer) (models.Consumer, error) {
+	start := time.Now()
+	v, err := s.next.UpdateConsumer(c)
+	s.observe("UpdateConsumer", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) DeleteConsumer(id int) error {
+	start := time.Now()
+	err := s.next.DeleteConsumer(id)
+	s.observe("DeleteConsumer", start, err)
+	return err
+}
+
+func (s *instrumentedStore) ListConsumers(f models.ConsumerFilter, page models.PageRequest) (models.ConsumerPage, error) {
+	start := time.Now()
+	v, err := s.next.ListConsumers(f, page)
+	s.observe("ListConsumers", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) ConsumersScheduledForDeletion(before int64, limit int) ([]models.Consumer, error) {
+	start := time.Now()
+	v, err := s.next.ConsumersScheduledForDeletion(before, limit)
+	s.observe("ConsumersScheduledForDeletion", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) PurgeConsumer(id int) error {
+	start := time.Now()
+	err := s.next.PurgeConsumer(id)
+	s.observe("PurgeConsumer", start, err)
+	return err
+}
+
+func (s *instrumentedStore) SaveStatusChange(ch models.StatusChange) error {
+	start := time.Now()
+	err := s.next.SaveStatusChange(ch)
+	s.observe("SaveStatusChange", start, err)
+	return err
+}
+
+func (s *instrumentedStore) StatusChanges(consumerID int) ([]models.StatusChange, error) {
+	start := time.Now()
+	v, err := s.next.StatusChanges(consumerID)
+	s.observe("StatusChanges", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) AppendAuditEvent(e models.AuditEvent) (models.AuditEvent, error) {
+	start := time.Now()
+	v, err := s.next.AppendAuditEvent(e)
+	s.observe("AppendAuditEvent", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) AuditEvents(f models.AuditFilter, page models.PageRequest) (models.AuditPage, error) {
+	start := time.Now()
+	v, err := s.next.AuditEvents(f, page)
+	s.observe("AuditEvents", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) AddOutboxMessage(m models.OutboxMessage) error {
+	start := time.Now()
+	err := s.next.AddOutboxMessage(m)
+	s.observe("AddOutboxMessage", start, err)
+	return err
+}
+
+func (s *instrumentedStore) PendingOutboxMessages(limit int) ([]models.OutboxMessage, error) {
+	start := time.Now()
+	v, err := s.next.PendingOutboxMessages(limit)
+	s.observe("PendingOutboxMessages", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) MarkOutboxMessagePublished(id int, publishedAt int64) error {
+	start := time.Now()
+	err := s.next.MarkOutboxMessagePublished(id, publishedAt)
+	s.observe("MarkOutboxMessagePublished", start, err)
+	return err
+}
+
+func (s *instrumentedStore) SaveWebhook(w models.Webhook) (models.Webhook, error) {
+	start := time.Now()
+	v, err := s.next.SaveWebhook(w)
+	s.observe("SaveWebhook", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) Webhook(id int) (models.Webhook, error) {
+	start := time.Now()
+	v, err := s.next.Webhook(id)
+	s.observe("Webhook", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) Webhooks() ([]models.Webhook, error) {
+	start := time.Now()
+	v, err := s.next.Webhooks()
+	s.observe("Webhooks", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) DeleteWebhook(id int) error {
+	start := time.Now()
+	err := s.next.DeleteWebhook(id)
+	s.observe("DeleteWebhook", start, err)
+	return err
+}
+
+func (s *instrumentedStore) CreateWebhookDelivery(d models.WebhookDelivery) (models.WebhookDelivery, error) {
+	start := time.Now()
+	v, err := s.next.CreateWebhookDelivery(d)
+	s.observe("CreateWebhookDelivery", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveWebhookDelivery(d models.WebhookDelivery) error {
+	start := time.Now()
+	err := s.next.SaveWebhookDelivery(d)
+	s.observe("SaveWebhookDelivery", start, err)
+	return err
+}
+
+func (s *instrumentedStore) WebhookDelivery(id int) (models.WebhookDelivery, error) {
+	start := time.Now()
+	v, err := s.next.WebhookDelivery(id)
+	s.observe("WebhookDelivery", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) WebhookDeliveries(webhookID int, page models.PageRequest) (models.WebhookDeliveryPage, error) {
+	start := time.Now()
+	v, err := s.next.WebhookDeliveries(webhookID, page)
+	s.observe("WebhookDeliveries", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) DueWebhookDeliveries(now int64, limit int) ([]models.WebhookDelivery, error) {
+	start := time.Now()
+	v, err := s.next.DueWebhookDeliveries(now, limit)
+	s.observe("DueWebhookDeliveries", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveTOTPFactor(f models.TOTPFactor) error {
+	start := time.Now()
+	err := s.next.SaveTOTPFactor(f)
+	s.observe("SaveTOTPFactor", start, err)
+	return err
+}
+
+func (s *instrumentedStore) TOTPFactor(consumerID int) (models.TOTPFactor, error) {
+	start := time.Now()
+	v, err := s.next.TOTPFactor(consumerID)
+	s.observe("TOTPFactor", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveWebAuthnCredential(c models.WebAuthnCredential) error {
+	start := time.Now()
+	err := s.next.SaveWebAuthnCredential(c)
+	s.observe("SaveWebAuthnCredential", start, err)
+	return err
+}
+
+func (s *instrumentedStore) WebAuthnCredential(id []byte) (models.WebAuthnCredential, error) {
+	start := time.Now()
+	v, err := s.next.WebAuthnCredential(id)
+	s.observe("WebAuthnCredential", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) WebAuthnCredentials(consumerID int) ([]models.WebAuthnCredential, error) {
+	start := time.Now()
+	v, err := s.next.WebAuthnCredentials(consumerID)
+	s.observe("WebAuthnCredentials", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveWebAuthnChallenge(c models.WebAuthnChallenge) error {
+	start := time.Now()
+	err := s.next.SaveWebAuthnChallenge(c)
+	s.observe("SaveWebAuthnChallenge", start, err)
+	return err
+}
+
+func (s *instrumentedStore) TakeWebAuthnChallenge(challenge string) (models.WebAuthnChallenge, error) {
+	start := time.Now()
+	v, err := s.next.TakeWebAuthnChallenge(challenge)
+	s.observe("TakeWebAuthnChallenge", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveExport(e models.Export) (models.Export, error) {
+	start := time.Now()
+	v, err := s.next.SaveExport(e)
+	s.observe("SaveExport", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) Export(id int) (models.Export, error) {
+	start := time.Now()
+	v, err := s.next.Export(id)
+	s.observe("Export", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) LastExport(consumerID int) (models.Export, error) {
+	start := time.Now()
+	v, err := s.next.LastExport(consumerID)
+	s.observe("LastExport", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) PendingExports(limit int) ([]models.Export, error) {
+	start := time.Now()
+	v, err := s.next.PendingExports(limit)
+	s.observe("PendingExports", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveOneTimeToken(t models.OneTimeToken) (models.OneTimeToken, error) {
+	start := time.Now()
+	v, err := s.next.SaveOneTimeToken(t)
+	s.observe("SaveOneTimeToken", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) OneTimeToken(id int) (models.OneTimeToken, error) {
+	start := time.Now()
+	v, err := s.next.OneTimeToken(id)
+	s.observe("OneTimeToken", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) LastOneTimeToken(consumerID int, purpose string) (models.OneTimeToken, error) {
+	start := time.Now()
+	v, err := s.next.LastOneTimeToken(consumerID, purpose)
+	s.observe("LastOneTimeToken", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) CreateOAuthClient(c models.OAuthClient) error {
+	start := time.Now()
+	err := s.next.CreateOAuthClient(c)
+	s.observe("CreateOAuthClient", start, err)
+	return err
+}
+
+func (s *instrumentedStore) OAuthClient(id string) (models.OAuthClient, error) {
+	start := time.Now()
+	v, err := s.next.OAuthClient(id)
+	s.observe("OAuthClient", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) OAuthClients() ([]models.OAuthClient, error) {
+	start := time.Now()
+	v, err := s.next.OAuthClients()
+	s.observe("OAuthClients", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) DeleteOAuthClient(id string) error {
+	start := time.Now()
+	err := s.next.DeleteOAuthClient(id)
+	s.observe("DeleteOAuthClient", start, err)
+	return err
+}
+
+func (s *instrumentedStore) SaveOAuthCode(c models.OAuthCode) error {
+	start := time.Now()
+	err := s.next.SaveOAuthCode(c)
+	s.observe("SaveOAuthCode", start, err)
+	return err
+}
+
+func (s *instrumentedStore) TakeOAuthCode(hash string) (models.OAuthCode, error) {
+	start := time.Now()
+	v, err := s.next.TakeOAuthCode(hash)
+	s.observe("TakeOAuthCode", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) SaveRefreshToken(t models.RefreshToken) error {
+	start := time.Now()
+	err := s.next.SaveRefreshToken(t)
+	s.observe("SaveRefreshToken", start, err)
+	return err
+}
+
+func (s *instrumentedStore) RefreshToken(hash string) (models.RefreshToken, error) {
+	start := time.Now()
+	v, err := s.next.RefreshToken(hash)
+	s.observe("RefreshToken", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) UseRefreshToken(hash string, usedAt int64) error {
+	start := time.Now()
+	err := s.next.UseRefreshToken(hash, usedAt)
+	s.observe("UseRefreshToken", start, err)
+	return err
+}
+
+func (s *instrumentedStore) RevokeRefreshTokens(familyID string) error {
+	start := time.Now()
+	err := s.next.RevokeRefreshTokens(familyID)
+	s.observe("RevokeRefreshTokens", start, err)
+	return err
+}
+
+func (s *instrumentedStore) ConsumerRefreshTokens(consumerID int) ([]models.RefreshToken, error) {
+	start := time.Now()
+	v, err := s.next.ConsumerRefreshTokens(consumerID)
+	s.observe("ConsumerRefreshTokens", start, err)
+	return v, err
+}
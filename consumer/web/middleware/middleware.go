@@ -0,0 +1,50 @@
+// Package middleware has helpers shared by HTTP middlewares which log, measure and trace requests
+package middleware
+
+import "net/http"
+
+// MethodOther replaces request methods which service doesn't know
+const MethodOther = "other"
+
+// Method returns request method if it is standard HTTP method or MethodOther,
+// so arbitrary methods sent by clients can't inflate label cardinality or span names.
+func Method(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return r.Method
+	default:
+		return MethodOther
+	}
+}
+
+// StatusWriter remembers status code of response
+type StatusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// NewStatusWriter returns writer to w, status is 200 until another one is written
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status returns status code of response
+func (w *StatusWriter) Status() int {
+	return w.status
+}
+
+// WriteHeader remembers the first status code and passes it to underlying writer
+func (w *StatusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,33 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nsmak/consumerService/consumer/web/middleware"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethod(t *testing.T) {
+	require.Equal(t, http.MethodPatch, middleware.Method(httptest.NewRequest(http.MethodPatch, "/", nil)))
+	require.Equal(t, middleware.MethodOther, middleware.Method(httptest.NewRequest("PROPFIND", "/", nil)))
+	require.Equal(t, middleware.MethodOther, middleware.Method(httptest.NewRequest("get", "/", nil)), "methods are case-sensitive")
+}
+
+func TestStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := middleware.NewStatusWriter(rec)
+	require.Equal(t, http.StatusOK, w.Status())
+
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError)
+	require.Equal(t, http.StatusCreated, w.Status(), "the first status is sent")
+
+	w = middleware.NewStatusWriter(httptest.NewRecorder())
+	_, err := w.Write([]byte("body"))
+	require.NoError(t, err)
+	w.WriteHeader(http.StatusNotFound)
+	require.Equal(t, http.StatusOK, w.Status(), "status can't be changed after body is written")
+}
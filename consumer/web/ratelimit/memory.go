@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type memoryItem struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// MemoryStore keeps counters in process memory. Limits don't hold across service replicas.
+type MemoryStore struct {
+	mu        sync.Mutex
+	items     map[string]memoryItem
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore returns new instance of in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]memoryItem), now: time.Now}
+}
+
+// Incr increments value of key
+func (s *MemoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	it, ok := s.items[key]
+	if !ok || !it.expiresAt.After(now) {
+		if now.Sub(s.lastSweep) > sweepInterval {
+			s.sweep(now)
+		}
+		it = memoryItem{expiresAt: now.Add(ttl)}
+	}
+	it.value++
+	s.items[key] = it
+	return it.value, nil
+}
+
+// Get returns value of key
+func (s *MemoryStore) Get(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[key]
+	if !ok || !it.expiresAt.After(s.now()) {
+		return 0, nil
+	}
+	return it.value, nil
+}
+
+// Set sets value of key
+func (s *MemoryStore) Set(key string, value int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = memoryItem{value: value, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+// Delete removes keys
+func (s *MemoryStore) Delete(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.items, k)
+	}
+	return nil
+}
+
+// sweep removes expired items, must be called under lock
+func (s *MemoryStore) sweep(now time.Time) {
+	s.lastSweep = now
+	for k, it := range s.items {
+		if !it.expiresAt.After(now) {
+			delete(s.items, k)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package ratelimit
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+)
+
+type limitError struct {
+	IsUserError bool          `json:"-"`
+	Code        string        `json:"code,omitempty"`
+	Message     string        `json:"message"`
+	Err         error         `json:"err,omitempty"`
+	Retry       time.Duration `json:"-"`
+}
+
+func (e *limitError) Error() string {
+	if e.Err != nil {
+		return e.Message + " --> " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *limitError) UserError() bool {
+	if e.Err == nil {
+		return e.IsUserError
+	}
+	err, ok := e.Err.(consumer.Error)
+	if !ok {
+		return e.IsUserError
+	}
+	return err.UserError()
+}
+
+func (e *limitError) Unwrap() error {
+	return e.Err
+}
+
+func (e *limitError) RetryAfter() time.Duration {
+	return e.Retry
+}
+
+func (e *limitError) ErrorCode() string {
+	return e.Code
+}
+
+// Store - storage of counters. Shared store makes limits hold across service replicas.
+type Store interface {
+	// Incr increments value of key and returns new value. TTL is set when key is created.
+	Incr(key string, ttl time.Duration) (int64, error)
+	// Get returns value of key or zero if key doesn't exist
+	Get(key string) (int64, error)
+	// Set sets value of key
+	Set(key string, value int64, ttl time.Duration) error
+	// Delete removes keys
+	Delete(keys ...string) error
+}
+
+// Rule - limit of events within sliding window
+type Rule struct {
+	Limit  int64
+	Window time.Duration
+}
+
+// Opts - limiter options structure
+type Opts struct {
+	IP        Rule          // attempts from single client address
+	Account   Rule          // failed attempts for single account before lockout
+	Lockout   time.Duration // duration of account lockout
+	BaseDelay time.Duration // delay after first failure, doubled after each next one
+	MaxDelay  time.Duration
+}
+
+// DefaultOpts - limiter options suitable for login endpoints
+var DefaultOpts = Opts{
+	IP:        Rule{Limit: 100, Window: time.Hour},
+	Account:   Rule{Limit: 10, Window: 15 * time.Minute},
+	Lockout:   15 * time.Minute,
+	BaseDelay: time.Second,
+	MaxDelay:  30 * time.Second,
+}
+
+// Limiter throttles authentication attempts per account and per client address
+type Limiter struct {
+	Opts
+	store Store
+	now   func() time.Time
+}
+
+// NewLimiter returns new instance of limiter
+func NewLimiter(s Store, opts Opts) *Limiter {
+	return &Limiter{Opts: opts, store: s, now: time.Now}
+}
+
+// NormalizeAccount returns account key which doesn't depend on letter case and surrounding spaces
+func NormalizeAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+// Allow registers attempt to authenticate to account from ip and returns error
+// if attempt must be rejected. Empty account or ip is not checked.
+func (l *Limiter) Allow(account, ip string) error {
+	now := l.now()
+	if ip != "" && l.IP.Limit > 0 {
+		n, err := l.hit("ip:"+ip, l.IP.Window, now)
+		if err != nil {
+			return err
+		}
+		if n > l.IP.Limit {
+			return &limitError{
+				IsUserError: true,
+				Code:        consumer.CodeTooManyAttempts,
+				Message:     "too many attempts",
+				Retry:       l.IP.Window / time.Duration(l.IP.Limit),
+			}
+		}
+	}
+	if account == "" {
+		return nil
+	}
+	account = NormalizeAccount(account)
+	for _, key := range []string{"lock:" + account, "delay:" + account} {
+		until, err := l.store.Get(key)
+		if err != nil {
+			return &limitError{Message: "can't get limit", Err: err}
+		}
+		if wait := time.Unix(0, until).Sub(now); wait > 0 {
+			code, msg := consumer.CodeTooManyAttempts, "too many attempts"
+			if strings.HasPrefix(key, "lock:") {
+				code, msg = consumer.CodeAccountLocked, "account is temporarily locked"
+			}
+			return &limitError{IsUserError: true, Code: code, Message: msg, Retry: (wait + time.Second - 1).Truncate(time.Second)}
+		}
+	}
+	return nil
+}
+
+// Fail registers failed attempt for account. Account is locked if count of failures exceeds limit.
+func (l *Limiter) Fail(account string) error {
+	if account == "" {
+		return nil
+	}
+	account = NormalizeAccount(account)
+	now := l.now()
+	n, err := l.hit("fail:"+account, l.Account.Window, now)
+	if err != nil {
+		return err
+	}
+	if l.Account.Limit > 0 && n >= l.Account.Limit {
+		if err := l.store.Set("lock:"+account, now.Add(l.Lockout).UnixNano(), l.Lockout); err != nil {
+			return &limitError{Message: "can't lock account", Err: err}
+		}
+		return nil
+	}
+	if delay := l.delay(n); delay > 0 {
+		if err := l.store.Set("delay:"+account, now.Add(delay).UnixNano(), delay); err != nil {
+			return &limitError{Message: "can't set delay", Err: err}
+		}
+	}
+	return nil
+}
+
+// Succeed resets failures of account
+func (l *Limiter) Succeed(account string) error {
+	if account == "" {
+		return nil
+	}
+	account = NormalizeAccount(account)
+	w := l.windowStart(l.Account.Window, l.now())
+	err := l.store.Delete(
+		"delay:"+account,
+		windowKey("fail:"+account, w),
+		windowKey("fail:"+account, w-1),
+	)
+	if err != nil {
+		return &limitError{Message: "can't reset limit", Err: err}
+	}
+	return nil
+}
+
+// delay returns progressive delay after n failures
+func (l *Limiter) delay(n int64) time.Duration {
+	if l.BaseDelay <= 0 || n <= 0 {
+		return 0
+	}
+	d := l.BaseDelay
+	for i := int64(1); i < n; i++ {
+		d *= 2
+		if l.MaxDelay > 0 && d >= l.MaxDelay {
+			return l.MaxDelay
+		}
+	}
+	return d
+}
+
+// hit registers event of key and returns approximate count of events within sliding window.
+// Count is weighted sum of current and previous fixed windows.
+func (l *Limiter) hit(key string, window time.Duration, now time.Time) (int64, error) {
+	if window <= 0 {
+		return 0, nil
+	}
+	w := l.windowStart(window, now)
+	cur, err := l.store.Incr(windowKey(key, w), 2*window)
+	if err != nil {
+		return 0, &limitError{Message: "can't increment counter", Err: err}
+	}
+	prev, err := l.store.Get(windowKey(key, w-1))
+	if err != nil {
+		return 0, &limitError{Message: "can't get counter", Err: err}
+	}
+	elapsed := float64(now.UnixNano()%int64(window)) / float64(window)
+	return cur + int64(float64(prev)*(1-elapsed)), nil
+}
+
+func (l *Limiter) windowStart(window time.Duration, now time.Time) int64 {
+	if window <= 0 {
+		return 0
+	}
+	return now.UnixNano() / int64(window)
+}
+
+func windowKey(key string, w int64) string {
+	return key + ":" + strconv.FormatInt(w, 10)
+}
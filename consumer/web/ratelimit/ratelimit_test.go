@@ -0,0 +1,224 @@
+package ratelimit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter(opts Opts) (*Limiter, *clock) {
+	c := &clock{t: time.Unix(1600000000, 0)}
+	s := NewMemoryStore()
+	s.now = c.now
+	l := NewLimiter(s, opts)
+	l.now = c.now
+	return l, c
+}
+
+func requireRetryable(t *testing.T, err error) time.Duration {
+	require.Error(t, err)
+	var re consumer.RetryableError
+	require.True(t, errors.As(err, &re))
+	require.True(t, re.UserError())
+	return re.RetryAfter()
+}
+
+func TestLimitErrorMessage(t *testing.T) {
+	err := &limitError{Message: "can't get limit", Err: errors.New("connection refused")}
+
+	require.Equal(t, "can't get limit --> connection refused", err.Error())
+	require.Equal(t, "can't get limit --> connection refused", err.Error())
+}
+
+func TestLimiterProgressiveDelay(t *testing.T) {
+	l, c := newTestLimiter(Opts{
+		Account:   Rule{Limit: 10, Window: time.Hour},
+		BaseDelay: time.Second,
+		MaxDelay:  4 * time.Second,
+	})
+
+	require.NoError(t, l.Allow("Test@Test.com", ""))
+	require.NoError(t, l.Fail("Test@Test.com"))
+	err := l.Allow("test@test.com", "")
+	require.Equal(t, time.Second, requireRetryable(t, err))
+	require.Equal(t, consumer.CodeTooManyAttempts, consumer.ErrorCode(err))
+
+	for _, delay := range []time.Duration{2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		c.t = c.t.Add(time.Minute)
+		require.NoError(t, l.Allow("test@test.com", ""))
+		require.NoError(t, l.Fail("test@test.com"))
+		c.t = c.t.Add(delay - time.Millisecond)
+		requireRetryable(t, l.Allow("test@test.com", ""))
+		c.t = c.t.Add(time.Millisecond)
+		require.NoError(t, l.Allow("test@test.com", ""))
+	}
+
+	require.NoError(t, l.Succeed("test@test.com"))
+	require.NoError(t, l.Fail("test@test.com"))
+	c.t = c.t.Add(time.Second)
+	require.NoError(t, l.Allow("test@test.com", ""), "failures must be reset after success")
+}
+
+func TestLimiterLockout(t *testing.T) {
+	l, c := newTestLimiter(Opts{
+		Account: Rule{Limit: 3, Window: time.Hour},
+		Lockout: 10 * time.Minute,
+	})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Allow("test@test.com", ""))
+		require.NoError(t, l.Fail("test@test.com"))
+	}
+	err := l.Allow("test@test.com", "")
+	retry := requireRetryable(t, err)
+	require.Equal(t, consumer.CodeAccountLocked, consumer.ErrorCode(err))
+	require.Equal(t, 10*time.Minute, retry)
+	require.NoError(t, l.Allow("other@test.com", ""))
+
+	c.t = c.t.Add(10 * time.Minute)
+	require.NoError(t, l.Allow("test@test.com", ""))
+}
+
+func TestLimiterIP(t *testing.T) {
+	l, c := newTestLimiter(Opts{IP: Rule{Limit: 2, Window: time.Minute}})
+
+	require.NoError(t, l.Allow("a@test.com", "10.0.0.1"))
+	require.NoError(t, l.Allow("b@test.com", "10.0.0.1"))
+	requireRetryable(t, l.Allow("c@test.com", "10.0.0.1"))
+	require.NoError(t, l.Allow("c@test.com", "10.0.0.2"))
+
+	c.t = c.t.Add(2 * time.Minute)
+	require.NoError(t, l.Allow("c@test.com", "10.0.0.1"))
+}
+
+func TestRedisStore(t *testing.T) {
+	addr := newFakeRedis(t)
+	s := NewRedisStore(RedisOpts{Addr: addr})
+	defer s.Close()
+
+	n, err := s.Incr("k", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+	n, err = s.Incr("k", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	v, err := s.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), v)
+
+	require.NoError(t, s.Set("lock", 42, time.Minute))
+	v, err = s.Get("lock")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), v)
+
+	require.NoError(t, s.Delete("k", "lock"))
+	v, err = s.Get("k")
+	require.NoError(t, err)
+	require.Zero(t, v)
+}
+
+// newFakeRedis starts server which understands commands used by RedisStore
+func newFakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(rd)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					reply := execFake(data, args)
+					mu.Unlock()
+					if _, err := conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return args, nil
+}
+
+func execFake(data map[string]string, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "EVAL":
+		// the only script is incrScript, ttl isn't emulated
+		if args[1] != incrScript || args[2] != "1" {
+			return "-ERR unknown script\r\n"
+		}
+		n, _ := strconv.Atoi(data[args[3]])
+		n++
+		data[args[3]] = strconv.Itoa(n)
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "GET":
+		v, ok := data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case "SET":
+		data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		for _, k := range args[1:] {
+			delete(data, k)
+		}
+		return ":" + strconv.Itoa(len(args)-1) + "\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisOpts - redis store options structure
+type RedisOpts struct {
+	Addr     string
+	Password string
+	DB       int
+	Timeout  time.Duration
+}
+
+// RedisStore keeps counters in redis, so limits are shared by all service replicas
+type RedisStore struct {
+	RedisOpts
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedisStore returns new instance of redis store. Connection is established on first command.
+func NewRedisStore(opts RedisOpts) *RedisStore {
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	return &RedisStore{RedisOpts: opts}
+}
+
+// incrScript increments counter and sets its ttl when it is created, so counter never lives without expiry
+const incrScript = `local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+return n`
+
+// Incr increments value of key, ttl is set atomically when key is created
+func (s *RedisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	v, err := s.do("EVAL", incrScript, "1", key, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, errors.New("redis: unexpected reply to INCR")
+	}
+	return n, nil
+}
+
+// Get returns value of key
+func (s *RedisStore) Get(key string) (int64, error) {
+	v, err := s.do("GET", key)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil {
+		return 0, nil
+	}
+	str, ok := v.(string)
+	if !ok {
+		return 0, errors.New("redis: unexpected reply to GET")
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// Set sets value of key
+func (s *RedisStore) Set(key string, value int64, ttl time.Duration) error {
+	_, err := s.do("SET", key, strconv.FormatInt(value, 10), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete removes keys
+func (s *RedisStore) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.do("DEL", keys...)
+	return err
+}
+
+// SetPassword replaces password used on connection, established connection stays authenticated
+func (s *RedisStore) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Password = password
+}
+
+// Close closes connection to redis
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *RedisStore) do(cmd string, args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	v, err := s.roundTrip(cmd, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// connection state is unknown after network error
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return v, err
+}
+
+func (s *RedisStore) connect() error {
+	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return fmt.Errorf("redis: can't connect: %w", err)
+	}
+	s.conn = conn
+	s.rd = bufio.NewReader(conn)
+	if s.Password != "" {
+		if _, err := s.roundTrip("AUTH", s.Password); err != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	if s.DB != 0 {
+		if _, err := s.roundTrip("SELECT", strconv.Itoa(s.DB)); err != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) roundTrip(cmd string, args ...string) (interface{}, error) {
+	if err := s.conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return nil, err
+	}
+	buf := []byte("*" + strconv.Itoa(len(args)+1) + "\r\n")
+	for _, a := range append([]string{cmd}, args...) {
+		buf = append(buf, "$"+strconv.Itoa(len(a))+"\r\n"+a+"\r\n"...)
+	}
+	if _, err := s.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(s.rd)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readReply reads single RESP reply. Bulk strings are returned as string, nil bulk string as nil.
+func readReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply")
+	}
+	payload := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	default:
+		return nil, fmt.Errorf("redis: unsupported reply type %q", line[0])
+	}
+}
@@ -0,0 +1,4 @@
+// Package consumerpb contains protobuf messages and gRPC stubs of consumer service
+package consumerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative consumer.proto
@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorDomain - domain of google.rpc.ErrorInfo details of errors
+const ErrorDomain = "consumer.service"
+
+// statusError converts application error to gRPC status error. Messages of internal errors are not shown to client.
+// Code of application error is sent in ErrorInfo details, retry delay is sent in RetryInfo details.
+func statusError(err error) error {
+	cErr, ok := err.(consumer.Error)
+	if !ok || !cErr.UserError() {
+		return status.Error(codes.Internal, "internal error")
+	}
+	code := consumer.ErrorCode(err)
+	c := codes.InvalidArgument
+	switch code {
+	case consumer.CodeInvalidCredentials, consumer.CodeInvalidToken, consumer.CodeTokenExpired, consumer.CodeTokenRequired,
+		consumer.CodeReauthRequired:
+		c = codes.Unauthenticated
+	case consumer.CodeNotFound:
+		c = codes.NotFound
+	case consumer.CodeTooManyAttempts, consumer.CodeAccountLocked:
+		c = codes.ResourceExhausted
+	case consumer.CodeAccountSuspended, consumer.CodeAccountSecurityLocked, consumer.CodeAccountPendingDeletion,
+		consumer.CodeInsufficientScope:
+		c = codes.PermissionDenied
+	case consumer.CodeInvalidStatusTransition:
+		c = codes.FailedPrecondition
+	case "":
+		code = "bad_request"
+	}
+	var retryAfter time.Duration
+	var retryable consumer.RetryableError
+	if errors.As(err, &retryable) {
+		retryAfter = retryable.RetryAfter()
+	}
+	return withDetails(status.New(c, err.Error()), code, retryAfter).Err()
+}
+
+// withDetails returns copy of st with ErrorInfo and, if retryAfter is positive, RetryInfo details
+func withDetails(st *status.Status, code string, retryAfter time.Duration) *status.Status {
+	withInfo, err := st.WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: ErrorDomain})
+	if err != nil {
+		return st
+	}
+	if retryAfter <= 0 {
+		return withInfo
+	}
+	withRetry, err := withInfo.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return withInfo
+	}
+	return withRetry
+}
@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/logging"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// intercept assigns request id to call like logging.Middleware does for http requests and logs completed call.
+// Id sent by client in x-request-id metadata is kept if it is well-formed, it is returned in response header.
+func (s *Server) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(logging.HeaderRequestID); len(values) != 0 {
+			id = values[0]
+		}
+	}
+	id = logging.ValidRequestID(id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(logging.HeaderRequestID, id))
+	ctx = logging.WithRequestID(ctx, id)
+
+	resp, err := next(ctx, req)
+
+	log := logging.WithContext(ctx, s.Logger)
+	code := status.Code(err)
+	fields := []zap.Field{
+		zap.String("method", info.FullMethod),
+		zap.String("status", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		log.Error("call failed", fields...)
+	default:
+		log.Info("call served", fields...)
+	}
+	return resp, err
+}
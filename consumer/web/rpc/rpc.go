@@ -0,0 +1,217 @@
+// Package rpc implements gRPC API of consumer service, it mirrors REST API for internal services
+package rpc
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/rpc/consumerpb"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Opts - gRPC server options structure
+type Opts struct {
+	Logger *zap.Logger // nothing is logged if nil
+}
+
+// Server - gRPC server with consumer, health and reflection services
+type Server struct {
+	consumerpb.UnimplementedConsumerServiceServer
+	Opts
+	domain *domain.Service
+	health *grpchealth.Server
+	grpc   *grpc.Server
+}
+
+// NewServer returns new instance of gRPC server
+func NewServer(d *domain.Service, opts Opts) *Server {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	s := &Server{Opts: opts, domain: d, health: grpchealth.NewServer()}
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.intercept))
+	consumerpb.RegisterConsumerServiceServer(s.grpc, s)
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	reflection.Register(s.grpc)
+	s.health.SetServingStatus(consumerpb.ConsumerService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+// Serve accepts connections on l until server is stopped
+func (s *Server) Serve(l net.Listener) error {
+	return s.grpc.Serve(l)
+}
+
+// Drain makes health service report that server isn't serving, so clients stop sending new calls
+func (s *Server) Drain() {
+	s.health.Shutdown()
+}
+
+// GracefulStop stops accepting connections and waits until in-flight calls are finished or ctx is done
+func (s *Server) GracefulStop(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// Register creates consumer's account
+func (s *Server) Register(ctx context.Context, req *consumerpb.RegisterRequest) (*consumerpb.RegisterResponse, error) {
+	c, err := s.domain.CreateConsumer(ctx, models.RegFrom{
+		Email:  req.Email,
+		Pass1:  req.Password,
+		Pass2:  req.PasswordConfirmation,
+		Client: clientInfo(ctx),
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if s.domain.EnumerationSafeRegistration {
+		return &consumerpb.RegisterResponse{}, nil
+	}
+	return &consumerpb.RegisterResponse{Consumer: consumerMessage(c)}, nil
+}
+
+// Authenticate exchanges credentials for token
+func (s *Server) Authenticate(ctx context.Context, req *consumerpb.AuthenticateRequest) (*consumerpb.AuthenticateResponse, error) {
+	res, err := s.domain.AuthenticateConsumer(ctx, models.AuthForm{
+		Email:  req.Email,
+		Pass:   req.Password,
+		Scopes: req.Scopes,
+		Client: clientInfo(ctx),
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &consumerpb.AuthenticateResponse{Token: res.Token, MfaRequired: res.MFARequired}, nil
+}
+
+// GetProfile returns owner of access token sent in metadata
+func (s *Server) GetProfile(ctx context.Context, _ *consumerpb.GetProfileRequest) (*consumerpb.GetProfileResponse, error) {
+	claims, err := s.authorize(ctx, token.ScopeProfileRead)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.domain.ConsumerByID(ctx, claims.ID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &consumerpb.GetProfileResponse{Consumer: consumerMessage(c)}, nil
+}
+
+// ValidateToken checks access token and returns its claims
+func (s *Server) ValidateToken(ctx context.Context, req *consumerpb.ValidateTokenRequest) (*consumerpb.ValidateTokenResponse, error) {
+	claims, err := s.domain.TokenClaims(ctx, req.Token)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &consumerpb.ValidateTokenResponse{
+		ConsumerId: int64(claims.ID),
+		Scopes:     claims.Scopes(),
+		ExpiresAt:  claims.ExpiresAt,
+	}, nil
+}
+
+// RefreshToken exchanges access token for a new one
+func (s *Server) RefreshToken(ctx context.Context, req *consumerpb.RefreshTokenRequest) (*consumerpb.RefreshTokenResponse, error) {
+	t, err := s.domain.RefreshToken(ctx, req.Token)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &consumerpb.RefreshTokenResponse{Token: t}, nil
+}
+
+// Revoke revokes all tokens of token's owner
+func (s *Server) Revoke(ctx context.Context, req *consumerpb.RevokeRequest) (*consumerpb.RevokeResponse, error) {
+	claims, err := s.domain.TokenClaims(ctx, req.Token)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if err := s.domain.RevokeTokens(ctx, claims.ID); err != nil {
+		return nil, statusError(err)
+	}
+	return &consumerpb.RevokeResponse{}, nil
+}
+
+// authorize returns claims of access token sent in "authorization" metadata if it has scope
+func (s *Server) authorize(ctx context.Context, scope string) (token.Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	const prefix = "bearer "
+	var t string
+	if values := md.Get("authorization"); len(values) != 0 && len(values[0]) > len(prefix) && strings.EqualFold(values[0][:len(prefix)], prefix) {
+		t = values[0][len(prefix):]
+	}
+	if t == "" {
+		return token.Claims{}, codeError(codes.Unauthenticated, consumer.CodeTokenRequired, "bearer token is required")
+	}
+	claims, err := s.domain.TokenClaims(ctx, t)
+	if err != nil {
+		return token.Claims{}, statusError(err)
+	}
+	if !claims.HasScope(scope) {
+		return token.Claims{}, codeError(codes.PermissionDenied, consumer.CodeInsufficientScope, "insufficient scope")
+	}
+	return claims, nil
+}
+
+// clientInfo returns address and user agent of client which sent request
+func clientInfo(ctx context.Context) models.ClientInfo {
+	var info models.ClientInfo
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		info.IP = p.Addr.String()
+		if ip, _, err := net.SplitHostPort(info.IP); err == nil {
+			info.IP = ip
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) != 0 {
+			info.UserAgent = ua[0]
+		}
+	}
+	return info
+}
+
+func consumerMessage(c models.Consumer) *consumerpb.Consumer {
+	p := c.Public()
+	return &consumerpb.Consumer{
+		Id:                  int64(p.ID),
+		Email:               p.Email,
+		EmailVerified:       p.EmailVerified,
+		RegTimestamp:        p.RegTimestamp,
+		Status:              p.Status,
+		DeletionScheduledAt: p.DeletionScheduledAt,
+		Profile: &consumerpb.Profile{
+			DisplayName: p.Profile.DisplayName,
+			Locale:      p.Profile.Locale,
+			Timezone:    p.Profile.Timezone,
+			Phone:       p.Profile.Phone,
+			AvatarUrl:   p.Profile.AvatarURL,
+			Attributes:  p.Profile.Attributes,
+		},
+	}
+}
+
+// codeError returns status error with code of REST API in details
+func codeError(c codes.Code, code, msg string) error {
+	return withDetails(status.New(c, msg), code, 0).Err()
+}
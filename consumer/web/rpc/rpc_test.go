@@ -0,0 +1,223 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer"
+	"github.com/nsmak/consumerService/consumer/web/auth"
+	"github.com/nsmak/consumerService/consumer/web/auth/token"
+	"github.com/nsmak/consumerService/consumer/web/domain"
+	"github.com/nsmak/consumerService/consumer/web/mailer"
+	"github.com/nsmak/consumerService/consumer/web/rpc/consumerpb"
+	"github.com/nsmak/consumerService/consumer/web/store/memory"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dial starts server on in-memory listener and returns connection to it
+func dial(t *testing.T, s *Server) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 20)
+	go func() { _ = s.Serve(l) }()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		s.GracefulStop(context.Background())
+	})
+	return conn
+}
+
+func newServer(opts domain.Opts) *Server {
+	a := auth.NewService(auth.Opts{SigningKey: []byte("1")})
+	return NewServer(domain.NewService(memory.New(), a, opts), Opts{})
+}
+
+// requireStatus checks code of status error and reason of its ErrorInfo details
+func requireStatus(t *testing.T, err error, c codes.Code, reason string) *status.Status {
+	st, ok := status.FromError(err)
+	require.True(t, ok, err)
+	require.Equal(t, c, st.Code(), st.Message())
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	require.NotNil(t, info, "no ErrorInfo details")
+	require.Equal(t, reason, info.Reason)
+	require.Equal(t, ErrorDomain, info.Domain)
+	return st
+}
+
+func TestConsumerLifecycle(t *testing.T) {
+	client := consumerpb.NewConsumerServiceClient(dial(t, newServer(domain.Opts{})))
+	ctx := context.Background()
+
+	reg, err := client.Register(ctx, &consumerpb.RegisterRequest{Email: "test@test.com", Password: "password", PasswordConfirmation: "password"})
+	require.NoError(t, err)
+	require.Equal(t, "test@test.com", reg.Consumer.Email)
+	require.Equal(t, "active", reg.Consumer.Status)
+
+	var header metadata.MD
+	login, err := client.Authenticate(metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-1"),
+		&consumerpb.AuthenticateRequest{Email: "test@test.com", Password: "password", Scopes: []string{token.ScopeProfileRead}},
+		grpc.Header(&header))
+	require.NoError(t, err)
+	require.False(t, login.MfaRequired)
+	require.Equal(t, []string{"req-1"}, header.Get("x-request-id"))
+
+	profile, err := client.GetProfile(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+login.Token), &consumerpb.GetProfileRequest{})
+	require.NoError(t, err)
+	require.Equal(t, reg.Consumer.Id, profile.Consumer.Id)
+
+	valid, err := client.ValidateToken(ctx, &consumerpb.ValidateTokenRequest{Token: login.Token})
+	require.NoError(t, err)
+	require.Equal(t, reg.Consumer.Id, valid.ConsumerId)
+	require.Equal(t, []string{token.ScopeProfileRead}, valid.Scopes)
+	require.True(t, valid.ExpiresAt > time.Now().Unix())
+
+	refreshed, err := client.RefreshToken(ctx, &consumerpb.RefreshTokenRequest{Token: login.Token})
+	require.NoError(t, err)
+	require.NotEmpty(t, refreshed.Token)
+
+	_, err = client.Revoke(ctx, &consumerpb.RevokeRequest{Token: refreshed.Token})
+	require.NoError(t, err)
+	for _, tok := range []string{login.Token, refreshed.Token} {
+		_, err = client.ValidateToken(ctx, &consumerpb.ValidateTokenRequest{Token: tok})
+		requireStatus(t, err, codes.Unauthenticated, consumer.CodeInvalidToken)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	client := consumerpb.NewConsumerServiceClient(dial(t, newServer(domain.Opts{})))
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &consumerpb.RegisterRequest{Email: "invalid", Password: "password", PasswordConfirmation: "password"})
+	requireStatus(t, err, codes.InvalidArgument, "bad_request")
+
+	_, err = client.Authenticate(ctx, &consumerpb.AuthenticateRequest{Email: "nobody@test.com", Password: "password"})
+	requireStatus(t, err, codes.Unauthenticated, consumer.CodeInvalidCredentials)
+
+	_, err = client.GetProfile(ctx, &consumerpb.GetProfileRequest{})
+	requireStatus(t, err, codes.Unauthenticated, consumer.CodeTokenRequired)
+
+	_, err = client.ValidateToken(ctx, &consumerpb.ValidateTokenRequest{Token: "invalid"})
+	requireStatus(t, err, codes.Unauthenticated, consumer.CodeInvalidToken)
+
+	_, err = client.Register(ctx, &consumerpb.RegisterRequest{Email: "test@test.com", Password: "password", PasswordConfirmation: "password"})
+	require.NoError(t, err)
+	login, err := client.Authenticate(ctx, &consumerpb.AuthenticateRequest{Email: "test@test.com", Password: "password", Scopes: []string{token.ScopeProfileWrite}})
+	require.NoError(t, err)
+	_, err = client.GetProfile(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+login.Token), &consumerpb.GetProfileRequest{})
+	requireStatus(t, err, codes.PermissionDenied, consumer.CodeInsufficientScope)
+}
+
+type discardMailer struct{}
+
+func (discardMailer) Send(mailer.Message) error { return nil }
+
+func TestEnumerationSafeRegistration(t *testing.T) {
+	client := consumerpb.NewConsumerServiceClient(dial(t, newServer(domain.Opts{EnumerationSafeRegistration: true, Mailer: discardMailer{}})))
+
+	reg, err := client.Register(context.Background(), &consumerpb.RegisterRequest{Email: "test@test.com", Password: "password", PasswordConfirmation: "password"})
+	require.NoError(t, err)
+	require.Nil(t, reg.Consumer)
+}
+
+type retryableError struct{}
+
+func (retryableError) Error() string             { return "too many attempts" }
+func (retryableError) UserError() bool           { return true }
+func (retryableError) ErrorCode() string         { return consumer.CodeTooManyAttempts }
+func (retryableError) RetryAfter() time.Duration { return 30 * time.Second }
+
+func TestStatusError(t *testing.T) {
+	st := requireStatus(t, statusError(retryableError{}), codes.ResourceExhausted, consumer.CodeTooManyAttempts)
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	require.NotNil(t, retry)
+	require.Equal(t, 30*time.Second, retry.RetryDelay.AsDuration())
+
+	st, _ = status.FromError(statusError(errors.New("connection refused")))
+	require.Equal(t, codes.Internal, st.Code())
+	require.Equal(t, "internal error", st.Message())
+	require.Empty(t, st.Details())
+}
+
+type userError string
+
+func (e userError) Error() string     { return string(e) }
+func (e userError) UserError() bool   { return true }
+func (e userError) ErrorCode() string { return string(e) }
+
+func TestStatusErrorCodes(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		want codes.Code
+	}{
+		{consumer.CodeInvalidCredentials, codes.Unauthenticated},
+		{consumer.CodeInvalidToken, codes.Unauthenticated},
+		{consumer.CodeTokenExpired, codes.Unauthenticated},
+		{consumer.CodeTokenRequired, codes.Unauthenticated},
+		{consumer.CodeReauthRequired, codes.Unauthenticated},
+		{consumer.CodeNotFound, codes.NotFound},
+		{consumer.CodeTooManyAttempts, codes.ResourceExhausted},
+		{consumer.CodeAccountLocked, codes.ResourceExhausted},
+		{consumer.CodeAccountSuspended, codes.PermissionDenied},
+		{consumer.CodeInsufficientScope, codes.PermissionDenied},
+		{consumer.CodeInvalidStatusTransition, codes.FailedPrecondition},
+	} {
+		requireStatus(t, statusError(userError(tc.code)), tc.want, tc.code)
+	}
+}
+
+func TestHealthAndReflection(t *testing.T) {
+	s := newServer(domain.Opts{})
+	conn := dial(t, s)
+	ctx := context.Background()
+
+	health := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", consumerpb.ConsumerService_ServiceDesc.ServiceName} {
+		resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status, service)
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	var services []string
+	for _, svc := range resp.GetListServicesResponse().Service {
+		services = append(services, svc.Name)
+	}
+	require.Contains(t, services, "consumer.v1.ConsumerService")
+	require.Contains(t, services, "grpc.health.v1.Health")
+
+	s.Drain()
+	resp2, err := health.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp2.Status)
+}
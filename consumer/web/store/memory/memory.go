@@ -0,0 +1,1044 @@
+// Package memory implements in-memory DataStore, it is used in tests and local development
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+)
+
+var _ store.DataStore = (*Store)(nil)
+
+// Store - in-memory data storage
+type Store struct {
+	mu   *sync.RWMutex
+	tx   bool      // store is passed to Atomic callback, lock is already held
+	undo *[]func() // reverts in-place changes of log entries made in transaction
+	*state
+}
+
+type state struct {
+	lastID         int
+	consumers      map[int]models.Consumer
+	byEmail        map[string]int
+	lastChangeID   int
+	statusChanges  []models.StatusChange
+	totp           map[int]models.TOTPFactor
+	credentials    map[string]models.WebAuthnCredential
+	challenges     map[string]models.WebAuthnChallenge
+	lastTokenID    int
+	tokens         map[int]models.OneTimeToken
+	lastExportID   int
+	exports        map[int]models.Export
+	audit          []models.AuditEvent
+	lastOutboxID   int
+	outbox         []models.OutboxMessage
+	lastWebhookID  int
+	webhooks       map[int]models.Webhook
+	lastDeliveryID int
+	deliveries     map[int]models.WebhookDelivery
+	clients        map[string]models.OAuthClient
+	codes          map[string]models.OAuthCode
+	refreshTokens  map[string]models.RefreshToken
+}
+
+// New creates empty Store
+func New() *Store {
+	return &Store{
+		mu: &sync.RWMutex{},
+		state: &state{
+			consumers:     make(map[int]models.Consumer),
+			byEmail:       make(map[string]int),
+			totp:          make(map[int]models.TOTPFactor),
+			credentials:   make(map[string]models.WebAuthnCredential),
+			challenges:    make(map[string]models.WebAuthnChallenge),
+			tokens:        make(map[int]models.OneTimeToken),
+			exports:       make(map[int]models.Export),
+			webhooks:      make(map[int]models.Webhook),
+			deliveries:    make(map[int]models.WebhookDelivery),
+			clients:       make(map[string]models.OAuthClient),
+			codes:         make(map[string]models.OAuthCode),
+			refreshTokens: make(map[string]models.RefreshToken),
+		},
+	}
+}
+
+// Ping always succeeds, memory is always available
+func (s *Store) Ping(context.Context) error {
+	return nil
+}
+
+// Atomic calls fn with store which is locked until fn returns,
+// all changes made by fn are rolled back if it returns error
+func (s *Store) Atomic(fn func(tx store.DataStore) error) error {
+	if s.tx {
+		return fn(s)
+	}
+	defer s.lock()()
+
+	snapshot := s.state.clone()
+	var undo []func()
+	if err := fn(&Store{mu: s.mu, tx: true, undo: &undo, state: s.state}); err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		*s.state = *snapshot
+		return err
+	}
+	return nil
+}
+
+// onRollback registers fn which reverts in-place change of log entry if transaction is rolled back
+func (s *Store) onRollback(fn func()) {
+	if s.tx {
+		*s.undo = append(*s.undo, fn)
+	}
+}
+
+func (s *Store) lock() func() {
+	if s.tx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *Store) rlock() func() {
+	if s.tx {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+// CreateConsumer saves new consumer and returns it with assigned id
+func (s *Store) CreateConsumer(c models.Consumer) (models.Consumer, error) {
+	defer s.lock()()
+
+	key := strings.ToLower(c.Email)
+	if _, ok := s.byEmail[key]; ok {
+		return models.Consumer{}, store.ErrAlreadyExists
+	}
+	s.lastID++
+	c.ID = s.lastID
+	if c.RegTimestamp == 0 {
+		c.RegTimestamp = time.Now().Unix()
+	}
+	if c.Status == "" {
+		c.Status = models.StatusActive
+	}
+	c.Version = 1
+	c.DeletedAt = 0
+	c = copyConsumer(c)
+	s.consumers[c.ID] = c
+	s.byEmail[key] = c.ID
+	return copyConsumer(c), nil
+}
+
+// ConsumerIsExist reports whether consumer with email exists
+func (s *Store) ConsumerIsExist(email string) (bool, error) {
+	defer s.rlock()()
+
+	_, ok := s.byEmail[strings.ToLower(email)]
+	return ok, nil
+}
+
+// Consumer returns consumer by email
+func (s *Store) Consumer(email string) (models.Consumer, error) {
+	defer s.rlock()()
+
+	id, ok := s.byEmail[strings.ToLower(email)]
+	if !ok {
+		return models.Consumer{}, store.ErrNotFound
+	}
+	return copyConsumer(s.consumers[id]), nil
+}
+
+// ConsumerByID returns consumer by id
+func (s *Store) ConsumerByID(id int) (models.Consumer, error) {
+	defer s.rlock()()
+
+	c, ok := s.consumers[id]
+	if !ok || c.DeletedAt != 0 {
+		return models.Consumer{}, store.ErrNotFound
+	}
+	return copyConsumer(c), nil
+}
+
+// UpdateConsumer saves consumer if its version matches stored one
+func (s *Store) UpdateConsumer(c models.Consumer) (models.Consumer, error) {
+	defer s.lock()()
+
+	old, ok := s.consumers[c.ID]
+	if !ok || old.DeletedAt != 0 {
+		return models.Consumer{}, store.ErrNotFound
+	}
+	if old.Version != c.Version {
+		return models.Consumer{}, store.ErrConflict
+	}
+	oldKey, newKey := strings.ToLower(old.Email), strings.ToLower(c.Email)
+	if oldKey != newKey {
+		if _, ok := s.byEmail[newKey]; ok {
+			return models.Consumer{}, store.ErrAlreadyExists
+		}
+		delete(s.byEmail, oldKey)
+		s.byEmail[newKey] = c.ID
+	}
+	c.Version++
+	c.DeletedAt = 0
+	c = copyConsumer(c)
+	s.consumers[c.ID] = c
+	return copyConsumer(c), nil
+}
+
+// DeleteConsumer marks consumer as deleted
+func (s *Store) DeleteConsumer(id int) error {
+	defer s.lock()()
+
+	c, ok := s.consumers[id]
+	if !ok || c.DeletedAt != 0 {
+		return store.ErrNotFound
+	}
+	c.DeletedAt = time.Now().Unix()
+	c.Version++
+	s.consumers[id] = c
+	delete(s.byEmail, strings.ToLower(c.Email))
+	return nil
+}
+
+// ListConsumers returns consumers ordered by id
+func (s *Store) ListConsumers(f models.ConsumerFilter, page models.PageRequest) (models.ConsumerPage, error) {
+	after, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return models.ConsumerPage{}, err
+	}
+
+	defer s.rlock()()
+
+	ids := make([]int, 0, len(s.consumers))
+	for id, c := range s.consumers {
+		if id > after && c.DeletedAt == 0 && f.Match(c) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	var p models.ConsumerPage
+	for i, id := range ids {
+		if i == page.Limit {
+			p.NextCursor = encodeCursor(ids[i-1])
+			break
+		}
+		p.Consumers = append(p.Consumers, copyConsumer(s.consumers[id]))
+	}
+	return p, nil
+}
+
+// ConsumersScheduledForDeletion returns up to limit consumers pending deletion whose deletion time is before
+func (s *Store) ConsumersScheduledForDeletion(before int64, limit int) ([]models.Consumer, error) {
+	defer s.rlock()()
+
+	ids := make([]int, 0)
+	for id, c := range s.consumers {
+		if c.DeletedAt == 0 && c.Status == models.StatusPendingDeletion && c.DeletionScheduledAt <= before {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	res := make([]models.Consumer, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, copyConsumer(s.consumers[id]))
+	}
+	return res, nil
+}
+
+// PurgeConsumer erases consumer's personal data, credentials and tokens
+func (s *Store) PurgeConsumer(id int) error {
+	defer s.lock()()
+
+	c, ok := s.consumers[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	delete(s.byEmail, strings.ToLower(c.Email))
+	now := time.Now().Unix()
+	s.consumers[id] = models.Consumer{
+		ID:              id,
+		RegTimestamp:    c.RegTimestamp,
+		Status:          models.StatusDeleted,
+		Version:         c.Version + 1,
+		DeletedAt:       now,
+		TokensRevokedAt: now,
+	}
+	delete(s.totp, id)
+	for k, cred := range s.credentials {
+		if cred.ConsumerID == id {
+			delete(s.credentials, k)
+		}
+	}
+	for k, t := range s.tokens {
+		if t.ConsumerID == id {
+			delete(s.tokens, k)
+		}
+	}
+	for k, e := range s.exports {
+		if e.ConsumerID == id {
+			delete(s.exports, k)
+		}
+	}
+	for k, c := range s.codes {
+		if c.ConsumerID == id {
+			delete(s.codes, k)
+		}
+	}
+	for k, t := range s.refreshTokens {
+		if t.ConsumerID == id {
+			delete(s.refreshTokens, k)
+		}
+	}
+	for i := range s.audit {
+		if e := &s.audit[i]; e.ConsumerID == id {
+			prev := *e
+			s.onRollback(func() { *e = prev })
+			e.ErasePII()
+		}
+	}
+	messages := make(map[int]bool)
+	for i := range s.outbox {
+		if m := &s.outbox[i]; m.ConsumerID == id {
+			prev := *m
+			s.onRollback(func() { *m = prev })
+			m.ErasePII()
+			messages[m.ID] = true
+		}
+	}
+	for k, d := range s.deliveries {
+		if messages[d.MessageID] {
+			d.ErasePII()
+			s.deliveries[k] = d
+		}
+	}
+	return nil
+}
+
+// SaveStatusChange appends consumer's status transition to history
+func (s *Store) SaveStatusChange(ch models.StatusChange) error {
+	defer s.lock()()
+
+	s.lastChangeID++
+	ch.ID = s.lastChangeID
+	s.statusChanges = append(s.statusChanges, ch)
+	return nil
+}
+
+// StatusChanges returns consumer's status transitions ordered by time
+func (s *Store) StatusChanges(consumerID int) ([]models.StatusChange, error) {
+	defer s.rlock()()
+
+	var res []models.StatusChange
+	for _, ch := range s.statusChanges {
+		if ch.ConsumerID == consumerID {
+			res = append(res, ch)
+		}
+	}
+	return res, nil
+}
+
+// AppendAuditEvent assigns id to event, links it to the last event of audit log and appends it
+func (s *Store) AppendAuditEvent(e models.AuditEvent) (models.AuditEvent, error) {
+	defer s.lock()()
+
+	prevHash := ""
+	if n := len(s.audit); n > 0 {
+		prevHash = s.audit[n-1].Hash
+	}
+	e.ID = len(s.audit) + 1
+	e = copyAuditEvent(e)
+	e.Seal(prevHash)
+	s.audit = append(s.audit, e)
+	return copyAuditEvent(e), nil
+}
+
+// AuditEvents returns audit log events ordered by id
+func (s *Store) AuditEvents(f models.AuditFilter, page models.PageRequest) (models.AuditPage, error) {
+	after, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return models.AuditPage{}, err
+	}
+
+	defer s.rlock()()
+
+	var p models.AuditPage
+	for i := after; i < len(s.audit); i++ {
+		e := s.audit[i]
+		if !f.Match(e) {
+			continue
+		}
+		if len(p.Events) == page.Limit {
+			p.NextCursor = encodeCursor(p.Events[len(p.Events)-1].ID)
+			break
+		}
+		p.Events = append(p.Events, copyAuditEvent(e))
+	}
+	return p, nil
+}
+
+// AddOutboxMessage assigns id to message and saves it
+func (s *Store) AddOutboxMessage(m models.OutboxMessage) error {
+	defer s.lock()()
+
+	s.lastOutboxID++
+	m.ID = s.lastOutboxID
+	m.Payload = append([]byte(nil), m.Payload...)
+	s.outbox = append(s.outbox, m)
+	return nil
+}
+
+// PendingOutboxMessages returns up to limit not published messages ordered by id
+func (s *Store) PendingOutboxMessages(limit int) ([]models.OutboxMessage, error) {
+	defer s.rlock()()
+
+	var res []models.OutboxMessage
+	for _, m := range s.outbox {
+		if len(res) == limit {
+			break
+		}
+		if m.PublishedAt == 0 {
+			m.Payload = append([]byte(nil), m.Payload...)
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// MarkOutboxMessagePublished sets message's publication time
+func (s *Store) MarkOutboxMessagePublished(id int, publishedAt int64) error {
+	defer s.lock()()
+
+	for i := range s.outbox {
+		if m := &s.outbox[i]; m.ID == id {
+			prev := *m
+			s.onRollback(func() { *m = prev })
+			m.PublishedAt = publishedAt
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+// SaveWebhook creates webhook if its id is zero or replaces existing one
+func (s *Store) SaveWebhook(w models.Webhook) (models.Webhook, error) {
+	defer s.lock()()
+
+	if w.ID == 0 {
+		s.lastWebhookID++
+		w.ID = s.lastWebhookID
+	} else if _, ok := s.webhooks[w.ID]; !ok {
+		return models.Webhook{}, store.ErrNotFound
+	}
+	w.Events = append([]string(nil), w.Events...)
+	s.webhooks[w.ID] = w
+	return copyWebhook(w), nil
+}
+
+// Webhook returns webhook by id
+func (s *Store) Webhook(id int) (models.Webhook, error) {
+	defer s.rlock()()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return models.Webhook{}, store.ErrNotFound
+	}
+	return copyWebhook(w), nil
+}
+
+// Webhooks returns all webhooks ordered by id
+func (s *Store) Webhooks() ([]models.Webhook, error) {
+	defer s.rlock()()
+
+	res := make([]models.Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		res = append(res, copyWebhook(w))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// DeleteWebhook deletes webhook with its deliveries
+func (s *Store) DeleteWebhook(id int) error {
+	defer s.lock()()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.webhooks, id)
+	for k, d := range s.deliveries {
+		if d.WebhookID == id {
+			delete(s.deliveries, k)
+		}
+	}
+	return nil
+}
+
+// CreateWebhookDelivery assigns id to delivery and saves it
+func (s *Store) CreateWebhookDelivery(d models.WebhookDelivery) (models.WebhookDelivery, error) {
+	defer s.lock()()
+
+	for _, existing := range s.deliveries {
+		if existing.WebhookID == d.WebhookID && existing.MessageID == d.MessageID {
+			return models.WebhookDelivery{}, store.ErrAlreadyExists
+		}
+	}
+	s.lastDeliveryID++
+	d.ID = s.lastDeliveryID
+	d = copyDelivery(d)
+	s.deliveries[d.ID] = d
+	return copyDelivery(d), nil
+}
+
+// SaveWebhookDelivery replaces existing delivery
+func (s *Store) SaveWebhookDelivery(d models.WebhookDelivery) error {
+	defer s.lock()()
+
+	if _, ok := s.deliveries[d.ID]; !ok {
+		return store.ErrNotFound
+	}
+	s.deliveries[d.ID] = copyDelivery(d)
+	return nil
+}
+
+// WebhookDelivery returns delivery by id
+func (s *Store) WebhookDelivery(id int) (models.WebhookDelivery, error) {
+	defer s.rlock()()
+
+	d, ok := s.deliveries[id]
+	if !ok {
+		return models.WebhookDelivery{}, store.ErrNotFound
+	}
+	return copyDelivery(d), nil
+}
+
+// WebhookDeliveries returns webhook's deliveries ordered by id
+func (s *Store) WebhookDeliveries(webhookID int, page models.PageRequest) (models.WebhookDeliveryPage, error) {
+	defer s.rlock()()
+
+	after, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return models.WebhookDeliveryPage{}, err
+	}
+	var res models.WebhookDeliveryPage
+	for id := after + 1; id <= s.lastDeliveryID; id++ {
+		d, ok := s.deliveries[id]
+		if !ok || d.WebhookID != webhookID {
+			continue
+		}
+		if len(res.Deliveries) == page.Limit {
+			res.NextCursor = encodeCursor(res.Deliveries[len(res.Deliveries)-1].ID)
+			break
+		}
+		res.Deliveries = append(res.Deliveries, copyDelivery(d))
+	}
+	return res, nil
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next attempt time is not after now
+func (s *Store) DueWebhookDeliveries(now int64, limit int) ([]models.WebhookDelivery, error) {
+	defer s.rlock()()
+
+	var res []models.WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.Status == models.DeliveryPending && d.NextAttemptAt <= now {
+			res = append(res, d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].NextAttemptAt != res[j].NextAttemptAt {
+			return res[i].NextAttemptAt < res[j].NextAttemptAt
+		}
+		return res[i].ID < res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	for i := range res {
+		res[i] = copyDelivery(res[i])
+	}
+	return res, nil
+}
+
+// SaveTOTPFactor creates or replaces consumer's TOTP factor, version of saved factor is incremented
+func (s *Store) SaveTOTPFactor(f models.TOTPFactor) error {
+	defer s.lock()()
+
+	if s.totp[f.ConsumerID].Version != f.Version {
+		return store.ErrConflict
+	}
+	f.Version++
+	f.RecoveryCodes = append([]string(nil), f.RecoveryCodes...)
+	s.totp[f.ConsumerID] = f
+	return nil
+}
+
+// TOTPFactor returns consumer's TOTP factor
+func (s *Store) TOTPFactor(consumerID int) (models.TOTPFactor, error) {
+	defer s.rlock()()
+
+	f, ok := s.totp[consumerID]
+	if !ok {
+		return models.TOTPFactor{}, store.ErrNotFound
+	}
+	f.RecoveryCodes = append([]string(nil), f.RecoveryCodes...)
+	return f, nil
+}
+
+// SaveWebAuthnCredential creates or replaces WebAuthn credential
+func (s *Store) SaveWebAuthnCredential(c models.WebAuthnCredential) error {
+	defer s.lock()()
+
+	s.credentials[string(c.ID)] = copyCredential(c)
+	return nil
+}
+
+// WebAuthnCredential returns WebAuthn credential by id
+func (s *Store) WebAuthnCredential(id []byte) (models.WebAuthnCredential, error) {
+	defer s.rlock()()
+
+	c, ok := s.credentials[string(id)]
+	if !ok {
+		return models.WebAuthnCredential{}, store.ErrNotFound
+	}
+	return copyCredential(c), nil
+}
+
+// WebAuthnCredentials returns all consumer's WebAuthn credentials ordered by creation time
+func (s *Store) WebAuthnCredentials(consumerID int) ([]models.WebAuthnCredential, error) {
+	defer s.rlock()()
+
+	var res []models.WebAuthnCredential
+	for _, c := range s.credentials {
+		if c.ConsumerID == consumerID {
+			res = append(res, copyCredential(c))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt < res[j].CreatedAt })
+	return res, nil
+}
+
+// SaveWebAuthnChallenge saves challenge of started ceremony, expired challenges are deleted
+func (s *Store) SaveWebAuthnChallenge(c models.WebAuthnChallenge) error {
+	defer s.lock()()
+
+	now := time.Now().Unix()
+	for k, v := range s.challenges {
+		if v.ExpiresAt <= now {
+			delete(s.challenges, k)
+		}
+	}
+	s.challenges[c.Challenge] = c
+	return nil
+}
+
+// TakeWebAuthnChallenge returns challenge and deletes it
+func (s *Store) TakeWebAuthnChallenge(challenge string) (models.WebAuthnChallenge, error) {
+	defer s.lock()()
+
+	c, ok := s.challenges[challenge]
+	if !ok {
+		return models.WebAuthnChallenge{}, store.ErrNotFound
+	}
+	delete(s.challenges, challenge)
+	return c, nil
+}
+
+// SaveExport creates export if its id is zero or replaces existing one
+func (s *Store) SaveExport(e models.Export) (models.Export, error) {
+	defer s.lock()()
+
+	if e.ID == 0 {
+		s.lastExportID++
+		e.ID = s.lastExportID
+	} else if _, ok := s.exports[e.ID]; !ok {
+		return models.Export{}, store.ErrNotFound
+	}
+	e.Data = append([]byte(nil), e.Data...)
+	s.exports[e.ID] = e
+	return e, nil
+}
+
+// Export returns export by id
+func (s *Store) Export(id int) (models.Export, error) {
+	defer s.rlock()()
+
+	e, ok := s.exports[id]
+	if !ok {
+		return models.Export{}, store.ErrNotFound
+	}
+	e.Data = append([]byte(nil), e.Data...)
+	return e, nil
+}
+
+// LastExport returns the most recently created consumer's export
+func (s *Store) LastExport(consumerID int) (models.Export, error) {
+	defer s.rlock()()
+
+	var last models.Export
+	for _, e := range s.exports {
+		if e.ConsumerID == consumerID && e.ID > last.ID {
+			last = e
+		}
+	}
+	if last.ID == 0 {
+		return models.Export{}, store.ErrNotFound
+	}
+	last.Data = append([]byte(nil), last.Data...)
+	return last, nil
+}
+
+// PendingExports returns up to limit pending exports ordered by id
+func (s *Store) PendingExports(limit int) ([]models.Export, error) {
+	defer s.rlock()()
+
+	var res []models.Export
+	for _, e := range s.exports {
+		if e.Status == models.ExportPending {
+			e.Data = append([]byte(nil), e.Data...)
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// SaveOneTimeToken creates token if its id is zero or replaces existing one of the same version,
+// version of saved token is incremented
+func (s *Store) SaveOneTimeToken(t models.OneTimeToken) (models.OneTimeToken, error) {
+	defer s.lock()()
+
+	if t.ID == 0 {
+		s.lastTokenID++
+		t.ID = s.lastTokenID
+		t.Version = 0
+	} else if cur, ok := s.tokens[t.ID]; !ok {
+		return models.OneTimeToken{}, store.ErrNotFound
+	} else if cur.Version != t.Version {
+		return models.OneTimeToken{}, store.ErrConflict
+	}
+	t.Version++
+	s.tokens[t.ID] = t
+	return t, nil
+}
+
+// OneTimeToken returns token by id
+func (s *Store) OneTimeToken(id int) (models.OneTimeToken, error) {
+	defer s.rlock()()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return models.OneTimeToken{}, store.ErrNotFound
+	}
+	return t, nil
+}
+
+// LastOneTimeToken returns the most recently created consumer's token with purpose
+func (s *Store) LastOneTimeToken(consumerID int, purpose string) (models.OneTimeToken, error) {
+	defer s.rlock()()
+
+	var last models.OneTimeToken
+	for _, t := range s.tokens {
+		if t.ConsumerID == consumerID && t.Purpose == purpose && t.ID > last.ID {
+			last = t
+		}
+	}
+	if last.ID == 0 {
+		return models.OneTimeToken{}, store.ErrNotFound
+	}
+	return last, nil
+}
+
+// CreateOAuthClient saves client if there is no client with the same id
+func (s *Store) CreateOAuthClient(c models.OAuthClient) error {
+	defer s.lock()()
+
+	if _, ok := s.clients[c.ID]; ok {
+		return store.ErrAlreadyExists
+	}
+	c.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+	s.clients[c.ID] = c
+	return nil
+}
+
+// OAuthClient returns client by id
+func (s *Store) OAuthClient(id string) (models.OAuthClient, error) {
+	defer s.rlock()()
+
+	c, ok := s.clients[id]
+	if !ok {
+		return models.OAuthClient{}, store.ErrNotFound
+	}
+	c.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+	return c, nil
+}
+
+// OAuthClients returns all clients ordered by creation time
+func (s *Store) OAuthClients() ([]models.OAuthClient, error) {
+	defer s.rlock()()
+
+	res := make([]models.OAuthClient, 0, len(s.clients))
+	for _, c := range s.clients {
+		c.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].CreatedAt != res[j].CreatedAt {
+			return res[i].CreatedAt < res[j].CreatedAt
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// DeleteOAuthClient deletes client by id with its authorization codes and refresh tokens
+func (s *Store) DeleteOAuthClient(id string) error {
+	defer s.lock()()
+
+	if _, ok := s.clients[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.clients, id)
+	for k, c := range s.codes {
+		if c.ClientID == id {
+			delete(s.codes, k)
+		}
+	}
+	for k, t := range s.refreshTokens {
+		if t.ClientID == id {
+			delete(s.refreshTokens, k)
+		}
+	}
+	return nil
+}
+
+// SaveOAuthCode saves authorization code, expired codes are deleted
+func (s *Store) SaveOAuthCode(c models.OAuthCode) error {
+	defer s.lock()()
+
+	now := time.Now().Unix()
+	for k, v := range s.codes {
+		if v.ExpiresAt <= now {
+			delete(s.codes, k)
+		}
+	}
+	c.AMR = append([]string(nil), c.AMR...)
+	s.codes[c.Hash] = c
+	return nil
+}
+
+// TakeOAuthCode returns authorization code by hash and deletes it
+func (s *Store) TakeOAuthCode(hash string) (models.OAuthCode, error) {
+	defer s.lock()()
+
+	c, ok := s.codes[hash]
+	if !ok {
+		return models.OAuthCode{}, store.ErrNotFound
+	}
+	delete(s.codes, hash)
+	return c, nil
+}
+
+// SaveRefreshToken saves refresh token, expired tokens are deleted
+func (s *Store) SaveRefreshToken(t models.RefreshToken) error {
+	defer s.lock()()
+
+	now := time.Now().Unix()
+	for k, v := range s.refreshTokens {
+		if v.ExpiresAt <= now {
+			delete(s.refreshTokens, k)
+		}
+	}
+	s.refreshTokens[t.Hash] = t
+	return nil
+}
+
+// RefreshToken returns refresh token by hash
+func (s *Store) RefreshToken(hash string) (models.RefreshToken, error) {
+	defer s.lock()()
+
+	t, ok := s.refreshTokens[hash]
+	if !ok {
+		return models.RefreshToken{}, store.ErrNotFound
+	}
+	return t, nil
+}
+
+// UseRefreshToken marks refresh token as used if it hasn't been used yet
+func (s *Store) UseRefreshToken(hash string, usedAt int64) error {
+	defer s.lock()()
+
+	t, ok := s.refreshTokens[hash]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if t.UsedAt != 0 {
+		return store.ErrConflict
+	}
+	t.UsedAt = usedAt
+	s.refreshTokens[hash] = t
+	return nil
+}
+
+// RevokeRefreshTokens deletes all refresh tokens of family
+func (s *Store) RevokeRefreshTokens(familyID string) error {
+	defer s.lock()()
+
+	for k, t := range s.refreshTokens {
+		if t.FamilyID == familyID {
+			delete(s.refreshTokens, k)
+		}
+	}
+	return nil
+}
+
+// ConsumerRefreshTokens returns refresh tokens issued to consumer ordered by creation time
+func (s *Store) ConsumerRefreshTokens(consumerID int) ([]models.RefreshToken, error) {
+	defer s.rlock()()
+
+	var res []models.RefreshToken
+	for _, t := range s.refreshTokens {
+		if t.ConsumerID == consumerID {
+			res = append(res, t)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt < res[j].CreatedAt })
+	return res, nil
+}
+
+// clone returns copy of state which Atomic restores on rollback. Maps are copied,
+// stored values are copied on save and never modified in place, so they are shared.
+// Logs are append-only and share backing arrays: entries appended later lie beyond copied lengths
+// and in-place changes of entries are reverted by functions registered with onRollback.
+func (s *state) clone() *state {
+	c := *s
+	c.consumers = make(map[int]models.Consumer, len(s.consumers))
+	for k, v := range s.consumers {
+		c.consumers[k] = v
+	}
+	c.byEmail = make(map[string]int, len(s.byEmail))
+	for k, v := range s.byEmail {
+		c.byEmail[k] = v
+	}
+	c.totp = make(map[int]models.TOTPFactor, len(s.totp))
+	for k, v := range s.totp {
+		c.totp[k] = v
+	}
+	c.credentials = make(map[string]models.WebAuthnCredential, len(s.credentials))
+	for k, v := range s.credentials {
+		c.credentials[k] = v
+	}
+	c.challenges = make(map[string]models.WebAuthnChallenge, len(s.challenges))
+	for k, v := range s.challenges {
+		c.challenges[k] = v
+	}
+	c.tokens = make(map[int]models.OneTimeToken, len(s.tokens))
+	for k, v := range s.tokens {
+		c.tokens[k] = v
+	}
+	c.exports = make(map[int]models.Export, len(s.exports))
+	for k, v := range s.exports {
+		c.exports[k] = v
+	}
+	c.webhooks = make(map[int]models.Webhook, len(s.webhooks))
+	for k, v := range s.webhooks {
+		c.webhooks[k] = v
+	}
+	c.deliveries = make(map[int]models.WebhookDelivery, len(s.deliveries))
+	for k, v := range s.deliveries {
+		c.deliveries[k] = v
+	}
+	c.clients = make(map[string]models.OAuthClient, len(s.clients))
+	for k, v := range s.clients {
+		c.clients[k] = v
+	}
+	c.codes = make(map[string]models.OAuthCode, len(s.codes))
+	for k, v := range s.codes {
+		c.codes[k] = v
+	}
+	c.refreshTokens = make(map[string]models.RefreshToken, len(s.refreshTokens))
+	for k, v := range s.refreshTokens {
+		c.refreshTokens[k] = v
+	}
+	return &c
+}
+
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, store.ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(string(b))
+	if err != nil || id < 0 {
+		return 0, store.ErrInvalidCursor
+	}
+	return id, nil
+}
+
+func copyConsumer(c models.Consumer) models.Consumer {
+	c.Roles = append([]string(nil), c.Roles...)
+	if c.Profile.Attributes != nil {
+		attrs := make(map[string]string, len(c.Profile.Attributes))
+		for k, v := range c.Profile.Attributes {
+			attrs[k] = v
+		}
+		c.Profile.Attributes = attrs
+	}
+	return c
+}
+
+func copyAuditEvent(e models.AuditEvent) models.AuditEvent {
+	if e.Details != nil {
+		details := make(map[string]string, len(e.Details))
+		for k, v := range e.Details {
+			details[k] = v
+		}
+		e.Details = details
+	}
+	e.PIISalt = append([]byte(nil), e.PIISalt...)
+	return e
+}
+
+func copyWebhook(w models.Webhook) models.Webhook {
+	w.Events = append([]string(nil), w.Events...)
+	return w
+}
+
+func copyDelivery(d models.WebhookDelivery) models.WebhookDelivery {
+	d.Payload = append([]byte(nil), d.Payload...)
+	d.Attempts = append([]models.WebhookAttempt(nil), d.Attempts...)
+	return d
+}
+
+func copyCredential(c models.WebAuthnCredential) models.WebAuthnCredential {
+	c.ID = append([]byte(nil), c.ID...)
+	c.PublicKey = append([]byte(nil), c.PublicKey...)
+	c.AAGUID = append([]byte(nil), c.AAGUID...)
+	return c
+}
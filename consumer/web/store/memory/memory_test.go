@@ -0,0 +1,574 @@
+package memory_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+	"github.com/nsmak/consumerService/consumer/web/store/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Consumer(t *testing.T) {
+	s := memory.New()
+
+	c, err := s.CreateConsumer(models.Consumer{Email: "Test@test.com", Roles: []string{models.RoleConsumer}})
+	require.NoError(t, err)
+	require.Equal(t, 1, c.ID)
+	require.Equal(t, 1, c.Version)
+	require.NotZero(t, c.RegTimestamp)
+
+	_, err = s.CreateConsumer(models.Consumer{Email: "test@TEST.com"})
+	require.Equal(t, store.ErrAlreadyExists, err)
+
+	exists, err := s.ConsumerIsExist("test@test.com")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	got, err := s.Consumer("test@test.com")
+	require.NoError(t, err)
+	require.Equal(t, c, got)
+
+	got.Roles[0] = models.RoleAdmin
+	got, err = s.ConsumerByID(c.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{models.RoleConsumer}, got.Roles)
+
+	_, err = s.ConsumerByID(2)
+	require.Equal(t, store.ErrNotFound, err)
+}
+
+func TestStore_UpdateConsumer(t *testing.T) {
+	s := memory.New()
+	c, err := s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+
+	c.Profile.Locale = "en"
+	updated, err := s.UpdateConsumer(c)
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+	require.Equal(t, "en", updated.Profile.Locale)
+
+	_, err = s.UpdateConsumer(c)
+	require.Equal(t, store.ErrConflict, err)
+
+	_, err = s.CreateConsumer(models.Consumer{Email: "other@test.com"})
+	require.NoError(t, err)
+	updated.Email = "other@test.com"
+	_, err = s.UpdateConsumer(updated)
+	require.Equal(t, store.ErrAlreadyExists, err)
+
+	updated.Email = "new@test.com"
+	_, err = s.UpdateConsumer(updated)
+	require.NoError(t, err)
+	_, err = s.Consumer("test@test.com")
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.Consumer("new@test.com")
+	require.NoError(t, err)
+}
+
+func TestStore_DeleteConsumer(t *testing.T) {
+	s := memory.New()
+	c, err := s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteConsumer(c.ID))
+	require.Equal(t, store.ErrNotFound, s.DeleteConsumer(c.ID))
+
+	_, err = s.ConsumerByID(c.ID)
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.Consumer(c.Email)
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.UpdateConsumer(c)
+	require.Equal(t, store.ErrNotFound, err)
+
+	p, err := s.ListConsumers(models.ConsumerFilter{}, models.PageRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, p.Consumers)
+
+	_, err = s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+}
+
+func TestStore_ListConsumers(t *testing.T) {
+	s := memory.New()
+	for i, email := range []string{"a@test.com", "b@test.com", "c@test.com", "d@test.com", "e@test.com"} {
+		_, err := s.CreateConsumer(models.Consumer{Email: email, RegTimestamp: int64(i + 1), EmailVerified: i%2 == 0})
+		require.NoError(t, err)
+	}
+	verified := true
+
+	tests := []struct {
+		name   string
+		filter models.ConsumerFilter
+		limit  int
+		pages  [][]int
+	}{
+		{name: "all in one page", limit: 10, pages: [][]int{{1, 2, 3, 4, 5}}},
+		{name: "paginated", limit: 2, pages: [][]int{{1, 2}, {3, 4}, {5}}},
+		{name: "exact pages", limit: 5, pages: [][]int{{1, 2, 3, 4, 5}}},
+		{name: "created range", filter: models.ConsumerFilter{CreatedFrom: 2, CreatedTo: 4}, limit: 10, pages: [][]int{{2, 3}}},
+		{name: "verified", filter: models.ConsumerFilter{Verified: &verified}, limit: 2, pages: [][]int{{1, 3}, {5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := models.PageRequest{Limit: tt.limit}
+			for i, want := range tt.pages {
+				p, err := s.ListConsumers(tt.filter, page)
+				require.NoError(t, err)
+
+				var ids []int
+				for _, c := range p.Consumers {
+					ids = append(ids, c.ID)
+				}
+				require.Equal(t, want, ids)
+				if i == len(tt.pages)-1 {
+					require.Empty(t, p.NextCursor)
+				} else {
+					require.NotEmpty(t, p.NextCursor)
+				}
+				page.Cursor = p.NextCursor
+			}
+		})
+	}
+
+	_, err := s.ListConsumers(models.ConsumerFilter{}, models.PageRequest{Cursor: "!", Limit: 10})
+	require.Equal(t, store.ErrInvalidCursor, err)
+}
+
+func TestStore_TOTPFactor(t *testing.T) {
+	s := memory.New()
+	require.NoError(t, s.SaveTOTPFactor(models.TOTPFactor{ConsumerID: 1, Secret: "secret"}))
+	f, err := s.TOTPFactor(1)
+	require.NoError(t, err)
+	require.Equal(t, 1, f.Version)
+
+	f.Confirmed = true
+	require.NoError(t, s.SaveTOTPFactor(f))
+	f.LastUsedStep = 10
+	require.Equal(t, store.ErrConflict, s.SaveTOTPFactor(f), "factor was saved since it was read")
+	require.Equal(t, store.ErrConflict, s.SaveTOTPFactor(models.TOTPFactor{ConsumerID: 1}))
+
+	saved, err := s.TOTPFactor(1)
+	require.NoError(t, err)
+	require.True(t, saved.Confirmed)
+	require.Zero(t, saved.LastUsedStep)
+}
+
+func TestStore_OneTimeToken(t *testing.T) {
+	s := memory.New()
+
+	_, err := s.LastOneTimeToken(1, models.PurposeLoginCode)
+	require.Equal(t, store.ErrNotFound, err)
+
+	first, err := s.SaveOneTimeToken(models.OneTimeToken{ConsumerID: 1, Purpose: models.PurposeLoginCode})
+	require.NoError(t, err)
+	second, err := s.SaveOneTimeToken(models.OneTimeToken{ConsumerID: 1, Purpose: models.PurposeLoginCode})
+	require.NoError(t, err)
+	_, err = s.SaveOneTimeToken(models.OneTimeToken{ConsumerID: 1, Purpose: models.PurposeLoginLink})
+	require.NoError(t, err)
+
+	last, err := s.LastOneTimeToken(1, models.PurposeLoginCode)
+	require.NoError(t, err)
+	require.Equal(t, second, last)
+
+	first.UsedAt = 1
+	saved, err := s.SaveOneTimeToken(first)
+	require.NoError(t, err)
+	require.Equal(t, first.Version+1, saved.Version)
+	got, err := s.OneTimeToken(first.ID)
+	require.NoError(t, err)
+	require.Equal(t, saved, got)
+	_, err = s.SaveOneTimeToken(first)
+	require.Equal(t, store.ErrConflict, err, "stale version")
+
+	_, err = s.SaveOneTimeToken(models.OneTimeToken{ID: 100})
+	require.Equal(t, store.ErrNotFound, err)
+}
+
+func TestStore_WebAuthnCredentials(t *testing.T) {
+	s := memory.New()
+	require.NoError(t, s.SaveWebAuthnCredential(models.WebAuthnCredential{ID: []byte{2}, ConsumerID: 1, CreatedAt: 2}))
+	require.NoError(t, s.SaveWebAuthnCredential(models.WebAuthnCredential{ID: []byte{1}, ConsumerID: 1, CreatedAt: 1}))
+	require.NoError(t, s.SaveWebAuthnCredential(models.WebAuthnCredential{ID: []byte{3}, ConsumerID: 2, CreatedAt: 3}))
+
+	creds, err := s.WebAuthnCredentials(1)
+	require.NoError(t, err)
+	require.Len(t, creds, 2)
+	require.Equal(t, []byte{1}, creds[0].ID)
+	require.Equal(t, []byte{2}, creds[1].ID)
+
+	_, err = s.WebAuthnCredential([]byte{4})
+	require.Equal(t, store.ErrNotFound, err)
+}
+
+func TestStore_WebAuthnChallenges(t *testing.T) {
+	s := memory.New()
+	require.NoError(t, s.SaveWebAuthnChallenge(models.WebAuthnChallenge{Challenge: "expired", ExpiresAt: 1}))
+	require.NoError(t, s.SaveWebAuthnChallenge(models.WebAuthnChallenge{Challenge: "a", ConsumerID: 1, ExpiresAt: time.Now().Add(time.Minute).Unix()}))
+
+	c, err := s.TakeWebAuthnChallenge("a")
+	require.NoError(t, err)
+	require.Equal(t, 1, c.ConsumerID)
+	_, err = s.TakeWebAuthnChallenge("a")
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.TakeWebAuthnChallenge("expired")
+	require.Equal(t, store.ErrNotFound, err)
+}
+
+func TestStore_StatusChanges(t *testing.T) {
+	s := memory.New()
+	c, err := s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+	require.Equal(t, models.StatusActive, c.Status)
+
+	require.NoError(t, s.SaveStatusChange(models.StatusChange{ConsumerID: 1, From: models.StatusActive, To: models.StatusSuspended}))
+	require.NoError(t, s.SaveStatusChange(models.StatusChange{ConsumerID: 2, From: models.StatusActive, To: models.StatusLocked}))
+	require.NoError(t, s.SaveStatusChange(models.StatusChange{ConsumerID: 1, From: models.StatusSuspended, To: models.StatusActive}))
+
+	changes, err := s.StatusChanges(1)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, models.StatusSuspended, changes[0].To)
+	require.Equal(t, models.StatusActive, changes[1].To)
+	require.NotEqual(t, changes[0].ID, changes[1].ID)
+}
+
+func TestStore_PurgeConsumer(t *testing.T) {
+	s := memory.New()
+	c, err := s.CreateConsumer(models.Consumer{Email: "test@test.com", PassHash: "hash", Profile: models.Profile{Phone: "+12345678"}})
+	require.NoError(t, err)
+	other, err := s.CreateConsumer(models.Consumer{Email: "other@test.com"})
+	require.NoError(t, err)
+	require.NoError(t, s.SaveTOTPFactor(models.TOTPFactor{ConsumerID: c.ID}))
+	require.NoError(t, s.SaveWebAuthnCredential(models.WebAuthnCredential{ID: []byte{1}, ConsumerID: c.ID}))
+	_, err = s.SaveOneTimeToken(models.OneTimeToken{ConsumerID: c.ID, Purpose: models.PurposeLoginCode})
+	require.NoError(t, err)
+
+	c.Status, c.DeletionScheduledAt = models.StatusPendingDeletion, 100
+	_, err = s.UpdateConsumer(c)
+	require.NoError(t, err)
+	other.Status, other.DeletionScheduledAt = models.StatusPendingDeletion, 200
+	_, err = s.UpdateConsumer(other)
+	require.NoError(t, err)
+
+	scheduled, err := s.ConsumersScheduledForDeletion(150, 10)
+	require.NoError(t, err)
+	require.Len(t, scheduled, 1)
+	require.Equal(t, c.ID, scheduled[0].ID)
+
+	require.NoError(t, s.PurgeConsumer(c.ID))
+
+	_, err = s.Consumer("test@test.com")
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.ConsumerByID(c.ID)
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.TOTPFactor(c.ID)
+	require.Equal(t, store.ErrNotFound, err)
+	creds, err := s.WebAuthnCredentials(c.ID)
+	require.NoError(t, err)
+	require.Empty(t, creds)
+	_, err = s.LastOneTimeToken(c.ID, models.PurposeLoginCode)
+	require.Equal(t, store.ErrNotFound, err)
+
+	scheduled, err = s.ConsumersScheduledForDeletion(150, 10)
+	require.NoError(t, err)
+	require.Empty(t, scheduled)
+
+	_, err = s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+}
+
+func TestStore_Export(t *testing.T) {
+	s := memory.New()
+	_, err := s.LastExport(1)
+	require.Equal(t, store.ErrNotFound, err)
+
+	first, err := s.SaveExport(models.Export{ConsumerID: 1, Status: models.ExportPending})
+	require.NoError(t, err)
+	second, err := s.SaveExport(models.Export{ConsumerID: 1, Status: models.ExportPending})
+	require.NoError(t, err)
+
+	first.Status, first.Data = models.ExportReady, []byte("data")
+	_, err = s.SaveExport(first)
+	require.NoError(t, err)
+	got, err := s.Export(first.ID)
+	require.NoError(t, err)
+	require.Equal(t, first, got)
+
+	last, err := s.LastExport(1)
+	require.NoError(t, err)
+	require.Equal(t, second.ID, last.ID)
+
+	pending, err := s.PendingExports(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, second.ID, pending[0].ID)
+
+	_, err = s.SaveExport(models.Export{ID: 100})
+	require.Equal(t, store.ErrNotFound, err)
+}
+
+func TestStore_AuditEvents(t *testing.T) {
+	s := memory.New()
+	for i := 0; i < 5; i++ {
+		e := models.AuditEvent{Type: models.AuditLoginFailed, ConsumerID: i%2 + 1, IP: "127.0.0.1"}
+		if i == 4 {
+			e.Type = models.AuditLoginSucceeded
+		}
+		require.NoError(t, e.SealPII())
+		appended, err := s.AppendAuditEvent(e)
+		require.NoError(t, err)
+		require.Equal(t, i+1, appended.ID)
+	}
+
+	var all []models.AuditEvent
+	page := models.PageRequest{Limit: 2}
+	for {
+		p, err := s.AuditEvents(models.AuditFilter{}, page)
+		require.NoError(t, err)
+		all = append(all, p.Events...)
+		if p.NextCursor == "" {
+			break
+		}
+		page.Cursor = p.NextCursor
+	}
+	require.Len(t, all, 5)
+	require.NoError(t, models.VerifyAuditChain("", all))
+
+	p, err := s.AuditEvents(models.AuditFilter{ConsumerID: 1, Type: models.AuditLoginFailed}, models.PageRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, p.Events, 2)
+	require.Equal(t, 1, p.Events[0].ID)
+	require.Equal(t, 3, p.Events[1].ID)
+
+	c, err := s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+	require.NoError(t, s.PurgeConsumer(c.ID))
+	p, err = s.AuditEvents(models.AuditFilter{}, models.PageRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, p.Events[0].IP)
+	require.Equal(t, "127.0.0.1", p.Events[1].IP)
+	require.NoError(t, models.VerifyAuditChain("", p.Events))
+}
+
+func TestStore_Atomic(t *testing.T) {
+	s := memory.New()
+	txErr := errors.New("tx error")
+
+	err := s.Atomic(func(tx store.DataStore) error {
+		if _, err := tx.CreateConsumer(models.Consumer{Email: "rollback@test.com"}); err != nil {
+			return err
+		}
+		require.NoError(t, tx.AddOutboxMessage(models.OutboxMessage{Type: models.EventConsumerRegistered}))
+		return txErr
+	})
+	require.Equal(t, txErr, err)
+	exists, err := s.ConsumerIsExist("rollback@test.com")
+	require.NoError(t, err)
+	require.False(t, exists)
+	pending, err := s.PendingOutboxMessages(10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	err = s.Atomic(func(tx store.DataStore) error {
+		c, err := tx.CreateConsumer(models.Consumer{Email: "test@test.com"})
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxMessage(models.OutboxMessage{Type: models.EventConsumerRegistered, ConsumerID: c.ID})
+	})
+	require.NoError(t, err)
+	c, err := s.Consumer("test@test.com")
+	require.NoError(t, err)
+	require.Equal(t, 1, c.ID)
+	pending, err = s.PendingOutboxMessages(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, 1, pending[0].ID)
+	require.Equal(t, 1, pending[0].ConsumerID)
+}
+
+func TestStore_PurgeConsumerEvents(t *testing.T) {
+	s := memory.New()
+	c, err := s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+	m, err := models.NewOutboxMessage(models.ConsumerRegistered{ConsumerID: c.ID, Email: c.Email}, 10)
+	require.NoError(t, err)
+	require.NoError(t, s.AddOutboxMessage(m))
+	d, err := s.CreateWebhookDelivery(models.WebhookDelivery{WebhookID: 1, MessageID: 1, Payload: []byte(`{"id":1,"data":{"email":"test@test.com"}}`)})
+	require.NoError(t, err)
+	txErr := errors.New("tx error")
+
+	err = s.Atomic(func(tx store.DataStore) error {
+		require.NoError(t, tx.PurgeConsumer(c.ID))
+		require.NoError(t, tx.MarkOutboxMessagePublished(1, 100))
+		return txErr
+	})
+	require.Equal(t, txErr, err)
+	pending, err := s.PendingOutboxMessages(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1, "publication is rolled back")
+	require.Contains(t, string(pending[0].Payload), c.Email, "erasure is rolled back")
+
+	require.NoError(t, s.PurgeConsumer(c.ID))
+	pending, err = s.PendingOutboxMessages(10)
+	require.NoError(t, err)
+	require.NotContains(t, string(pending[0].Payload), c.Email)
+	d, err = s.WebhookDelivery(d.ID)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":1,"data":{}}`, string(d.Payload))
+}
+
+func TestStore_Outbox(t *testing.T) {
+	s := memory.New()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.AddOutboxMessage(models.OutboxMessage{Type: models.EventConsumerDeleted, Payload: []byte("{}")}))
+	}
+	require.NoError(t, s.MarkOutboxMessagePublished(2, 100))
+	require.Equal(t, store.ErrNotFound, s.MarkOutboxMessagePublished(4, 100))
+
+	pending, err := s.PendingOutboxMessages(1)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, 1, pending[0].ID)
+	pending, err = s.PendingOutboxMessages(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, 3, pending[1].ID)
+}
+
+func TestStore_Webhooks(t *testing.T) {
+	s := memory.New()
+	w, err := s.SaveWebhook(models.Webhook{URL: "https://partner.test", Enabled: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, w.ID)
+	_, err = s.SaveWebhook(models.Webhook{ID: 5})
+	require.Equal(t, store.ErrNotFound, err)
+
+	d1, err := s.CreateWebhookDelivery(models.WebhookDelivery{WebhookID: w.ID, MessageID: 1, Status: models.DeliveryPending, NextAttemptAt: 20})
+	require.NoError(t, err)
+	_, err = s.CreateWebhookDelivery(models.WebhookDelivery{WebhookID: w.ID, MessageID: 1})
+	require.Equal(t, store.ErrAlreadyExists, err)
+	d2, err := s.CreateWebhookDelivery(models.WebhookDelivery{WebhookID: w.ID, MessageID: 2, Status: models.DeliveryPending, NextAttemptAt: 10})
+	require.NoError(t, err)
+
+	due, err := s.DueWebhookDeliveries(15, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, d2.ID, due[0].ID)
+	due, err = s.DueWebhookDeliveries(20, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int{d2.ID, d1.ID}, []int{due[0].ID, due[1].ID})
+
+	d1.Status = models.DeliverySucceeded
+	require.NoError(t, s.SaveWebhookDelivery(d1))
+	due, err = s.DueWebhookDeliveries(20, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+
+	p, err := s.WebhookDeliveries(w.ID, models.PageRequest{Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, d1.ID, p.Deliveries[0].ID)
+	p, err = s.WebhookDeliveries(w.ID, models.PageRequest{Limit: 1, Cursor: p.NextCursor})
+	require.NoError(t, err)
+	require.Equal(t, d2.ID, p.Deliveries[0].ID)
+	require.Empty(t, p.NextCursor)
+
+	require.NoError(t, s.DeleteWebhook(w.ID))
+	_, err = s.WebhookDelivery(d1.ID)
+	require.Equal(t, store.ErrNotFound, err)
+	hooks, err := s.Webhooks()
+	require.NoError(t, err)
+	require.Empty(t, hooks)
+}
+
+func TestStore_OAuthClients(t *testing.T) {
+	s := memory.New()
+	uris := []string{"https://billing.test/cb"}
+	require.NoError(t, s.CreateOAuthClient(models.OAuthClient{ID: "b", Name: "billing", RedirectURIs: uris, CreatedAt: 10}))
+	uris[0] = "https://attacker.test/cb"
+	require.NoError(t, s.CreateOAuthClient(models.OAuthClient{ID: "a", Name: "analytics", CreatedAt: 10}))
+	require.NoError(t, s.CreateOAuthClient(models.OAuthClient{ID: "c", CreatedAt: 5}))
+	require.Equal(t, store.ErrAlreadyExists, s.CreateOAuthClient(models.OAuthClient{ID: "a"}))
+
+	c, err := s.OAuthClient("b")
+	require.NoError(t, err)
+	require.Equal(t, "billing", c.Name)
+	require.Equal(t, []string{"https://billing.test/cb"}, c.RedirectURIs, "client is copied on save")
+	c.RedirectURIs[0] = "https://attacker.test/cb"
+	c, err = s.OAuthClient("b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://billing.test/cb"}, c.RedirectURIs, "client is copied on read")
+	clients, err := s.OAuthClients()
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a", "b"}, []string{clients[0].ID, clients[1].ID, clients[2].ID})
+
+	require.NoError(t, s.DeleteOAuthClient("b"))
+	_, err = s.OAuthClient("b")
+	require.Equal(t, store.ErrNotFound, err)
+	require.Equal(t, store.ErrNotFound, s.DeleteOAuthClient("b"))
+}
+
+func TestStore_OAuthCodes(t *testing.T) {
+	s := memory.New()
+	require.NoError(t, s.SaveOAuthCode(models.OAuthCode{Hash: "expired", ExpiresAt: 1}))
+	require.NoError(t, s.SaveOAuthCode(models.OAuthCode{Hash: "a", ConsumerID: 1, ExpiresAt: time.Now().Add(time.Minute).Unix()}))
+
+	c, err := s.TakeOAuthCode("a")
+	require.NoError(t, err)
+	require.Equal(t, 1, c.ConsumerID)
+	_, err = s.TakeOAuthCode("a")
+	require.Equal(t, store.ErrNotFound, err)
+	_, err = s.TakeOAuthCode("expired")
+	require.Equal(t, store.ErrNotFound, err)
+}
+
+func TestStore_RefreshTokens(t *testing.T) {
+	s := memory.New()
+	expires := time.Now().Add(time.Hour).Unix()
+	require.NoError(t, s.SaveRefreshToken(models.RefreshToken{Hash: "expired", FamilyID: "expired", ExpiresAt: 1}))
+	require.NoError(t, s.SaveRefreshToken(models.RefreshToken{Hash: "a", FamilyID: "a", ClientID: "web", ConsumerID: 1, ExpiresAt: expires}))
+	require.NoError(t, s.SaveRefreshToken(models.RefreshToken{Hash: "b", FamilyID: "a", ClientID: "web", ConsumerID: 1, ExpiresAt: expires}))
+	require.NoError(t, s.SaveRefreshToken(models.RefreshToken{Hash: "c", FamilyID: "c", ClientID: "cli", ConsumerID: 1, ExpiresAt: expires}))
+	require.NoError(t, s.SaveRefreshToken(models.RefreshToken{Hash: "d", FamilyID: "d", ClientID: "web", ConsumerID: 2, ExpiresAt: expires}))
+
+	rt, err := s.RefreshToken("a")
+	require.NoError(t, err)
+	require.Equal(t, "web", rt.ClientID)
+	require.NoError(t, s.UseRefreshToken("a", 10))
+	require.Equal(t, store.ErrConflict, s.UseRefreshToken("a", 20), "token can be used once")
+	rt, err = s.RefreshToken("a")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), rt.UsedAt, "the first use is kept")
+	_, err = s.RefreshToken("expired")
+	require.Equal(t, store.ErrNotFound, err)
+	require.Equal(t, store.ErrNotFound, s.UseRefreshToken("expired", 10))
+
+	tokens, err := s.ConsumerRefreshTokens(1)
+	require.NoError(t, err)
+	require.Len(t, tokens, 3)
+	for _, rt := range tokens {
+		require.Equal(t, 1, rt.ConsumerID)
+	}
+
+	require.NoError(t, s.RevokeRefreshTokens("a"))
+	_, err = s.RefreshToken("b")
+	require.Equal(t, store.ErrNotFound, err)
+
+	require.NoError(t, s.CreateOAuthClient(models.OAuthClient{ID: "cli"}))
+	require.NoError(t, s.DeleteOAuthClient("cli"))
+	_, err = s.RefreshToken("c")
+	require.Equal(t, store.ErrNotFound, err, "tokens are deleted with client")
+
+	_, err = s.CreateConsumer(models.Consumer{Email: "a@test.com"})
+	require.NoError(t, err)
+	_, err = s.CreateConsumer(models.Consumer{Email: "b@test.com"})
+	require.NoError(t, err)
+	require.NoError(t, s.PurgeConsumer(2))
+	_, err = s.RefreshToken("d")
+	require.Equal(t, store.ErrNotFound, err, "tokens are deleted with consumer")
+}
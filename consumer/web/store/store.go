@@ -1,12 +1,131 @@
 package store
 
-import "github.com/nsmak/consumerService/consumer/models"
+import (
+	"context"
+	"errors"
+
+	"github.com/nsmak/consumerService/consumer/models"
+)
 
 //go:generate mockgen -destination=../domain/mock_db_test.go -package=domain_test -source=store.go DataStore
+//go:generate mockgen -destination=../api/mock_db_test.go -package=api_test -source=store.go DataStore
+
+var (
+	// ErrNotFound is returned when requested entity doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when entity with the same unique key exists
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrConflict is returned when entity was modified since it was read
+	ErrConflict = errors.New("version conflict")
+	// ErrInvalidCursor is returned when page cursor is malformed
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
 
 // DataStore - interface of data storage service
 type DataStore interface {
+	// Ping returns error if storage isn't available
+	Ping(ctx context.Context) error
+	// Atomic calls fn with store whose changes are committed only if fn returns nil.
+	// Store passed to fn must not be used after fn returns.
+	Atomic(fn func(tx DataStore) error) error
+
 	CreateConsumer(c models.Consumer) (models.Consumer, error)
 	ConsumerIsExist(email string) (bool, error)
 	Consumer(email string) (models.Consumer, error)
+	ConsumerByID(id int) (models.Consumer, error)
+	// UpdateConsumer saves consumer if its version matches stored one and returns it with new version
+	UpdateConsumer(c models.Consumer) (models.Consumer, error)
+	// DeleteConsumer marks consumer as deleted, deleted consumers are not returned by other methods
+	DeleteConsumer(id int) error
+	// ListConsumers returns consumers ordered by id
+	ListConsumers(f models.ConsumerFilter, page models.PageRequest) (models.ConsumerPage, error)
+	// ConsumersScheduledForDeletion returns up to limit consumers pending deletion whose deletion time is before
+	ConsumersScheduledForDeletion(before int64, limit int) ([]models.Consumer, error)
+	// PurgeConsumer erases consumer's personal data, credentials and tokens, anonymized row is kept.
+	// Personal data is erased from consumer's audit events too.
+	PurgeConsumer(id int) error
+
+	SaveStatusChange(ch models.StatusChange) error
+	// StatusChanges returns consumer's status transitions ordered by time
+	StatusChanges(consumerID int) ([]models.StatusChange, error)
+
+	// AppendAuditEvent assigns id to event, links it to the last event of audit log and appends it
+	AppendAuditEvent(e models.AuditEvent) (models.AuditEvent, error)
+	// AuditEvents returns audit log events ordered by id
+	AuditEvents(f models.AuditFilter, page models.PageRequest) (models.AuditPage, error)
+
+	// AddOutboxMessage assigns id to message and saves it, it must be called within Atomic
+	// together with the change which caused the event
+	AddOutboxMessage(m models.OutboxMessage) error
+	// PendingOutboxMessages returns up to limit not published messages ordered by id
+	PendingOutboxMessages(limit int) ([]models.OutboxMessage, error)
+	MarkOutboxMessagePublished(id int, publishedAt int64) error
+
+	// SaveWebhook creates webhook if its id is zero or replaces existing one
+	SaveWebhook(w models.Webhook) (models.Webhook, error)
+	Webhook(id int) (models.Webhook, error)
+	// Webhooks returns all webhooks ordered by id
+	Webhooks() ([]models.Webhook, error)
+	// DeleteWebhook deletes webhook with its deliveries
+	DeleteWebhook(id int) error
+	// CreateWebhookDelivery assigns id to delivery and saves it,
+	// ErrAlreadyExists is returned if the message was already queued for the webhook
+	CreateWebhookDelivery(d models.WebhookDelivery) (models.WebhookDelivery, error)
+	SaveWebhookDelivery(d models.WebhookDelivery) error
+	WebhookDelivery(id int) (models.WebhookDelivery, error)
+	// WebhookDeliveries returns webhook's deliveries ordered by id
+	WebhookDeliveries(webhookID int, page models.PageRequest) (models.WebhookDeliveryPage, error)
+	// DueWebhookDeliveries returns up to limit pending deliveries whose next attempt time is not after now,
+	// ordered by next attempt time
+	DueWebhookDeliveries(now int64, limit int) ([]models.WebhookDelivery, error)
+
+	// SaveTOTPFactor creates or replaces consumer's factor if its Version matches stored one (zero if there is none),
+	// otherwise ErrConflict is returned
+	SaveTOTPFactor(f models.TOTPFactor) error
+	TOTPFactor(consumerID int) (models.TOTPFactor, error)
+
+	SaveWebAuthnCredential(c models.WebAuthnCredential) error
+	WebAuthnCredential(id []byte) (models.WebAuthnCredential, error)
+	WebAuthnCredentials(consumerID int) ([]models.WebAuthnCredential, error)
+	// SaveWebAuthnChallenge saves challenge of started ceremony, expired challenges are deleted
+	SaveWebAuthnChallenge(c models.WebAuthnChallenge) error
+	// TakeWebAuthnChallenge returns challenge and deletes it, so ceremony can be finished once
+	TakeWebAuthnChallenge(challenge string) (models.WebAuthnChallenge, error)
+
+	SaveExport(e models.Export) (models.Export, error)
+	Export(id int) (models.Export, error)
+	// LastExport returns the most recently created consumer's export
+	LastExport(consumerID int) (models.Export, error)
+	// PendingExports returns up to limit pending exports ordered by id
+	PendingExports(limit int) ([]models.Export, error)
+
+	// SaveOneTimeToken creates token if its ID is zero or replaces existing one if its Version matches stored one,
+	// otherwise ErrConflict is returned. Saved token with incremented Version is returned.
+	SaveOneTimeToken(t models.OneTimeToken) (models.OneTimeToken, error)
+	OneTimeToken(id int) (models.OneTimeToken, error)
+	LastOneTimeToken(consumerID int, purpose string) (models.OneTimeToken, error)
+
+	// CreateOAuthClient saves client, ErrAlreadyExists is returned if client with the same id exists
+	CreateOAuthClient(c models.OAuthClient) error
+	OAuthClient(id string) (models.OAuthClient, error)
+	// OAuthClients returns all clients ordered by creation time
+	OAuthClients() ([]models.OAuthClient, error)
+	// DeleteOAuthClient deletes client with its authorization codes and refresh tokens
+	DeleteOAuthClient(id string) error
+
+	SaveOAuthCode(c models.OAuthCode) error
+	// TakeOAuthCode returns authorization code by hash and deletes it, so code can be used once
+	TakeOAuthCode(hash string) (models.OAuthCode, error)
+
+	// SaveRefreshToken saves refresh token, expired tokens are deleted
+	SaveRefreshToken(t models.RefreshToken) error
+	// RefreshToken returns refresh token by hash
+	RefreshToken(hash string) (models.RefreshToken, error)
+	// UseRefreshToken marks refresh token found by hash as used at usedAt. ErrConflict is returned if it has
+	// already been used, so token can't be exchanged twice by concurrent requests
+	UseRefreshToken(hash string, usedAt int64) error
+	// RevokeRefreshTokens deletes all refresh tokens of family
+	RevokeRefreshTokens(familyID string) error
+	// ConsumerRefreshTokens returns refresh tokens issued to consumer ordered by creation time
+	ConsumerRefreshTokens(consumerID int) ([]models.RefreshToken, error)
 }
@@ -0,0 +1,408 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Store returns data store which creates span for each call to s.
+// Spans are roots of new traces unless store is bound to context by WithContext.
+func Store(tp trace.TracerProvider, s store.DataStore) store.DataStore {
+	return &tracedStore{next: s, tracer: Tracer(tp), ctx: context.Background()}
+}
+
+// WithContext returns store whose spans are children of span in ctx if s is returned by Store,
+// otherwise s is returned as is
+func WithContext(ctx context.Context, s store.DataStore) store.DataStore {
+	t, ok := s.(*tracedStore)
+	if !ok {
+		return s
+	}
+	return &tracedStore{next: t.next, tracer: t.tracer, ctx: ctx}
+}
+
+type tracedStore struct {
+	next   store.DataStore
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+func (s *tracedStore) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "store."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBOperationKey.String(method)),
+	)
+}
+
+// Ping is traced as child of span in ctx
+func (s *tracedStore) Ping(ctx context.Context) error {
+	_, span := s.start(ctx, "Ping")
+	err := s.next.Ping(ctx)
+	End(span, err)
+	return err
+}
+
+// Atomic traces calls within transaction as children of its span
+func (s *tracedStore) Atomic(fn func(tx store.DataStore) error) error {
+	ctx, span := s.start(s.ctx, "Atomic")
+	err := s.next.Atomic(func(tx store.DataStore) error {
+		return fn(&tracedStore{next: tx, tracer: s.tracer, ctx: ctx})
+	})
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) CreateConsumer(c models.Consumer) (models.Consumer, error) {
+	_, span := s.start(s.ctx, "CreateConsumer")
+	v, err := s.next.CreateConsumer(c)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) ConsumerIsExist(email string) (bool, error) {
+	_, span := s.start(s.ctx, "ConsumerIsExist")
+	v, err := s.next.ConsumerIsExist(email)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) Consumer(email string) (models.Consumer, error) {
+	_, span := s.start(s.ctx, "Consumer")
+	v, err := s.next.Consumer(email)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) ConsumerByID(id int) (models.Consumer, error) {
+	_, span := s.start(s.ctx, "ConsumerByID")
+	v, err := s.next.ConsumerByID(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) UpdateConsumer(c models.Consumer) (models.Consumer, error) {
+	_, span := s.start(s.ctx, "UpdateConsumer")
+	v, err := s.next.UpdateConsumer(c)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteConsumer(id int) error {
+	_, span := s.start(s.ctx, "DeleteConsumer")
+	err := s.next.DeleteConsumer(id)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) ListConsumers(f models.ConsumerFilter, page models.PageRequest) (models.ConsumerPage, error) {
+	_, span := s.start(s.ctx, "ListConsumers")
+	v, err := s.next.ListConsumers(f, page)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) ConsumersScheduledForDeletion(before int64, limit int) ([]models.Consumer, error) {
+	_, span := s.start(s.ctx, "ConsumersScheduledForDeletion")
+	v, err := s.next.ConsumersScheduledForDeletion(before, limit)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) PurgeConsumer(id int) error {
+	_, span := s.start(s.ctx, "PurgeConsumer")
+	err := s.next.PurgeConsumer(id)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) SaveStatusChange(ch models.StatusChange) error {
+	_, span := s.start(s.ctx, "SaveStatusChange")
+	err := s.next.SaveStatusChange(ch)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) StatusChanges(consumerID int) ([]models.StatusChange, error) {
+	_, span := s.start(s.ctx, "StatusChanges")
+	v, err := s.next.StatusChanges(consumerID)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) AppendAuditEvent(e models.AuditEvent) (models.AuditEvent, error) {
+	_, span := s.start(s.ctx, "AppendAuditEvent")
+	v, err := s.next.AppendAuditEvent(e)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) AuditEvents(f models.AuditFilter, page models.PageRequest) (models.AuditPage, error) {
+	_, span := s.start(s.ctx, "AuditEvents")
+	v, err := s.next.AuditEvents(f, page)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) AddOutboxMessage(m models.OutboxMessage) error {
+	_, span := s.start(s.ctx, "AddOutboxMessage")
+	err := s.next.AddOutboxMessage(m)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) PendingOutboxMessages(limit int) ([]models.OutboxMessage, error) {
+	_, span := s.start(s.ctx, "PendingOutboxMessages")
+	v, err := s.next.PendingOutboxMessages(limit)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) MarkOutboxMessagePublished(id int, publishedAt int64) error {
+	_, span := s.start(s.ctx, "MarkOutboxMessagePublished")
+	err := s.next.MarkOutboxMessagePublished(id, publishedAt)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) SaveWebhook(w models.Webhook) (models.Webhook, error) {
+	_, span := s.start(s.ctx, "SaveWebhook")
+	v, err := s.next.SaveWebhook(w)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) Webhook(id int) (models.Webhook, error) {
+	_, span := s.start(s.ctx, "Webhook")
+	v, err := s.next.Webhook(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) Webhooks() ([]models.Webhook, error) {
+	_, span := s.start(s.ctx, "Webhooks")
+	v, err := s.next.Webhooks()
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteWebhook(id int) error {
+	_, span := s.start(s.ctx, "DeleteWebhook")
+	err := s.next.DeleteWebhook(id)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) CreateWebhookDelivery(d models.WebhookDelivery) (models.WebhookDelivery, error) {
+	_, span := s.start(s.ctx, "CreateWebhookDelivery")
+	v, err := s.next.CreateWebhookDelivery(d)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveWebhookDelivery(d models.WebhookDelivery) error {
+	_, span := s.start(s.ctx, "SaveWebhookDelivery")
+	err := s.next.SaveWebhookDelivery(d)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) WebhookDelivery(id int) (models.WebhookDelivery, error) {
+	_, span := s.start(s.ctx, "WebhookDelivery")
+	v, err := s.next.WebhookDelivery(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) WebhookDeliveries(webhookID int, page models.PageRequest) (models.WebhookDeliveryPage, error) {
+	_, span := s.start(s.ctx, "WebhookDeliveries")
+	v, err := s.next.WebhookDeliveries(webhookID, page)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) DueWebhookDeliveries(now int64, limit int) ([]models.WebhookDelivery, error) {
+	_, span := s.start(s.ctx, "DueWebhookDeliveries")
+	v, err := s.next.DueWebhookDeliveries(now, limit)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveTOTPFactor(f models.TOTPFactor) error {
+	_, span := s.start(s.ctx, "SaveTOTPFactor")
+	err := s.next.SaveTOTPFactor(f)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) TOTPFactor(consumerID int) (models.TOTPFactor, error) {
+	_, span := s.start(s.ctx, "TOTPFactor")
+	v, err := s.next.TOTPFactor(consumerID)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveWebAuthnCredential(c models.WebAuthnCredential) error {
+	_, span := s.start(s.ctx, "SaveWebAuthnCredential")
+	err := s.next.SaveWebAuthnCredential(c)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) WebAuthnCredential(id []byte) (models.WebAuthnCredential, error) {
+	_, span := s.start(s.ctx, "WebAuthnCredential")
+	v, err := s.next.WebAuthnCredential(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) WebAuthnCredentials(consumerID int) ([]models.WebAuthnCredential, error) {
+	_, span := s.start(s.ctx, "WebAuthnCredentials")
+	v, err := s.next.WebAuthnCredentials(consumerID)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveWebAuthnChallenge(c models.WebAuthnChallenge) error {
+	_, span := s.start(s.ctx, "SaveWebAuthnChallenge")
+	err := s.next.SaveWebAuthnChallenge(c)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) TakeWebAuthnChallenge(challenge string) (models.WebAuthnChallenge, error) {
+	_, span := s.start(s.ctx, "TakeWebAuthnChallenge")
+	v, err := s.next.TakeWebAuthnChallenge(challenge)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveExport(e models.Export) (models.Export, error) {
+	_, span := s.start(s.ctx, "SaveExport")
+	v, err := s.next.SaveExport(e)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) Export(id int) (models.Export, error) {
+	_, span := s.start(s.ctx, "Export")
+	v, err := s.next.Export(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) LastExport(consumerID int) (models.Export, error) {
+	_, span := s.start(s.ctx, "LastExport")
+	v, err := s.next.LastExport(consumerID)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) PendingExports(limit int) ([]models.Export, error) {
+	_, span := s.start(s.ctx, "PendingExports")
+	v, err := s.next.PendingExports(limit)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveOneTimeToken(t models.OneTimeToken) (models.OneTimeToken, error) {
+	_, span := s.start(s.ctx, "SaveOneTimeToken")
+	v, err := s.next.SaveOneTimeToken(t)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) OneTimeToken(id int) (models.OneTimeToken, error) {
+	_, span := s.start(s.ctx, "OneTimeToken")
+	v, err := s.next.OneTimeToken(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) LastOneTimeToken(consumerID int, purpose string) (models.OneTimeToken, error) {
+	_, span := s.start(s.ctx, "LastOneTimeToken")
+	v, err := s.next.LastOneTimeToken(consumerID, purpose)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) CreateOAuthClient(c models.OAuthClient) error {
+	_, span := s.start(s.ctx, "CreateOAuthClient")
+	err := s.next.CreateOAuthClient(c)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) OAuthClient(id string) (models.OAuthClient, error) {
+	_, span := s.start(s.ctx, "OAuthClient")
+	v, err := s.next.OAuthClient(id)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) OAuthClients() ([]models.OAuthClient, error) {
+	_, span := s.start(s.ctx, "OAuthClients")
+	v, err := s.next.OAuthClients()
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) DeleteOAuthClient(id string) error {
+	_, span := s.start(s.ctx, "DeleteOAuthClient")
+	err := s.next.DeleteOAuthClient(id)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) SaveOAuthCode(c models.OAuthCode) error {
+	_, span := s.start(s.ctx, "SaveOAuthCode")
+	err := s.next.SaveOAuthCode(c)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) TakeOAuthCode(hash string) (models.OAuthCode, error) {
+	_, span := s.start(s.ctx, "TakeOAuthCode")
+	v, err := s.next.TakeOAuthCode(hash)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) SaveRefreshToken(t models.RefreshToken) error {
+	_, span := s.start(s.ctx, "SaveRefreshToken")
+	err := s.next.SaveRefreshToken(t)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) RefreshToken(hash string) (models.RefreshToken, error) {
+	_, span := s.start(s.ctx, "RefreshToken")
+	v, err := s.next.RefreshToken(hash)
+	End(span, err)
+	return v, err
+}
+
+func (s *tracedStore) UseRefreshToken(hash string, usedAt int64) error {
+	_, span := s.start(s.ctx, "UseRefreshToken")
+	err := s.next.UseRefreshToken(hash, usedAt)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) RevokeRefreshTokens(familyID string) error {
+	_, span := s.start(s.ctx, "RevokeRefreshTokens")
+	err := s.next.RevokeRefreshTokens(familyID)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) ConsumerRefreshTokens(consumerID int) ([]models.RefreshToken, error) {
+	_, span := s.start(s.ctx, "ConsumerRefreshTokens")
+	v, err := s.next.ConsumerRefreshTokens(consumerID)
+	End(span, err)
+	return v, err
+}
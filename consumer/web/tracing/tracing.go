@@ -0,0 +1,127 @@
+// Package tracing configures OpenTelemetry tracing, instruments HTTP handlers and data stores
+// and propagates trace context in W3C traceparent format.
+// Nil tracer provider means the global one, which doesn't record spans unless it is set by otel.SetTracerProvider.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/nsmak/consumerService/consumer/web/middleware"
+	"github.com/nsmak/consumerService/consumer/web/store"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/nsmak/consumerService"
+
+// propagator reads and writes trace context in W3C traceparent and tracestate headers
+var propagator = propagation.TraceContext{}
+
+// Opts - exporter and sampler options
+type Opts struct {
+	Endpoint    string // OTLP/HTTP collector host:port, e.g. "otel-collector:4318"
+	Insecure    bool   // use plain http
+	ServiceName string
+	SampleRatio float64 // ratio of sampled traces which don't have sampled parent
+}
+
+// NewProvider returns tracer provider which exports spans to OTLP collector in batches.
+// Provider must be shut down to flush spans.
+func NewProvider(ctx context.Context, opts Opts) (*sdktrace.TracerProvider, error) {
+	exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(opts.ServiceName))),
+	), nil
+}
+
+// Tracer returns tracer of service components
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+// End ends span, err is recorded if it isn't nil. Not found errors are expected, they don't fail span.
+func End(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectHeader writes trace context of ctx to outgoing request headers
+func InjectHeader(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// InjectMap writes trace context of ctx to m, e.g. to headers of email message
+func InjectMap(ctx context.Context, m map[string]string) {
+	propagator.Inject(ctx, mapCarrier(m))
+}
+
+// mapCarrier - propagation carrier backed by map
+type mapCarrier map[string]string
+
+func (c mapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c mapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c mapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InstrumentHandler returns handler which continues trace of incoming request or starts new one
+// and passes request to next within server span. route returns pattern of request's route, it names the span.
+func InstrumentHandler(tp trace.TracerProvider, route func(*http.Request) string, next http.Handler) http.Handler {
+	tracer := Tracer(tp)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := route(r)
+		if rt == "" {
+			rt = "unmatched"
+		}
+		method := middleware.Method(r)
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, method+" "+rt,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(method),
+				semconv.HTTPRouteKey.String(rt),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := middleware.NewStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(sw.Status()))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(sw.Status()))
+	})
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/store/memory"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func newRecorder() (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	rec := tracetest.NewSpanRecorder()
+	return rec, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	rec, tp := newRecorder()
+	var outgoing http.Header
+	h := InstrumentHandler(tp, func(*http.Request) string { return "/v1/consumers" }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = http.Header{}
+		InjectHeader(r.Context(), outgoing)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/consumers", nil)
+	r.Header.Set("Traceparent", traceparent)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	s := spans[0]
+	require.Equal(t, "POST /v1/consumers", s.Name())
+	require.Equal(t, trace.SpanKindServer, s.SpanKind())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", s.Parent().SpanID().String())
+	require.True(t, s.Parent().IsRemote())
+	require.Equal(t, codes.Error, s.Status().Code)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+s.SpanContext().SpanID().String()+"-01", outgoing.Get("Traceparent"))
+}
+
+func TestInstrumentHandlerUnknownMethod(t *testing.T) {
+	rec, tp := newRecorder()
+	h := InstrumentHandler(tp, func(*http.Request) string { return "/v1/consumers" }, http.NotFoundHandler())
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOO", "/v1/consumers", nil))
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "other /v1/consumers", spans[0].Name())
+}
+
+func TestStore(t *testing.T) {
+	rec, tp := newRecorder()
+	s := Store(tp, memory.New())
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	_, err := WithContext(ctx, s).Consumer("test@test.com")
+	require.Error(t, err)
+	_, err = s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.NoError(t, err)
+	_, err = s.CreateConsumer(models.Consumer{Email: "test@test.com"})
+	require.Error(t, err)
+	parent.End()
+
+	spans := rec.Ended()
+	require.Len(t, spans, 4)
+	require.Equal(t, "store.Consumer", spans[0].Name())
+	require.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Equal(t, codes.Unset, spans[0].Status().Code, "not found isn't an error")
+	require.Equal(t, "store.CreateConsumer", spans[1].Name())
+	require.False(t, spans[1].Parent().IsValid())
+	require.Equal(t, codes.Unset, spans[1].Status().Code)
+	require.Equal(t, codes.Error, spans[2].Status().Code)
+}
+
+func TestWithContextUntracedStore(t *testing.T) {
+	s := memory.New()
+	require.Equal(t, s, WithContext(context.Background(), s))
+}
+
+func TestInjectMap(t *testing.T) {
+	_, tp := newRecorder()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "send")
+	m := map[string]string{}
+
+	InjectMap(ctx, m)
+
+	require.Equal(t, "00-"+span.SpanContext().TraceID().String()+"-"+span.SpanContext().SpanID().String()+"-01", m["traceparent"])
+	m = map[string]string{}
+	InjectMap(context.Background(), m)
+	require.Empty(t, m)
+}
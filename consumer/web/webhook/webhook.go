@@ -0,0 +1,146 @@
+// Package webhook sends signed webhook requests
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/models"
+	"github.com/nsmak/consumerService/consumer/web/tracing"
+)
+
+// Request headers
+const (
+	HeaderID        = "X-Webhook-Id" // delivery id, the same for retries of the delivery
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	signaturePrefix = "sha256="
+	defaultTimeout  = 10 * time.Second
+	maxResponseSize = 64 << 10
+)
+
+var (
+	// ErrInvalidSignature is returned by Verify when signature doesn't match request
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrInternalAddress is returned when webhook host resolves to internal address
+	ErrInternalAddress = errors.New("webhook host resolves to internal address")
+)
+
+var defaultClient = NewClient(defaultTimeout)
+
+// Request - webhook request
+type Request struct {
+	URL        string
+	Secret     string
+	DeliveryID int
+	Event      string
+	Body       []byte
+}
+
+// Sender sends webhook requests
+type Sender struct {
+	Client *http.Client // client returned by NewClient with 10 seconds timeout is used if nil
+}
+
+// NewClient returns client which refuses to connect to internal addresses, see models.IsInternalIP.
+// Address is checked after host is resolved, so DNS records pointing inside network are refused too.
+// Proxy isn't used, because it would connect on behalf of client.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || models.IsInternalIP(ip) {
+				return ErrInternalAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// Send posts signed request with trace context of ctx and returns response status code. Error is returned
+// if response wasn't received or its status isn't 2xx.
+func (s Sender) Send(ctx context.Context, r Request) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.Itoa(r.DeliveryID))
+	req.Header.Set(HeaderEvent, r.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(r.Secret, ts, r.Body))
+	tracing.InjectHeader(ctx, req.Header)
+
+	client := s.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns signature header value: HMAC-SHA256 of "timestamp.body" keyed by secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature of received request. Requests whose timestamp differs
+// from now by more than tolerance are rejected to prevent replay.
+func Verify(secret string, h http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(h.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrInvalidSignature
+	}
+	sig := h.Get(HeaderSignature)
+	if !strings.HasPrefix(sig, signaturePrefix) || !hmac.Equal([]byte(sig), []byte(Sign(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
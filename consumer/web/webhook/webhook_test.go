@@ -0,0 +1,98 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsmak/consumerService/consumer/web/webhook"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSendVerify(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	code, err := webhook.Sender{Client: srv.Client()}.Send(context.Background(), webhook.Request{
+		URL:        srv.URL,
+		Secret:     "secret",
+		DeliveryID: 3,
+		Event:      "consumer.registered",
+		Body:       []byte(`{"id":1}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"id":1}`, string(body))
+	require.Equal(t, "3", received.Header.Get(webhook.HeaderID))
+	require.Equal(t, "consumer.registered", received.Header.Get(webhook.HeaderEvent))
+	require.NoError(t, webhook.Verify("secret", received.Header, body, time.Minute, time.Now()))
+
+	require.Equal(t, webhook.ErrInvalidSignature, webhook.Verify("other", received.Header, body, time.Minute, time.Now()))
+	require.Equal(t, webhook.ErrInvalidSignature, webhook.Verify("secret", received.Header, []byte(`{"id":2}`), time.Minute, time.Now()))
+	require.Equal(t, webhook.ErrInvalidSignature, webhook.Verify("secret", received.Header, body, time.Minute, time.Now().Add(2*time.Minute)))
+}
+
+func TestSendTraceContext(t *testing.T) {
+	var received http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer srv.Close()
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	_, err := webhook.Sender{Client: srv.Client()}.Send(trace.ContextWithSpanContext(context.Background(), sc), webhook.Request{URL: srv.URL, Body: []byte("{}")})
+	require.NoError(t, err)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", received.Get("Traceparent"))
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1600000000.{}' | openssl dgst -sha256 -hmac secret
+	require.Equal(t, "sha256=1e56a11da123b137c26fa37b7c222060bdf22988aa9b3248c31244f8b2ef4a28", webhook.Sign("secret", 1600000000, []byte("{}")))
+	h := http.Header{}
+	h.Set(webhook.HeaderTimestamp, strconv.Itoa(1600000000))
+	h.Set(webhook.HeaderSignature, webhook.Sign("secret", 1600000000, []byte("{}")))
+	require.NoError(t, webhook.Verify("secret", h, []byte("{}"), time.Minute, time.Unix(1600000030, 0)))
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	code, err := webhook.Sender{Client: srv.Client()}.Send(context.Background(), webhook.Request{URL: srv.URL, Body: []byte("{}")})
+	require.Error(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestSendRefusesInternalAddress(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	for _, u := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		code, err := webhook.Sender{}.Send(context.Background(), webhook.Request{URL: u, Body: []byte("{}")})
+		require.True(t, errors.Is(err, webhook.ErrInternalAddress), u)
+		require.Zero(t, code)
+	}
+	require.False(t, called)
+}